@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"apartment_login/service"
+)
+
+// getLookalikeIDs lists the apartment IDs that look like the same flat
+func getLookalikeIDs() ([]service.IDGroup, error) {
+	if err := requirePermission(PermViewApartments); err != nil {
+		return nil, err
+	}
+	return appService.LookalikeIDs()
+}
+
+// mergeApartments moves the collections and resident logins of one
+// apartment to another and moves the first to the recycle bin
+func mergeApartments(from, into string) (service.Merge, error) {
+	if err := requirePermission(PermEditApartments); err != nil {
+		return service.Merge{}, err
+	}
+	if err := requirePermission(PermDeleteApartments); err != nil {
+		return service.Merge{}, err
+	}
+
+	// A merge whose resident logins were left behind is still audited
+	merge, err := appService.MergeApartments(from, into, currentUser.Username)
+	if merge.Into.ID == "" {
+		return merge, err
+	}
+	invalidateApartmentLists()
+
+	if merge.Created {
+		if err := recordAudit(auditEntityApartment, merge.Into.ID, auditActionCreate, nil, merge.Into); err != nil {
+			return merge, err
+		}
+	}
+	if err := recordAudit(auditEntityApartment, from, auditActionMerge, merge.From,
+		map[string]any{"merged_into": merge.Into.ID, "collections": merge.Collections}); err != nil {
+		return merge, err
+	}
+	return merge, err
+}
+
+// Duplicate IDs UI, listing lookalike apartment IDs and merging them.
+// onMerge lets the Apartment Manager reload its list.
+func ShowDuplicateIDs(myApp fyne.App, previousWindow fyne.Window, onMerge func()) {
+	duplicatesWindow := myApp.NewWindow("Duplicate Apartment IDs")
+	duplicatesWindow.Resize(fyne.NewSize(800, 450))
+
+	var groups []service.IDGroup
+	var selected *service.IDGroup
+
+	groupsList := widget.NewList(
+		func() int { return len(groups) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			group := groups[id]
+			suggested := group.Suggested
+			if suggested == "" {
+				suggested = "? (does not follow the pattern)"
+			}
+			obj.(*widget.Label).SetText(fmt.Sprintf("%s  ->  %s", strings.Join(group.IDs, ", "), suggested))
+		},
+	)
+
+	fromSelect := widget.NewSelect(nil, nil)
+	fromSelect.PlaceHolder = "Apartment to merge away"
+
+	intoEntry := widget.NewEntry()
+	intoEntry.SetPlaceHolder("Apartment to keep")
+
+	detailsLabel := widget.NewLabel("Select a group of lookalike IDs.")
+	detailsLabel.Wrapping = fyne.TextWrapWord
+
+	describe := func() {
+		if selected == nil {
+			detailsLabel.SetText("Select a group of lookalike IDs.")
+			return
+		}
+		var lines []string
+		for _, id := range selected.IDs {
+			lines = append(lines, fmt.Sprintf("%s: %d collection(s)", id, selected.Collections[id]))
+		}
+		if selected.Suggested == "" {
+			lines = append(lines, "", "This ID cannot be written the way the pattern asks; type the ID to keep.")
+		}
+		lines = append(lines, "",
+			"Merging moves the collections and resident logins of the first apartment to the second, "+
+				"then moves the first to the recycle bin. An ID that does not exist yet is created "+
+				"with the first apartment's details.")
+		detailsLabel.SetText(strings.Join(lines, "\n"))
+	}
+
+	groupsList.OnSelected = func(id widget.ListItemID) {
+		selected = &groups[id]
+		var others []string
+		for _, apartmentID := range selected.IDs {
+			if apartmentID != selected.Suggested {
+				others = append(others, apartmentID)
+			}
+		}
+		fromSelect.Options = others
+		fromSelect.ClearSelected()
+		if len(others) > 0 {
+			fromSelect.SetSelected(others[0])
+		}
+		intoEntry.SetText(selected.Suggested)
+		describe()
+	}
+
+	refreshList := func() {
+		var err error
+		groups, err = getLookalikeIDs()
+		if err != nil {
+			dialog.ShowError(err, duplicatesWindow)
+		}
+		selected = nil
+		fromSelect.Options = nil
+		fromSelect.ClearSelected()
+		intoEntry.SetText("")
+		groupsList.UnselectAll()
+		groupsList.Refresh()
+		describe()
+	}
+
+	mergeButton := widget.NewButtonWithIcon("Merge", theme.ContentPasteIcon(), func() {
+		from, into := fromSelect.Selected, strings.TrimSpace(intoEntry.Text)
+		if from == "" || into == "" {
+			dialog.ShowError(errors.New("choose the apartment to merge away and the one to keep"), duplicatesWindow)
+			return
+		}
+		count := selected.Collections[from]
+		message := fmt.Sprintf("Move %d collection(s) and any resident logins from %s to %s, "+
+			"and move %s to the recycle bin?", count, from, into, from)
+		dialog.ShowConfirm("Confirm Merge", message, func(ok bool) {
+			if !ok {
+				return
+			}
+			merge, err := mergeApartments(from, into)
+			if merge.Into.ID != "" {
+				refreshList()
+				if onMerge != nil {
+					onMerge()
+				}
+			}
+			if err != nil {
+				dialog.ShowError(err, duplicatesWindow)
+				return
+			}
+			dialog.ShowInformation("Merged", fmt.Sprintf("Apartment %s merged into %s; %d collection(s) moved.",
+				merge.From.ID, merge.Into.ID, merge.Collections), duplicatesWindow)
+		}, duplicatesWindow)
+	})
+	if !can(PermEditApartments) || !can(PermDeleteApartments) {
+		mergeButton.Disable()
+	}
+
+	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
+		duplicatesWindow.Hide()
+		previousWindow.Show()
+	})
+
+	refreshList()
+
+	pattern := "Apartment IDs are not checked against a pattern; set one for the society under Societies."
+	if currentSociety.IDPattern != "" {
+		pattern = fmt.Sprintf("Apartment IDs follow the pattern %s, as in %s.",
+			currentSociety.IDPattern, service.IDExample(currentSociety.IDPattern))
+	}
+
+	form := container.NewVBox(
+		widget.NewLabel(pattern),
+		widget.NewLabel("Merge:"),
+		fromSelect,
+		widget.NewLabel("Into:"),
+		intoEntry,
+		detailsLabel,
+		container.NewHBox(mergeButton, backButton),
+	)
+
+	split := container.NewHSplit(groupsList, container.NewVScroll(form))
+	split.Offset = 0.4
+
+	showSessionWindow(duplicatesWindow, split)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"apartment_login/store"
+)
+
+// apartmentPageSize is how many apartments one list query fetches
+const apartmentPageSize = 200
+
+// apartmentsVersion is bumped on every apartment write so cached lists know
+// their pages are stale
+var apartmentsVersion atomic.Int64
+
+// invalidateApartmentLists marks every cached apartment list as stale
+func invalidateApartmentLists() {
+	apartmentsVersion.Add(1)
+}
+
+// apartmentListModel backs the apartment list widget. It loads apartments a
+// page at a time in ID order on a background goroutine, keeps the pages it
+// has loaded and drops them once apartments are written. onChange is called
+// whenever newly loaded data is available.
+type apartmentListModel struct {
+	store    store.ApartmentStore
+	pageSize int
+	onChange func()
+
+	mu           sync.Mutex
+	version      int64
+	count        int
+	counted      bool
+	countLoading bool
+	pages        map[int][]Apartment
+	pageLoading  map[int]bool
+}
+
+func newApartmentListModel(s store.ApartmentStore, onChange func()) *apartmentListModel {
+	m := &apartmentListModel{
+		store:    s,
+		pageSize: apartmentPageSize,
+		onChange: onChange,
+	}
+	m.reset()
+	return m
+}
+
+// reset drops the cached pages; the last known count is kept until the new
+// one arrives so the list does not collapse while reloading.
+// The caller must hold mu.
+func (m *apartmentListModel) reset() {
+	m.version = apartmentsVersion.Load()
+	m.counted = false
+	m.countLoading = false
+	m.pages = map[int][]Apartment{}
+	m.pageLoading = map[int]bool{}
+}
+
+// checkVersion resets the cache if apartments were written since it was
+// filled. The caller must hold mu.
+func (m *apartmentListModel) checkVersion() {
+	if m.version != apartmentsVersion.Load() {
+		m.reset()
+	}
+}
+
+// Len returns the number of apartments, starting a count query if the cached
+// one is missing or stale
+func (m *apartmentListModel) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.checkVersion()
+	if !m.counted && !m.countLoading {
+		m.countLoading = true
+		go m.loadCount(m.version)
+	}
+	return m.count
+}
+
+// Item returns the apartment at index. If its page is not cached yet the
+// page is requested and ok is false; onChange fires once it has loaded.
+func (m *apartmentListModel) Item(index int) (apt Apartment, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.checkVersion()
+	page, offset := index/m.pageSize, index%m.pageSize
+	if rows, loaded := m.pages[page]; loaded {
+		if offset < len(rows) {
+			return rows[offset], true
+		}
+		return Apartment{}, false
+	}
+	if !m.pageLoading[page] {
+		m.pageLoading[page] = true
+		go m.loadPage(page, m.version)
+	}
+	return Apartment{}, false
+}
+
+// Reload drops the cache and asks the list to redraw
+func (m *apartmentListModel) Reload() {
+	m.mu.Lock()
+	m.reset()
+	m.mu.Unlock()
+	m.onChange()
+}
+
+func (m *apartmentListModel) loadCount(version int64) {
+	count, err := m.store.CountApartments()
+
+	m.mu.Lock()
+	if version != m.version {
+		// Apartments changed while counting; a newer query replaces this one
+		m.mu.Unlock()
+		return
+	}
+	m.countLoading = false
+	if err != nil {
+		m.mu.Unlock()
+		log.Println("Error counting apartments:", err)
+		return
+	}
+	m.count = count
+	m.counted = true
+	m.mu.Unlock()
+
+	m.onChange()
+}
+
+func (m *apartmentListModel) loadPage(page int, version int64) {
+	rows, err := m.store.ListApartments(page*m.pageSize, m.pageSize)
+
+	m.mu.Lock()
+	if version != m.version {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.pageLoading, page)
+	if err != nil {
+		m.mu.Unlock()
+		log.Println("Error fetching apartments:", err)
+		return
+	}
+	m.pages[page] = rows
+	m.mu.Unlock()
+
+	m.onChange()
+}
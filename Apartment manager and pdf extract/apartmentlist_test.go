@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"apartment_login/store"
+)
+
+func TestApartmentListModel(t *testing.T) {
+	s := store.NewMemory()
+	for _, id := range []string{"105", "101", "104", "102", "103"} {
+		if err := s.SaveApartments(Apartment{ID: id, Owner: "Owner " + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed := make(chan struct{}, 10)
+	m := newApartmentListModel(s, func() { changed <- struct{}{} })
+	m.pageSize = 2
+	waitForChange := func() {
+		t.Helper()
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the model never reported new data")
+		}
+	}
+
+	if n := m.Len(); n != 0 {
+		t.Fatalf("Len before loading = %d, want 0", n)
+	}
+	waitForChange()
+	if n := m.Len(); n != 5 {
+		t.Fatalf("Len = %d, want 5", n)
+	}
+
+	if _, ok := m.Item(3); ok {
+		t.Fatal("Item(3) returned before its page loaded")
+	}
+	waitForChange()
+	for index, want := range map[int]string{2: "103", 3: "104"} {
+		if apt, ok := m.Item(index); !ok || apt.ID != want {
+			t.Errorf("Item(%d) = %q, %v; want %q in ID order", index, apt.ID, ok, want)
+		}
+	}
+	if _, ok := m.Item(0); ok {
+		t.Error("Item(0) came from a page that was never loaded")
+	}
+	waitForChange()
+
+	// A write elsewhere makes the cached pages stale
+	if err := s.SaveApartments(Apartment{ID: "100", Owner: "New"}); err != nil {
+		t.Fatal(err)
+	}
+	invalidateApartmentLists()
+	if n := m.Len(); n != 5 {
+		t.Errorf("Len while recounting = %d, want the last count", n)
+	}
+	if _, ok := m.Item(0); ok {
+		t.Error("Item(0) served from a stale page")
+	}
+	for i := 0; i < 2; i++ {
+		waitForChange()
+	}
+	if n := m.Len(); n != 6 {
+		t.Errorf("Len after the write = %d, want 6", n)
+	}
+	if apt, ok := m.Item(0); !ok || apt.ID != "100" {
+		t.Errorf("Item(0) = %q, %v; want the new apartment 100", apt.ID, ok)
+	}
+}
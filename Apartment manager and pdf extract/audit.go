@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"apartment_login/store"
+)
+
+// Audited entities
+const (
+	auditEntityUser       = "user"
+	auditEntityApartment  = "apartment"
+	auditEntityCollection = "collection"
+	auditEntityPayment    = "payment"
+	auditEntityBackup     = "backup"
+	auditEntitySociety    = "society"
+	auditEntityPerson     = "person"
+	auditEntitySetting    = "setting"
+)
+
+// Audited actions
+const (
+	auditActionCreate  = "create"
+	auditActionUpdate  = "update"
+	auditActionDelete  = "delete"
+	auditActionRestore = "restore"
+	auditActionPurge   = "purge"
+	auditActionMerge   = "merge"
+)
+
+// auditActorSystem is recorded for changes no signed-in user made
+const auditActorSystem = "system"
+
+// AuditEntry is one row of the append-only audit log
+type AuditEntry struct {
+	ID        int
+	Timestamp string
+	Actor     string
+	Entity    string
+	EntityID  string
+	Action    string
+	Before    string
+	After     string
+}
+
+// AuditFilter narrows the audit viewer; empty fields match everything
+type AuditFilter struct {
+	Actor  string
+	Entity string
+	Action string
+	From   string // YYYY-MM-DD, inclusive
+	To     string // YYYY-MM-DD, inclusive
+}
+
+// auditUser is the audited view of a user; it never includes the password
+type auditUser struct {
+	Username        string `json:"username"`
+	FullName        string `json:"full_name,omitempty"`
+	Role            Role   `json:"role"`
+	Disabled        bool   `json:"disabled,omitempty"`
+	PasswordChanged bool   `json:"password_changed,omitempty"`
+	FailedAttempts  int    `json:"failed_attempts,omitempty"`
+	Locked          bool   `json:"locked,omitempty"`
+	TwoFactor       bool   `json:"two_factor,omitempty"`
+}
+
+// recordAudit appends an entry attributed to the signed-in user.
+// before and after are stored as JSON; pass nil when there is no value.
+//
+// Auditing is best-effort: most changes live in resident.db while the log
+// lives in app.db, so the entry is written after the change has committed
+// and cannot be rolled back with it. An error here means the change was
+// saved but not logged, and the message says so.
+func recordAudit(entity, entityID, action string, before, after any) error {
+	actor := currentUser.Username
+	if actor == "" {
+		actor = auditActorSystem
+	}
+	return recordAuditAs(actor, entity, entityID, action, before, after)
+}
+
+// recordSystemAudit appends an entry for work the application does on its
+// own, such as scheduled backups, even while someone is signed in
+func recordSystemAudit(entity, entityID, action string, before, after any) error {
+	return recordAuditAs(auditActorSystem, entity, entityID, action, before, after)
+}
+
+func recordAuditAs(actor, entity, entityID, action string, before, after any) error {
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		return err
+	}
+
+	_, err = userDB.Exec(
+		`INSERT INTO audit_log (timestamp, actor, entity, entity_id, action, before, after)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().Format("2006-01-02 15:04:05"), actor, entity, entityID, action,
+		beforeJSON, afterJSON)
+	if err != nil {
+		log.Printf("Audit entry lost for %s %s %s by %s: %v", action, entity, entityID, actor, err)
+		return fmt.Errorf("the change was saved, but writing the audit log failed: %w", err)
+	}
+	return nil
+}
+
+func auditJSON(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// getAuditEntries returns audit entries matching the filter, newest first
+func getAuditEntries(filter AuditFilter) ([]AuditEntry, error) {
+	query := `SELECT id, timestamp, actor, entity, entity_id, action,
+		COALESCE(before, ''), COALESCE(after, '') FROM audit_log`
+	var conditions []string
+	var args []any
+
+	if filter.Actor != "" {
+		conditions = append(conditions, `actor LIKE ? ESCAPE '\'`)
+		args = append(args, store.ContainsPattern(filter.Actor))
+	}
+	if filter.Entity != "" {
+		conditions = append(conditions, "entity = ?")
+		args = append(args, filter.Entity)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.From != "" {
+		conditions = append(conditions, "date(timestamp) >= date(?)")
+		args = append(args, filter.From)
+	}
+	if filter.To != "" {
+		conditions = append(conditions, "date(timestamp) <= date(?)")
+		args = append(args, filter.To)
+	}
+
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+
+	rows, err := userDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.Actor, &e.Entity, &e.EntityID,
+			&e.Action, &e.Before, &e.After); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// exportAuditToCSV writes audit entries to a CSV file for the auditors
+func exportAuditToCSV(path string, entries []AuditEntry) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+
+	header := []string{"ID", "Timestamp", "Actor", "Entity", "Entity ID", "Action", "Before", "After"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		record := []string{fmt.Sprint(e.ID), e.Timestamp, e.Actor, e.Entity, e.EntityID,
+			e.Action, e.Before, e.After}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Audit Log UI
+func ShowAuditLog(myApp fyne.App, previousWindow fyne.Window) {
+	auditWindow := myApp.NewWindow("Audit Log")
+	auditWindow.Resize(fyne.NewSize(900, 600))
+
+	// Filter widgets
+	actorEntry := widget.NewEntry()
+	actorEntry.SetPlaceHolder("Actor")
+
+	entities := []string{"", auditEntityUser, auditEntityApartment, auditEntityCollection, auditEntityPayment,
+		auditEntityBackup, auditEntitySociety, auditEntityPerson, auditEntitySetting}
+	entitySelect := widget.NewSelect(entities, nil)
+	entitySelect.PlaceHolder = "Any entity"
+
+	actions := []string{"", auditActionCreate, auditActionUpdate, auditActionDelete, auditActionRestore,
+		auditActionPurge, auditActionMerge}
+	actionSelect := widget.NewSelect(actions, nil)
+	actionSelect.PlaceHolder = "Any action"
+
+	fromEntry := widget.NewEntry()
+	fromEntry.SetPlaceHolder("From (YYYY-MM-DD)")
+
+	toEntry := widget.NewEntry()
+	toEntry.SetPlaceHolder("To (YYYY-MM-DD)")
+
+	detailsLabel := widget.NewLabel("")
+	detailsLabel.Wrapping = fyne.TextWrapWord
+
+	var entries []AuditEntry
+	entriesList := widget.NewList(
+		func() int { return len(entries) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			e := entries[id]
+			obj.(*widget.Label).SetText(fmt.Sprintf("%s  %s  %s %s %s",
+				e.Timestamp, e.Actor, e.Action, e.Entity, e.EntityID))
+		},
+	)
+
+	entriesList.OnSelected = func(id widget.ListItemID) {
+		e := entries[id]
+		detailsLabel.SetText(fmt.Sprintf("Before:\n%s\n\nAfter:\n%s", e.Before, e.After))
+	}
+
+	currentFilter := func() (AuditFilter, error) {
+		filter := AuditFilter{
+			Actor:  strings.TrimSpace(actorEntry.Text),
+			Entity: entitySelect.Selected,
+			Action: actionSelect.Selected,
+			From:   strings.TrimSpace(fromEntry.Text),
+			To:     strings.TrimSpace(toEntry.Text),
+		}
+		for _, d := range []string{filter.From, filter.To} {
+			if d == "" {
+				continue
+			}
+			if _, err := time.Parse("2006-01-02", d); err != nil {
+				return filter, errors.New("dates must be in YYYY-MM-DD format")
+			}
+		}
+		return filter, nil
+	}
+
+	refreshList := func() {
+		filter, err := currentFilter()
+		if err != nil {
+			dialog.ShowError(err, auditWindow)
+			return
+		}
+		entries, err = getAuditEntries(filter)
+		if err != nil {
+			log.Println("Error fetching audit log:", err)
+			dialog.ShowError(err, auditWindow)
+		}
+		entriesList.UnselectAll()
+		detailsLabel.SetText("")
+		entriesList.Refresh()
+	}
+
+	filterButton := widget.NewButtonWithIcon("Filter", theme.SearchIcon(), refreshList)
+
+	exportButton := widget.NewButtonWithIcon("Export", theme.DownloadIcon(), func() {
+		fd := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil || writer == nil {
+				return
+			}
+			defer writer.Close()
+
+			path := writer.URI().Path()
+			if strings.ToLower(filepath.Ext(path)) != ".csv" {
+				dialog.ShowError(fmt.Errorf("unsupported file type: %s", filepath.Ext(path)), auditWindow)
+				return
+			}
+
+			if err := exportAuditToCSV(path, entries); err != nil {
+				dialog.ShowError(err, auditWindow)
+			} else {
+				dialog.ShowInformation("Success", "Audit log exported", auditWindow)
+			}
+		}, auditWindow)
+		fd.SetFileName("audit_log.csv")
+		fd.Show()
+	})
+
+	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
+		auditWindow.Hide()
+		previousWindow.Show()
+	})
+
+	refreshList()
+
+	filters := container.NewGridWithColumns(5, actorEntry, entitySelect, actionSelect, fromEntry, toEntry)
+	controls := container.NewVBox(
+		filters,
+		container.NewHBox(filterButton, exportButton, backButton),
+	)
+
+	split := container.NewHSplit(
+		entriesList,
+		container.NewVScroll(detailsLabel),
+	)
+	split.Offset = 0.6
+
+	showSessionWindow(auditWindow, container.NewBorder(controls, nil, nil, nil, split))
+}
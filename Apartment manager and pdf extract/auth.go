@@ -0,0 +1,235 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashPassword returns a salted bcrypt hash of the given password
+func hashPassword(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("password cannot be empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// checkPassword reports whether password matches the stored bcrypt hash
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// dummyPasswordHash is compared against when no account matches, so a
+// failed sign-in takes as long whether or not the username exists
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Failed to hash the dummy password:", err)
+	}
+	return hash
+})
+
+// checkNoPassword spends the time checkPassword would on an account that
+// does not exist
+func checkNoPassword(password string) {
+	bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+}
+
+// isPasswordHash reports whether the stored value is already a bcrypt hash
+func isPasswordHash(stored string) bool {
+	_, err := bcrypt.Cost([]byte(stored))
+	return err == nil
+}
+
+// migratePlaintextPasswords rehashes any rows still holding a plaintext
+// password. It runs on every startup and is a no-op once all rows are hashed.
+func migratePlaintextPasswords(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, password FROM users")
+	if err != nil {
+		return err
+	}
+
+	plaintext := map[int]string{}
+	for rows.Next() {
+		var id int
+		var password sql.NullString
+		if err := rows.Scan(&id, &password); err != nil {
+			rows.Close()
+			return err
+		}
+		if password.Valid && password.String != "" && !isPasswordHash(password.String) {
+			plaintext[id] = password.String
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(plaintext) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for id, password := range plaintext {
+		hash, err := hashPassword(password)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec("UPDATE users SET password = ? WHERE id = ?", hash, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	log.Printf("Migrated %d plaintext password(s) to bcrypt", len(plaintext))
+	return nil
+}
+
+// Login throttling. Each failed attempt blocks further attempts for a
+// doubling delay, and maxFailedAttempts in a row locks the account.
+const (
+	maxFailedAttempts = 5
+	lockoutDuration   = 15 * time.Minute
+	maxRetryDelay     = 30 * time.Second
+)
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// LoginRecord is one row of the login history
+type LoginRecord struct {
+	Username  string
+	Success   bool
+	Reason    string
+	Timestamp string
+}
+
+// retryDelay returns how long to block sign-in after the given number of
+// consecutive failures
+func retryDelay(failures int) time.Duration {
+	if failures >= maxFailedAttempts {
+		return lockoutDuration
+	}
+	delay := time.Second << (failures - 1)
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// recordLogin appends a sign-in attempt to the login history
+func recordLogin(username string, success bool, reason string) {
+	_, err := userDB.Exec(
+		"INSERT INTO login_history (username, success, reason, timestamp) VALUES (?, ?, ?, ?)",
+		username, boolToInt(success), reason, time.Now().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.Println("Error recording login:", err)
+	}
+}
+
+// recordFailedLogin bumps the failure counter and blocks the account for
+// the next retry delay. It returns the error to show to the user.
+//
+// The counter is bumped in the database, so failures from several copies
+// of the application all count, and starts again once a lockout has run
+// out rather than locking the account again on the next mistake.
+func recordFailedLogin(user User) error {
+	failures := user.FailedAttempts + 1
+	err := userDB.QueryRow(
+		`UPDATE users SET failed_attempts = CASE
+			WHEN failed_attempts >= ? AND (locked_until IS NULL OR julianday(locked_until) <= julianday('now'))
+			THEN 1 ELSE failed_attempts + 1 END
+		WHERE id = ? RETURNING failed_attempts`,
+		maxFailedAttempts, user.ID).Scan(&failures)
+	if err != nil {
+		log.Println("Error recording failed login:", err)
+	} else {
+		// A failure recorded meanwhile sets its own, later, delay
+		lockedUntil := time.Now().Add(retryDelay(failures))
+		_, err = userDB.Exec("UPDATE users SET locked_until = ? WHERE id = ? AND failed_attempts = ?",
+			lockedUntil.Format(time.RFC3339), user.ID, failures)
+		if err != nil {
+			log.Println("Error recording failed login:", err)
+		}
+	}
+
+	if failures >= maxFailedAttempts {
+		recordLogin(user.Username, false, "wrong password, account locked")
+		return fmt.Errorf("too many failed attempts, account locked for %s", lockoutDuration)
+	}
+	recordLogin(user.Username, false, "wrong password")
+	return errInvalidCredentials
+}
+
+// unlockUser clears the failure counter and any lockout
+func unlockUser(id int) error {
+	if err := requirePermission(PermManageUsers); err != nil {
+		return err
+	}
+
+	before, err := getUserByID(id)
+	if err != nil {
+		return err
+	}
+
+	_, err = userDB.Exec(
+		"UPDATE users SET failed_attempts = 0, locked_until = NULL WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return recordAudit(auditEntityUser, strconv.Itoa(id), auditActionUpdate,
+		auditUser{Username: before.Username, Role: before.Role,
+			FailedAttempts: before.FailedAttempts, Locked: before.IsLocked()},
+		auditUser{Username: before.Username, Role: before.Role})
+}
+
+// getLoginHistory returns recent sign-in attempts, newest first.
+// An empty username returns attempts for every account.
+func getLoginHistory(username string, limit int) ([]LoginRecord, error) {
+	query := "SELECT username, success, reason, timestamp FROM login_history"
+	var args []any
+	if username != "" {
+		query += " WHERE username = ?"
+		args = append(args, username)
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := userDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []LoginRecord
+	for rows.Next() {
+		var r LoginRecord
+		var success int
+		if err := rows.Scan(&r.Username, &success, &r.Reason, &r.Timestamp); err != nil {
+			return nil, err
+		}
+		r.Success = intToBool(success)
+		history = append(history, r)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"apartment_login/service"
+	"apartment_login/store"
+)
+
+// newTestDatabases points userDB, apartmentDB and the store and service
+// wrapping them at freshly migrated databases in a temporary directory
+func newTestDatabases(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	open := func(name string, migrations []store.Migration) *sql.DB {
+		path := filepath.Join(dir, name)
+		db, err := store.OpenDatabase(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if err := store.Migrate(db, path, migrations); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	userDB = open("app.db", store.AppMigrations)
+	apartmentDB = open("resident.db", store.ResidentMigrations)
+	appStore = store.NewSQLite(userDB, apartmentDB)
+	appService = service.New(appStore)
+	t.Cleanup(func() {
+		userDB, apartmentDB, appStore, appService = nil, nil, nil, nil
+	})
+}
+
+// mustAddUser stores an account with a hashed password
+func mustAddUser(t *testing.T, username, password string) {
+	t.Helper()
+	hash, err := hashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userDB.Exec("INSERT INTO users (username, password) VALUES (?, ?)", username, hash); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigratePlaintextPasswords(t *testing.T) {
+	newTestDatabases(t)
+	mustAddUser(t, "hashed", "already hashed")
+	if _, err := userDB.Exec("INSERT INTO users (username, password) VALUES ('plain', 'secret'), ('blank', '')"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second run finds nothing left to do
+	for run := 1; run <= 2; run++ {
+		if err := migratePlaintextPasswords(userDB); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+	}
+
+	stored := map[string]string{}
+	rows, err := userDB.Query("SELECT username, password FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var username, password string
+		if err := rows.Scan(&username, &password); err != nil {
+			t.Fatal(err)
+		}
+		stored[username] = password
+	}
+	if !isPasswordHash(stored["plain"]) || !checkPassword(stored["plain"], "secret") {
+		t.Errorf("plaintext password stored as %q, want a bcrypt hash of it", stored["plain"])
+	}
+	if !checkPassword(stored["hashed"], "already hashed") {
+		t.Error("an existing hash was rehashed")
+	}
+	if stored["blank"] != "" {
+		t.Errorf("blank password stored as %q, want it left blank", stored["blank"])
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{maxFailedAttempts, lockoutDuration},
+		{maxFailedAttempts + 3, lockoutDuration},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.failures); got != tt.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestAuthenticateLockout(t *testing.T) {
+	newTestDatabases(t)
+	mustAddUser(t, "asha", "correct horse")
+
+	// waitOut clears the retry delay, as if the user waited it out
+	waitOut := func() {
+		t.Helper()
+		if _, err := userDB.Exec("UPDATE users SET locked_until = NULL"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 1; i < maxFailedAttempts; i++ {
+		if _, err := Authenticate("asha", "wrong"); !errors.Is(err, errInvalidCredentials) {
+			t.Fatalf("attempt %d: got error %v, want invalid credentials", i, err)
+		}
+		// Until the delay passes even the right password is refused
+		if _, err := Authenticate("asha", "correct horse"); err == nil || !strings.Contains(err.Error(), "try again") {
+			t.Fatalf("attempt %d: got error %v during the retry delay", i, err)
+		}
+		waitOut()
+	}
+	if _, err := Authenticate("asha", "wrong"); err == nil || !strings.Contains(err.Error(), "account locked") {
+		t.Fatalf("got error %v, want the account locked", err)
+	}
+	var lockedUntil sql.NullString
+	if err := userDB.QueryRow("SELECT locked_until FROM users").Scan(&lockedUntil); err != nil {
+		t.Fatal(err)
+	}
+	if until, _ := time.Parse(time.RFC3339, lockedUntil.String); time.Until(until) < lockoutDuration-time.Minute {
+		t.Errorf("locked until %s, want about %s from now", until, lockoutDuration)
+	}
+
+	waitOut()
+	user, err := Authenticate("asha", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.FailedAttempts != 0 {
+		t.Errorf("FailedAttempts = %d after signing in, want 0", user.FailedAttempts)
+	}
+	var stored int
+	if err := userDB.QueryRow("SELECT failed_attempts FROM users").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Errorf("stored failed_attempts = %d after signing in, want 0", stored)
+	}
+
+	if _, err := Authenticate("nobody", "wrong"); !errors.Is(err, errInvalidCredentials) {
+		t.Errorf("unknown user: got error %v, want invalid credentials", err)
+	}
+
+	history, err := getLoginHistory("", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reasons := map[string]int{}
+	for _, r := range history {
+		reasons[r.Reason]++
+	}
+	want := map[string]int{
+		"wrong password":                 maxFailedAttempts - 1,
+		"wrong password, account locked": 1,
+		"locked":                         maxFailedAttempts - 1,
+		"":                               1,
+		"unknown user":                   1,
+	}
+	for reason, n := range want {
+		if reasons[reason] != n {
+			t.Errorf("%d login(s) recorded as %q, want %d", reasons[reason], reason, n)
+		}
+	}
+}
+
+func TestRecordFailedLogin(t *testing.T) {
+	newTestDatabases(t)
+	mustAddUser(t, "asha", "correct horse")
+	user, err := appStore.GetUserByUsername("asha")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stored reads the counter and whether the account is blocked now
+	stored := func() (int, bool) {
+		t.Helper()
+		var failures int
+		var lockedUntil sql.NullString
+		if err := userDB.QueryRow("SELECT failed_attempts, locked_until FROM users WHERE id = ?", user.ID).
+			Scan(&failures, &lockedUntil); err != nil {
+			t.Fatal(err)
+		}
+		until, _ := time.Parse(time.RFC3339, lockedUntil.String)
+		return failures, time.Now().Before(until)
+	}
+	set := func(failures int, lockedUntil time.Time) {
+		t.Helper()
+		if _, err := userDB.Exec("UPDATE users SET failed_attempts = ?, locked_until = ? WHERE id = ?",
+			failures, lockedUntil.Format(time.RFC3339), user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Failures counted elsewhere since user was read still count
+	recordFailedLogin(user)
+	recordFailedLogin(user)
+	if failures, blocked := stored(); failures != 2 || !blocked {
+		t.Errorf("after two failures from one read got %d, blocked %v; want 2, true", failures, blocked)
+	}
+
+	set(maxFailedAttempts, time.Now().Add(time.Minute))
+	if err := recordFailedLogin(user); err == nil || !strings.Contains(err.Error(), "account locked") {
+		t.Errorf("during a lockout got error %v, want the account locked", err)
+	}
+	if failures, _ := stored(); failures != maxFailedAttempts+1 {
+		t.Errorf("during a lockout got %d failures, want %d", failures, maxFailedAttempts+1)
+	}
+
+	// Once the lockout has run out the count starts again
+	set(maxFailedAttempts, time.Now().Add(-time.Minute))
+	if err := recordFailedLogin(user); !errors.Is(err, errInvalidCredentials) {
+		t.Errorf("after a lockout got error %v, want invalid credentials", err)
+	}
+	if failures, blocked := stored(); failures != 1 || !blocked {
+		t.Errorf("after a lockout got %d failures, blocked %v; want 1, true", failures, blocked)
+	}
+}
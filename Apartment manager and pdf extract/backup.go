@@ -0,0 +1,726 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/mattn/go-sqlite3"
+
+	"apartment_login/store"
+)
+
+const (
+	backupPrefix       = "backup-"
+	backupExt          = ".zip"
+	backupTimeFormat   = "20060102-150405.000"
+	backupManifestName = "manifest.json"
+	backupReceiptsDir  = "receipts/"
+
+	// backupCheckInterval is how often the scheduler looks for a due backup
+	backupCheckInterval = time.Hour
+)
+
+// BackupManifest is stored in every archive and lists the SHA-256 of each
+// other file so a restore can detect a damaged or altered archive
+type BackupManifest struct {
+	CreatedAt      string            `json:"created_at"`
+	CreatedBy      string            `json:"created_by"`
+	SchemaVersions map[string]int    `json:"schema_versions"`
+	Files          map[string]string `json:"files"`
+}
+
+// BackupInfo describes an archive in the backup directory
+type BackupInfo struct {
+	Path      string
+	Name      string
+	Size      int64
+	CreatedAt time.Time
+}
+
+// backupMu serialises backups and restores, which may also run from the scheduler
+var backupMu sync.Mutex
+
+// createBackup writes a new archive to the backup directory, removes archives
+// beyond the retention count and returns the new archive's path
+func createBackup() (string, error) {
+	if err := requirePermission(PermManageBackups); err != nil {
+		return "", err
+	}
+
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	path, err := writeBackup(currentUser.Username)
+	if err != nil {
+		return "", err
+	}
+	if err := pruneBackups(config.Backup.Keep); err != nil {
+		log.Println("Error pruning old backups:", err)
+	}
+	return path, recordAudit(auditEntityBackup, filepath.Base(path), auditActionCreate, nil, nil)
+}
+
+// writeBackup snapshots both databases with the SQLite online backup API,
+// so the application can keep running, and archives them together with the
+// receipt folder. createdBy goes in the manifest, with an empty name recorded
+// as system. The caller must hold backupMu.
+func writeBackup(createdBy string) (string, error) {
+	if err := os.MkdirAll(config.Backup.Dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	staging, err := os.MkdirTemp(config.Backup.Dir, "staging-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+
+	manifest := BackupManifest{
+		CreatedAt:      time.Now().Format(time.RFC3339),
+		CreatedBy:      createdBy,
+		SchemaVersions: map[string]int{},
+		Files:          map[string]string{},
+	}
+	if manifest.CreatedBy == "" {
+		manifest.CreatedBy = auditActorSystem
+	}
+
+	for _, d := range backupDatabases() {
+		if err := snapshotDatabase(d.db, filepath.Join(staging, d.name)); err != nil {
+			return "", fmt.Errorf("failed to snapshot %s: %w", d.name, err)
+		}
+		version, err := store.SchemaVersion(d.db)
+		if err != nil {
+			return "", err
+		}
+		manifest.SchemaVersions[d.name] = version
+	}
+
+	name := backupPrefix + time.Now().Format(backupTimeFormat) + backupExt
+	final := filepath.Join(config.Backup.Dir, name)
+	partial := final + ".partial"
+
+	file, err := os.OpenFile(partial, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	archive := zip.NewWriter(file)
+
+	fail := func(err error) (string, error) {
+		archive.Close()
+		file.Close()
+		os.Remove(partial)
+		return "", err
+	}
+
+	for _, d := range backupDatabases() {
+		if err := addFileToArchive(archive, &manifest, d.name, filepath.Join(staging, d.name)); err != nil {
+			return fail(err)
+		}
+	}
+
+	err = filepath.WalkDir(config.ReceiptDir, func(path string, entry os.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return filepath.SkipDir
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(config.ReceiptDir, path)
+		if err != nil {
+			return err
+		}
+		return addFileToArchive(archive, &manifest, backupReceiptsDir+filepath.ToSlash(rel), path)
+	})
+	if err != nil {
+		return fail(fmt.Errorf("failed to archive receipts: %w", err))
+	}
+
+	manifestWriter, err := archive.Create(backupManifestName)
+	if err != nil {
+		return fail(err)
+	}
+	encoder := json.NewEncoder(manifestWriter)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return fail(err)
+	}
+
+	if err := archive.Close(); err != nil {
+		return fail(err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	if err := os.Rename(partial, final); err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+
+	log.Println("Backup written to", final)
+	return final, nil
+}
+
+// backupDatabase is a database included in every archive
+type backupDatabase struct {
+	name       string
+	db         *sql.DB
+	migrations []store.Migration
+}
+
+func backupDatabases() []backupDatabase {
+	return []backupDatabase{
+		{"app.db", userDB, store.AppMigrations},
+		{"resident.db", apartmentDB, store.ResidentMigrations},
+	}
+}
+
+// snapshotDatabase copies a live database to destPath page by page
+func snapshotDatabase(src *sql.DB, destPath string) error {
+	dest, err := sql.Open("sqlite3", destPath)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	ctx := context.Background()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriver any) error {
+		return srcConn.Raw(func(srcDriver any) error {
+			backup, err := destDriver.(*sqlite3.SQLiteConn).Backup("main", srcDriver.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return err
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+func addFileToArchive(archive *zip.Writer, manifest *BackupManifest, name, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, hash), src); err != nil {
+		return err
+	}
+	manifest.Files[name] = hex.EncodeToString(hash.Sum(nil))
+	return nil
+}
+
+// listBackups returns the archives in the backup directory, newest first
+func listBackups() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(config.Backup.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []BackupInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupExt) {
+			continue
+		}
+		created, err := time.ParseInLocation(backupTimeFormat,
+			strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupExt), time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, BackupInfo{
+			Path:      filepath.Join(config.Backup.Dir, name),
+			Name:      name,
+			Size:      info.Size(),
+			CreatedAt: created,
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+// pruneBackups deletes all but the newest keep archives; zero keeps everything.
+// Removals follow the retention setting rather than anyone's request, so
+// they are audited as system.
+func pruneBackups(keep int) error {
+	if keep == 0 {
+		return nil
+	}
+	backups, err := listBackups()
+	if err != nil {
+		return err
+	}
+	for i := keep; i < len(backups); i++ {
+		if err := os.Remove(backups[i].Path); err != nil {
+			return err
+		}
+		log.Println("Removed old backup", backups[i].Name)
+		if err := recordSystemAudit(auditEntityBackup, backups[i].Name, auditActionDelete, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startBackupScheduler takes a backup whenever the newest one is older than
+// the configured interval, checking at startup and then every hour
+func startBackupScheduler() {
+	if config.Backup.IntervalHours == 0 {
+		return
+	}
+	go func() {
+		runScheduledBackup()
+		ticker := time.NewTicker(backupCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			runScheduledBackup()
+		}
+	}()
+}
+
+func runScheduledBackup() {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	backups, err := listBackups()
+	if err != nil {
+		log.Println("Error listing backups:", err)
+		return
+	}
+	interval := time.Duration(config.Backup.IntervalHours) * time.Hour
+	if len(backups) > 0 && time.Since(backups[0].CreatedAt) < interval {
+		return
+	}
+
+	path, err := writeBackup(auditActorSystem)
+	if err != nil {
+		log.Println("Scheduled backup failed:", err)
+		return
+	}
+	if err := pruneBackups(config.Backup.Keep); err != nil {
+		log.Println("Error pruning old backups:", err)
+	}
+	// A lost audit entry is already logged and there is no one to tell
+	_ = recordSystemAudit(auditEntityBackup, filepath.Base(path), auditActionCreate, nil, nil)
+}
+
+// extractBackup verifies an archive against its manifest and unpacks it into
+// dir. Both databases must be present, pass PRAGMA integrity_check and be at
+// a schema version this build can migrate.
+func extractBackup(path, dir string) (BackupManifest, error) {
+	var manifest BackupManifest
+
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return manifest, fmt.Errorf("not a valid backup archive: %w", err)
+	}
+	defer archive.Close()
+
+	manifestFile, err := archive.Open(backupManifestName)
+	if err != nil {
+		return manifest, errors.New("backup archive has no manifest")
+	}
+	err = json.NewDecoder(manifestFile).Decode(&manifest)
+	manifestFile.Close()
+	if err != nil {
+		return manifest, fmt.Errorf("backup manifest is unreadable: %w", err)
+	}
+
+	extracted := map[string]bool{}
+	for _, f := range archive.File {
+		if f.Name == backupManifestName {
+			continue
+		}
+		want, ok := manifest.Files[f.Name]
+		if !ok {
+			return manifest, fmt.Errorf("backup contains %s, which is not in its manifest", f.Name)
+		}
+		if !filepath.IsLocal(f.Name) {
+			return manifest, fmt.Errorf("backup contains an unsafe path: %s", f.Name)
+		}
+		got, err := extractArchiveFile(f, filepath.Join(dir, filepath.FromSlash(f.Name)))
+		if err != nil {
+			return manifest, err
+		}
+		if got != want {
+			return manifest, fmt.Errorf("checksum mismatch for %s; the backup is damaged", f.Name)
+		}
+		extracted[f.Name] = true
+	}
+	for name := range manifest.Files {
+		if !extracted[name] {
+			return manifest, fmt.Errorf("backup is missing %s", name)
+		}
+	}
+
+	for _, d := range backupDatabases() {
+		if !extracted[d.name] {
+			return manifest, fmt.Errorf("backup does not contain %s", d.name)
+		}
+		if err := checkBackupDatabase(filepath.Join(dir, d.name), d.migrations); err != nil {
+			return manifest, fmt.Errorf("%s in backup: %w", d.name, err)
+		}
+	}
+	return manifest, nil
+}
+
+func extractArchiveFile(f *zip.File, dest string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+		return "", err
+	}
+	src, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hash), src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w", f.Name, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func checkBackupDatabase(path string, migrations []store.Migration) error {
+	db, err := sql.Open("sqlite3", path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+
+	version, err := store.SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than this build supports (%d)", version, len(migrations))
+	}
+	return nil
+}
+
+// restoreBackup validates an archive and swaps its databases and receipts in
+// place of the current ones. The current data is backed up first so the
+// restore itself can be undone. Older schemas are migrated on reopen.
+func restoreBackup(path string) (BackupManifest, error) {
+	if err := requirePermission(PermManageBackups); err != nil {
+		return BackupManifest{}, err
+	}
+
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	// Stage next to the databases so the final rename stays on one filesystem
+	staging, err := os.MkdirTemp(config.DataDir, "restore-")
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	defer os.RemoveAll(staging)
+
+	manifest, err := extractBackup(path, staging)
+	if err != nil {
+		return manifest, err
+	}
+
+	safety, err := writeBackup(currentUser.Username)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to back up current data before restoring: %w", err)
+	}
+
+	userDB.Close()
+	apartmentDB.Close()
+
+	if err := swapDatabases(staging); err != nil {
+		if reopenErr := openDatabases(); reopenErr != nil {
+			log.Println("Error reopening databases:", reopenErr)
+		}
+		return manifest, fmt.Errorf("failed to replace the databases (current data saved in %s): %w", safety, err)
+	}
+
+	if err := openDatabases(); err != nil {
+		return manifest, fmt.Errorf("restored data could not be opened (previous data saved in %s): %w", safety, err)
+	}
+
+	receiptsErr := restoreReceipts(staging)
+	if receiptsErr != nil {
+		log.Println("Error restoring receipts:", receiptsErr)
+	}
+
+	log.Printf("Restored %s; previous data saved in %s", path, safety)
+	if err := recordAudit(auditEntityBackup, filepath.Base(path), auditActionRestore,
+		map[string]string{"saved_as": filepath.Base(safety)}, manifest); err != nil {
+		return manifest, err
+	}
+	if receiptsErr != nil {
+		return manifest, fmt.Errorf("the databases were restored, but restoring the receipts failed: %w", receiptsErr)
+	}
+	return manifest, nil
+}
+
+// swapDatabases puts the staged databases in place, app.db first. The current
+// files and their -wal, -shm and -journal files are moved into the staging
+// directory beforehand, so if any rename fails every file already moved is
+// put back and the current data stays in place.
+func swapDatabases(staging string) error {
+	aside := filepath.Join(staging, "current")
+	if err := os.Mkdir(aside, 0o700); err != nil {
+		return err
+	}
+
+	type move struct{ from, to string }
+	var moved []move
+	rename := func(from, to string) error {
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+		moved = append(moved, move{from, to})
+		return nil
+	}
+
+	targets := []struct{ name, path string }{
+		{"app.db", appDBPath()},
+		{"resident.db", residentDBPath()},
+	}
+	err := func() error {
+		for _, target := range targets {
+			for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+				if !fileExists(target.path + suffix) {
+					continue
+				}
+				if err := rename(target.path+suffix, filepath.Join(aside, target.name+suffix)); err != nil {
+					return err
+				}
+			}
+			if err := rename(filepath.Join(staging, target.name), target.path); err != nil {
+				return fmt.Errorf("failed to replace %s: %w", target.name, err)
+			}
+		}
+		return nil
+	}()
+	if err == nil {
+		return nil
+	}
+
+	for i := len(moved) - 1; i >= 0; i-- {
+		if undoErr := os.Rename(moved[i].to, moved[i].from); undoErr != nil {
+			log.Printf("Failed to move %s back to %s: %v", moved[i].to, moved[i].from, undoErr)
+		}
+	}
+	return err
+}
+
+// restoreReceipts copies the receipts in the staging directory into the
+// receipt directory, which may be on another filesystem. Newer receipts not
+// in the backup are kept.
+func restoreReceipts(staging string) error {
+	receipts := filepath.Join(staging, filepath.FromSlash(strings.TrimSuffix(backupReceiptsDir, "/")))
+	return filepath.WalkDir(receipts, func(path string, entry os.DirEntry, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return filepath.SkipDir
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(receipts, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(config.ReceiptDir, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return copyFile(path, target)
+	})
+}
+
+// copyFile copies src to dest, replacing dest if it exists
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %w", filepath.Base(src), err)
+	}
+	return nil
+}
+
+// Backup Manager UI
+func ShowBackupManager(myApp fyne.App, previousWindow fyne.Window) {
+	backupWindow := myApp.NewWindow("Backup & Restore")
+	backupWindow.Resize(fyne.NewSize(600, 450))
+
+	var backups []BackupInfo
+	var selected *BackupInfo
+
+	backupsList := widget.NewList(
+		func() int { return len(backups) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			b := backups[id]
+			obj.(*widget.Label).SetText(fmt.Sprintf("%s  (%.1f KB)",
+				b.CreatedAt.Format("2006-01-02 15:04:05"), float64(b.Size)/1024))
+		},
+	)
+	backupsList.OnSelected = func(id widget.ListItemID) {
+		selected = &backups[id]
+	}
+
+	refreshList := func() {
+		var err error
+		backups, err = listBackups()
+		if err != nil {
+			dialog.ShowError(err, backupWindow)
+		}
+		selected = nil
+		backupsList.UnselectAll()
+		backupsList.Refresh()
+	}
+
+	schedule := "Automatic backups are off"
+	if config.Backup.IntervalHours > 0 {
+		schedule = fmt.Sprintf("Automatic backup every %d hour(s)", config.Backup.IntervalHours)
+		if config.Backup.Keep > 0 {
+			schedule += fmt.Sprintf(", keeping the newest %d", config.Backup.Keep)
+		}
+	}
+	infoLabel := widget.NewLabel(fmt.Sprintf("%s\nBackups are saved in %s", schedule, config.Backup.Dir))
+
+	backupNowButton := widget.NewButtonWithIcon("Back Up Now", theme.DocumentSaveIcon(), func() {
+		path, err := createBackup()
+		if err != nil {
+			dialog.ShowError(err, backupWindow)
+		} else {
+			dialog.ShowInformation("Success", "Backup saved to "+path, backupWindow)
+		}
+		refreshList()
+	})
+
+	restore := func(path string) {
+		message := fmt.Sprintf("Replace all current data with %s?\n"+
+			"The current data is backed up first. You will be signed out afterwards.", filepath.Base(path))
+		dialog.ShowConfirm("Restore Backup", message, func(ok bool) {
+			if !ok {
+				return
+			}
+			manifest, err := restoreBackup(path)
+			if err != nil {
+				dialog.ShowError(err, backupWindow)
+				refreshList()
+				return
+			}
+			info := dialog.NewInformation("Restore Complete",
+				fmt.Sprintf("Restored the backup taken %s by %s. Please sign in again.",
+					manifest.CreatedAt, manifest.CreatedBy), backupWindow)
+			info.SetOnClosed(logout)
+			info.Show()
+		}, backupWindow)
+	}
+
+	restoreSelectedButton := widget.NewButtonWithIcon("Restore Selected", theme.HistoryIcon(), func() {
+		if selected == nil {
+			dialog.ShowError(errors.New("select a backup first"), backupWindow)
+			return
+		}
+		restore(selected.Path)
+	})
+
+	restoreFileButton := widget.NewButtonWithIcon("Restore From File", theme.FolderOpenIcon(), func() {
+		fd := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil || reader == nil {
+				return
+			}
+			path := reader.URI().Path()
+			reader.Close()
+			restore(path)
+		}, backupWindow)
+		fd.Show()
+	})
+
+	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
+		backupWindow.Hide()
+		previousWindow.Show()
+	})
+
+	refreshList()
+
+	controls := container.NewVBox(
+		infoLabel,
+		container.NewHBox(backupNowButton, restoreSelectedButton, restoreFileButton, backButton),
+	)
+	showSessionWindow(backupWindow, container.NewBorder(controls, nil, nil, nil, backupsList))
+}
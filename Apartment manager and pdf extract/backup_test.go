@@ -0,0 +1,435 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"apartment_login/store"
+)
+
+// newTestBackupEnv points both databases and the data, receipt and backup
+// directories at a temporary directory
+func newTestBackupEnv(t *testing.T) {
+	t.Helper()
+	keepConfig(t)
+	newTestDatabases(t)
+
+	dir := t.TempDir()
+	config.DataDir = dir
+	config.ReceiptDir = filepath.Join(dir, "receipts")
+	config.Backup = BackupConfig{Dir: filepath.Join(dir, "backups")}
+}
+
+// mustWriteBackup writes an archive of the test databases and one receipt
+func mustWriteBackup(t *testing.T) string {
+	t.Helper()
+	mustAddUser(t, "admin", "correct horse")
+	receipt := filepath.Join(config.ReceiptDir, "2024", "receipt-1.pdf")
+	if err := os.MkdirAll(filepath.Dir(receipt), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(receipt, []byte("%PDF receipt"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path, err := writeBackup("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// rewriteBackup copies the archive at path, letting edit replace, drop (by
+// returning nil) or keep each file, and appending extra files at the end
+func rewriteBackup(t *testing.T, path string, edit func(name string, data []byte) []byte, extra map[string][]byte) string {
+	t.Helper()
+	src, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	out := filepath.Join(t.TempDir(), "edited"+backupExt)
+	file, err := os.Create(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	archive := zip.NewWriter(file)
+	write := func(name string, data []byte) {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range src.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data = edit(f.Name, data); data != nil {
+			write(f.Name, data)
+		}
+	}
+	for name, data := range extra {
+		write(name, data)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// editManifest returns an edit function changing only the manifest
+func editManifest(t *testing.T, change func(*BackupManifest)) func(string, []byte) []byte {
+	return func(name string, data []byte) []byte {
+		if name != backupManifestName {
+			return data
+		}
+		var manifest BackupManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			t.Fatal(err)
+		}
+		change(&manifest)
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+}
+
+func TestExtractBackup(t *testing.T) {
+	newTestBackupEnv(t)
+	path := mustWriteBackup(t)
+
+	dir := t.TempDir()
+	manifest, err := extractBackup(path, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.CreatedBy != "system" {
+		t.Errorf("created by %q, want system when nobody is signed in", manifest.CreatedBy)
+	}
+	if manifest.SchemaVersions["app.db"] != len(store.AppMigrations) ||
+		manifest.SchemaVersions["resident.db"] != len(store.ResidentMigrations) {
+		t.Errorf("schema versions %v", manifest.SchemaVersions)
+	}
+	if _, ok := manifest.Files["receipts/2024/receipt-1.pdf"]; !ok {
+		t.Errorf("receipt missing from manifest %v", manifest.Files)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "receipts", "2024", "receipt-1.pdf"))
+	if err != nil || string(data) != "%PDF receipt" {
+		t.Errorf("extracted receipt %q, %v", data, err)
+	}
+	if err := checkBackupDatabase(filepath.Join(dir, "app.db"), store.AppMigrations); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractBackupRejects(t *testing.T) {
+	newTestBackupEnv(t)
+	path := mustWriteBackup(t)
+
+	keep := func(name string, data []byte) []byte { return data }
+	tests := []struct {
+		name  string
+		edit  func(string, []byte) []byte
+		extra map[string][]byte
+		want  string
+	}{
+		{
+			name: "altered database",
+			edit: func(name string, data []byte) []byte {
+				if name == "app.db" {
+					data = append([]byte{}, data...)
+					data[len(data)-1] ^= 0xff
+				}
+				return data
+			},
+			want: "checksum mismatch for app.db",
+		},
+		{
+			name: "altered receipt",
+			edit: func(name string, data []byte) []byte {
+				if strings.HasPrefix(name, backupReceiptsDir) {
+					return []byte("forged")
+				}
+				return data
+			},
+			want: "checksum mismatch for receipts/2024/receipt-1.pdf",
+		},
+		{
+			name:  "file not in manifest",
+			edit:  keep,
+			extra: map[string][]byte{"receipts/extra.pdf": []byte("x")},
+			want:  "not in its manifest",
+		},
+		{
+			name: "file missing",
+			edit: func(name string, data []byte) []byte {
+				if strings.HasPrefix(name, backupReceiptsDir) {
+					return nil
+				}
+				return data
+			},
+			want: "backup is missing receipts/2024/receipt-1.pdf",
+		},
+		{
+			name: "no manifest",
+			edit: func(name string, data []byte) []byte {
+				if name == backupManifestName {
+					return nil
+				}
+				return data
+			},
+			want: "no manifest",
+		},
+		{
+			name: "database missing",
+			edit: func(name string, data []byte) []byte {
+				if name == "resident.db" {
+					return nil
+				}
+				return editManifest(t, func(m *BackupManifest) { delete(m.Files, "resident.db") })(name, data)
+			},
+			want: "backup does not contain resident.db",
+		},
+		{
+			name: "unsafe path",
+			edit: editManifest(t, func(m *BackupManifest) {
+				m.Files["../escape.pdf"] = "unused"
+			}),
+			extra: map[string][]byte{"../escape.pdf": []byte("x")},
+			want:  "unsafe path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edited := rewriteBackup(t, path, tt.edit, tt.extra)
+			_, err := extractBackup(edited, t.TempDir())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractBackupNewerSchema(t *testing.T) {
+	newTestBackupEnv(t)
+	if _, err := apartmentDB.Exec("PRAGMA user_version = 999"); err != nil {
+		t.Fatal(err)
+	}
+	path := mustWriteBackup(t)
+
+	_, err := extractBackup(path, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "resident.db in backup: schema version 999") {
+		t.Fatalf("got error %v, want the newer schema refused", err)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	newTestBackupEnv(t)
+	currentUser = User{ID: 1, Username: "admin", Role: RoleAdmin}
+	t.Cleanup(func() { currentUser = User{} })
+	if err := os.MkdirAll(config.Backup.Dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 4, 1, 9, 0, 0, 0, time.Local)
+	for i := 0; i < 5; i++ {
+		name := backupPrefix + start.Add(time.Duration(i)*time.Hour).Format(backupTimeFormat) + backupExt
+		if err := os.WriteFile(filepath.Join(config.Backup.Dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Files that are not archives are left alone
+	other := filepath.Join(config.Backup.Dir, "notes.txt")
+	if err := os.WriteFile(other, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pruneBackups(0); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ := listBackups(); len(backups) != 5 {
+		t.Fatalf("keep 0 left %d backups, want all 5", len(backups))
+	}
+
+	if err := pruneBackups(2); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := listBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || !backups[0].CreatedAt.Equal(start.Add(4*time.Hour)) ||
+		!backups[1].CreatedAt.Equal(start.Add(3*time.Hour)) {
+		t.Fatalf("kept %+v, want the newest two", backups)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("pruning removed an unrelated file: %v", err)
+	}
+
+	// Pruning follows the retention setting, so it is not the signed-in user's doing
+	entries, err := getAuditEntries(AuditFilter{Entity: auditEntityBackup, Action: auditActionDelete})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("got %d audit entries, want one per removed backup", len(entries))
+	}
+	for _, e := range entries {
+		if e.Actor != auditActorSystem {
+			t.Errorf("removal of %s audited as %q, want %q", e.EntityID, e.Actor, auditActorSystem)
+		}
+	}
+}
+
+func TestRunScheduledBackup(t *testing.T) {
+	newTestBackupEnv(t)
+	currentUser = User{ID: 1, Username: "admin", Role: RoleAdmin}
+	t.Cleanup(func() { currentUser = User{} })
+	config.Backup.IntervalHours = 24
+	config.Backup.Keep = 1
+
+	if err := os.MkdirAll(config.Backup.Dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	oldName := backupPrefix + time.Now().Add(-48*time.Hour).Format(backupTimeFormat) + backupExt
+	if err := os.WriteFile(filepath.Join(config.Backup.Dir, oldName), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	runScheduledBackup()
+	backups, err := listBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Name == oldName {
+		t.Fatalf("got backups %+v, want only a new one", backups)
+	}
+	dir := t.TempDir()
+	manifest, err := extractBackup(backups[0].Path, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.CreatedBy != auditActorSystem {
+		t.Errorf("manifest created by %q, want %q", manifest.CreatedBy, auditActorSystem)
+	}
+
+	entries, err := getAuditEntries(AuditFilter{Entity: auditEntityBackup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{backups[0].Name: auditActionCreate, oldName: auditActionDelete}
+	if len(entries) != len(want) {
+		t.Fatalf("got audit entries %+v, want the new backup and the removed one", entries)
+	}
+	for _, e := range entries {
+		if want[e.EntityID] != e.Action || e.Actor != auditActorSystem {
+			t.Errorf("got %s %s by %q, want %s by %q", e.Action, e.EntityID, e.Actor, want[e.EntityID], auditActorSystem)
+		}
+	}
+
+	// The new backup is recent, so the next check does nothing
+	runScheduledBackup()
+	if backups, _ := listBackups(); len(backups) != 1 {
+		t.Errorf("got %d backups after a second check, want 1", len(backups))
+	}
+}
+
+func TestSwapDatabases(t *testing.T) {
+	keepConfig(t)
+	config.DataDir = t.TempDir()
+
+	// writeFiles writes each file under dir with its name and tag as contents
+	writeFiles := func(dir, tag string, names ...string) {
+		t.Helper()
+		for _, name := range names {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(name+" "+tag), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// contents reads a file, or returns "" when it is missing
+	contents := func(path string) string {
+		data, _ := os.ReadFile(path)
+		return string(data)
+	}
+	writeFiles(config.DataDir, "current", "app.db", "app.db-wal", "resident.db")
+
+	// resident.db is missing from the staging directory, so app.db, swapped
+	// first, is put back with its WAL
+	staging := t.TempDir()
+	writeFiles(staging, "restored", "app.db")
+	if err := swapDatabases(staging); err == nil || !strings.Contains(err.Error(), "resident.db") {
+		t.Fatalf("got error %v, want one naming resident.db", err)
+	}
+	for _, name := range []string{"app.db", "app.db-wal", "resident.db"} {
+		if got := contents(filepath.Join(config.DataDir, name)); got != name+" current" {
+			t.Errorf("after a failed swap %s holds %q", name, got)
+		}
+	}
+	if got := contents(filepath.Join(staging, "app.db")); got != "app.db restored" {
+		t.Errorf("after a failed swap the staged app.db holds %q", got)
+	}
+
+	staging = t.TempDir()
+	writeFiles(staging, "restored", "app.db", "resident.db")
+	if err := swapDatabases(staging); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"app.db", "resident.db"} {
+		if got := contents(filepath.Join(config.DataDir, name)); got != name+" restored" {
+			t.Errorf("after swapping %s holds %q", name, got)
+		}
+		if got := contents(filepath.Join(staging, "current", name)); got != name+" current" {
+			t.Errorf("the current %s was not set aside, got %q", name, got)
+		}
+	}
+	if fileExists(filepath.Join(config.DataDir, "app.db-wal")) {
+		t.Error("the current WAL was left beside the restored app.db")
+	}
+}
+
+func TestRestoreReceipts(t *testing.T) {
+	keepConfig(t)
+	config.ReceiptDir = t.TempDir()
+	staging := t.TempDir()
+
+	for path, data := range map[string]string{
+		filepath.Join(staging, "receipts", "2024", "receipt-1.pdf"): "restored",
+		filepath.Join(config.ReceiptDir, "2024", "receipt-1.pdf"):   "current",
+		filepath.Join(config.ReceiptDir, "2025", "receipt-2.pdf"):   "newer",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := restoreReceipts(staging); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"2024/receipt-1.pdf": "restored", "2025/receipt-2.pdf": "newer"} {
+		data, err := os.ReadFile(filepath.Join(config.ReceiptDir, filepath.FromSlash(name)))
+		if err != nil || string(data) != want {
+			t.Errorf("%s holds %q (%v), want %q", name, data, err, want)
+		}
+	}
+}
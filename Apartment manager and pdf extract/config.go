@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"apartment_login/money"
+)
+
+// appDirName is the per-user directory holding the config file and data
+const appDirName = "apartment-manager"
+
+// Environment variables overriding the config file
+const (
+	envConfigFile        = "APARTMENT_CONFIG"
+	envDataDir           = "APARTMENT_DATA_DIR"
+	envReceiptDir        = "APARTMENT_RECEIPT_DIR"
+	envSocietyName       = "APARTMENT_SOCIETY_NAME"
+	envSocietyAddress    = "APARTMENT_SOCIETY_ADDRESS"
+	envSocietyRegNumber  = "APARTMENT_SOCIETY_REGISTRATION"
+	envCurrencySymbol    = "APARTMENT_CURRENCY"
+	envMaintenanceAmount = "APARTMENT_MAINTENANCE_AMOUNT"
+	envBackupDir         = "APARTMENT_BACKUP_DIR"
+)
+
+// Config holds the installation settings read from config.toml.
+// Precedence, highest first: command-line flags, environment variables,
+// the config file, then the defaults from defaultConfig. Not every setting
+// has a flag or environment variable; see registerConfigFlags and the env
+// constants.
+type Config struct {
+	DataDir                  string        `toml:"data_dir"`
+	ReceiptDir               string        `toml:"receipt_dir"`
+	CurrencySymbol           string        `toml:"currency_symbol"`
+	DefaultMaintenanceAmount float64       `toml:"default_maintenance_amount"`
+	Society                  SocietyConfig `toml:"society"`
+	Backup                   BackupConfig  `toml:"backup"`
+	RecycleBin               BinConfig     `toml:"recycle_bin"`
+}
+
+// SocietyConfig describes the first society; it seeds the society record
+// when an installation is upgraded to support several societies
+type SocietyConfig struct {
+	Name               string `toml:"name"`
+	Address            string `toml:"address"`
+	RegistrationNumber string `toml:"registration_number"`
+}
+
+// BackupConfig controls where backups go and how often they are taken
+type BackupConfig struct {
+	Dir           string `toml:"dir"`
+	IntervalHours int    `toml:"interval_hours"`
+	Keep          int    `toml:"keep"`
+}
+
+// BinConfig controls how long deleted rows stay in the recycle bin before an
+// administrator may purge them
+type BinConfig struct {
+	RetentionDays int `toml:"retention_days"`
+}
+
+// config is the configuration in effect, set by loadConfig at startup
+var config = defaultConfig()
+
+// configFlags are the command-line overrides registered by registerConfigFlags
+type configFlags struct {
+	configFile  *string
+	dataDir     *string
+	receiptDir  *string
+	currency    *string
+	maintenance *float64
+	backupDir   *string
+}
+
+// defaultConfigDir returns the per-user directory under os.UserConfigDir,
+// falling back to the working directory when it cannot be determined
+func defaultConfigDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		log.Println("Could not determine user config directory:", err)
+		return "."
+	}
+	return filepath.Join(dir, appDirName)
+}
+
+func defaultConfig() Config {
+	dir := defaultConfigDir()
+	return Config{
+		DataDir:                  dir,
+		ReceiptDir:               filepath.Join(dir, "receipts"),
+		CurrencySymbol:           "₹",
+		DefaultMaintenanceAmount: 4000,
+		Backup: BackupConfig{
+			Dir:           filepath.Join(dir, "backups"),
+			IntervalHours: 24,
+			Keep:          14,
+		},
+		RecycleBin: BinConfig{RetentionDays: 30},
+	}
+}
+
+// defaultConfigFile returns the config file path used when none is given
+func defaultConfigFile() string {
+	if path := os.Getenv(envConfigFile); path != "" {
+		return path
+	}
+	return filepath.Join(defaultConfigDir(), "config.toml")
+}
+
+// registerConfigFlags adds the config overrides to the command line
+func registerConfigFlags() configFlags {
+	return configFlags{
+		configFile:  flag.String("config", defaultConfigFile(), "path to config.toml"),
+		dataDir:     flag.String("data-dir", "", "directory holding app.db and resident.db"),
+		receiptDir:  flag.String("receipt-dir", "", "directory receipts are saved to"),
+		currency:    flag.String("currency", "", "currency symbol shown with amounts"),
+		maintenance: flag.Float64("maintenance", 0, "default monthly maintenance amount"),
+		backupDir:   flag.String("backup-dir", "", "directory backups are written to"),
+	}
+}
+
+// loadConfig reads the config file, applies environment and flag overrides
+// and makes sure the data directory exists. A missing config file is not an
+// error; the defaults are used instead.
+func loadConfig(flags configFlags) error {
+	cfg := defaultConfig()
+
+	path := *flags.configFile
+	if _, err := toml.DecodeFile(path, &cfg); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	// Receipt and backup directories left unset follow a relocated data directory
+	receiptDirSet := cfg.ReceiptDir != defaultConfig().ReceiptDir
+	backupDirSet := cfg.Backup.Dir != defaultConfig().Backup.Dir
+
+	overrides := []struct {
+		env    string
+		target *string
+	}{
+		{envDataDir, &cfg.DataDir},
+		{envReceiptDir, &cfg.ReceiptDir},
+		{envSocietyName, &cfg.Society.Name},
+		{envSocietyAddress, &cfg.Society.Address},
+		{envSocietyRegNumber, &cfg.Society.RegistrationNumber},
+		{envCurrencySymbol, &cfg.CurrencySymbol},
+		{envBackupDir, &cfg.Backup.Dir},
+	}
+	for _, o := range overrides {
+		if value := os.Getenv(o.env); value != "" {
+			*o.target = value
+			switch o.env {
+			case envReceiptDir:
+				receiptDirSet = true
+			case envBackupDir:
+				backupDirSet = true
+			}
+		}
+	}
+	if value := os.Getenv(envMaintenanceAmount); value != "" {
+		amount, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", envMaintenanceAmount, value)
+		}
+		cfg.DefaultMaintenanceAmount = amount
+	}
+
+	if *flags.dataDir != "" {
+		cfg.DataDir = *flags.dataDir
+	}
+	if *flags.receiptDir != "" {
+		cfg.ReceiptDir = *flags.receiptDir
+		receiptDirSet = true
+	}
+	if *flags.backupDir != "" {
+		cfg.Backup.Dir = *flags.backupDir
+		backupDirSet = true
+	}
+	if *flags.currency != "" {
+		cfg.CurrencySymbol = *flags.currency
+	}
+	if *flags.maintenance != 0 {
+		cfg.DefaultMaintenanceAmount = *flags.maintenance
+	}
+
+	if cfg.Backup.IntervalHours < 0 || cfg.Backup.Keep < 0 {
+		return errors.New("backup interval_hours and keep cannot be negative")
+	}
+	if cfg.RecycleBin.RetentionDays < 0 {
+		return errors.New("recycle_bin retention_days cannot be negative")
+	}
+
+	amount, err := money.FromFloat(cfg.DefaultMaintenanceAmount)
+	if err == nil {
+		err = money.Validate(amount)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid default maintenance amount %v: %w", cfg.DefaultMaintenanceAmount, err)
+	}
+
+	if cfg.DataDir == "" {
+		return errors.New("data directory cannot be empty")
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	// Older versions kept their databases in the working directory
+	if cfg.DataDir == defaultConfig().DataDir {
+		cfg.DataDir = adoptLegacyDatabases(cfg.DataDir)
+	}
+
+	if !receiptDirSet {
+		cfg.ReceiptDir = filepath.Join(cfg.DataDir, "receipts")
+	}
+	if !backupDirSet {
+		cfg.Backup.Dir = filepath.Join(cfg.DataDir, "backups")
+	}
+
+	config = cfg
+	money.Symbol = config.CurrencySymbol
+	log.Println("Using data directory", config.DataDir)
+	if dataDirNotice != "" {
+		log.Println(dataDirNotice)
+	}
+	return nil
+}
+
+// legacyDataDir is where versions before the config file kept their databases
+const legacyDataDir = "."
+
+// dataDirNotice tells the user, once, on the first window shown that the
+// databases were moved from, or are still used from, legacyDataDir. It is
+// empty when nothing had to be done.
+var dataDirNotice string
+
+// databaseFiles lists a database and the WAL and shared-memory files SQLite
+// keeps beside it
+func databaseFiles(name string) []string {
+	return []string{name, name + "-wal", name + "-shm"}
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// adoptLegacyDatabases moves app.db and resident.db from legacyDataDir into
+// dataDir when dataDir has neither. If they cannot be moved, nothing is left
+// half-moved and legacyDataDir stays the data directory. It returns the data
+// directory to use.
+func adoptLegacyDatabases(dataDir string) string {
+	legacy, err := filepath.Abs(legacyDataDir)
+	if err != nil {
+		return dataDir
+	}
+	target, err := filepath.Abs(dataDir)
+	if err != nil || target == legacy {
+		return dataDir
+	}
+
+	var found []string
+	for _, name := range []string{"app.db", "resident.db"} {
+		if fileExists(filepath.Join(target, name)) {
+			return dataDir
+		}
+		for _, file := range databaseFiles(name) {
+			if fileExists(filepath.Join(legacy, file)) {
+				found = append(found, file)
+			}
+		}
+	}
+	if len(found) == 0 {
+		return dataDir
+	}
+
+	for i, file := range found {
+		if err := os.Rename(filepath.Join(legacy, file), filepath.Join(target, file)); err != nil {
+			for _, moved := range found[:i] {
+				if err := os.Rename(filepath.Join(target, moved), filepath.Join(legacy, moved)); err != nil {
+					log.Printf("Failed to move %s back to %s: %v", moved, legacy, err)
+				}
+			}
+			dataDirNotice = fmt.Sprintf("The databases in %s could not be moved to %s (%v), so they are "+
+				"still used from there. Pass -data-dir or set %s to choose where they live.",
+				legacy, target, err, envDataDir)
+			return legacy
+		}
+	}
+	dataDirNotice = fmt.Sprintf("The databases were moved from %s to the data directory %s.", legacy, target)
+	return dataDir
+}
+
+// appDBPath is the accounts, settings and audit database
+func appDBPath() string {
+	return filepath.Join(config.DataDir, "app.db")
+}
+
+// residentDBPath is the apartments, collections and payments database
+func residentDBPath() string {
+	return filepath.Join(config.DataDir, "resident.db")
+}
+
+// societyName returns the configured society name, falling back to the name
+// entered during first-run setup
+func societyName() string {
+	if config.Society.Name != "" {
+		return config.Society.Name
+	}
+	return getSetting(settingSocietyName)
+}
+
+// defaultMaintenance returns the monthly maintenance charge per apartment
+func defaultMaintenance() money.Money {
+	amount, err := money.FromFloat(config.DefaultMaintenanceAmount)
+	if err != nil {
+		log.Println("Invalid default maintenance amount:", err)
+	}
+	return amount
+}
+
+// binRetention is how long deleted rows must stay in the recycle bin
+func binRetention() time.Duration {
+	return time.Duration(config.RecycleBin.RetentionDays) * 24 * time.Hour
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testConfigFlags returns flags as if none were given on the command line,
+// reading the config file at path
+func testConfigFlags(path string) configFlags {
+	var (
+		dataDir, receiptDir, currency, backupDir string
+		maintenance                              float64
+	)
+	return configFlags{
+		configFile:  &path,
+		dataDir:     &dataDir,
+		receiptDir:  &receiptDir,
+		currency:    &currency,
+		maintenance: &maintenance,
+		backupDir:   &backupDir,
+	}
+}
+
+// writeConfigFile writes contents to a config.toml in a temporary directory
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// keepConfig restores the configuration in effect when the test ends
+func keepConfig(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	keepConfig(t)
+	fileDir := t.TempDir()
+	envDir := t.TempDir()
+	flagDir := t.TempDir()
+	path := writeConfigFile(t, `
+data_dir = "`+filepath.ToSlash(fileDir)+`"
+currency_symbol = "Rs."
+default_maintenance_amount = 3500
+
+[society]
+name = "Green Acres"
+address = "1 Lake Road"
+`)
+
+	// The file alone
+	if err := loadConfig(testConfigFlags(path)); err != nil {
+		t.Fatal(err)
+	}
+	if config.DataDir != fileDir || config.CurrencySymbol != "Rs." ||
+		config.DefaultMaintenanceAmount != 3500 || config.Society.Name != "Green Acres" {
+		t.Fatalf("file values not applied: %+v", config)
+	}
+	if want := filepath.Join(fileDir, "receipts"); config.ReceiptDir != want {
+		t.Errorf("receipt dir %q, want %q beside the data", config.ReceiptDir, want)
+	}
+
+	// The environment overrides the file
+	t.Setenv(envDataDir, envDir)
+	t.Setenv(envSocietyName, "Blue Meadows")
+	t.Setenv(envMaintenanceAmount, "4500")
+	if err := loadConfig(testConfigFlags(path)); err != nil {
+		t.Fatal(err)
+	}
+	if config.DataDir != envDir || config.Society.Name != "Blue Meadows" ||
+		config.DefaultMaintenanceAmount != 4500 {
+		t.Fatalf("environment did not override the file: %+v", config)
+	}
+	if config.Society.Address != "1 Lake Road" || config.CurrencySymbol != "Rs." {
+		t.Errorf("file values without overrides lost: %+v", config)
+	}
+
+	// Flags override the environment
+	flags := testConfigFlags(path)
+	*flags.dataDir = flagDir
+	*flags.maintenance = 5000
+	*flags.currency = "INR "
+	if err := loadConfig(flags); err != nil {
+		t.Fatal(err)
+	}
+	if config.DataDir != flagDir || config.DefaultMaintenanceAmount != 5000 ||
+		config.CurrencySymbol != "INR " || config.Society.Name != "Blue Meadows" {
+		t.Fatalf("flags did not override the environment: %+v", config)
+	}
+	if want := filepath.Join(flagDir, "receipts"); config.ReceiptDir != want {
+		t.Errorf("receipt dir %q, want %q beside the data", config.ReceiptDir, want)
+	}
+	if want := filepath.Join(flagDir, "backups"); config.Backup.Dir != want {
+		t.Errorf("backup dir %q, want %q beside the data", config.Backup.Dir, want)
+	}
+}
+
+func TestLoadConfigReceiptDir(t *testing.T) {
+	keepConfig(t)
+	dataDir := t.TempDir()
+	receiptDir := t.TempDir()
+	path := writeConfigFile(t, `receipt_dir = "`+filepath.ToSlash(receiptDir)+`"`)
+
+	flags := testConfigFlags(path)
+	*flags.dataDir = dataDir
+	if err := loadConfig(flags); err != nil {
+		t.Fatal(err)
+	}
+	if config.ReceiptDir != receiptDir {
+		t.Errorf("receipt dir %q, want the configured %q", config.ReceiptDir, receiptDir)
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	keepConfig(t)
+	flags := testConfigFlags(filepath.Join(t.TempDir(), "missing.toml"))
+	*flags.dataDir = t.TempDir()
+	if err := loadConfig(flags); err != nil {
+		t.Fatalf("missing config file: %v", err)
+	}
+	defaults := defaultConfig()
+	if config.CurrencySymbol != defaults.CurrencySymbol ||
+		config.DefaultMaintenanceAmount != defaults.DefaultMaintenanceAmount {
+		t.Errorf("got %+v, want the defaults", config)
+	}
+}
+
+func TestLoadConfigRejects(t *testing.T) {
+	tests := []struct {
+		name, file, env, want string
+	}{
+		{"malformed file", "data_dir = ", "", "failed to read config"},
+		{"negative amount", "default_maintenance_amount = -1", "", "amount cannot be negative"},
+		{"zero amount", "default_maintenance_amount = 0", "", "greater than zero"},
+		{"amount from env", "", "lots", "invalid " + envMaintenanceAmount},
+		{"NaN from env", "", "NaN", "invalid default maintenance amount"},
+		{"empty data dir", `data_dir = ""`, "", "data directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keepConfig(t)
+			before := config
+			if tt.env != "" {
+				t.Setenv(envMaintenanceAmount, tt.env)
+			}
+			err := loadConfig(testConfigFlags(writeConfigFile(t, tt.file)))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got error %v, want %q", err, tt.want)
+			}
+			if config != before {
+				t.Errorf("a rejected config was put into effect: %+v", config)
+			}
+		})
+	}
+}
+
+func TestAdoptLegacyDatabases(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := t.TempDir()
+	if err := os.Chdir(legacy); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Cleanup(func() { dataDirNotice = "" })
+
+	for _, file := range []string{"app.db", "app.db-wal", "resident.db"} {
+		if err := os.WriteFile(file, []byte(file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A data directory that already has a database is left alone
+	used := t.TempDir()
+	if err := os.WriteFile(filepath.Join(used, "resident.db"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := adoptLegacyDatabases(used); got != used || dataDirNotice != "" || !fileExists("app.db") {
+		t.Errorf("with resident.db in the data directory got %s, notice %q", got, dataDirNotice)
+	}
+
+	empty := t.TempDir()
+	if got := adoptLegacyDatabases(empty); got != empty {
+		t.Errorf("got data directory %s, want %s", got, empty)
+	}
+	for _, file := range []string{"app.db", "app.db-wal", "resident.db"} {
+		if fileExists(file) || !fileExists(filepath.Join(empty, file)) {
+			t.Errorf("%s was not moved into the data directory", file)
+		}
+	}
+	if !strings.Contains(dataDirNotice, "were moved") {
+		t.Errorf("got notice %q, want one saying the databases were moved", dataDirNotice)
+	}
+}
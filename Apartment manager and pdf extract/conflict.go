@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"apartment_login/service"
+)
+
+// showSaveError reports a failed save. When another editor saved the record
+// first, the fields that differ are listed and the user chooses between
+// reloading the saved copy and overwriting it with their own; reload and
+// overwrite receive the saved record. Any other error is shown as is.
+func showSaveError(err error, parent fyne.Window, reload, overwrite func(saved any)) {
+	var conflict *service.ConflictError
+	if !errors.As(err, &conflict) {
+		dialog.ShowError(err, parent)
+		return
+	}
+	if conflict.Saved == nil {
+		dialog.ShowError(err, parent)
+		reload(nil)
+		return
+	}
+
+	grid := container.NewGridWithColumns(3,
+		widget.NewLabelWithStyle("Field", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		widget.NewLabelWithStyle("Yours", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		widget.NewLabelWithStyle("Saved", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+	)
+	for _, diff := range conflict.Diff {
+		grid.Add(widget.NewLabel(diff.Field))
+		grid.Add(widget.NewLabel(diff.Yours))
+		grid.Add(widget.NewLabel(diff.Saved))
+	}
+
+	message := "Nothing you changed differs from the saved copy."
+	if len(conflict.Diff) > 0 {
+		message = "These fields differ from the saved copy:"
+	}
+	content := container.NewVBox(
+		widget.NewLabel(fmt.Sprintf("%s.\n%s", conflict.Error(), message)),
+		grid,
+	)
+
+	dialog.ShowCustomConfirm("Record Changed", "Overwrite", "Reload Saved", content, func(overwriteMine bool) {
+		if overwriteMine {
+			overwrite(conflict.Saved)
+			return
+		}
+		reload(conflict.Saved)
+	}, parent)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"apartment_login/store"
+)
+
+// IntegrityIssue is one problem found by checkIntegrity
+type IntegrityIssue struct {
+	Database string
+	Table    string
+	RowID    string
+	Problem  string
+}
+
+// checkIntegrity verifies both databases and lists rows whose references no
+// longer resolve, including collections orphaned by apartments deleted before
+// foreign keys were enforced
+func checkIntegrity() ([]IntegrityIssue, error) {
+	if err := requirePermission(PermViewAudit); err != nil {
+		return nil, err
+	}
+
+	var issues []IntegrityIssue
+	for _, d := range []struct {
+		name string
+		db   *sql.DB
+	}{{appDBPath(), userDB}, {residentDBPath(), apartmentDB}} {
+		found, err := sqliteIntegrityCheck(d.name, d.db)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, found...)
+	}
+
+	found, err := foreignKeyCheck(appDBPath(), userDB)
+	if err != nil {
+		return nil, err
+	}
+	issues = append(issues, found...)
+
+	found, err = orphanedCollections()
+	if err != nil {
+		return nil, err
+	}
+	issues = append(issues, found...)
+
+	found, err = orphanedApartmentLinks()
+	if err != nil {
+		return nil, err
+	}
+	return append(issues, found...), nil
+}
+
+// sqliteIntegrityCheck reports corruption found by PRAGMA integrity_check
+func sqliteIntegrityCheck(name string, db *sql.DB) ([]IntegrityIssue, error) {
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var issues []IntegrityIssue
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return nil, err
+		}
+		if result != "ok" {
+			issues = append(issues, IntegrityIssue{Database: name, Problem: result})
+		}
+	}
+	return issues, rows.Err()
+}
+
+// foreignKeyCheck reports rows violating a declared foreign key
+func foreignKeyCheck(name string, db *sql.DB) ([]IntegrityIssue, error) {
+	rows, err := db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var issues []IntegrityIssue
+	for rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var fkID int
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return nil, err
+		}
+		issues = append(issues, IntegrityIssue{
+			Database: name,
+			Table:    table,
+			RowID:    fmt.Sprint(rowID.Int64),
+			Problem:  fmt.Sprintf("references a missing row in %s", parent),
+		})
+	}
+	return issues, rows.Err()
+}
+
+// orphanedCollections lists collections whose apartment no longer exists
+func orphanedCollections() ([]IntegrityIssue, error) {
+	rows, err := apartmentDB.Query(
+		`SELECT c.id, c.apartment_id, c.month, c.type, c.price
+		FROM collections c
+		LEFT JOIN apartments a ON a.society_id = c.society_id AND a.id = c.apartment_id
+		WHERE a.id IS NULL ORDER BY c.apartment_id, c.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var issues []IntegrityIssue
+	for rows.Next() {
+		var c Collection
+		if err := rows.Scan(&c.ID, &c.ApartmentID, &c.Month, &c.Type, &c.Price); err != nil {
+			return nil, err
+		}
+		issues = append(issues, IntegrityIssue{
+			Database: residentDBPath(),
+			Table:    "collections",
+			RowID:    fmt.Sprint(c.ID),
+			Problem: fmt.Sprintf("%s %s %s belongs to missing apartment %s",
+				c.Month, c.Type, c.Price, c.ApartmentID),
+		})
+	}
+	return issues, rows.Err()
+}
+
+// orphanedApartmentLinks lists resident links to apartments that no longer
+// exist; the link lives in app.db so SQLite cannot enforce it
+func orphanedApartmentLinks() ([]IntegrityIssue, error) {
+	rows, err := userDB.Query(
+		`SELECT ua.user_id, u.username, ua.society_id, ua.apartment_id
+		FROM user_apartments ua JOIN users u ON u.id = ua.user_id
+		ORDER BY u.username, ua.society_id, ua.apartment_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var issues []IntegrityIssue
+	for rows.Next() {
+		var userID, societyID int
+		var username, apartmentID string
+		if err := rows.Scan(&userID, &username, &societyID, &apartmentID); err != nil {
+			return nil, err
+		}
+		society := appStore.InSociety(societyID)
+		if _, err := society.GetApartment(apartmentID); err == nil {
+			continue
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		// Links to an apartment in the recycle bin come back with it
+		if _, err := society.DeletedEntry(store.BinApartments, apartmentID); err == nil {
+			continue
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		issues = append(issues, IntegrityIssue{
+			Database: appDBPath(),
+			Table:    "user_apartments",
+			RowID:    fmt.Sprint(userID),
+			Problem:  fmt.Sprintf("%s is linked to missing apartment %s", username, apartmentID),
+		})
+	}
+	return issues, rows.Err()
+}
+
+// Integrity Report UI
+func ShowIntegrityReport(myApp fyne.App, previousWindow fyne.Window) {
+	reportWindow := myApp.NewWindow("Integrity Check")
+	reportWindow.Resize(fyne.NewSize(700, 450))
+
+	var issues []IntegrityIssue
+	summaryLabel := widget.NewLabel("")
+
+	issuesList := widget.NewList(
+		func() int { return len(issues) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			issue := issues[id]
+			location := issue.Database
+			if issue.Table != "" {
+				location = fmt.Sprintf("%s %s #%s", issue.Database, issue.Table, issue.RowID)
+			}
+			obj.(*widget.Label).SetText(fmt.Sprintf("%s: %s", location, issue.Problem))
+		},
+	)
+
+	runCheck := func() {
+		var err error
+		issues, err = checkIntegrity()
+		if err != nil {
+			dialog.ShowError(err, reportWindow)
+		}
+		if len(issues) == 0 {
+			summaryLabel.SetText("No problems found")
+		} else {
+			summaryLabel.SetText(fmt.Sprintf("%d problem(s) found", len(issues)))
+		}
+		issuesList.Refresh()
+	}
+
+	runButton := widget.NewButtonWithIcon("Run Again", theme.ViewRefreshIcon(), runCheck)
+
+	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
+		reportWindow.Hide()
+		previousWindow.Show()
+	})
+
+	runCheck()
+
+	controls := container.NewVBox(summaryLabel, container.NewHBox(runButton, backButton))
+	showSessionWindow(reportWindow, container.NewBorder(controls, nil, nil, nil, issuesList))
+}
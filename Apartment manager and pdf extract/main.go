@@ -4,7 +4,9 @@ import (
 	"database/sql"
 	"encoding/csv"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -18,115 +20,138 @@ import (
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
-	"github.com/jung-kurt/gofpdf"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/xuri/excelize/v2"
+
+	"apartment_login/money"
+	"apartment_login/service"
+	"apartment_login/store"
 )
 
 var (
 	userDB      *sql.DB
 	apartmentDB *sql.DB
-)
 
-// User represents a user in the database
-type User struct {
-	ID       int
-	Username string
-	Password string
-}
+	// appStore and appService wrap the databases once they are open
+	appStore   store.Store
+	appService *service.Service
+)
 
-// Apartment represents an apartment entry
-type Apartment struct {
-	ID       string
-	Owner    string
-	Resident string
-	SameFlag bool
-}
+// Entity types live in the store package
+type (
+	User       = store.User
+	Apartment  = store.Apartment
+	Collection = store.Collection
+	Payment    = store.Payment
+)
 
 // Initialize the SQLite databases
 func initDBs() {
+	if err := openDatabases(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Database init")
+}
+
+// openDatabases opens both databases and brings their schemas up to date
+func openDatabases() error {
 	var err error
 
 	// Open user database
-	userDB, err = sql.Open("sqlite3", "./app.db")
+	userDB, err = store.OpenDatabase(appDBPath())
 	if err != nil {
-		log.Fatal("Failed to open user database:", err)
+		return fmt.Errorf("failed to open user database: %w", err)
+	}
+	if err := store.Migrate(userDB, appDBPath(), store.AppMigrations); err != nil {
+		return fmt.Errorf("failed to migrate user database: %w", err)
 	}
 
-	// Create users table
-	createUsersTable := `CREATE TABLE IF NOT EXISTS users (
-		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-		"username" TEXT UNIQUE,
-		"password" TEXT
-	);`
-
-	_, err = userDB.Exec(createUsersTable)
-	if err != nil {
-		log.Fatal("Failed to create users table:", err)
+	// Rehash any passwords stored by older versions in plaintext
+	if err := migratePlaintextPasswords(userDB); err != nil {
+		return fmt.Errorf("failed to migrate user passwords: %w", err)
 	}
 
 	// Open apartment database
-	apartmentDB, err = sql.Open("sqlite3", "./resident.db")
+	apartmentDB, err = store.OpenDatabase(residentDBPath())
 	if err != nil {
-		log.Fatal("Failed to open apartment database:", err)
+		return fmt.Errorf("failed to open apartment database: %w", err)
+	}
+	if err := store.Migrate(apartmentDB, residentDBPath(), store.ResidentMigrations); err != nil {
+		return fmt.Errorf("failed to migrate apartment database: %w", err)
 	}
 
-	// Create apartments table
-	createApartmentsTable := `CREATE TABLE IF NOT EXISTS apartments (
-		"id" TEXT PRIMARY KEY,
-		"owner" TEXT NOT NULL,
-		"resident" TEXT NOT NULL,
-		"same_flag" INTEGER NOT NULL
-	);`
+	appStore = store.NewSQLite(userDB, apartmentDB)
+	appService = service.New(appStore)
+	invalidateApartmentLists()
+	if err := nameDefaultSociety(); err != nil {
+		return fmt.Errorf("failed to name the default society: %w", err)
+	}
 
-	_, err = apartmentDB.Exec(createApartmentsTable)
-	if err != nil {
-		log.Fatal("Failed to create apartments table:", err)
+	// Reopening after a restore keeps working in the society in use, or
+	// the first one left if the restored data no longer has it
+	if currentSociety.ID != 0 {
+		if err := selectSociety(currentSociety.ID); err != nil {
+			log.Printf("Society %d is not available after reopening: %v", currentSociety.ID, err)
+			return selectFirstSociety()
+		}
 	}
+	return nil
+}
 
-	// Create collections table
-	createCollectionsTable := `CREATE TABLE IF NOT EXISTS collections (
-    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-    "apartment_id" TEXT NOT NULL,
-    "month" TEXT NOT NULL,
-    "type" TEXT NOT NULL,
-    "price" REAL NOT NULL,
-    "date" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (apartment_id) REFERENCES apartments (id)
-);`
+// Authentication functions
+func Authenticate(username, password string) (User, error) {
+	user, err := appStore.GetUserByUsername(username)
+	if err != nil {
+		log.Println("Authentication failed:", err)
+		checkNoPassword(password)
+		recordLogin(username, false, "unknown user")
+		return User{}, errInvalidCredentials
+	}
 
-	_, err = apartmentDB.Exec(createCollectionsTable)
+	var dbPassword string
+	err = userDB.QueryRow("SELECT password FROM users WHERE id = ?", user.ID).Scan(&dbPassword)
 	if err != nil {
-		log.Fatal("Failed to create collections table:", err)
+		return User{}, err
 	}
 
-	// Create payments table
-	createPaymentsTable := `CREATE TABLE IF NOT EXISTS payments (
-    "id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-    "month" TEXT NOT NULL,
-    "type" TEXT NOT NULL,
-    "price" REAL NOT NULL,
-    "transaction_type" TEXT NOT NULL,
-    "date" TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);`
+	if user.IsLocked() {
+		recordLogin(username, false, "locked")
+		wait := time.Until(user.LockedUntil).Round(time.Second)
+		return User{}, fmt.Errorf("too many failed attempts, try again in %s", wait)
+	}
 
-	_, err = apartmentDB.Exec(createPaymentsTable)
-	if err != nil {
-		log.Fatal("Failed to create payments table:", err)
+	if !checkPassword(dbPassword, password) {
+		return User{}, recordFailedLogin(user)
 	}
 
-	fmt.Println("Database init")
+	// Only reveal that the account is disabled once the password is right
+	if user.Disabled {
+		recordLogin(username, false, "disabled")
+		return User{}, errors.New("this account has been disabled")
+	}
+
+	// The password is right; two-factor users still need CompleteSecondFactor
+	if user.TOTPEnabled {
+		return user, errSecondFactorRequired
+	}
+
+	return finishLogin(user), nil
 }
 
-// Authentication functions
-func Authenticate(username, password string) bool {
-	var dbPassword string
-	err := userDB.QueryRow("SELECT password FROM users WHERE username = ?", username).Scan(&dbPassword)
+// finishLogin clears the failure counter and records a successful sign-in
+func finishLogin(user User) User {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err := userDB.Exec(
+		"UPDATE users SET failed_attempts = 0, locked_until = NULL, last_login_at = ? WHERE id = ?",
+		now, user.ID)
 	if err != nil {
-		log.Println("Authentication failed:", err)
-		return false
+		log.Println("Error resetting failed logins:", err)
 	}
-	return password == dbPassword
+	user.FailedAttempts = 0
+	user.LockedUntil = time.Time{}
+	user.LastLoginAt = now
+
+	recordLogin(user.Username, true, "")
+	return user
 }
 
 // Login Window
@@ -144,16 +169,60 @@ func ShowLoginWindow(myApp fyne.App) {
 		username := usernameEntry.Text
 		password := passwordEntry.Text
 
-		if Authenticate(username, password) {
-			loginWindow.Hide()
-			ShowHomePage(myApp)
-		} else {
-			dialog.ShowError(errors.New("invalid credentials"), loginWindow)
+		openHome := func() {
+			if err := selectFirstSociety(); err != nil {
+				currentUser = User{}
+				passwordEntry.SetText("")
+				dialog.ShowError(err, loginWindow)
+				return
+			}
+			startSession(myApp)
+			if currentUser.Role == RoleResident {
+				ShowResidentPortal(myApp)
+			} else {
+				ShowHomePage(myApp)
+			}
+			loginWindow.Close()
+		}
+
+		signIn := func(user User) {
+			currentUser = user
+			if !needsPasswordChange(user) {
+				openHome()
+				return
+			}
+
+			// Reset or expired passwords must be replaced before going further
+			showChangePasswordDialog(loginWindow, true, func(changed bool) {
+				if !changed {
+					currentUser = User{}
+					passwordEntry.SetText("")
+					return
+				}
+				openHome()
+			})
+		}
+
+		user, err := Authenticate(username, password)
+		if errors.Is(err, errSecondFactorRequired) {
+			promptSecondFactor(user, loginWindow, signIn)
+			return
 		}
+		if err != nil {
+			dialog.ShowError(err, loginWindow)
+			return
+		}
+
+		signIn(user)
 	})
 
+	title := "Apartment Management System"
+	if society := societyName(); society != "" {
+		title = society
+	}
+
 	content := container.NewVBox(
-		widget.NewLabel("Apartment Management System"),
+		widget.NewLabel(title),
 		widget.NewLabel("Username:"),
 		usernameEntry,
 		widget.NewLabel("Password:"),
@@ -163,6 +232,10 @@ func ShowLoginWindow(myApp fyne.App) {
 
 	loginWindow.SetContent(content)
 	loginWindow.Show()
+	if dataDirNotice != "" {
+		dialog.ShowInformation("Data Directory", dataDirNotice, loginWindow)
+		dataDirNotice = ""
+	}
 }
 
 // Home Page UI
@@ -180,6 +253,11 @@ func ShowHomePage(myApp fyne.App) {
 		ShowApartmentManager(myApp, homeWindow)
 	})
 
+	peopleButton := widget.NewButton("PEOPLE", func() {
+		homeWindow.Hide()
+		ShowPeopleManager(myApp, homeWindow)
+	})
+
 	collectionManagerButton := widget.NewButton("COLLECTION MANAGER", func() {
 		homeWindow.Hide()
 		ShowCollectionManager(myApp, homeWindow)
@@ -190,179 +268,572 @@ func ShowHomePage(myApp fyne.App) {
 		ShowAccountsManager(myApp, homeWindow)
 	})
 
+	auditLogButton := widget.NewButton("AUDIT LOG", func() {
+		homeWindow.Hide()
+		ShowAuditLog(myApp, homeWindow)
+	})
+
+	integrityButton := widget.NewButton("INTEGRITY CHECK", func() {
+		homeWindow.Hide()
+		ShowIntegrityReport(myApp, homeWindow)
+	})
+
+	backupButton := widget.NewButton("BACKUP & RESTORE", func() {
+		homeWindow.Hide()
+		ShowBackupManager(myApp, homeWindow)
+	})
+
+	societiesButton := widget.NewButton("SOCIETIES", func() {
+		homeWindow.Hide()
+		ShowSocietyManager(myApp, homeWindow)
+	})
+
+	sessionSettingsButton := widget.NewButtonWithIcon("Session Settings", theme.SettingsIcon(), func() {
+		showIdleTimeoutDialog(homeWindow)
+	})
+
+	passwordPolicyButton := widget.NewButtonWithIcon("Password Policy", theme.SettingsIcon(), func() {
+		showPasswordPolicyDialog(homeWindow)
+	})
+
+	changePasswordButton := widget.NewButtonWithIcon("Change My Password", theme.AccountIcon(), func() {
+		showChangePasswordDialog(homeWindow, false, func(bool) {})
+	})
+
+	logoutButton := widget.NewButtonWithIcon("Logout", theme.LogoutIcon(), logout)
+
+	// Windows opened from here read the society when they open, so only
+	// the home page itself has to be rebuilt after switching
+	switcher := societySwitcher(homeWindow, func() {
+		ShowHomePage(myApp)
+		homeWindow.Close()
+	})
+
 	content := container.NewVBox(
-		widget.NewLabel("Welcome to Apartment Management System"),
-		container.NewCenter(userManagerButton),
-		container.NewCenter(apartmentManagerButton),
-		container.NewCenter(collectionManagerButton),
-		container.NewCenter(accountsManagerButton),
+		widget.NewLabel(fmt.Sprintf("Welcome to %s", currentSociety.Name)),
+		widget.NewLabel(fmt.Sprintf("Signed in as %s (%s)", currentUser.Username, currentUser.Role)),
+		switcher,
 	)
 
-	homeWindow.SetContent(content)
-	homeWindow.Show()
+	// Only offer the modules the signed-in role may open
+	if can(PermManageUsers) {
+		content.Add(container.NewCenter(userManagerButton))
+	}
+	if can(PermViewApartments) {
+		content.Add(container.NewCenter(apartmentManagerButton))
+		content.Add(container.NewCenter(peopleButton))
+	}
+	if can(PermViewCollections) {
+		content.Add(container.NewCenter(collectionManagerButton))
+	}
+	if can(PermViewAccounts) {
+		content.Add(container.NewCenter(accountsManagerButton))
+	}
+	if can(PermViewAudit) {
+		content.Add(container.NewCenter(auditLogButton))
+		content.Add(container.NewCenter(integrityButton))
+	}
+	if can(PermManageBackups) {
+		content.Add(container.NewCenter(backupButton))
+	}
+	if can(PermManageSocieties) {
+		content.Add(container.NewCenter(societiesButton))
+	}
+
+	if can(PermManageUsers) {
+		content.Add(container.NewCenter(container.NewHBox(sessionSettingsButton, passwordPolicyButton)))
+	}
+	content.Add(container.NewCenter(container.NewHBox(changePasswordButton, logoutButton)))
+
+	showSessionWindow(homeWindow, content)
 }
 
 // User Manager UI
 func ShowUserManager(myApp fyne.App, previousWindow fyne.Window) {
 	userWindow := myApp.NewWindow("User Manager")
-	userWindow.Resize(fyne.NewSize(800, 600))
+	userWindow.Resize(fyne.NewSize(1100, 600))
 
 	// UI elements for user management
 	usernameEntry := widget.NewEntry()
 	usernameEntry.SetPlaceHolder("Username")
 
+	fullNameEntry := widget.NewEntry()
+	fullNameEntry.SetPlaceHolder("Full Name")
+
 	passwordEntry := widget.NewPasswordEntry()
-	passwordEntry.SetPlaceHolder("Password")
+	passwordEntry.SetPlaceHolder("New password")
+
+	forceChangeCheck := widget.NewCheck("Require password change at next login", nil)
+	forceChangeCheck.SetChecked(getPasswordPolicy().ForceOnReset)
+
+	apartmentsEntry := widget.NewEntry()
+	apartmentsEntry.SetPlaceHolder("e.g. 01, 02")
+	apartmentsEntry.Disable()
+
+	// Societies are offered by name and stored by ID
+	societies, err := appStore.ListSocieties()
+	if err != nil {
+		log.Println("Error fetching societies:", err)
+	}
+	societyNames := make([]string, len(societies))
+	for i, society := range societies {
+		societyNames[i] = society.Name
+	}
+	societiesCheck := widget.NewCheckGroup(societyNames, nil)
+	societiesCheck.Horizontal = true
+	societiesCheck.SetSelected([]string{currentSociety.Name})
+
+	roleSelect := widget.NewSelect(roleNames(), func(role string) {
+		if Role(role) == RoleResident {
+			apartmentsEntry.Enable()
+		} else {
+			apartmentsEntry.SetText("")
+			apartmentsEntry.Disable()
+		}
+	})
+
+	// Search field
+	searchEntry := widget.NewEntry()
+	searchEntry.SetPlaceHolder("Search by username, name or role...")
 
-	// Create list to display users
-	usersList := widget.NewList(
-		func() int { return getUserCount() },
+	// Create table to display the user directory
+	var users []User
+	headers := []string{"Username", "Full Name", "Role", "Status", "2FA", "Created", "Last Login"}
+	usersTable := widget.NewTableWithHeaders(
+		func() (int, int) { return len(users), len(headers) },
 		func() fyne.CanvasObject { return widget.NewLabel("") },
-		func(id widget.ListItemID, obj fyne.CanvasObject) {
-			user := getUserByIndex(id)
-			obj.(*widget.Label).SetText(fmt.Sprintf("ID: %d - Username: %s", user.ID, user.Username))
+		func(id widget.TableCellID, obj fyne.CanvasObject) {
+			obj.(*widget.Label).SetText(userDirectoryCell(users[id.Row], id.Col))
 		},
 	)
+	usersTable.ShowHeaderColumn = false
+	usersTable.UpdateHeader = func(id widget.TableCellID, obj fyne.CanvasObject) {
+		obj.(*widget.Label).SetText(headers[id.Col])
+	}
+	for col, width := range []float32{110, 150, 90, 80, 50, 150, 150} {
+		usersTable.SetColumnWidth(col, width)
+	}
+
+	var selectedUser User
 
 	// Refresh function
 	refreshList := func() {
-		usersList.Refresh()
+		var err error
+		users, err = searchUsers(searchEntry.Text)
+		if err != nil {
+			log.Println("Error searching users:", err)
+		}
+		usersTable.UnselectAll()
+		usersTable.Refresh()
 	}
 
-	var currentUser User
+	toggleDisabledButton := widget.NewButtonWithIcon("Disable", theme.CancelIcon(), nil)
 
-	// Handle selecting a user from the list
-	usersList.OnSelected = func(id widget.ListItemID) {
-		user := getUserByIndex(id)
-		currentUser = user
+	// Handle selecting a user from the table
+	usersTable.OnSelected = func(id widget.TableCellID) {
+		if id.Row < 0 || id.Row >= len(users) {
+			return
+		}
+		user := users[id.Row]
+		selectedUser = user
 
 		usernameEntry.SetText(user.Username)
-		passwordEntry.SetText(user.Password)
+		fullNameEntry.SetText(user.FullName)
+		roleSelect.SetSelected(string(user.Role))
+		apartmentsEntry.SetText(strings.Join(getUserApartments(user.ID), ", "))
+		userSocieties, err := appStore.UserSocieties(user.ID)
+		if err != nil {
+			log.Println("Error fetching user societies:", err)
+		}
+		var checked []string
+		for _, society := range societies {
+			for _, id := range userSocieties {
+				if id == society.ID {
+					checked = append(checked, society.Name)
+				}
+			}
+		}
+		societiesCheck.SetSelected(checked)
+		passwordEntry.SetText("")
+		passwordEntry.SetPlaceHolder("Leave blank to keep current password")
+
+		if user.Disabled {
+			toggleDisabledButton.SetText("Enable")
+		} else {
+			toggleDisabledButton.SetText("Disable")
+		}
+	}
+
+	resetForm := func() {
+		selectedUser = User{}
+		clearUserForm(usernameEntry, passwordEntry, roleSelect)
+		fullNameEntry.SetText("")
+		apartmentsEntry.SetText("")
+		societiesCheck.SetSelected([]string{currentSociety.Name})
+		toggleDisabledButton.SetText("Disable")
 	}
 
 	// Form handlers
 	saveButton := widget.NewButtonWithIcon("Save", theme.DocumentSaveIcon(), func() {
-		if usernameEntry.Text == "" || passwordEntry.Text == "" {
-			dialog.ShowError(errors.New("username and password are required"), userWindow)
+		if usernameEntry.Text == "" {
+			dialog.ShowError(errors.New("username is required"), userWindow)
+			return
+		}
+		if selectedUser.ID == 0 && passwordEntry.Text == "" {
+			dialog.ShowError(errors.New("password is required for a new user"), userWindow)
+			return
+		}
+		if roleSelect.Selected == "" {
+			dialog.ShowError(errors.New("role is required"), userWindow)
 			return
 		}
 
-		currentUser.Username = usernameEntry.Text
-		currentUser.Password = passwordEntry.Text
+		selectedUser.Username = usernameEntry.Text
+		selectedUser.FullName = strings.TrimSpace(fullNameEntry.Text)
+		selectedUser.Password = passwordEntry.Text
+		selectedUser.Role = Role(roleSelect.Selected)
+		selectedUser.Apartments = nil
+		if selectedUser.Role == RoleResident {
+			selectedUser.Apartments = parseApartmentList(apartmentsEntry.Text)
+		}
+		selectedUser.Societies = nil
+		for _, society := range societies {
+			for _, name := range societiesCheck.Selected {
+				if name == society.Name {
+					selectedUser.Societies = append(selectedUser.Societies, society.ID)
+				}
+			}
+		}
+		selectedUser.MustChangePassword = passwordEntry.Text != "" && forceChangeCheck.Checked
 
-		if err := saveUser(currentUser); err != nil {
-			dialog.ShowError(err, userWindow)
+		mine := selectedUser
+		if err := saveUser(mine); err != nil {
+			showSaveError(err, userWindow, func(saved any) {
+				refreshList()
+				resetForm()
+			}, func(saved any) {
+				mine.Version = saved.(User).Version
+				if err := saveUser(mine); err != nil {
+					dialog.ShowError(err, userWindow)
+				}
+				refreshList()
+				resetForm()
+			})
 			return
 		}
 
 		refreshList()
-		clearUserForm(usernameEntry, passwordEntry)
+		resetForm()
 	})
 
 	addButton := widget.NewButtonWithIcon("Add New", theme.ContentAddIcon(), func() {
-		currentUser = User{} // Create a new user
-		clearUserForm(usernameEntry, passwordEntry)
+		resetForm() // Create a new user
 	})
 
 	deleteButton := widget.NewButtonWithIcon("Delete", theme.DeleteIcon(), func() {
-		if currentUser.ID == 0 {
+		if selectedUser.ID == 0 {
 			dialog.ShowError(errors.New("select a user first"), userWindow)
 			return
 		}
 
-		dialog.ShowConfirm("Confirm Delete", "Delete user "+currentUser.Username+"?",
+		dialog.ShowConfirm("Confirm Delete", "Move user "+selectedUser.Username+
+			" to the recycle bin?\nThe account can no longer sign in until it is restored.",
 			func(ok bool) {
 				if ok {
-					if err := deleteUser(currentUser.ID); err != nil {
+					if err := deleteUser(selectedUser.ID); err != nil {
 						dialog.ShowError(err, userWindow)
 						return
 					}
 					refreshList()
-					clearUserForm(usernameEntry, passwordEntry)
+					resetForm()
+				}
+			}, userWindow)
+	})
+
+	toggleDisabledButton.OnTapped = func() {
+		if selectedUser.ID == 0 {
+			dialog.ShowError(errors.New("select a user first"), userWindow)
+			return
+		}
+
+		if err := setUserDisabled(selectedUser.ID, !selectedUser.Disabled); err != nil {
+			dialog.ShowError(err, userWindow)
+			return
+		}
+		refreshList()
+		resetForm()
+	}
+
+	unlockButton := widget.NewButtonWithIcon("Unlock", theme.ConfirmIcon(), func() {
+		if selectedUser.ID == 0 {
+			dialog.ShowError(errors.New("select a user first"), userWindow)
+			return
+		}
+
+		if err := unlockUser(selectedUser.ID); err != nil {
+			dialog.ShowError(err, userWindow)
+			return
+		}
+		refreshList()
+		dialog.ShowInformation("Unlocked", "User "+selectedUser.Username+" can sign in again", userWindow)
+	})
+
+	twoFactorButton := widget.NewButtonWithIcon("Two-Factor", theme.AccountIcon(), func() {
+		if selectedUser.ID == 0 {
+			dialog.ShowError(errors.New("select a user first"), userWindow)
+			return
+		}
+
+		if !selectedUser.TOTPEnabled {
+			showTOTPEnrolment(selectedUser, userWindow, refreshList)
+			return
+		}
+
+		dialog.ShowConfirm("Disable Two-Factor",
+			"Disable two-factor sign-in for "+selectedUser.Username+"?",
+			func(ok bool) {
+				if !ok {
+					return
 				}
+				if err := disableTOTP(selectedUser.ID); err != nil {
+					dialog.ShowError(err, userWindow)
+					return
+				}
+				selectedUser.TOTPEnabled = false
+				refreshList()
 			}, userWindow)
 	})
 
+	historyButton := widget.NewButtonWithIcon("Sign-ins", theme.HistoryIcon(), func() {
+		showLoginHistory(selectedUser.Username, userWindow)
+	})
+
 	// Back button to return to home
 	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
 		userWindow.Hide()
 		previousWindow.Show()
 	})
 
-	// Search field
-	searchEntry := widget.NewEntry()
-	searchEntry.SetPlaceHolder("Search users...")
-
 	searchEntry.OnChanged = func(text string) {
-		// This would trigger a filtered refresh of the users list
-		// Currently just refreshes without filtering
 		refreshList()
 	}
 
+	refreshList()
+
 	// Layout
 	form := container.NewVBox(
 		widget.NewLabel("User Details"),
 		widget.NewLabel("Username:"),
 		usernameEntry,
+		widget.NewLabel("Full Name:"),
+		fullNameEntry,
 		widget.NewLabel("Password:"),
 		passwordEntry,
+		forceChangeCheck,
+		widget.NewLabel("Role:"),
+		roleSelect,
+		widget.NewLabel("Societies:"),
+		societiesCheck,
+		widget.NewLabel(fmt.Sprintf("Linked Apartments in %s (residents only):", currentSociety.Name)),
+		apartmentsEntry,
 		container.NewHBox(saveButton, addButton, deleteButton),
+		container.NewHBox(toggleDisabledButton, unlockButton, twoFactorButton, historyButton),
+		container.NewHBox(recycleBinButton(myApp, userWindow, store.BinUsers, refreshList)),
 	)
 
-	controls := container.NewVBox(
-		container.NewHBox(searchEntry, backButton),
-	)
+	controls := container.NewBorder(nil, nil, nil, backButton, searchEntry)
 
 	split := container.NewHSplit(
-		container.NewBorder(controls, nil, nil, nil, usersList),
+		container.NewBorder(controls, nil, nil, nil, usersTable),
 		form,
 	)
-	split.Offset = 0.3
+	split.Offset = 0.6
 
-	userWindow.SetContent(split)
-	userWindow.Show()
+	showSessionWindow(userWindow, split)
+}
+
+// userDirectoryCell returns the text for one column of the user directory
+func userDirectoryCell(u User, col int) string {
+	switch col {
+	case 0:
+		return u.Username
+	case 1:
+		return u.FullName
+	case 2:
+		return string(u.Role)
+	case 3:
+		return userStatus(u)
+	case 4:
+		if u.TOTPEnabled {
+			return "yes"
+		}
+		return "no"
+	case 5:
+		return u.CreatedAt
+	case 6:
+		return u.LastLoginAt
+	}
+	return ""
 }
 
 // User database operations
 func saveUser(user User) error {
-	var err error
+	if err := requirePermission(PermManageUsers); err != nil {
+		return err
+	}
+	if !isValidRole(user.Role) {
+		return fmt.Errorf("unknown role: %s", user.Role)
+	}
+
+	if user.Password != "" {
+		if err := getPasswordPolicy().validate(user.Password); err != nil {
+			return err
+		}
+	}
+
 	if user.ID == 0 {
 		// Insert new user
-		_, err = userDB.Exec(
-			"INSERT INTO users (username, password) VALUES (?, ?)",
-			user.Username, user.Password,
-		)
-	} else {
-		// Update existing user
-		_, err = userDB.Exec(
-			"UPDATE users SET username = ?, password = ? WHERE id = ?",
-			user.Username, user.Password, user.ID,
-		)
+		hash, err := hashPassword(user.Password)
+		if err != nil {
+			return err
+		}
+		created, err := appStore.CreateUser(user, hash)
+		if err != nil {
+			return err
+		}
+		err = recordAudit(auditEntityUser, strconv.Itoa(created.ID), auditActionCreate, nil,
+			auditUser{Username: user.Username, FullName: user.FullName, Role: user.Role, PasswordChanged: true})
+		if err != nil {
+			return err
+		}
+		if err := setUserSocieties(created.ID, user.Societies); err != nil {
+			return err
+		}
+		return setUserApartments(created.ID, user.Apartments)
+	}
+
+	// Update existing user
+	before, err := appService.UpdateUser(user)
+	if err != nil {
+		return err
+	}
+
+	// An administrator reset keeps the old hash in the reuse history
+	if user.Password != "" {
+		if err := setUserPassword(user.ID, user.Password, user.MustChangePassword); err != nil {
+			return err
+		}
 	}
-	return err
+
+	err = recordAudit(auditEntityUser, strconv.Itoa(user.ID), auditActionUpdate,
+		auditUser{Username: before.Username, FullName: before.FullName, Role: before.Role},
+		auditUser{Username: user.Username, FullName: user.FullName, Role: user.Role,
+			PasswordChanged: user.Password != ""})
+	if err != nil {
+		return err
+	}
+	if err := setUserSocieties(user.ID, user.Societies); err != nil {
+		return err
+	}
+	return setUserApartments(user.ID, user.Apartments)
 }
 
 func deleteUser(id int) error {
-	_, err := userDB.Exec("DELETE FROM users WHERE id = ?", id)
-	return err
+	if err := requirePermission(PermManageUsers); err != nil {
+		return err
+	}
+
+	before, err := appService.DeleteUser(id, currentUser.ID, currentUser.Username)
+	if err != nil {
+		return err
+	}
+
+	return recordAudit(auditEntityUser, strconv.Itoa(id), auditActionDelete,
+		auditUser{Username: before.Username, Role: before.Role}, nil)
+}
+
+// setUserDisabled disables or re-enables an account without deleting its history
+func setUserDisabled(id int, disabled bool) error {
+	if err := requirePermission(PermManageUsers); err != nil {
+		return err
+	}
+
+	before, err := appService.SetUserDisabled(id, currentUser.ID, disabled)
+	if err != nil {
+		return err
+	}
+
+	return recordAudit(auditEntityUser, strconv.Itoa(id), auditActionUpdate,
+		auditUser{Username: before.Username, Role: before.Role, Disabled: before.Disabled},
+		auditUser{Username: before.Username, Role: before.Role, Disabled: disabled})
+}
+
+func getUserByID(id int) (User, error) {
+	return appStore.GetUser(id)
 }
 
 func getUserCount() int {
-	var count int
-	userDB.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	count, err := appStore.CountUsers()
+	if err != nil {
+		log.Println("Error counting users:", err)
+	}
 	return count
 }
 
-func getUserByIndex(index int) User {
-	var user User
-	row := userDB.QueryRow("SELECT id, username, password FROM users LIMIT 1 OFFSET ?", index)
-	row.Scan(&user.ID, &user.Username, &user.Password)
-	return user
+// searchUsers returns users whose username, full name or role contains the
+// query, ordered by username. An empty query returns everyone.
+func searchUsers(query string) ([]User, error) {
+	return appStore.SearchUsers(query)
+}
+
+// userStatus summarises whether the account can currently sign in
+func userStatus(u User) string {
+	switch {
+	case u.Disabled:
+		return "disabled"
+	case u.FailedAttempts >= maxFailedAttempts && u.IsLocked():
+		return "locked"
+	default:
+		return "active"
+	}
 }
 
-func clearUserForm(usernameEntry, passwordEntry *widget.Entry) {
+// showLoginHistory lists recent sign-ins for a user, or for everyone if username is empty
+func showLoginHistory(username string, parent fyne.Window) {
+	history, err := getLoginHistory(username, 50)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
+
+	historyList := widget.NewList(
+		func() int { return len(history) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			r := history[id]
+			status := "success"
+			if !r.Success {
+				status = "failed: " + r.Reason
+			}
+			obj.(*widget.Label).SetText(fmt.Sprintf("%s  %s  %s", r.Timestamp, r.Username, status))
+		},
+	)
+
+	title := "Recent Sign-ins"
+	if username != "" {
+		title += " for " + username
+	}
+
+	d := dialog.NewCustom(title, "Close", historyList, parent)
+	d.Resize(fyne.NewSize(600, 400))
+	d.Show()
+}
+
+func clearUserForm(usernameEntry, passwordEntry *widget.Entry, roleSelect *widget.Select) {
 	usernameEntry.SetText("")
 	passwordEntry.SetText("")
+	passwordEntry.SetPlaceHolder("New password")
+	roleSelect.ClearSelected()
 }
 
 // Apartment Manager UI
@@ -375,6 +846,9 @@ func ShowApartmentManager(myApp fyne.App, previousWindow ...fyne.Window) {
 	// UI elements
 	idEntry := widget.NewEntry()
 	idEntry.SetPlaceHolder("Apartment ID")
+	if currentSociety.IDPattern != "" {
+		idEntry.SetPlaceHolder("Apartment ID, e.g. " + service.IDExample(currentSociety.IDPattern))
+	}
 
 	ownerEntry := widget.NewEntry()
 	ownerEntry.SetPlaceHolder("Owner Name")
@@ -398,28 +872,69 @@ func ShowApartmentManager(myApp fyne.App, previousWindow ...fyne.Window) {
 		}
 	}
 
-	// List widget
-	apartmentsList := widget.NewList(
-		func() int { return getApartmentCount() },
+	// Left as they are, a new owner or resident takes over today
+	ownerSinceEntry := widget.NewEntry()
+	ownerSinceEntry.SetPlaceHolder("YYYY-MM-DD")
+
+	residentSinceEntry := widget.NewEntry()
+	residentSinceEntry.SetPlaceHolder("YYYY-MM-DD")
+
+	blockEntry := widget.NewEntry()
+	blockEntry.SetPlaceHolder("Block / Tower")
+
+	floorEntry := widget.NewEntry()
+	floorEntry.SetPlaceHolder("Floor (blank or G for ground)")
+
+	unitTypeSelect := widget.NewSelect(service.UnitTypes, nil)
+
+	carpetAreaEntry := widget.NewEntry()
+	carpetAreaEntry.SetPlaceHolder("Carpet area (sq ft)")
+
+	superAreaEntry := widget.NewEntry()
+	superAreaEntry.SetPlaceHolder("Super built-up area (sq ft)")
+
+	parkingEntry := widget.NewEntry()
+	parkingEntry.SetPlaceHolder("e.g. P-12, P-13")
+
+	// List widget, fed a page at a time by the list model
+	var apartmentsList *widget.List
+	model := newApartmentListModel(appStore, func() { apartmentsList.Refresh() })
+	apartmentsList = widget.NewList(
+		model.Len,
 		func() fyne.CanvasObject { return widget.NewLabel("") },
 		func(id widget.ListItemID, obj fyne.CanvasObject) {
-			apt := getApartmentByIndex(id)
+			apt, ok := model.Item(id)
+			if !ok {
+				obj.(*widget.Label).SetText("Loading...")
+				return
+			}
 			obj.(*widget.Label).SetText(fmt.Sprintf("%s: %s - %s", apt.ID, apt.Owner, apt.Resident))
 		},
 	)
 
 	refreshList := func() {
-		apartmentsList.Refresh()
+		apartmentsList.UnselectAll()
+		model.Reload()
 	}
 
-	apartmentsList.OnSelected = func(id widget.ListItemID) {
-		apt := getApartmentByIndex(id)
+	loadApartment := func(apt Apartment) {
 		currentApartment = apt
 
 		idEntry.SetText(apt.ID)
 		ownerEntry.SetText(apt.Owner)
 		residentEntry.SetText(apt.Resident)
 		sameCheck.SetChecked(apt.SameFlag)
+		ownerSinceEntry.SetText(apt.OwnerSince)
+		residentSinceEntry.SetText(apt.ResidentSince)
+		blockEntry.SetText(apt.Block)
+		floorEntry.SetText(strconv.Itoa(apt.Floor))
+		unitTypeSelect.SetSelected(apt.UnitType)
+		if apt.UnitType == "" {
+			unitTypeSelect.ClearSelected()
+		}
+		carpetAreaEntry.SetText(service.FormatArea(apt.CarpetArea))
+		superAreaEntry.SetText(service.FormatArea(apt.SuperBuiltUpArea))
+		parkingEntry.SetText(strings.Join(apt.ParkingSlots, ", "))
 
 		if sameCheck.Checked {
 			residentEntry.Disable()
@@ -428,6 +943,25 @@ func ShowApartmentManager(myApp fyne.App, previousWindow ...fyne.Window) {
 		}
 	}
 
+	apartmentsList.OnSelected = func(id widget.ListItemID) {
+		apt, ok := model.Item(id)
+		if !ok {
+			apartmentsList.Unselect(id)
+			return
+		}
+		loadApartment(apt)
+	}
+
+	resetForm := func() {
+		currentApartment = Apartment{}
+		clearForm(idEntry, ownerEntry, residentEntry, sameCheck)
+		for _, entry := range []*widget.Entry{ownerSinceEntry, residentSinceEntry, blockEntry, floorEntry,
+			carpetAreaEntry, superAreaEntry, parkingEntry} {
+			entry.SetText("")
+		}
+		unitTypeSelect.ClearSelected()
+	}
+
 	// Form handlers
 	saveButton := widget.NewButtonWithIcon("Save", theme.DocumentSaveIcon(), func() {
 		if idEntry.Text == "" {
@@ -435,6 +969,10 @@ func ShowApartmentManager(myApp fyne.App, previousWindow ...fyne.Window) {
 			return
 		}
 
+		// Saving under another ID creates a new apartment
+		if idEntry.Text != currentApartment.ID {
+			currentApartment.Version = 0
+		}
 		currentApartment.ID = idEntry.Text
 		currentApartment.Owner = ownerEntry.Text
 
@@ -447,15 +985,49 @@ func ShowApartmentManager(myApp fyne.App, previousWindow ...fyne.Window) {
 			}
 		}
 
-		updateSameFlag(&currentApartment)
+		service.UpdateSameFlag(&currentApartment)
+		currentApartment.OwnerSince = ownerSinceEntry.Text
+		currentApartment.ResidentSince = residentSinceEntry.Text
 
-		if err := saveApartment(currentApartment); err != nil {
+		var err error
+		currentApartment.Block = blockEntry.Text
+		if currentApartment.Floor, err = service.ParseFloor(floorEntry.Text); err != nil {
 			dialog.ShowError(err, mainWindow)
 			return
 		}
+		currentApartment.UnitType = unitTypeSelect.Selected
+		if currentApartment.CarpetArea, err = service.ParseArea(carpetAreaEntry.Text); err != nil {
+			dialog.ShowError(err, mainWindow)
+			return
+		}
+		if currentApartment.SuperBuiltUpArea, err = service.ParseArea(superAreaEntry.Text); err != nil {
+			dialog.ShowError(err, mainWindow)
+			return
+		}
+		currentApartment.ParkingSlots = service.ParseParkingSlots(parkingEntry.Text)
+
+		mine := currentApartment
+		if err := saveApartment(mine); err != nil {
+			showSaveError(err, mainWindow, func(saved any) {
+				refreshList()
+				if apt, ok := saved.(Apartment); ok {
+					loadApartment(apt)
+				} else {
+					resetForm()
+				}
+			}, func(saved any) {
+				mine.Version = saved.(Apartment).Version
+				if err := saveApartment(mine); err != nil {
+					dialog.ShowError(err, mainWindow)
+				}
+				refreshList()
+				resetForm()
+			})
+			return
+		}
 
 		refreshList()
-		clearForm(idEntry, ownerEntry, residentEntry, sameCheck)
+		resetForm()
 	})
 
 	deleteButton := widget.NewButtonWithIcon("Delete", theme.DeleteIcon(), func() {
@@ -464,7 +1036,7 @@ func ShowApartmentManager(myApp fyne.App, previousWindow ...fyne.Window) {
 			return
 		}
 
-		dialog.ShowConfirm("Confirm Delete", "Delete apartment "+currentApartment.ID+"?",
+		dialog.ShowConfirm("Confirm Delete", "Move apartment "+currentApartment.ID+" to the recycle bin?",
 			func(ok bool) {
 				if ok {
 					if err := deleteApartment(currentApartment.ID); err != nil {
@@ -472,11 +1044,36 @@ func ShowApartmentManager(myApp fyne.App, previousWindow ...fyne.Window) {
 						return
 					}
 					refreshList()
-					clearForm(idEntry, ownerEntry, residentEntry, sameCheck)
+					resetForm()
 				}
 			}, mainWindow)
 	})
 
+	historyButton := widget.NewButtonWithIcon("History", theme.HistoryIcon(), func() {
+		if currentApartment.ID == "" {
+			dialog.ShowError(errors.New("select an apartment first"), mainWindow)
+			return
+		}
+		mainWindow.Hide()
+		ShowOccupancyTimeline(myApp, mainWindow, currentApartment.ID)
+	})
+
+	ownersButton := widget.NewButtonWithIcon("Owners", theme.AccountIcon(), func() {
+		if currentApartment.ID == "" {
+			dialog.ShowError(errors.New("select an apartment first"), mainWindow)
+			return
+		}
+		showOwnershipDialog(mainWindow, currentApartment.ID)
+	})
+
+	duplicatesButton := widget.NewButtonWithIcon("Duplicate IDs", theme.SearchIcon(), func() {
+		mainWindow.Hide()
+		ShowDuplicateIDs(myApp, mainWindow, func() {
+			refreshList()
+			resetForm()
+		})
+	})
+
 	// Back button
 	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
 		mainWindow.Hide()
@@ -545,10 +1142,23 @@ func ShowApartmentManager(myApp fyne.App, previousWindow ...fyne.Window) {
 		fd.Show()
 	})
 
+	// Read-only roles can browse and export but not change apartments
+	if !can(PermEditApartments) {
+		saveButton.Disable()
+		importButton.Disable()
+	}
+	if !can(PermDeleteApartments) {
+		deleteButton.Disable()
+	}
+
+	binButton := recycleBinButton(myApp, mainWindow, store.BinApartments, refreshList)
+
 	// Layout
-	buttons := container.NewHBox(saveButton, deleteButton, importButton, exportButton)
+	buttons := container.NewHBox(saveButton, deleteButton, historyButton, ownersButton, importButton, exportButton,
+		duplicatesButton, binButton)
 	if len(previousWindow) > 0 {
-		buttons = container.NewHBox(saveButton, deleteButton, importButton, exportButton, backButton)
+		buttons = container.NewHBox(saveButton, deleteButton, historyButton, ownersButton, importButton, exportButton,
+			duplicatesButton, binButton, backButton)
 	}
 
 	form := container.NewVBox(
@@ -560,23 +1170,38 @@ func ShowApartmentManager(myApp fyne.App, previousWindow ...fyne.Window) {
 		widget.NewLabel("Resident:"),
 		residentEntry,
 		sameCheck,
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Owner Since:"), widget.NewLabel("Resident Since:"),
+			ownerSinceEntry, residentSinceEntry,
+		),
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Block:"), widget.NewLabel("Floor:"),
+			blockEntry, floorEntry,
+		),
+		widget.NewLabel("Unit Type:"),
+		unitTypeSelect,
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Carpet Area:"), widget.NewLabel("Super Built-up Area:"),
+			carpetAreaEntry, superAreaEntry,
+		),
+		widget.NewLabel("Parking Slots:"),
+		parkingEntry,
 		buttons,
 	)
 
 	split := container.NewHSplit(
 		container.NewBorder(nil, nil, nil, nil, apartmentsList),
-		form,
+		container.NewVScroll(form),
 	)
 	split.Offset = 0.3
 
-	mainWindow.SetContent(split)
-	mainWindow.Show()
+	showSessionWindow(mainWindow, split)
 }
 
 // Collection Manager UI
 func ShowCollectionManager(myApp fyne.App, previousWindow fyne.Window) {
 	collectionWindow := myApp.NewWindow("Collection Manager")
-	collectionWindow.Resize(fyne.NewSize(600, 500))
+	collectionWindow.Resize(fyne.NewSize(600, 700))
 
 	// Get all apartment IDs for dropdown
 	apartmentIDs := getApartmentIDs()
@@ -585,7 +1210,39 @@ func ShowCollectionManager(myApp fyne.App, previousWindow fyne.Window) {
 	apartmentSelect := widget.NewSelect(apartmentIDs, nil)
 	apartmentDetailsLabel := widget.NewLabel("")
 
+	// Collections already recorded for the selected apartment
+	var statement []service.StatementLine
+	var selectedCollection *Collection
+	collectionsList := widget.NewList(
+		func() int { return len(statement) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			c := statement[id].Collection
+			obj.(*widget.Label).SetText(fmt.Sprintf("#%d  %s  %s %s: %s  %s", c.ReceiptNumber, c.Date, c.Month, c.Type,
+				c.Price, occupantsText(statement[id].Occupants)))
+		},
+	)
+	collectionsList.OnSelected = func(id widget.ListItemID) {
+		selectedCollection = &statement[id].Collection
+	}
+
+	refreshCollections := func() {
+		statement = nil
+		if apartmentSelect.Selected != "" {
+			var err error
+			statement, err = getStatement(apartmentSelect.Selected)
+			if err != nil {
+				log.Println("Error fetching collections:", err)
+			}
+		}
+		selectedCollection = nil
+		collectionsList.UnselectAll()
+		collectionsList.Refresh()
+	}
+
 	apartmentSelect.OnChanged = func(id string) {
+		refreshCollections()
+
 		apt, err := getApartmentByID(id)
 		if err != nil {
 			apartmentDetailsLabel.SetText("Error: " + err.Error())
@@ -597,11 +1254,7 @@ func ShowCollectionManager(myApp fyne.App, previousWindow fyne.Window) {
 	}
 
 	// Month dropdown
-	months := []string{
-		"January", "February", "March", "April", "May", "June",
-		"July", "August", "September", "October", "November", "December",
-	}
-	monthSelect := widget.NewSelect(months, nil)
+	monthSelect := widget.NewSelect(service.MonthNames, nil)
 
 	// Type dropdown
 	collectionTypes := []string{"Maintenance", "Other"}
@@ -609,7 +1262,7 @@ func ShowCollectionManager(myApp fyne.App, previousWindow fyne.Window) {
 
 	// Price field (readonly)
 	priceEntry := widget.NewEntry()
-	priceEntry.SetText("₹4000")
+	priceEntry.SetText(defaultMaintenance().String())
 	priceEntry.Disable()
 
 	// Process button
@@ -620,17 +1273,55 @@ func ShowCollectionManager(myApp fyne.App, previousWindow fyne.Window) {
 		}
 
 		err := saveCollection(apartmentSelect.Selected, monthSelect.Selected,
-			typeSelect.Selected, 4000.0)
+			typeSelect.Selected, defaultMaintenance())
 		if err != nil {
 			dialog.ShowError(err, collectionWindow)
 			return
 		}
 
+		refreshCollections()
 		dialog.ShowInformation("Success",
 			"Collection recorded and receipt generated",
 			collectionWindow)
 	})
 
+	if !can(PermRecordCollections) {
+		processButton.Disable()
+	}
+
+	deleteButton := widget.NewButtonWithIcon("Delete", theme.DeleteIcon(), func() {
+		if selectedCollection == nil {
+			dialog.ShowError(errors.New("select a collection first"), collectionWindow)
+			return
+		}
+		collection := *selectedCollection
+		dialog.ShowConfirm("Confirm Delete",
+			fmt.Sprintf("Move collection #%d (%s %s, %s) to the recycle bin?\n"+
+				"The month will show as due again.", collection.ReceiptNumber, collection.Month, collection.Type, collection.Price),
+			func(ok bool) {
+				if !ok {
+					return
+				}
+				if err := deleteCollection(collection.ID); err != nil {
+					dialog.ShowError(err, collectionWindow)
+					return
+				}
+				refreshCollections()
+			}, collectionWindow)
+	})
+	if !can(PermDeleteTransactions) {
+		deleteButton.Disable()
+	}
+
+	statementsButton := widget.NewButtonWithIcon("Owner Statements", theme.DocumentIcon(), func() {
+		if apartmentSelect.Selected == "" {
+			dialog.ShowError(errors.New("select an apartment first"), collectionWindow)
+			return
+		}
+		collectionWindow.Hide()
+		ShowOwnerStatements(myApp, collectionWindow, apartmentSelect.Selected)
+	})
+
 	// Back button
 	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
 		collectionWindow.Hide()
@@ -650,10 +1341,12 @@ func ShowCollectionManager(myApp fyne.App, previousWindow fyne.Window) {
 		widget.NewLabel("Price:"),
 		priceEntry,
 		container.NewHBox(processButton, backButton),
+		widget.NewLabel("Recorded Collections:"),
 	)
+	actions := container.NewHBox(deleteButton, statementsButton,
+		recycleBinButton(myApp, collectionWindow, store.BinCollections, refreshCollections))
 
-	collectionWindow.SetContent(content)
-	collectionWindow.Show()
+	showSessionWindow(collectionWindow, container.NewBorder(content, actions, nil, nil, collectionsList))
 }
 
 // Function to get all apartment IDs
@@ -693,46 +1386,45 @@ func ShowCollectionManager(myApp fyne.App, previousWindow fyne.Window) {
 // }
 
 // Function to save collection
-func saveCollection(apartmentID, month, collectionType string, price float64) error {
-	// First save to database
-	result, err := apartmentDB.Exec(
-		"INSERT INTO collections (apartment_id, month, type, price) VALUES (?, ?, ?, ?)",
-		apartmentID, month, collectionType, price)
-	if err != nil {
+func saveCollection(apartmentID, month, collectionType string, price money.Money) error {
+	if err := requirePermission(PermRecordCollections); err != nil {
 		return err
 	}
 
-	// Get the ID of the newly inserted record
-	id, err := result.LastInsertId()
+	collection, err := appService.RecordCollection(apartmentID, month, collectionType, price)
 	if err != nil {
 		return err
 	}
 
-	// Create a Collection object
-	collection := Collection{
-		ID:          int(id),
-		ApartmentID: apartmentID,
-		Month:       month,
-		Type:        collectionType,
-		Price:       price,
-		Date:        time.Now().Format("2006-01-02"),
+	if err := recordAudit(auditEntityCollection, strconv.Itoa(collection.ID),
+		auditActionCreate, nil, collection); err != nil {
+		return err
 	}
 
 	// Generate the receipt PDF
 	return generateReceipt(collection)
 }
 
+// deleteCollection moves a collection to the recycle bin
+func deleteCollection(id int) error {
+	if err := requirePermission(PermDeleteTransactions); err != nil {
+		return err
+	}
+
+	before, err := appService.DeleteCollection(id, currentUser.Username)
+	if err != nil {
+		return err
+	}
+	return recordAudit(auditEntityCollection, strconv.Itoa(id), auditActionDelete, before, nil)
+}
+
 // Accounts Manager UI
 func ShowAccountsManager(myApp fyne.App, previousWindow fyne.Window) {
 	accountsWindow := myApp.NewWindow("Accounts Manager")
 	accountsWindow.Resize(fyne.NewSize(600, 500))
 
 	// UI elements
-	months := []string{
-		"January", "February", "March", "April", "May", "June",
-		"July", "August", "September", "October", "November", "December",
-	}
-	monthSelect := widget.NewSelect(months, nil)
+	monthSelect := widget.NewSelect(service.MonthNames, nil)
 
 	// Expense type dropdown
 	expenseTypes := []string{"Security Service", "Cleaning Services", "Utilities", "Repairs"}
@@ -753,14 +1445,21 @@ func ShowAccountsManager(myApp fyne.App, previousWindow fyne.Window) {
 		func() fyne.CanvasObject { return widget.NewLabel("") },
 		func(id widget.ListItemID, obj fyne.CanvasObject) {
 			t := transactions[id]
-			obj.(*widget.Label).SetText(fmt.Sprintf("%s - %s: %s ₹%.2f",
+			obj.(*widget.Label).SetText(fmt.Sprintf("%s - %s: %s %s",
 				t.Month, t.Type, t.TransactionType, t.Price))
 		},
 	)
 
+	var selectedPayment *Payment
+	transactionList.OnSelected = func(id widget.ListItemID) {
+		selectedPayment = &transactions[id]
+	}
+
 	// Function to refresh transaction list
 	refreshTransactionList := func() {
 		transactions = getRecentTransactions(10)
+		selectedPayment = nil
+		transactionList.UnselectAll()
 		transactionList.Refresh()
 	}
 
@@ -775,9 +1474,9 @@ func ShowAccountsManager(myApp fyne.App, previousWindow fyne.Window) {
 			return
 		}
 
-		price, err := strconv.ParseFloat(priceEntry.Text, 64)
+		price, err := money.Parse(priceEntry.Text)
 		if err != nil {
-			dialog.ShowError(errors.New("invalid price format"), accountsWindow)
+			dialog.ShowError(err, accountsWindow)
 			return
 		}
 
@@ -802,6 +1501,10 @@ func ShowAccountsManager(myApp fyne.App, previousWindow fyne.Window) {
 		transactionSelect.ClearSelected()
 	})
 
+	if !can(PermEditAccounts) {
+		processButton.Disable()
+	}
+
 	// Back button
 	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
 		accountsWindow.Hide()
@@ -826,10 +1529,35 @@ func ShowAccountsManager(myApp fyne.App, previousWindow fyne.Window) {
 	scrollableList := container.NewScroll(transactionList)
 	scrollableList.SetMinSize(fyne.NewSize(1000, 300)) // Width enough to show full transaction details
 
+	deleteButton := widget.NewButtonWithIcon("Delete", theme.DeleteIcon(), func() {
+		if selectedPayment == nil {
+			dialog.ShowError(errors.New("select a transaction first"), accountsWindow)
+			return
+		}
+		payment := *selectedPayment
+		dialog.ShowConfirm("Confirm Delete",
+			fmt.Sprintf("Move the %s %s transaction of %s to the recycle bin?", payment.Month, payment.Type, payment.Price),
+			func(ok bool) {
+				if !ok {
+					return
+				}
+				if err := deletePayment(payment.ID); err != nil {
+					dialog.ShowError(err, accountsWindow)
+					return
+				}
+				refreshTransactionList()
+			}, accountsWindow)
+	})
+	if !can(PermDeleteTransactions) {
+		deleteButton.Disable()
+	}
+
 	// Use the scrollable list in the history section, not the raw transactionList
 	history := container.NewVBox(
 		widget.NewLabel("Recent Transactions (Last 10)"),
 		scrollableList,
+		container.NewHBox(deleteButton,
+			recycleBinButton(myApp, accountsWindow, store.BinPayments, refreshTransactionList)),
 	)
 
 	// Use tabs for switching between form and history
@@ -847,107 +1575,69 @@ func ShowAccountsManager(myApp fyne.App, previousWindow fyne.Window) {
 
 	content := tabs
 
-	accountsWindow.SetContent(content)
-	accountsWindow.Show()
+	showSessionWindow(accountsWindow, content)
 }
 
-// Payment struct
-type Payment struct {
-	ID              int
-	Month           string
-	Type            string
-	Price           float64
-	TransactionType string
-	Date            string
+// Payment database operations
+func savePayment(month, expenseType string, price money.Money, transactionType string) error {
+	if err := requirePermission(PermEditAccounts); err != nil {
+		return err
+	}
+
+	payment, err := appService.RecordPayment(month, expenseType, price, transactionType)
+	if err != nil {
+		return err
+	}
+	return recordAudit(auditEntityPayment, strconv.Itoa(payment.ID), auditActionCreate, nil, payment)
 }
 
-// Payment database operations
-func savePayment(month, expenseType string, price float64, transactionType string) error {
-	_, err := apartmentDB.Exec(
-		"INSERT INTO payments (month, type, price, transaction_type) VALUES (?, ?, ?, ?)",
-		month, expenseType, price, transactionType)
-	return err
+// deletePayment moves a payment to the recycle bin
+func deletePayment(id int) error {
+	if err := requirePermission(PermDeleteTransactions); err != nil {
+		return err
+	}
+
+	before, err := appService.DeletePayment(id, currentUser.Username)
+	if err != nil {
+		return err
+	}
+	return recordAudit(auditEntityPayment, strconv.Itoa(id), auditActionDelete, before, nil)
 }
 
 func getRecentTransactions(limit int) []Payment {
-	var transactions []Payment
-
-	rows, err := apartmentDB.Query(
-		`SELECT id, month, type, price, transaction_type, datetime(date) 
-         FROM payments ORDER BY date DESC LIMIT ?`,
-		limit)
+	transactions, err := appStore.RecentPayments(limit)
 	if err != nil {
 		log.Println("Error fetching transactions:", err)
-		return transactions
 	}
-	defer rows.Close()
+	return transactions
+}
 
-	for rows.Next() {
-		var t Payment
-		var date string
-		err := rows.Scan(&t.ID, &t.Month, &t.Type, &t.Price, &t.TransactionType, &date)
-		if err != nil {
-			continue
-		}
-		t.Date = date
-		transactions = append(transactions, t)
+// writeReceipt renders the receipt for a collection to w
+func writeReceipt(collection Collection, w io.Writer) error {
+	if !canAccessApartment(collection.ApartmentID) {
+		return errors.New("permission denied: apartment is not linked to your account")
 	}
-	return transactions
+	occupants, err := appService.CollectionOccupants(collection)
+	if err != nil {
+		return err
+	}
+	return service.WriteReceipt(w, collection, currentSociety, occupants)
 }
 
 func generateReceipt(collection Collection) error {
-	// Create PDF document
-	pdf := gofpdf.New("P", "mm", "A4", "")
-	pdf.AddPage()
-	pdf.SetFont("Arial", "B", 16)
-	pdf.Cell(40, 10, "Apartment Management System")
-	pdf.Ln(15)
-
-	pdf.SetFont("Arial", "", 12)
-	pdf.Cell(40, 10, fmt.Sprintf("Receipt #: %d", collection.ID))
-	pdf.Ln(8)
-	pdf.Cell(40, 10, fmt.Sprintf("Date: %s", collection.Date))
-	pdf.Ln(8)
-	pdf.Cell(40, 10, fmt.Sprintf("Apartment: %s", collection.ApartmentID))
-	pdf.Ln(8)
-	pdf.Cell(40, 10, fmt.Sprintf("Month: %s", collection.Month))
-	pdf.Ln(8)
-	pdf.Cell(40, 10, fmt.Sprintf("Type: %s", collection.Type))
-	pdf.Ln(8)
-	pdf.Cell(40, 10, fmt.Sprintf("Amount: ₹%.2f", collection.Price))
-
-	// Create the output directory if it doesn't exist
-	outputDir := "/home/l30/Documents/apartment_login/pdf"
-	if _, err := os.Stat(outputDir); os.IsNotExist(err) {
-		err = os.MkdirAll(outputDir, 0o755)
-		if err != nil {
-			return fmt.Errorf("failed to create output directory: %w", err)
-		}
+	occupants, err := appService.CollectionOccupants(collection)
+	if err != nil {
+		return err
 	}
-
-	// Create the full file path
-	filename := filepath.Join(outputDir, fmt.Sprintf("receipt_%d_%s.pdf", collection.ID, collection.ApartmentID))
-
-	// Save the PDF
-	err := pdf.OutputFileAndClose(filename)
+	filename, err := service.SaveReceipt(config.ReceiptDir, collection, currentSociety, occupants)
 	if err != nil {
-		return fmt.Errorf("failed to save PDF file: %w", err)
+		return err
 	}
 
 	fmt.Printf("PDF receipt generated: %s\n", filename)
 	return nil
 }
 
-// Collection struct
-type Collection struct {
-	ID          int
-	ApartmentID string
-	Month       string
-	Type        string
-	Price       float64
-	Date        string
-}
-
 // Function to get collection by ID
 // func getCollectionByID(id int) (Collection, error) {
 // 	var c Collection
@@ -986,77 +1676,57 @@ type Collection struct {
 
 // Apartment database operations
 func saveApartment(apt Apartment) error {
-	updateSameFlag(&apt)
+	if err := requirePermission(PermEditApartments); err != nil {
+		return err
+	}
 
-	_, err := apartmentDB.Exec(
-		`INSERT OR REPLACE INTO apartments (id, owner, resident, same_flag) 
-		VALUES (?, ?, ?, ?)`,
-		apt.ID, apt.Owner, apt.Resident, boolToInt(apt.SameFlag),
-	)
-	return err
+	change, err := appService.SaveApartment(apt)
+	if err != nil {
+		return err
+	}
+	invalidateApartmentLists()
+	return auditApartmentChange(change)
 }
 
-func deleteApartment(id string) error {
-	_, err := apartmentDB.Exec("DELETE FROM apartments WHERE id = ?", id)
-	return err
+// auditApartmentChange records the creation or update of an apartment
+func auditApartmentChange(change service.ApartmentChange) error {
+	action := auditActionCreate
+	if change.Before != nil {
+		action = auditActionUpdate
+	}
+	return recordAudit(auditEntityApartment, change.After.ID, action, change.Before, change.After)
 }
 
-func getApartmentCount() int {
-	var count int
-	apartmentDB.QueryRow("SELECT COUNT(*) FROM apartments").Scan(&count)
-	return count
-}
+func deleteApartment(id string) error {
+	if err := requirePermission(PermDeleteApartments); err != nil {
+		return err
+	}
 
-func getApartmentByIndex(index int) Apartment {
-	var apt Apartment
-	var sameFlag int
+	before, err := appService.DeleteApartment(id, currentUser.Username)
+	if err != nil {
+		return err
+	}
+	invalidateApartmentLists()
 
-	row := apartmentDB.QueryRow(
-		"SELECT id, owner, resident, same_flag FROM apartments LIMIT 1 OFFSET ?",
-		index,
-	)
-	row.Scan(&apt.ID, &apt.Owner, &apt.Resident, &sameFlag)
-	apt.SameFlag = intToBool(sameFlag)
-	return apt
+	return recordAudit(auditEntityApartment, id, auditActionDelete, before, nil)
 }
 
 // Helper functions
-// Add these helper functions
 func getApartmentIDs() []string {
 	var ids []string
-	rows, err := apartmentDB.Query("SELECT id FROM apartments ORDER BY id")
+	apartments, err := appStore.ListApartments(0, 0)
 	if err != nil {
 		log.Println("Error fetching apartment IDs:", err)
 		return ids
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
-			continue
-		}
-		ids = append(ids, id)
+	for _, apt := range apartments {
+		ids = append(ids, apt.ID)
 	}
 	return ids
 }
 
 func getApartmentByID(id string) (Apartment, error) {
-	var apt Apartment
-	var sameFlag int
-
-	err := apartmentDB.QueryRow(
-		"SELECT id, owner, resident, same_flag FROM apartments WHERE id = ?",
-		id).Scan(&apt.ID, &apt.Owner, &apt.Resident, &sameFlag)
-	if err != nil {
-		return apt, err
-	}
-	apt.SameFlag = sameFlag == 1
-	return apt, nil
-}
-
-func updateSameFlag(apt *Apartment) {
-	apt.SameFlag = apt.Owner != "" && apt.Owner == apt.Resident
+	return appStore.GetApartment(id)
 }
 
 func boolToInt(b bool) int {
@@ -1080,6 +1750,10 @@ func clearForm(idEntry, ownerEntry, residentEntry *widget.Entry, sameCheck *widg
 
 // Import/Export functions
 func importFromCSV(path string, refresh func()) error {
+	if err := requirePermission(PermEditApartments); err != nil {
+		return err
+	}
+
 	file, err := os.Open(path)
 	if err != nil {
 		return err
@@ -1092,39 +1766,31 @@ func importFromCSV(path string, refresh func()) error {
 		return err
 	}
 
-	tx, err := apartmentDB.Begin()
+	return importApartmentRecords(records)
+}
+
+// importApartmentRecords saves imported rows in one transaction, reading the
+// columns from the header row, and audits every change
+func importApartmentRecords(records [][]string) error {
+	changes, err := appService.ImportApartments(records)
 	if err != nil {
 		return err
 	}
-
-	for i, record := range records {
-		if i == 0 { // Skip header
-			continue
-		}
-
-		apt := Apartment{
-			ID:       record[0],
-			Owner:    record[1],
-			Resident: record[2],
-		}
-		if apt.Resident == "" {
-			apt.Resident = "Vacant"
-		}
-		updateSameFlag(&apt)
-
-		_, err = tx.Exec(
-			"INSERT OR REPLACE INTO apartments (id, owner, resident, same_flag) VALUES (?, ?, ?, ?)",
-			apt.ID, apt.Owner, apt.Resident, boolToInt(apt.SameFlag),
-		)
-		if err != nil {
-			tx.Rollback()
+	invalidateApartmentLists()
+	for _, change := range changes {
+		if err := auditApartmentChange(change); err != nil {
 			return err
 		}
 	}
-	return tx.Commit()
+	return nil
 }
 
 func exportToCSV(path string) error {
+	apartments, err := appStore.ListApartments(0, 0)
+	if err != nil {
+		return err
+	}
+
 	file, err := os.Create(path)
 	if err != nil {
 		return err
@@ -1134,25 +1800,12 @@ func exportToCSV(path string) error {
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
 
-	header := []string{"ID", "Owner", "Resident", "Same"}
-	if err := writer.Write(header); err != nil {
-		return err
-	}
-
-	rows, err := apartmentDB.Query("SELECT id, owner, resident, same_flag FROM apartments")
-	if err != nil {
+	if err := writer.Write(service.ApartmentColumns); err != nil {
 		return err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var id, owner, resident string
-		var sameFlag int
-		if err := rows.Scan(&id, &owner, &resident, &sameFlag); err != nil {
-			return err
-		}
-		record := []string{id, owner, resident, fmt.Sprintf("%t", intToBool(sameFlag))}
-		if err := writer.Write(record); err != nil {
+	for _, apt := range apartments {
+		if err := writer.Write(service.ApartmentRecord(apt)); err != nil {
 			return err
 		}
 	}
@@ -1160,6 +1813,10 @@ func exportToCSV(path string) error {
 }
 
 func importFromExcel(path string, refresh func()) error {
+	if err := requirePermission(PermEditApartments); err != nil {
+		return err
+	}
+
 	f, err := excelize.OpenFile(path)
 	if err != nil {
 		return err
@@ -1171,75 +1828,81 @@ func importFromExcel(path string, refresh func()) error {
 		return err
 	}
 
-	tx, err := apartmentDB.Begin()
+	return importApartmentRecords(rows)
+}
+
+func exportToExcel(path string) error {
+	apartments, err := appStore.ListApartments(0, 0)
 	if err != nil {
 		return err
 	}
 
-	for i, row := range rows {
-		if i == 0 { // Skip header
-			continue
-		}
-
-		apt := Apartment{
-			ID:       row[0],
-			Owner:    row[1],
-			Resident: row[2],
-		}
-		if apt.Resident == "" {
-			apt.Resident = "Vacant"
-		}
-		updateSameFlag(&apt)
-
-		_, err = tx.Exec(
-			"INSERT OR REPLACE INTO apartments (id, owner, resident, same_flag) VALUES (?, ?, ?, ?)",
-			apt.ID, apt.Owner, apt.Resident, boolToInt(apt.SameFlag),
-		)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-	return tx.Commit()
-}
-
-func exportToExcel(path string) error {
 	f := excelize.NewFile()
 	defer f.Close()
 
 	// Create header
-	f.SetCellValue("Sheet1", "A1", "ID")
-	f.SetCellValue("Sheet1", "B1", "Owner")
-	f.SetCellValue("Sheet1", "C1", "Resident")
-	f.SetCellValue("Sheet1", "D1", "Same")
-
-	rows, err := apartmentDB.Query("SELECT id, owner, resident, same_flag FROM apartments")
-	if err != nil {
+	header := make([]any, len(service.ApartmentColumns))
+	for i, name := range service.ApartmentColumns {
+		header[i] = name
+	}
+	if err := f.SetSheetRow("Sheet1", "A1", &header); err != nil {
 		return err
 	}
-	defer rows.Close()
 
-	rowIdx := 2
-	for rows.Next() {
-		var id, owner, resident string
-		var sameFlag int
-		if err := rows.Scan(&id, &owner, &resident, &sameFlag); err != nil {
+	// Numbers are written as numbers so they can be totalled in the sheet,
+	// and unknown areas as blank cells rather than zeros
+	for i, apt := range apartments {
+		row := []any{
+			apt.ID, apt.Owner, apt.Resident, apt.SameFlag, apt.Block, apt.Floor, apt.UnitType,
+			areaCell(apt.CarpetArea), areaCell(apt.SuperBuiltUpArea), strings.Join(apt.ParkingSlots, ", "),
+		}
+		if err := f.SetSheetRow("Sheet1", fmt.Sprintf("A%d", i+2), &row); err != nil {
 			return err
 		}
-
-		f.SetCellValue("Sheet1", fmt.Sprintf("A%d", rowIdx), id)
-		f.SetCellValue("Sheet1", fmt.Sprintf("B%d", rowIdx), owner)
-		f.SetCellValue("Sheet1", fmt.Sprintf("C%d", rowIdx), resident)
-		f.SetCellValue("Sheet1", fmt.Sprintf("D%d", rowIdx), intToBool(sameFlag))
-		rowIdx++
 	}
 
 	return f.SaveAs(path)
 }
 
+// areaCell is an area as an Excel cell value: nil, a blank cell, when the
+// area is unknown
+func areaCell(area float64) any {
+	if area == 0 {
+		return nil
+	}
+	return area
+}
+
 func main() {
+	setup := flag.Bool("setup", false, "create the first administrator without opening a window")
+	society := flag.String("society", "", "society name for -setup")
+	adminUser := flag.String("admin", "", "administrator username for -setup")
+	configOverrides := registerConfigFlags()
+	flag.Parse()
+
+	if err := loadConfig(configOverrides); err != nil {
+		log.Fatal(err)
+	}
+	if *society == "" {
+		*society = config.Society.Name
+	}
+
 	initDBs()
+
+	if *setup {
+		if err := runHeadlessSetup(*society, *adminUser, os.Stdin); err != nil {
+			log.Fatal("Setup failed: ", err)
+		}
+		return
+	}
+
+	startBackupScheduler()
+
 	myApp := app.New()
-	ShowLoginWindow(myApp)
+	if needsFirstRunSetup() {
+		ShowSetupWindow(myApp)
+	} else {
+		ShowLoginWindow(myApp)
+	}
 	myApp.Run()
 }
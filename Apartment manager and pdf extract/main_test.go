@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+
+	"apartment_login/store"
+)
+
+func TestExportToExcel(t *testing.T) {
+	newTestDatabases(t)
+	for _, apt := range []store.Apartment{
+		{ID: "101", Owner: "Asha", Floor: 1, CarpetArea: 850.5, SuperBuiltUpArea: 1100},
+		{ID: "G01", Owner: "Ravi"},
+	} {
+		if _, err := appService.SaveApartment(apt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "apartments.xlsx")
+	if err := exportToExcel(path); err != nil {
+		t.Fatal(err)
+	}
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Floor, carpet area and super built-up area of each apartment
+	want := map[string]string{
+		"F2": "1", "H2": "850.5", "I2": "1100",
+		"F3": "0", "H3": "", "I3": "",
+	}
+	for cell, value := range want {
+		got, err := f.GetCellValue("Sheet1", cell)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != value {
+			t.Errorf("%s = %q, want %q", cell, got, value)
+		}
+	}
+}
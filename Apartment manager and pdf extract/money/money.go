@@ -0,0 +1,181 @@
+// Package money handles rupee amounts held as integer paise.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in paise. Amounts are stored and summed as integers so
+// totals over thousands of rows never drift the way float64 rupees do.
+type Money int64
+
+// maxMoney keeps parsed amounts far from int64 overflow when summed
+const maxMoney = Money(1e15)
+
+// Symbol is the currency symbol printed by String and accepted by Parse
+var Symbol = "₹"
+
+var (
+	ErrNegative = errors.New("amount cannot be negative")
+	ErrInvalid  = errors.New("enter an amount such as 4000, 4,000.50 or 1,23,456.78")
+	ErrTooLarge = errors.New("amount is too large")
+)
+
+// amountPattern accepts plain digits or Indian/Western digit grouping with
+// up to two decimal places
+var amountPattern = regexp.MustCompile(`^(\d+|\d{1,3}(,\d{3})+|\d{1,2}(,\d{2})*,\d{3})(\.\d{1,2})?$`)
+
+// Parse reads an amount typed by a user, such as "₹1,23,456.78".
+// Negative, exponent, NaN and Inf forms are rejected.
+func Parse(text string) (Money, error) {
+	text = strings.TrimSpace(text)
+	text = strings.TrimSpace(strings.TrimPrefix(text, Symbol))
+	text = strings.TrimSpace(strings.TrimPrefix(text, "₹"))
+	if strings.HasPrefix(text, "-") {
+		return 0, ErrNegative
+	}
+	if !amountPattern.MatchString(text) {
+		return 0, ErrInvalid
+	}
+
+	rupees, paise, _ := strings.Cut(strings.ReplaceAll(text, ",", ""), ".")
+	if len(paise) == 1 {
+		paise += "0"
+	}
+	if len(rupees) > 13 {
+		return 0, ErrTooLarge
+	}
+
+	whole, err := strconv.ParseInt(rupees, 10, 64)
+	if err != nil {
+		return 0, ErrInvalid
+	}
+	var fraction int64
+	if paise != "" {
+		if fraction, err = strconv.ParseInt(paise, 10, 64); err != nil {
+			return 0, ErrInvalid
+		}
+	}
+
+	m := Money(whole*100 + fraction)
+	if m > maxMoney {
+		return 0, ErrTooLarge
+	}
+	return m, nil
+}
+
+// FromFloat converts a rupee amount from a float source such as the
+// config file, rounding to the nearest paisa
+func FromFloat(rupees float64) (Money, error) {
+	if math.IsNaN(rupees) || math.IsInf(rupees, 0) {
+		return 0, ErrInvalid
+	}
+	if rupees < 0 {
+		return 0, ErrNegative
+	}
+	paise := math.Round(rupees * 100)
+	if paise > float64(maxMoney) {
+		return 0, ErrTooLarge
+	}
+	return Money(paise), nil
+}
+
+// Validate checks an amount about to be recorded
+func Validate(m Money) error {
+	switch {
+	case m < 0:
+		return ErrNegative
+	case m == 0:
+		return errors.New("amount must be greater than zero")
+	case m > maxMoney:
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// String formats the amount with Symbol and Indian
+// digit grouping, e.g. ₹1,23,456.78
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	return fmt.Sprintf("%s%s%s.%02d", sign, Symbol, groupIndian(int64(m/100)), int64(m%100))
+}
+
+// groupIndian inserts separators after the thousands and then every two
+// digits: 1,23,45,678
+func groupIndian(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	if len(digits) <= 3 {
+		return digits
+	}
+
+	head, tail := digits[:len(digits)-3], digits[len(digits)-3:]
+	var groups []string
+	for len(head) > 2 {
+		groups = append([]string{head[len(head)-2:]}, groups...)
+		head = head[:len(head)-2]
+	}
+	groups = append([]string{head}, groups...)
+	return strings.Join(groups, ",") + "," + tail
+}
+
+// Allocate splits the amount in proportion to weights. Paise left over by
+// rounding go to the largest remainders, so the parts always add up to m. A
+// negative amount is split as its size and each part negated.
+func (m Money) Allocate(weights []int) []Money {
+	if m < 0 {
+		parts := (-m).Allocate(weights)
+		for i := range parts {
+			parts[i] = -parts[i]
+		}
+		return parts
+	}
+
+	parts := make([]Money, len(weights))
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	if total <= 0 {
+		return parts
+	}
+
+	remainders := make([]int64, len(weights))
+	allocated := Money(0)
+	for i, w := range weights {
+		share := int64(m) * int64(w)
+		parts[i] = Money(share / int64(total))
+		remainders[i] = share % int64(total)
+		allocated += parts[i]
+	}
+	for left := m - allocated; left > 0; left-- {
+		largest := 0
+		for i := range remainders {
+			if remainders[i] > remainders[largest] {
+				largest = i
+			}
+		}
+		parts[largest]++
+		remainders[largest] = -1
+	}
+	return parts
+}
+
+// MarshalJSON writes the amount in rupees so audit entries read the same as
+// those recorded when amounts were stored as REAL
+func (m Money) MarshalJSON() ([]byte, error) {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	return []byte(fmt.Sprintf("%s%d.%02d", sign, int64(m/100), int64(m%100))), nil
+}
@@ -0,0 +1,150 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		text string
+		want Money
+		err  error
+	}{
+		{"4000", 400000, nil},
+		{"4000.5", 400050, nil},
+		{"4000.50", 400050, nil},
+		{"0.05", 5, nil},
+		{" ₹4,000 ", 400000, nil},
+		{"₹ 1,23,456.78", 12345678, nil},
+		{"1,23,45,678", 1234567800, nil},
+		{"12,345", 1234500, nil},
+		{"123,456.78", 12345678, nil},
+		{"1,234,567", 123456700, nil},
+		{"9999999999999.99", 999999999999999, nil},
+		{"-1", 0, ErrNegative},
+		{"-₹5", 0, ErrNegative},
+		{"₹-5", 0, ErrNegative},
+		{"NaN", 0, ErrInvalid},
+		{"Inf", 0, ErrInvalid},
+		{"+Inf", 0, ErrInvalid},
+		{"1e3", 0, ErrInvalid},
+		{"1E3", 0, ErrInvalid},
+		{".5", 0, ErrInvalid},
+		{"5.", 0, ErrInvalid},
+		{"4000.505", 0, ErrInvalid},
+		{"", 0, ErrInvalid},
+		{"12,34", 0, ErrInvalid},
+		{"1,2345", 0, ErrInvalid},
+		{"1,23,4567", 0, ErrInvalid},
+		{",123", 0, ErrInvalid},
+		{"0x10", 0, ErrInvalid},
+		{"10000000000000", 0, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.text)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q) = %d, %v; want %d, %v", tt.text, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestMoneyFromFloat(t *testing.T) {
+	tests := []struct {
+		rupees float64
+		want   Money
+		err    error
+	}{
+		{4000, 400000, nil},
+		{0.1 + 0.2, 30, nil},
+		{1234.565, 123457, nil},
+		{19.999, 2000, nil},
+		{0, 0, nil},
+		{-0.01, 0, ErrNegative},
+		{math.NaN(), 0, ErrInvalid},
+		{math.Inf(1), 0, ErrInvalid},
+		{math.Inf(-1), 0, ErrInvalid},
+		{1e14, 0, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		got, err := FromFloat(tt.rupees)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("FromFloat(%v) = %d, %v; want %d, %v", tt.rupees, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestValidateAmount(t *testing.T) {
+	tests := []struct {
+		amount Money
+		ok     bool
+	}{
+		{1, true},
+		{400000, true},
+		{maxMoney, true},
+		{0, false},
+		{-1, false},
+		{maxMoney + 1, false},
+	}
+	for _, tt := range tests {
+		if err := Validate(tt.amount); (err == nil) != tt.ok {
+			t.Errorf("Validate(%d) = %v, want ok %v", tt.amount, err, tt.ok)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		amount Money
+		want   string
+	}{
+		{0, "₹0.00"},
+		{5, "₹0.05"},
+		{400050, "₹4,000.50"},
+		{12345678, "₹1,23,456.78"},
+		{123456789012, "₹1,23,45,67,890.12"},
+		{99900, "₹999.00"},
+		{-12345678, "-₹1,23,456.78"},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyMarshalJSON(t *testing.T) {
+	for amount, want := range map[Money]string{0: "0.00", 400050: "4000.50", -5: "-0.05"} {
+		got, err := amount.MarshalJSON()
+		if err != nil || string(got) != want {
+			t.Errorf("Money(%d).MarshalJSON() = %s, %v; want %s", amount, got, err, want)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  Money
+		weights []int
+		want    []Money
+	}{
+		{"even", 10000, []int{1, 1}, []Money{5000, 5000}},
+		{"odd paisa", 101, []int{1, 1}, []Money{51, 50}},
+		{"negative odd paisa", -101, []int{1, 1}, []Money{-51, -50}},
+		{"largest remainder", 100001, []int{6000, 4000}, []Money{60001, 40000}},
+		{"thirds", 1000, []int{1, 1, 1}, []Money{334, 333, 333}},
+		{"negative thirds", -1000, []int{1, 1, 1}, []Money{-334, -333, -333}},
+		{"zero weight", 999, []int{0, 3}, []Money{0, 999}},
+		{"no weight", 999, []int{0, 0}, []Money{0, 0}},
+		{"zero", 0, []int{1, 1}, []Money{0, 0}},
+	}
+	for _, tt := range tests {
+		got := tt.amount.Allocate(tt.weights)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: %d.Allocate(%v) = %v, want %v", tt.name, tt.amount, tt.weights, got, tt.want)
+		}
+	}
+}
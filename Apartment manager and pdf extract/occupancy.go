@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"apartment_login/store"
+)
+
+// getOccupancyHistory lists who owned and lived in an apartment over time
+func getOccupancyHistory(apartmentID string) ([]store.Occupancy, error) {
+	if err := requirePermission(PermViewApartments); err != nil {
+		return nil, err
+	}
+	return appStore.OccupancyHistory(apartmentID)
+}
+
+// Occupancy Timeline UI, listing the owners and then the residents of an
+// apartment from the earliest recorded
+func ShowOccupancyTimeline(myApp fyne.App, previousWindow fyne.Window, apartmentID string) {
+	timelineWindow := myApp.NewWindow(fmt.Sprintf("History - %s", apartmentID))
+	timelineWindow.Resize(fyne.NewSize(600, 400))
+
+	history, err := getOccupancyHistory(apartmentID)
+	if err != nil {
+		dialog.ShowError(err, timelineWindow)
+	}
+
+	headers := []string{"Role", "Name", "From", "To"}
+	historyTable := widget.NewTableWithHeaders(
+		func() (int, int) { return len(history), len(headers) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.TableCellID, obj fyne.CanvasObject) {
+			obj.(*widget.Label).SetText(occupancyCell(history[id.Row], id.Col))
+		},
+	)
+	historyTable.ShowHeaderColumn = false
+	historyTable.UpdateHeader = func(id widget.TableCellID, obj fyne.CanvasObject) {
+		obj.(*widget.Label).SetText(headers[id.Col])
+	}
+	for col, width := range []float32{90, 220, 120, 120} {
+		historyTable.SetColumnWidth(col, width)
+	}
+
+	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
+		timelineWindow.Hide()
+		previousWindow.Show()
+	})
+
+	header := widget.NewLabel(fmt.Sprintf("Owners and residents of %s", apartmentID))
+	showSessionWindow(timelineWindow, container.NewBorder(header, container.NewHBox(backButton), nil, nil, historyTable))
+}
+
+// occupancyCell returns the text for one column of the occupancy timeline
+func occupancyCell(period store.Occupancy, col int) string {
+	switch col {
+	case 0:
+		if period.Role == store.OccupancyOwner {
+			return "Owner"
+		}
+		return "Resident"
+	case 1:
+		return period.Name
+	case 2:
+		if period.From == "" {
+			return "(before records)"
+		}
+		return period.From
+	case 3:
+		if period.To == "" {
+			return "present"
+		}
+		return period.To
+	}
+	return ""
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"apartment_login/money"
+	"apartment_login/service"
+	"apartment_login/store"
+)
+
+// getOwners lists the owner and co-owners of an apartment with their shares
+func getOwners(apartmentID string) ([]store.PersonLink, error) {
+	if err := requirePermission(PermViewApartments); err != nil {
+		return nil, err
+	}
+	return appService.Owners(apartmentID)
+}
+
+// setOwnershipShares sets the owners' shares of an apartment, keyed by person
+func setOwnershipShares(apartmentID string, shares map[int]int) error {
+	if err := requirePermission(PermEditApartments); err != nil {
+		return err
+	}
+
+	before, err := appService.SetOwnershipShares(apartmentID, shares)
+	if err != nil {
+		return err
+	}
+	after, err := appService.Owners(apartmentID)
+	if err != nil {
+		return err
+	}
+	return recordAudit(auditEntityApartment, apartmentID, auditActionUpdate,
+		map[string][]store.PersonLink{"owners": before},
+		map[string][]store.PersonLink{"owners": after})
+}
+
+// getVotingWeights lists everyone's voting weight at the AGM
+func getVotingWeights() ([]service.Vote, error) {
+	if err := requirePermission(PermViewApartments); err != nil {
+		return nil, err
+	}
+	return appService.VotingWeights()
+}
+
+// getOwnerStatements splits an apartment's collections for a year among its
+// owners
+func getOwnerStatements(apartmentID string, year int) ([]service.OwnerStatement, error) {
+	if err := requirePermission(PermViewCollections); err != nil {
+		return nil, err
+	}
+	return appService.OwnerStatements(apartmentID, year)
+}
+
+// showOwnershipDialog lets the user set the share of each owner of an
+// apartment
+func showOwnershipDialog(parent fyne.Window, apartmentID string) {
+	owners, err := getOwners(apartmentID)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
+	if len(owners) == 0 {
+		dialog.ShowInformation("Owners", fmt.Sprintf("Apartment %s has no owners linked.", apartmentID), parent)
+		return
+	}
+
+	entries := make([]*widget.Entry, len(owners))
+	grid := container.NewGridWithColumns(3,
+		widget.NewLabelWithStyle("Name", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		widget.NewLabelWithStyle("Role", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		widget.NewLabelWithStyle("Share (%)", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+	)
+	for i, o := range owners {
+		entries[i] = widget.NewEntry()
+		entries[i].SetText(strings.TrimSuffix(service.FormatShare(o.Share), "%"))
+		grid.Add(widget.NewLabel(o.PersonName))
+		grid.Add(widget.NewLabel(string(o.Role)))
+		grid.Add(entries[i])
+	}
+
+	content := container.NewVBox(
+		widget.NewLabel("Co-owners are linked in People. The shares must total 100%."),
+		grid,
+	)
+	form := dialog.NewCustomConfirm(fmt.Sprintf("Owners of %s", apartmentID), "Save", "Cancel", content,
+		func(ok bool) {
+			if !ok {
+				return
+			}
+			shares := map[int]int{}
+			for i, o := range owners {
+				share, err := service.ParseShare(entries[i].Text)
+				if err != nil {
+					dialog.ShowError(fmt.Errorf("%s: %w", o.PersonName, err), parent)
+					return
+				}
+				shares[o.PersonID] = share
+			}
+			if err := setOwnershipShares(apartmentID, shares); err != nil {
+				dialog.ShowError(err, parent)
+			}
+		}, parent)
+	if !can(PermEditApartments) {
+		for _, entry := range entries {
+			entry.Disable()
+		}
+	}
+	form.Show()
+}
+
+// Voting Weights UI, listing everyone's share of the votes at the AGM
+func ShowVotingWeights(myApp fyne.App, previousWindow fyne.Window) {
+	votesWindow := myApp.NewWindow("AGM Voting Weights")
+	votesWindow.Resize(fyne.NewSize(600, 450))
+
+	votes, err := getVotingWeights()
+	if err != nil {
+		dialog.ShowError(err, votesWindow)
+	}
+	total := 0
+	for _, vote := range votes {
+		total += vote.Weight
+	}
+
+	headers := []string{"Name", "Apartments", "Votes"}
+	votesTable := widget.NewTableWithHeaders(
+		func() (int, int) { return len(votes), len(headers) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.TableCellID, obj fyne.CanvasObject) {
+			vote := votes[id.Row]
+			text := vote.Name
+			switch id.Col {
+			case 1:
+				text = strings.Join(vote.Apartments, ", ")
+			case 2:
+				text = service.FormatVotes(vote.Weight)
+			}
+			obj.(*widget.Label).SetText(text)
+		},
+	)
+	votesTable.ShowHeaderColumn = false
+	votesTable.UpdateHeader = func(id widget.TableCellID, obj fyne.CanvasObject) {
+		obj.(*widget.Label).SetText(headers[id.Col])
+	}
+	for col, width := range []float32{200, 250, 80} {
+		votesTable.SetColumnWidth(col, width)
+	}
+
+	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
+		votesWindow.Hide()
+		previousWindow.Show()
+	})
+
+	header := widget.NewLabel(fmt.Sprintf(
+		"Each apartment carries one vote, split among its owners by share. Total: %s votes",
+		service.FormatVotes(total)))
+	showSessionWindow(votesWindow, container.NewBorder(header, container.NewHBox(backButton), nil, nil, votesTable))
+}
+
+// Owner Statements UI, splitting an apartment's collections for a year
+// among its owners
+func ShowOwnerStatements(myApp fyne.App, previousWindow fyne.Window, apartmentID string) {
+	statementsWindow := myApp.NewWindow(fmt.Sprintf("Owner Statements - %s", apartmentID))
+	statementsWindow.Resize(fyne.NewSize(600, 400))
+
+	var statements []service.OwnerStatement
+
+	headers := []string{"Owner", "Share", "Collections", "Owner's Part"}
+	statementsTable := widget.NewTableWithHeaders(
+		func() (int, int) { return len(statements), len(headers) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.TableCellID, obj fyne.CanvasObject) {
+			statement := statements[id.Row]
+			text := statement.Owner.PersonName
+			switch id.Col {
+			case 1:
+				text = service.FormatShare(statement.Owner.Share)
+			case 2:
+				text = strconv.Itoa(len(statement.Lines))
+			case 3:
+				text = statement.Total.String()
+			}
+			obj.(*widget.Label).SetText(text)
+		},
+	)
+	statementsTable.ShowHeaderColumn = false
+	statementsTable.UpdateHeader = func(id widget.TableCellID, obj fyne.CanvasObject) {
+		obj.(*widget.Label).SetText(headers[id.Col])
+	}
+	for col, width := range []float32{200, 80, 100, 130} {
+		statementsTable.SetColumnWidth(col, width)
+	}
+
+	totalLabel := widget.NewLabel("")
+
+	thisYear := time.Now().Year()
+	years := make([]string, 5)
+	for i := range years {
+		years[i] = strconv.Itoa(thisYear - i)
+	}
+	yearSelect := widget.NewSelect(years, func(text string) {
+		year, _ := strconv.Atoi(text)
+		var err error
+		statements, err = getOwnerStatements(apartmentID, year)
+		if err != nil {
+			dialog.ShowError(err, statementsWindow)
+		}
+		var total money.Money
+		for _, statement := range statements {
+			total += statement.Total
+		}
+		totalLabel.SetText(fmt.Sprintf("Received in %d: %s", year, total))
+		statementsTable.Refresh()
+	})
+
+	saveButton := widget.NewButtonWithIcon("Save PDFs", theme.DocumentSaveIcon(), func() {
+		var saved []string
+		for _, statement := range statements {
+			filename, err := service.SaveOwnerStatement(config.ReceiptDir, statement, currentSociety)
+			if err != nil {
+				dialog.ShowError(err, statementsWindow)
+				return
+			}
+			saved = append(saved, filename)
+		}
+		dialog.ShowInformation("Success", fmt.Sprintf("Saved %d statement(s) to %s", len(saved), config.ReceiptDir),
+			statementsWindow)
+	})
+
+	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
+		statementsWindow.Hide()
+		previousWindow.Show()
+	})
+
+	yearSelect.SetSelected(years[0])
+
+	header := container.NewVBox(
+		container.NewHBox(widget.NewLabel("Year:"), yearSelect),
+		totalLabel,
+	)
+	footer := container.NewHBox(saveButton, backButton)
+	showSessionWindow(statementsWindow, container.NewBorder(header, footer, nil, nil, statementsTable))
+}
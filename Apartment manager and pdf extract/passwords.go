@@ -0,0 +1,381 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+)
+
+// PasswordPolicy holds the rules new passwords must satisfy
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	HistoryCount  int // reject reuse of the last N passwords
+	MaxAgeDays    int // 0 means passwords never expire
+	ForceOnReset  bool
+}
+
+// defaultPasswordPolicy applies until an administrator changes the policy
+var defaultPasswordPolicy = PasswordPolicy{
+	MinLength:    8,
+	RequireLower: true,
+	RequireDigit: true,
+	HistoryCount: 3,
+	MaxAgeDays:   0,
+	ForceOnReset: true,
+}
+
+// Keys used to store the policy in the settings table
+const (
+	settingPasswordMinLength     = "password_min_length"
+	settingPasswordRequireUpper  = "password_require_upper"
+	settingPasswordRequireLower  = "password_require_lower"
+	settingPasswordRequireDigit  = "password_require_digit"
+	settingPasswordRequireSymbol = "password_require_symbol"
+	settingPasswordHistory       = "password_history_count"
+	settingPasswordMaxAgeDays    = "password_max_age_days"
+	settingPasswordForceOnReset  = "password_force_change_on_reset"
+)
+
+func settingInt(key string, fallback int) int {
+	value := getSetting(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Println("Invalid setting", key+":", value)
+		return fallback
+	}
+	return n
+}
+
+func settingBool(key string, fallback bool) bool {
+	value := getSetting(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Println("Invalid setting", key+":", value)
+		return fallback
+	}
+	return b
+}
+
+// auditSettingPasswordPolicy identifies the password policy in the audit log
+const auditSettingPasswordPolicy = "password_policy"
+
+// getPasswordPolicy loads the policy from the settings table
+func getPasswordPolicy() PasswordPolicy {
+	d := defaultPasswordPolicy
+	return PasswordPolicy{
+		MinLength:     settingInt(settingPasswordMinLength, d.MinLength),
+		RequireUpper:  settingBool(settingPasswordRequireUpper, d.RequireUpper),
+		RequireLower:  settingBool(settingPasswordRequireLower, d.RequireLower),
+		RequireDigit:  settingBool(settingPasswordRequireDigit, d.RequireDigit),
+		RequireSymbol: settingBool(settingPasswordRequireSymbol, d.RequireSymbol),
+		HistoryCount:  settingInt(settingPasswordHistory, d.HistoryCount),
+		MaxAgeDays:    settingInt(settingPasswordMaxAgeDays, d.MaxAgeDays),
+		ForceOnReset:  settingBool(settingPasswordForceOnReset, d.ForceOnReset),
+	}
+}
+
+// savePasswordPolicy stores the policy in the settings table and audits
+// the change
+func savePasswordPolicy(p PasswordPolicy) error {
+	if err := requirePermission(PermManageUsers); err != nil {
+		return err
+	}
+	if p.MinLength < 1 || p.HistoryCount < 0 || p.MaxAgeDays < 0 {
+		return errors.New("minimum length must be at least 1 and other limits cannot be negative")
+	}
+	before := getPasswordPolicy()
+
+	values := map[string]string{
+		settingPasswordMinLength:     strconv.Itoa(p.MinLength),
+		settingPasswordRequireUpper:  strconv.FormatBool(p.RequireUpper),
+		settingPasswordRequireLower:  strconv.FormatBool(p.RequireLower),
+		settingPasswordRequireDigit:  strconv.FormatBool(p.RequireDigit),
+		settingPasswordRequireSymbol: strconv.FormatBool(p.RequireSymbol),
+		settingPasswordHistory:       strconv.Itoa(p.HistoryCount),
+		settingPasswordMaxAgeDays:    strconv.Itoa(p.MaxAgeDays),
+		settingPasswordForceOnReset:  strconv.FormatBool(p.ForceOnReset),
+	}
+	for key, value := range values {
+		if err := setSetting(key, value); err != nil {
+			return err
+		}
+	}
+	return recordAudit(auditEntitySetting, auditSettingPasswordPolicy, auditActionUpdate, before, p)
+}
+
+// validate checks a candidate password against the policy rules
+func (p PasswordPolicy) validate(password string) error {
+	var problems []string
+
+	if len([]rune(password)) < p.MinLength {
+		problems = append(problems, fmt.Sprintf("at least %d characters", p.MinLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		problems = append(problems, "an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		problems = append(problems, "a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		problems = append(problems, "a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		problems = append(problems, "a symbol")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("password must contain " + strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// isExpired reports whether a password changed at the given time is too old
+func (p PasswordPolicy) isExpired(changedAt time.Time) bool {
+	if p.MaxAgeDays == 0 || changedAt.IsZero() {
+		return false
+	}
+	return time.Since(changedAt) > time.Duration(p.MaxAgeDays)*24*time.Hour
+}
+
+// needsPasswordChange reports whether the user must pick a new password
+// before continuing past the login screen
+func needsPasswordChange(user User) bool {
+	return user.MustChangePassword || getPasswordPolicy().isExpired(user.PasswordChangedAt)
+}
+
+// checkPasswordReuse rejects the current password and the last N in history
+func checkPasswordReuse(userID int, password string, historyCount int) error {
+	var current string
+	if err := userDB.QueryRow("SELECT password FROM users WHERE id = ?", userID).Scan(&current); err != nil {
+		return err
+	}
+	if checkPassword(current, password) {
+		return errors.New("new password must be different from the current password")
+	}
+
+	if historyCount == 0 {
+		return nil
+	}
+
+	rows, err := userDB.Query(
+		"SELECT password FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?",
+		userID, historyCount)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var previous string
+		if err := rows.Scan(&previous); err != nil {
+			return err
+		}
+		if checkPassword(previous, password) {
+			return fmt.Errorf("password was used recently; choose one not among your last %d", historyCount)
+		}
+	}
+	return rows.Err()
+}
+
+// setUserPassword hashes and stores a new password, moving the old hash to
+// the history table. mustChange flags the account for a forced change.
+func setUserPassword(userID int, password string, mustChange bool) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tx, err := userDB.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO password_history (user_id, password, changed_at)
+		SELECT id, password, ? FROM users WHERE id = ? AND password IS NOT NULL`,
+		now.Format(time.RFC3339), userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE users SET password = ?, must_change_password = ?, password_changed_at = ? WHERE id = ?",
+		hash, boolToInt(mustChange), now.Format(time.RFC3339), userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// changeOwnPassword lets the signed-in user replace their password
+func changeOwnPassword(currentPassword, newPassword string) error {
+	if currentUser.ID == 0 {
+		return errors.New("not signed in")
+	}
+
+	var stored string
+	err := userDB.QueryRow("SELECT password FROM users WHERE id = ?", currentUser.ID).Scan(&stored)
+	if err != nil {
+		return err
+	}
+	if !checkPassword(stored, currentPassword) {
+		return errors.New("current password is incorrect")
+	}
+
+	policy := getPasswordPolicy()
+	if err := policy.validate(newPassword); err != nil {
+		return err
+	}
+	if err := checkPasswordReuse(currentUser.ID, newPassword, policy.HistoryCount); err != nil {
+		return err
+	}
+
+	if err := setUserPassword(currentUser.ID, newPassword, false); err != nil {
+		return err
+	}
+	currentUser.MustChangePassword = false
+	currentUser.PasswordChangedAt = time.Now()
+
+	return recordAudit(auditEntityUser, strconv.Itoa(currentUser.ID), auditActionUpdate,
+		auditUser{Username: currentUser.Username, Role: currentUser.Role},
+		auditUser{Username: currentUser.Username, Role: currentUser.Role, PasswordChanged: true})
+}
+
+// showChangePasswordDialog asks for the current and new password. When
+// forced is set the dialog explains why and onDone only runs on success.
+func showChangePasswordDialog(parent fyne.Window, forced bool, onDone func(changed bool)) {
+	currentEntry := widget.NewPasswordEntry()
+	newEntry := widget.NewPasswordEntry()
+	confirmEntry := widget.NewPasswordEntry()
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Current password", currentEntry),
+		widget.NewFormItem("New password", newEntry),
+		widget.NewFormItem("Confirm new password", confirmEntry),
+	}
+
+	title := "Change My Password"
+	if forced {
+		title = "Password Change Required"
+		items = append([]*widget.FormItem{
+			widget.NewFormItem("", widget.NewLabel("Your password has expired or was reset.\nChoose a new password to continue.")),
+		}, items...)
+	}
+
+	dialog.ShowForm(title, "Change", "Cancel", items, func(ok bool) {
+		if !ok {
+			onDone(false)
+			return
+		}
+		if newEntry.Text != confirmEntry.Text {
+			dialog.ShowError(errors.New("new passwords do not match"), parent)
+			onDone(false)
+			return
+		}
+		if err := changeOwnPassword(currentEntry.Text, newEntry.Text); err != nil {
+			dialog.ShowError(err, parent)
+			onDone(false)
+			return
+		}
+		dialog.ShowInformation("Password Changed", "Your password has been updated", parent)
+		onDone(true)
+	}, parent)
+}
+
+// showPasswordPolicyDialog lets an administrator edit the password policy
+func showPasswordPolicyDialog(parent fyne.Window) {
+	p := getPasswordPolicy()
+
+	minLengthEntry := widget.NewEntry()
+	minLengthEntry.SetText(strconv.Itoa(p.MinLength))
+	upperCheck := widget.NewCheck("Uppercase letter", nil)
+	upperCheck.SetChecked(p.RequireUpper)
+	lowerCheck := widget.NewCheck("Lowercase letter", nil)
+	lowerCheck.SetChecked(p.RequireLower)
+	digitCheck := widget.NewCheck("Digit", nil)
+	digitCheck.SetChecked(p.RequireDigit)
+	symbolCheck := widget.NewCheck("Symbol", nil)
+	symbolCheck.SetChecked(p.RequireSymbol)
+	historyEntry := widget.NewEntry()
+	historyEntry.SetText(strconv.Itoa(p.HistoryCount))
+	maxAgeEntry := widget.NewEntry()
+	maxAgeEntry.SetText(strconv.Itoa(p.MaxAgeDays))
+	forceCheck := widget.NewCheck("Force change after an admin reset", nil)
+	forceCheck.SetChecked(p.ForceOnReset)
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Minimum length", minLengthEntry),
+		widget.NewFormItem("Require", upperCheck),
+		widget.NewFormItem("", lowerCheck),
+		widget.NewFormItem("", digitCheck),
+		widget.NewFormItem("", symbolCheck),
+		widget.NewFormItem("Block reuse of last", historyEntry),
+		widget.NewFormItem("Expire after (days, 0 = never)", maxAgeEntry),
+		widget.NewFormItem("", forceCheck),
+	}
+
+	dialog.ShowForm("Password Policy", "Save", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+
+		minLength, err1 := strconv.Atoi(strings.TrimSpace(minLengthEntry.Text))
+		history, err2 := strconv.Atoi(strings.TrimSpace(historyEntry.Text))
+		maxAge, err3 := strconv.Atoi(strings.TrimSpace(maxAgeEntry.Text))
+		if err1 != nil || err2 != nil || err3 != nil {
+			dialog.ShowError(errors.New("length, history and expiry must be whole numbers"), parent)
+			return
+		}
+
+		err := savePasswordPolicy(PasswordPolicy{
+			MinLength:     minLength,
+			RequireUpper:  upperCheck.Checked,
+			RequireLower:  lowerCheck.Checked,
+			RequireDigit:  digitCheck.Checked,
+			RequireSymbol: symbolCheck.Checked,
+			HistoryCount:  history,
+			MaxAgeDays:    maxAge,
+			ForceOnReset:  forceCheck.Checked,
+		})
+		if err != nil {
+			dialog.ShowError(err, parent)
+		}
+	}, parent)
+}
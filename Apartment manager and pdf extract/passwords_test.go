@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	strict := PasswordPolicy{MinLength: 10, RequireUpper: true, RequireLower: true, RequireDigit: true,
+		RequireSymbol: true}
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		wantErr  string
+	}{
+		{"default", defaultPasswordPolicy, "monsoon42", ""},
+		{"default too short", defaultPasswordPolicy, "rain42", "at least 8 characters"},
+		{"default without digit", defaultPasswordPolicy, "monsoonrain", "a digit"},
+		{"strict", strict, "Monsoon-2024", ""},
+		{"strict missing all classes", strict, "          ", "an uppercase letter, a lowercase letter, a digit"},
+		{"strict without symbol", strict, "Monsoon2024", "a symbol"},
+		{"length counts characters, not bytes", PasswordPolicy{MinLength: 4}, "ಮನೆ१", ""},
+	}
+	for _, tt := range tests {
+		err := tt.policy.validate(tt.password)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPasswordPolicyIsExpired(t *testing.T) {
+	policy := PasswordPolicy{MaxAgeDays: 90}
+	tests := []struct {
+		name      string
+		policy    PasswordPolicy
+		changedAt time.Time
+		want      bool
+	}{
+		{"recent", policy, time.Now().AddDate(0, 0, -89), false},
+		{"old", policy, time.Now().AddDate(0, 0, -91), true},
+		{"never changed", policy, time.Time{}, false},
+		{"no expiry", PasswordPolicy{}, time.Now().AddDate(-5, 0, 0), false},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.isExpired(tt.changedAt); got != tt.want {
+			t.Errorf("%s: isExpired = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSavePasswordPolicy(t *testing.T) {
+	newTestDatabases(t)
+	currentUser = User{ID: 1, Username: "admin", Role: RoleAdmin}
+	t.Cleanup(func() { currentUser = User{} })
+
+	if got := getPasswordPolicy(); got != defaultPasswordPolicy {
+		t.Errorf("got policy %+v before saving, want the default %+v", got, defaultPasswordPolicy)
+	}
+	policy := PasswordPolicy{MinLength: 12, RequireUpper: true, RequireSymbol: true, HistoryCount: 5, MaxAgeDays: 90}
+	if err := savePasswordPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	if got := getPasswordPolicy(); got != policy {
+		t.Errorf("got policy %+v, want %+v", got, policy)
+	}
+	entries, err := getAuditEntries(AuditFilter{Entity: auditEntitySetting})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].EntityID != auditSettingPasswordPolicy || entries[0].Actor != "admin" ||
+		!strings.Contains(entries[0].Before, `"MinLength":8`) || !strings.Contains(entries[0].After, `"MinLength":12`) {
+		t.Errorf("got audit entries %+v, want the policy change by admin", entries)
+	}
+	if err := savePasswordPolicy(PasswordPolicy{MinLength: 0}); err == nil {
+		t.Error("a minimum length of 0 was saved")
+	}
+
+	currentUser.Role = RoleCollector
+	if err := savePasswordPolicy(policy); err == nil {
+		t.Error("a collector changed the password policy")
+	}
+}
+
+func TestChangeOwnPasswordReuse(t *testing.T) {
+	newTestDatabases(t)
+	mustAddUser(t, "asha", "monsoon01")
+	currentUser = User{ID: 1, Username: "asha", Role: RoleCollector, MustChangePassword: true}
+	t.Cleanup(func() { currentUser = User{} })
+
+	// The default policy rejects the last three passwords besides the
+	// current one
+	steps := []struct {
+		current, next string
+		wantErr       string
+	}{
+		{"wrong", "monsoon02", "current password is incorrect"},
+		{"monsoon01", "short1", "at least 8 characters"},
+		{"monsoon01", "monsoon01", "different from the current password"},
+		{"monsoon01", "monsoon02", ""},
+		{"monsoon02", "monsoon03", ""},
+		{"monsoon03", "monsoon01", "used recently"},
+		{"monsoon03", "monsoon04", ""},
+		{"monsoon04", "monsoon05", ""},
+		{"monsoon05", "monsoon01", ""},
+	}
+	for _, step := range steps {
+		err := changeOwnPassword(step.current, step.next)
+		if step.wantErr == "" {
+			if err != nil {
+				t.Fatalf("%s -> %s: %v", step.current, step.next, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), step.wantErr) {
+			t.Fatalf("%s -> %s: got error %v, want %q", step.current, step.next, err, step.wantErr)
+		}
+	}
+
+	if currentUser.MustChangePassword {
+		t.Error("MustChangePassword is still set after changing the password")
+	}
+	var mustChange int
+	var changedAt string
+	if err := userDB.QueryRow("SELECT must_change_password, password_changed_at FROM users WHERE id = 1").
+		Scan(&mustChange, &changedAt); err != nil {
+		t.Fatal(err)
+	}
+	if mustChange != 0 || changedAt == "" {
+		t.Errorf("stored must_change_password = %d, password_changed_at = %q", mustChange, changedAt)
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+	"github.com/xuri/excelize/v2"
+
+	"apartment_login/service"
+	"apartment_login/store"
+)
+
+// searchPeople matches the directory on name, phone or email
+func searchPeople(query string) ([]store.Person, error) {
+	if err := requirePermission(PermViewApartments); err != nil {
+		return nil, err
+	}
+	return appStore.SearchPeople(query)
+}
+
+// savePerson adds someone to the directory or updates their details
+func savePerson(p store.Person) (store.Person, error) {
+	if err := requirePermission(PermEditApartments); err != nil {
+		return p, err
+	}
+
+	change, err := appService.SavePerson(p)
+	if err != nil {
+		return p, err
+	}
+	return change.After, auditPersonChange(change)
+}
+
+// auditPersonChange records someone added to or updated in the directory
+func auditPersonChange(change service.PersonChange) error {
+	action := auditActionCreate
+	if change.Before != nil {
+		action = auditActionUpdate
+	}
+	return recordAudit(auditEntityPerson, strconv.Itoa(change.After.ID), action, change.Before, change.After)
+}
+
+// deletePerson removes someone who is no longer linked to any apartment
+func deletePerson(id int) error {
+	if err := requirePermission(PermEditApartments); err != nil {
+		return err
+	}
+
+	before, err := appService.DeletePerson(id)
+	if err != nil {
+		return err
+	}
+	return recordAudit(auditEntityPerson, strconv.Itoa(id), auditActionDelete, before, nil)
+}
+
+// getPersonLinks lists the apartments a person is linked to
+func getPersonLinks(personID int) ([]store.PersonLink, error) {
+	if err := requirePermission(PermViewApartments); err != nil {
+		return nil, err
+	}
+	return appStore.PersonLinks(personID)
+}
+
+// changePersonLinks applies change to a person's links and audits the
+// links before and after
+func changePersonLinks(personID int, change func() error) error {
+	if err := requirePermission(PermEditApartments); err != nil {
+		return err
+	}
+
+	before, err := appStore.PersonLinks(personID)
+	if err != nil {
+		return err
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	after, err := appStore.PersonLinks(personID)
+	if err != nil {
+		return err
+	}
+	return recordAudit(auditEntityPerson, strconv.Itoa(personID), auditActionUpdate,
+		map[string][]store.PersonLink{"links": before},
+		map[string][]store.PersonLink{"links": after})
+}
+
+// linkPerson ties a person to an apartment in a role
+func linkPerson(personID int, apartmentID string, role store.LinkRole) error {
+	return changePersonLinks(personID, func() error {
+		return appService.LinkPerson(personID, apartmentID, role)
+	})
+}
+
+// unlinkCoOwner removes a person from the co-owners of an apartment
+func unlinkCoOwner(personID int, apartmentID string) error {
+	return changePersonLinks(personID, func() error {
+		return appService.UnlinkCoOwner(personID, apartmentID)
+	})
+}
+
+// importPeople adds the people in a CSV or Excel file to the directory,
+// updating those already there, and returns how many were added and updated
+func importPeople(path string) (added, updated int, err error) {
+	if err := requirePermission(PermEditApartments); err != nil {
+		return 0, 0, err
+	}
+
+	var records [][]string
+	switch ext := filepath.Ext(path); strings.ToLower(ext) {
+	case ".csv":
+		file, err := os.Open(path)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer file.Close()
+		records, err = csv.NewReader(file).ReadAll()
+		if err != nil {
+			return 0, 0, err
+		}
+	case ".xlsx":
+		f, err := excelize.OpenFile(path)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer f.Close()
+		records, err = f.GetRows("Sheet1")
+		if err != nil {
+			return 0, 0, err
+		}
+	default:
+		return 0, 0, fmt.Errorf("unsupported file type: %s", ext)
+	}
+
+	changes, err := appService.ImportPeople(records)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, change := range changes {
+		if change.Before == nil {
+			added++
+		} else {
+			updated++
+		}
+		if err := auditPersonChange(change); err != nil {
+			return added, updated, err
+		}
+	}
+	return added, updated, nil
+}
+
+// People Manager UI
+func ShowPeopleManager(myApp fyne.App, previousWindow fyne.Window) {
+	peopleWindow := myApp.NewWindow("People")
+	peopleWindow.Resize(fyne.NewSize(900, 600))
+
+	searchEntry := widget.NewEntry()
+	searchEntry.SetPlaceHolder("Search by name, phone or email...")
+
+	nameEntry := widget.NewEntry()
+	nameEntry.SetPlaceHolder("Full Name")
+
+	phoneEntry := widget.NewEntry()
+	phoneEntry.SetPlaceHolder("Phone")
+
+	emailEntry := widget.NewEntry()
+	emailEntry.SetPlaceHolder("Email")
+
+	alternateEntry := widget.NewEntry()
+	alternateEntry.SetPlaceHolder("Alternate contact")
+
+	idProofEntry := widget.NewEntry()
+	idProofEntry.SetPlaceHolder("e.g. PAN ABCDE1234F")
+
+	duplicatesLabel := widget.NewLabel("")
+	duplicatesLabel.Wrapping = fyne.TextWrapWord
+
+	var people []store.Person
+	var selected store.Person
+	var links []store.PersonLink
+	var selectedLink *store.PersonLink
+
+	peopleList := widget.NewList(
+		func() int { return len(people) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			p := people[id]
+			text := p.Name
+			if p.Phone != "" {
+				text += "  " + p.Phone
+			}
+			obj.(*widget.Label).SetText(text)
+		},
+	)
+
+	linksList := widget.NewList(
+		func() int { return len(links) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			link := links[id]
+			text := fmt.Sprintf("%s  (%s)", link.ApartmentID, link.Role)
+			if link.Role != store.LinkResident {
+				text = fmt.Sprintf("%s  (%s, %s)", link.ApartmentID, link.Role, service.FormatShare(link.Share))
+			}
+			obj.(*widget.Label).SetText(text)
+		},
+	)
+	linksList.OnSelected = func(id widget.ListItemID) {
+		selectedLink = &links[id]
+	}
+
+	refreshLinks := func() {
+		links = nil
+		selectedLink = nil
+		if selected.ID != 0 {
+			var err error
+			links, err = getPersonLinks(selected.ID)
+			if err != nil {
+				dialog.ShowError(err, peopleWindow)
+			}
+		}
+		linksList.UnselectAll()
+		linksList.Refresh()
+	}
+
+	showDuplicates := func() {
+		duplicatesLabel.SetText("")
+		if selected.ID == 0 {
+			return
+		}
+		duplicates, err := appService.PossibleDuplicates(selected)
+		if err != nil {
+			duplicatesLabel.SetText("Error: " + err.Error())
+			return
+		}
+		var parts []string
+		for _, d := range duplicates {
+			parts = append(parts, fmt.Sprintf("%s (%s)", d.Person.Name, d.Reason))
+		}
+		if len(parts) > 0 {
+			duplicatesLabel.SetText("May be the same person as: " + strings.Join(parts, ", "))
+		}
+	}
+
+	loadPerson := func(p store.Person) {
+		selected = p
+		nameEntry.SetText(p.Name)
+		phoneEntry.SetText(p.Phone)
+		emailEntry.SetText(p.Email)
+		alternateEntry.SetText(p.AlternateContact)
+		idProofEntry.SetText(p.IDProof)
+		refreshLinks()
+		showDuplicates()
+	}
+
+	resetForm := func() {
+		loadPerson(store.Person{})
+	}
+
+	refreshList := func() {
+		var err error
+		people, err = searchPeople(searchEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, peopleWindow)
+		}
+		peopleList.UnselectAll()
+		peopleList.Refresh()
+	}
+	searchEntry.OnChanged = func(string) { refreshList() }
+
+	peopleList.OnSelected = func(id widget.ListItemID) {
+		loadPerson(people[id])
+	}
+
+	saveButton := widget.NewButtonWithIcon("Save", theme.DocumentSaveIcon(), func() {
+		mine := selected
+		mine.Name = nameEntry.Text
+		mine.Phone = phoneEntry.Text
+		mine.Email = emailEntry.Text
+		mine.AlternateContact = alternateEntry.Text
+		mine.IDProof = idProofEntry.Text
+
+		save := func() {
+			saved, err := savePerson(mine)
+			if err != nil {
+				showSaveError(err, peopleWindow, func(saved any) {
+					refreshList()
+					if p, ok := saved.(store.Person); ok {
+						loadPerson(p)
+					} else {
+						resetForm()
+					}
+				}, func(saved any) {
+					mine.Version = saved.(store.Person).Version
+					if _, err := savePerson(mine); err != nil {
+						dialog.ShowError(err, peopleWindow)
+					}
+					refreshList()
+					resetForm()
+				})
+				return
+			}
+			refreshList()
+			loadPerson(saved)
+		}
+
+		// Someone new who looks like someone already listed is probably a
+		// duplicate, so ask before adding them
+		if mine.ID != 0 {
+			save()
+			return
+		}
+		duplicates, err := appService.PossibleDuplicates(mine)
+		if err != nil || len(duplicates) == 0 {
+			save()
+			return
+		}
+		var names []string
+		for _, d := range duplicates {
+			names = append(names, fmt.Sprintf("%s (%s)", d.Person.Name, d.Reason))
+		}
+		dialog.ShowConfirm("Possible Duplicate",
+			fmt.Sprintf("This may be someone already listed:\n%s\nAdd them anyway?", strings.Join(names, "\n")),
+			func(ok bool) {
+				if ok {
+					save()
+				}
+			}, peopleWindow)
+	})
+
+	addButton := widget.NewButtonWithIcon("Add New", theme.ContentAddIcon(), func() {
+		peopleList.UnselectAll()
+		resetForm()
+	})
+
+	deleteButton := widget.NewButtonWithIcon("Delete", theme.DeleteIcon(), func() {
+		if selected.ID == 0 {
+			dialog.ShowError(errors.New("select a person first"), peopleWindow)
+			return
+		}
+		person := selected
+		dialog.ShowConfirm("Confirm Delete", fmt.Sprintf("Remove %s from the directory?", person.Name),
+			func(ok bool) {
+				if !ok {
+					return
+				}
+				if err := deletePerson(person.ID); err != nil {
+					dialog.ShowError(err, peopleWindow)
+					return
+				}
+				refreshList()
+				resetForm()
+			}, peopleWindow)
+	})
+
+	importButton := widget.NewButtonWithIcon("Import", theme.FolderOpenIcon(), func() {
+		fd := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil || reader == nil {
+				return
+			}
+			defer reader.Close()
+
+			added, updated, err := importPeople(reader.URI().Path())
+			refreshList()
+			if err != nil {
+				dialog.ShowError(err, peopleWindow)
+				return
+			}
+			dialog.ShowInformation("Success",
+				fmt.Sprintf("Added %d people; updated %d already listed", added, updated), peopleWindow)
+		}, peopleWindow)
+		fd.Show()
+	})
+
+	apartmentSelect := widget.NewSelect(getApartmentIDs(), nil)
+	apartmentSelect.PlaceHolder = "Apartment"
+	roles := make([]string, len(store.LinkRoles))
+	for i, role := range store.LinkRoles {
+		roles[i] = string(role)
+	}
+	roleSelect := widget.NewSelect(roles, nil)
+	roleSelect.SetSelected(string(store.LinkCoOwner))
+
+	linkButton := widget.NewButtonWithIcon("Link", theme.ContentAddIcon(), func() {
+		if selected.ID == 0 || apartmentSelect.Selected == "" {
+			dialog.ShowError(errors.New("select a person and an apartment first"), peopleWindow)
+			return
+		}
+		err := linkPerson(selected.ID, apartmentSelect.Selected, store.LinkRole(roleSelect.Selected))
+		if err != nil {
+			dialog.ShowError(err, peopleWindow)
+			return
+		}
+		refreshLinks()
+	})
+
+	unlinkButton := widget.NewButtonWithIcon("Unlink Co-owner", theme.ContentRemoveIcon(), func() {
+		if selectedLink == nil {
+			dialog.ShowError(errors.New("select a linked apartment first"), peopleWindow)
+			return
+		}
+		if err := unlinkCoOwner(selectedLink.PersonID, selectedLink.ApartmentID); err != nil {
+			dialog.ShowError(err, peopleWindow)
+			return
+		}
+		refreshLinks()
+	})
+
+	if !can(PermEditApartments) {
+		for _, button := range []*widget.Button{saveButton, addButton, deleteButton, importButton, linkButton, unlinkButton} {
+			button.Disable()
+		}
+	}
+
+	votingButton := widget.NewButtonWithIcon("AGM Voting", theme.ListIcon(), func() {
+		peopleWindow.Hide()
+		ShowVotingWeights(myApp, peopleWindow)
+	})
+
+	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
+		peopleWindow.Hide()
+		previousWindow.Show()
+	})
+
+	refreshList()
+
+	form := container.NewVBox(
+		widget.NewLabel("Person Details"),
+		widget.NewLabel("Name:"),
+		nameEntry,
+		container.NewGridWithColumns(2,
+			widget.NewLabel("Phone:"), widget.NewLabel("Email:"),
+			phoneEntry, emailEntry,
+		),
+		widget.NewLabel("Alternate Contact:"),
+		alternateEntry,
+		widget.NewLabel("ID Proof:"),
+		idProofEntry,
+		duplicatesLabel,
+		container.NewHBox(saveButton, addButton, deleteButton, importButton, backButton),
+		widget.NewLabel("Linked Apartments:"),
+		container.NewHBox(apartmentSelect, roleSelect, linkButton, unlinkButton),
+	)
+
+	details := container.NewBorder(form, nil, nil, nil, linksList)
+	left := container.NewBorder(searchEntry, container.NewHBox(votingButton), nil, nil, peopleList)
+
+	split := container.NewHSplit(left, details)
+	split.Offset = 0.35
+
+	showSessionWindow(peopleWindow, split)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"apartment_login/service"
+	"apartment_login/store"
+)
+
+// binPermissions is the permission needed to see and restore each kind of
+// deleted row; it is the same one needed to delete it
+var binPermissions = map[store.BinKind]Permission{
+	store.BinApartments:  PermDeleteApartments,
+	store.BinUsers:       PermManageUsers,
+	store.BinCollections: PermDeleteTransactions,
+	store.BinPayments:    PermDeleteTransactions,
+}
+
+// binAuditEntities maps each kind of deleted row to its audit entity
+var binAuditEntities = map[store.BinKind]string{
+	store.BinApartments:  auditEntityApartment,
+	store.BinUsers:       auditEntityUser,
+	store.BinCollections: auditEntityCollection,
+	store.BinPayments:    auditEntityPayment,
+}
+
+// getDeleted lists the rows of a kind in the recycle bin
+func getDeleted(kind store.BinKind) ([]store.BinEntry, error) {
+	if err := requirePermission(binPermissions[kind]); err != nil {
+		return nil, err
+	}
+	return appStore.Deleted(kind)
+}
+
+// restoreDeleted returns a row from the recycle bin to normal use
+func restoreDeleted(kind store.BinKind, id string) error {
+	if err := requirePermission(binPermissions[kind]); err != nil {
+		return err
+	}
+
+	entry, err := appService.Restore(kind, id)
+	if err != nil {
+		return err
+	}
+	if kind == store.BinApartments {
+		invalidateApartmentLists()
+	}
+	return recordAudit(binAuditEntities[kind], id, auditActionRestore, nil, entry)
+}
+
+// purgeDeleted permanently removes a row whose retention period has ended
+func purgeDeleted(kind store.BinKind, id string) error {
+	if err := requirePermission(PermPurgeDeleted); err != nil {
+		return err
+	}
+
+	entry, err := appService.Purge(kind, id, time.Now(), binRetention())
+	if err != nil {
+		return err
+	}
+	return recordAudit(binAuditEntities[kind], id, auditActionPurge, entry, nil)
+}
+
+// purgeExpired permanently removes every row whose retention period has
+// ended and returns how many were removed
+func purgeExpired() (int, error) {
+	if err := requirePermission(PermPurgeDeleted); err != nil {
+		return 0, err
+	}
+
+	purged, err := appService.PurgeExpired(time.Now(), binRetention())
+	for _, entry := range purged {
+		if auditErr := recordAudit(binAuditEntities[entry.Kind], entry.ID, auditActionPurge, entry, nil); auditErr != nil {
+			return len(purged), auditErr
+		}
+	}
+	return len(purged), err
+}
+
+// Recycle Bin UI, opened from the manager window of the given kind.
+// onRestore lets that window reload its list.
+func ShowRecycleBin(myApp fyne.App, previousWindow fyne.Window, kind store.BinKind, onRestore func()) {
+	binWindow := myApp.NewWindow(fmt.Sprintf("Recycle Bin - %s", kind))
+	binWindow.Resize(fyne.NewSize(800, 450))
+
+	var entries []store.BinEntry
+	var selected *store.BinEntry
+
+	headers := []string{"ID", "Details", "Amount", "Deleted", "Deleted By", "Purge From"}
+	entriesTable := widget.NewTableWithHeaders(
+		func() (int, int) { return len(entries), len(headers) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.TableCellID, obj fyne.CanvasObject) {
+			obj.(*widget.Label).SetText(binEntryCell(entries[id.Row], id.Col))
+		},
+	)
+	entriesTable.ShowHeaderColumn = false
+	entriesTable.UpdateHeader = func(id widget.TableCellID, obj fyne.CanvasObject) {
+		obj.(*widget.Label).SetText(headers[id.Col])
+	}
+	for col, width := range []float32{70, 240, 100, 130, 100, 100} {
+		entriesTable.SetColumnWidth(col, width)
+	}
+	entriesTable.OnSelected = func(id widget.TableCellID) {
+		if id.Row >= 0 && id.Row < len(entries) {
+			selected = &entries[id.Row]
+		}
+	}
+
+	refreshList := func() {
+		var err error
+		entries, err = getDeleted(kind)
+		if err != nil {
+			dialog.ShowError(err, binWindow)
+		}
+		selected = nil
+		entriesTable.UnselectAll()
+		entriesTable.Refresh()
+	}
+
+	retention := "Deleted records can be purged straight away"
+	if config.RecycleBin.RetentionDays > 0 {
+		retention = fmt.Sprintf("Deleted records can be purged after %d day(s)", config.RecycleBin.RetentionDays)
+	}
+	infoLabel := widget.NewLabel(retention)
+
+	restoreButton := widget.NewButtonWithIcon("Restore", theme.HistoryIcon(), func() {
+		if selected == nil {
+			dialog.ShowError(errors.New("select a record first"), binWindow)
+			return
+		}
+		if err := restoreDeleted(kind, selected.ID); err != nil {
+			dialog.ShowError(err, binWindow)
+			return
+		}
+		refreshList()
+		if onRestore != nil {
+			onRestore()
+		}
+	})
+
+	purgeButton := widget.NewButtonWithIcon("Purge", theme.DeleteIcon(), func() {
+		if selected == nil {
+			dialog.ShowError(errors.New("select a record first"), binWindow)
+			return
+		}
+		entry := *selected
+		dialog.ShowConfirm("Confirm Purge",
+			fmt.Sprintf("Permanently remove %s %s?\nThis cannot be undone.", kind, entry.ID),
+			func(ok bool) {
+				if !ok {
+					return
+				}
+				if err := purgeDeleted(kind, entry.ID); err != nil {
+					dialog.ShowError(err, binWindow)
+					return
+				}
+				refreshList()
+			}, binWindow)
+	})
+
+	purgeExpiredButton := widget.NewButtonWithIcon("Purge Expired", theme.ContentClearIcon(), func() {
+		dialog.ShowConfirm("Confirm Purge",
+			"Permanently remove every deleted record past its retention period?\nThis cannot be undone.",
+			func(ok bool) {
+				if !ok {
+					return
+				}
+				count, err := purgeExpired()
+				refreshList()
+				if err != nil {
+					dialog.ShowError(err, binWindow)
+					return
+				}
+				dialog.ShowInformation("Purged", fmt.Sprintf("Removed %d record(s)", count), binWindow)
+			}, binWindow)
+	})
+
+	if !can(PermPurgeDeleted) {
+		purgeButton.Disable()
+		purgeExpiredButton.Disable()
+	}
+
+	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
+		binWindow.Hide()
+		previousWindow.Show()
+	})
+
+	refreshList()
+
+	controls := container.NewVBox(
+		infoLabel,
+		container.NewHBox(restoreButton, purgeButton, purgeExpiredButton, backButton),
+	)
+	showSessionWindow(binWindow, container.NewBorder(controls, nil, nil, nil, entriesTable))
+}
+
+// binEntryCell returns the text for one column of the recycle bin
+func binEntryCell(entry store.BinEntry, col int) string {
+	switch col {
+	case 0:
+		return entry.ID
+	case 1:
+		return entry.Summary
+	case 2:
+		if entry.Amount == 0 {
+			return ""
+		}
+		return entry.Amount.String()
+	case 3:
+		return entry.DeletedAt.Format("2006-01-02 15:04")
+	case 4:
+		return entry.DeletedBy
+	case 5:
+		return service.PurgeableAt(entry, binRetention()).Format("2006-01-02")
+	}
+	return ""
+}
+
+// recycleBinButton opens the recycle bin for kind from a manager window; it
+// is disabled for users who may not see it
+func recycleBinButton(myApp fyne.App, managerWindow fyne.Window, kind store.BinKind, onRestore func()) *widget.Button {
+	button := widget.NewButtonWithIcon("Recycle Bin", theme.ContentUndoIcon(), func() {
+		managerWindow.Hide()
+		ShowRecycleBin(myApp, managerWindow, kind, onRestore)
+	})
+	if !can(binPermissions[kind]) {
+		button.Disable()
+	}
+	return button
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"apartment_login/money"
+	"apartment_login/service"
+)
+
+// getUserApartments returns the apartment IDs linked to a user
+func getUserApartments(userID int) []string {
+	ids, err := appStore.UserApartments(userID)
+	if err != nil {
+		log.Println("Error fetching linked apartments:", err)
+	}
+	return ids
+}
+
+// parseApartmentList splits a comma separated list of apartment IDs
+func parseApartmentList(text string) []string {
+	var ids []string
+	for _, part := range strings.Split(text, ",") {
+		if id := strings.TrimSpace(part); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// setUserApartments replaces the apartments linked to a user
+func setUserApartments(userID int, apartmentIDs []string) error {
+	if err := requirePermission(PermManageUsers); err != nil {
+		return err
+	}
+
+	before, err := appService.SetUserApartments(userID, apartmentIDs)
+	if err != nil {
+		return err
+	}
+
+	if strings.Join(before, ",") == strings.Join(apartmentIDs, ",") {
+		return nil
+	}
+	return recordAudit(auditEntityUser, strconv.Itoa(userID), auditActionUpdate,
+		map[string][]string{"apartments": before},
+		map[string][]string{"apartments": apartmentIDs})
+}
+
+// canAccessApartment reports whether the signed-in user may see an
+// apartment's collections: staff see all, residents only their own
+func canAccessApartment(apartmentID string) bool {
+	if can(PermViewCollections) {
+		return true
+	}
+	if !can(PermViewOwnAccount) {
+		return false
+	}
+	for _, id := range getUserApartments(currentUser.ID) {
+		if id == apartmentID {
+			return true
+		}
+	}
+	return false
+}
+
+// getStatement returns an apartment's collections, newest first, with who
+// owned and lived in it when each was received
+func getStatement(apartmentID string) ([]service.StatementLine, error) {
+	if !canAccessApartment(apartmentID) {
+		return nil, errors.New("permission denied: apartment is not linked to your account")
+	}
+	return appService.Statement(apartmentID)
+}
+
+// occupantsText names the owners, and the resident when someone else lived
+// there
+func occupantsText(occupants service.Occupants) string {
+	if occupants.SeparateResident() {
+		return fmt.Sprintf("%s / %s", occupants.Owners(), occupants.Resident)
+	}
+	return occupants.Owners()
+}
+
+// getOutstandingDues lists the months of the given year, up to and including
+// the current month, with no maintenance collection recorded
+func getOutstandingDues(apartmentID string, now time.Time) ([]service.Due, error) {
+	if !canAccessApartment(apartmentID) {
+		return nil, errors.New("permission denied: apartment is not linked to your account")
+	}
+	return appService.OutstandingDues(apartmentID, now, defaultMaintenance())
+}
+
+// Resident Portal UI
+func ShowResidentPortal(myApp fyne.App) {
+	portalWindow := myApp.NewWindow("My Apartment")
+	portalWindow.Resize(fyne.NewSize(700, 500))
+
+	apartmentIDs := getUserApartments(currentUser.ID)
+
+	var statement []service.StatementLine
+	var dues []service.Due
+	var selectedCollection *Collection
+
+	collectionsList := widget.NewList(
+		func() int { return len(statement) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			c := statement[id].Collection
+			obj.(*widget.Label).SetText(fmt.Sprintf("#%d  %s  %s %s  %s  %s",
+				c.ReceiptNumber, c.Date, c.Month, c.Type, c.Price, occupantsText(statement[id].Occupants)))
+		},
+	)
+
+	duesList := widget.NewList(
+		func() int { return len(dues) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			d := dues[id]
+			obj.(*widget.Label).SetText(fmt.Sprintf("%s  %s", d.Month, d.Amount))
+		},
+	)
+
+	duesTotalLabel := widget.NewLabel("")
+
+	collectionsList.OnSelected = func(id widget.ListItemID) {
+		selectedCollection = &statement[id].Collection
+	}
+
+	apartmentSelect := widget.NewSelect(apartmentIDs, func(id string) {
+		var err error
+		selectedCollection = nil
+		collectionsList.UnselectAll()
+
+		statement, err = getStatement(id)
+		if err != nil {
+			dialog.ShowError(err, portalWindow)
+		}
+		dues, err = getOutstandingDues(id, time.Now())
+		if err != nil {
+			dialog.ShowError(err, portalWindow)
+		}
+
+		var total money.Money
+		for _, d := range dues {
+			total += d.Amount
+		}
+		duesTotalLabel.SetText(fmt.Sprintf("Outstanding for %d: %s", time.Now().Year(), total))
+
+		collectionsList.Refresh()
+		duesList.Refresh()
+	})
+
+	downloadButton := widget.NewButtonWithIcon("Download Receipt", theme.DownloadIcon(), func() {
+		if selectedCollection == nil {
+			dialog.ShowError(errors.New("select a payment first"), portalWindow)
+			return
+		}
+		collection := *selectedCollection
+
+		fd := dialog.NewFileSave(func(writer fyne.URIWriteCloser, err error) {
+			if err != nil || writer == nil {
+				return
+			}
+			defer writer.Close()
+
+			if err := writeReceipt(collection, writer); err != nil {
+				dialog.ShowError(err, portalWindow)
+				return
+			}
+			dialog.ShowInformation("Success", "Receipt saved", portalWindow)
+		}, portalWindow)
+		fd.SetFileName(service.ReceiptFileName(collection))
+		fd.Show()
+	})
+
+	changePasswordButton := widget.NewButtonWithIcon("Change My Password", theme.AccountIcon(), func() {
+		showChangePasswordDialog(portalWindow, false, func(bool) {})
+	})
+
+	logoutButton := widget.NewButtonWithIcon("Logout", theme.LogoutIcon(), logout)
+
+	if len(apartmentIDs) > 0 {
+		apartmentSelect.SetSelectedIndex(0)
+	}
+
+	// Linked apartments differ between societies, so the whole portal is
+	// rebuilt after switching
+	switcher := societySwitcher(portalWindow, func() {
+		ShowResidentPortal(myApp)
+		portalWindow.Close()
+	})
+
+	header := container.NewVBox(
+		widget.NewLabel(fmt.Sprintf("Welcome, %s", currentUser.Username)),
+		switcher,
+		container.NewHBox(widget.NewLabel("Apartment:"), apartmentSelect),
+	)
+	if len(apartmentIDs) == 0 {
+		header.Add(widget.NewLabel("No apartments are linked to your account. Please contact the office."))
+	}
+
+	payments := container.NewBorder(nil, downloadButton, nil, nil, collectionsList)
+	outstanding := container.NewBorder(duesTotalLabel, nil, nil, nil, duesList)
+
+	tabs := container.NewAppTabs(
+		container.NewTabItem("Payments & Receipts", payments),
+		container.NewTabItem("Outstanding Dues", outstanding),
+	)
+
+	content := container.NewBorder(header, container.NewHBox(changePasswordButton, logoutButton), nil, nil, tabs)
+	showSessionWindow(portalWindow, content)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"apartment_login/store"
+)
+
+// Role is the committee role assigned to a user
+type Role = store.Role
+
+const (
+	RoleAdmin     Role = "admin"
+	RoleTreasurer Role = "treasurer"
+	RoleCollector Role = "collector"
+	RoleAuditor   Role = "auditor"
+	RoleResident  Role = "resident"
+)
+
+// Permission is a single action a role may be allowed to perform
+type Permission string
+
+const (
+	PermManageUsers       Permission = "manage_users"
+	PermViewApartments    Permission = "view_apartments"
+	PermEditApartments    Permission = "edit_apartments"
+	PermDeleteApartments  Permission = "delete_apartments"
+	PermViewCollections   Permission = "view_collections"
+	PermRecordCollections Permission = "record_collections"
+	PermViewAccounts      Permission = "view_accounts"
+	PermEditAccounts      Permission = "edit_accounts"
+	PermViewAudit         Permission = "view_audit"
+	PermViewOwnAccount    Permission = "view_own_account"
+	PermManageBackups     Permission = "manage_backups"
+	// PermDeleteTransactions covers deleting and restoring collections and payments
+	PermDeleteTransactions Permission = "delete_transactions"
+	// PermPurgeDeleted covers permanently removing rows from the recycle bin
+	PermPurgeDeleted Permission = "purge_deleted"
+	// PermManageSocieties covers adding and editing societies; holders may
+	// work in every society without being assigned to it
+	PermManageSocieties Permission = "manage_societies"
+)
+
+// allRoles lists the roles in the order they are offered in the UI
+var allRoles = []Role{RoleAdmin, RoleTreasurer, RoleCollector, RoleAuditor, RoleResident}
+
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermManageUsers,
+		PermViewApartments, PermEditApartments, PermDeleteApartments,
+		PermViewCollections, PermRecordCollections,
+		PermViewAccounts, PermEditAccounts,
+		PermViewAudit,
+		PermManageBackups,
+		PermDeleteTransactions, PermPurgeDeleted,
+		PermManageSocieties,
+	},
+	RoleTreasurer: {
+		PermViewApartments,
+		PermViewCollections, PermRecordCollections,
+		PermViewAccounts, PermEditAccounts,
+		PermDeleteTransactions,
+	},
+	RoleCollector: {
+		PermViewApartments,
+		PermViewCollections, PermRecordCollections,
+	},
+	RoleAuditor: {
+		PermViewApartments,
+		PermViewCollections,
+		PermViewAccounts,
+		PermViewAudit,
+	},
+	// Residents only see the apartments linked to their account
+	RoleResident: {
+		PermViewOwnAccount,
+	},
+}
+
+// currentUser is the user signed in through the login window
+var currentUser User
+
+// roleNames returns the role names for use in a select widget
+func roleNames() []string {
+	names := make([]string, len(allRoles))
+	for i, r := range allRoles {
+		names[i] = string(r)
+	}
+	return names
+}
+
+// isValidRole reports whether r is one of the known roles
+func isValidRole(r Role) bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// roleHasPermission reports whether the role grants the permission
+func roleHasPermission(r Role, p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// can reports whether the signed-in user holds the permission
+func can(p Permission) bool {
+	return roleHasPermission(currentUser.Role, p)
+}
+
+// requirePermission returns an error if the signed-in user lacks the permission
+func requirePermission(p Permission) error {
+	if !can(p) {
+		return fmt.Errorf("permission denied: role %q cannot %s",
+			currentUser.Role, strings.ReplaceAll(string(p), "_", " "))
+	}
+	return nil
+}
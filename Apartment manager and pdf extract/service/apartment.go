@@ -0,0 +1,235 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"apartment_login/store"
+)
+
+// UnitTypes are the unit types offered for an apartment
+var UnitTypes = []string{"1RK", "1BHK", "2BHK", "3BHK", "4BHK", "5BHK", "Penthouse", "Shop", "Office"}
+
+// ApartmentColumns is the header of an apartment export. Imports accept the
+// same names in any order.
+var ApartmentColumns = []string{
+	"ID", "Owner", "Resident", "Same", "Block", "Floor", "Unit Type",
+	"Carpet Area", "Super Built-up Area", "Parking",
+}
+
+// ApartmentRecord is an apartment as a row under ApartmentColumns
+func ApartmentRecord(apt store.Apartment) []string {
+	return []string{
+		apt.ID, apt.Owner, apt.Resident, strconv.FormatBool(apt.SameFlag), apt.Block,
+		strconv.Itoa(apt.Floor), apt.UnitType, FormatArea(apt.CarpetArea), FormatArea(apt.SuperBuiltUpArea),
+		strings.Join(apt.ParkingSlots, ", "),
+	}
+}
+
+// ParseFloor reads a floor number; blank and "G" mean the ground floor and
+// basements are negative
+func ParseFloor(text string) (int, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.EqualFold(text, "G") {
+		return 0, nil
+	}
+	floor, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid floor %q", text)
+	}
+	return floor, nil
+}
+
+// ParseArea reads an area in square feet; blank means unknown
+func ParseArea(text string) (float64, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, nil
+	}
+	area, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(area) || math.IsInf(area, 0) {
+		return 0, fmt.Errorf("invalid area %q", text)
+	}
+	if area < 0 {
+		return 0, fmt.Errorf("area cannot be negative: %s", text)
+	}
+	return area, nil
+}
+
+// FormatArea shows an area without trailing zeros, or blank when unknown
+func FormatArea(area float64) string {
+	if area == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(area, 'f', -1, 64)
+}
+
+// ParseParkingSlots splits a comma separated list of parking slots
+func ParseParkingSlots(text string) []string {
+	var slots []string
+	for _, part := range strings.Split(text, ",") {
+		if slot := strings.TrimSpace(part); slot != "" {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// normaliseUnitType matches a unit type to one of UnitTypes, ignoring case
+// and spaces, so "2 bhk" is stored as "2BHK"
+func normaliseUnitType(unitType string) (string, error) {
+	key := strings.ToUpper(strings.ReplaceAll(unitType, " ", ""))
+	if key == "" {
+		return "", nil
+	}
+	for _, known := range UnitTypes {
+		if strings.ToUpper(known) == key {
+			return known, nil
+		}
+	}
+	return "", fmt.Errorf("unknown unit type %q; use one of %s", unitType, strings.Join(UnitTypes, ", "))
+}
+
+// normaliseDetails validates the location, size and parking of an apartment
+func normaliseDetails(apt *store.Apartment) error {
+	apt.Block = strings.TrimSpace(apt.Block)
+
+	unitType, err := normaliseUnitType(apt.UnitType)
+	if err != nil {
+		return err
+	}
+	apt.UnitType = unitType
+
+	if apt.CarpetArea < 0 || apt.SuperBuiltUpArea < 0 {
+		return errors.New("area cannot be negative")
+	}
+	if apt.SuperBuiltUpArea != 0 && apt.SuperBuiltUpArea < apt.CarpetArea {
+		return errors.New("super built-up area cannot be smaller than the carpet area")
+	}
+
+	seen := map[string]bool{}
+	var slots []string
+	for _, slot := range apt.ParkingSlots {
+		slot = strings.TrimSpace(slot)
+		if slot == "" || seen[strings.ToUpper(slot)] {
+			continue
+		}
+		if strings.Contains(slot, ",") {
+			return fmt.Errorf("parking slot %q cannot contain a comma", slot)
+		}
+		seen[strings.ToUpper(slot)] = true
+		slots = append(slots, slot)
+	}
+	sort.Strings(slots)
+	apt.ParkingSlots = slots
+	return nil
+}
+
+// checkParkingSlots makes sure no parking slot is allotted to two
+// apartments, counting the apartments about to be saved in place of their
+// stored versions
+func (s *Service) checkParkingSlots(apartments ...store.Apartment) error {
+	saving := map[string]bool{}
+	for _, apt := range apartments {
+		saving[apt.ID] = true
+	}
+
+	stored, err := s.store.ListApartments(0, 0)
+	if err != nil {
+		return err
+	}
+	for _, apt := range stored {
+		if !saving[apt.ID] {
+			apartments = append(apartments, apt)
+		}
+	}
+
+	allotted := map[string]string{}
+	for _, apt := range apartments {
+		for _, slot := range apt.ParkingSlots {
+			key := strings.ToUpper(slot)
+			if other, ok := allotted[key]; ok && other != apt.ID {
+				return fmt.Errorf("parking slot %s is allotted to both %s and %s", slot, other, apt.ID)
+			}
+			allotted[key] = apt.ID
+		}
+	}
+	return nil
+}
+
+// importColumns maps the header of an imported file to ApartmentColumns
+// indexes. Files without an ID column are read the old way, as ID, Owner
+// and Resident.
+func importColumns(header []string) map[int]int {
+	key := func(name string) string {
+		name = strings.ToLower(name)
+		return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(name)
+	}
+	known := map[string]int{}
+	for i, name := range ApartmentColumns {
+		known[key(name)] = i
+	}
+	// Alternative names seen in society registers
+	known["apartmentid"] = 0
+	known["tower"] = 4
+	known["type"] = 6
+	known["superarea"] = 8
+	known["parkingslots"] = 9
+
+	columns := map[int]int{}
+	hasID := false
+	for i, name := range header {
+		if column, ok := known[key(strings.TrimSpace(name))]; ok {
+			columns[i] = column
+			hasID = hasID || column == 0
+		}
+	}
+	if !hasID {
+		return map[int]int{0: 0, 1: 1, 2: 2}
+	}
+	return columns
+}
+
+// applyImportedRow sets the fields of apt that the row has columns for;
+// fields without a column keep their current value. A blank cell clears
+// its field, except that a blank floor is the ground floor, as in
+// ParseFloor; the store has no separate unknown floor.
+func applyImportedRow(apt *store.Apartment, record []string, columns map[int]int) error {
+	for i, value := range record {
+		column, ok := columns[i]
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch column {
+		case 0:
+			apt.ID = value
+		case 1:
+			apt.Owner = value
+		case 2:
+			apt.Resident = value
+		case 4:
+			apt.Block = value
+		case 5:
+			apt.Floor, err = ParseFloor(value)
+		case 6:
+			apt.UnitType = value
+		case 7:
+			apt.CarpetArea, err = ParseArea(value)
+		case 8:
+			apt.SuperBuiltUpArea, err = ParseArea(value)
+		case 9:
+			apt.ParkingSlots = ParseParkingSlots(value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
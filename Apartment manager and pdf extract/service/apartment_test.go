@@ -0,0 +1,127 @@
+package service
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"apartment_login/store"
+)
+
+func TestParseFloor(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"G", 0, false},
+		{" g ", 0, false},
+		{"3", 3, false},
+		{"-1", -1, false},
+		{"third", 0, true},
+		{"2.5", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFloor(tt.text)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseFloor(%q) = %d, %v; want %d, error %v", tt.text, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseArea(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    float64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"850", 850, false},
+		{" 1025.5 ", 1025.5, false},
+		{"-10", 0, true},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+		{"large", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseArea(tt.text)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseArea(%q) = %v, %v; want %v, error %v", tt.text, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseParkingSlots(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", nil},
+		{"P1", []string{"P1"}},
+		{" P1 , B-12,, ", []string{"P1", "B-12"}},
+	}
+	for _, tt := range tests {
+		if got := ParseParkingSlots(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseParkingSlots(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNormaliseDetails(t *testing.T) {
+	tests := []struct {
+		name    string
+		apt     store.Apartment
+		want    store.Apartment
+		wantErr string
+	}{
+		{
+			name: "tidied",
+			apt:  store.Apartment{Block: " A ", UnitType: "2 bhk", ParkingSlots: []string{" P2", "p1", "P2 ", ""}},
+			want: store.Apartment{Block: "A", UnitType: "2BHK", ParkingSlots: []string{"P2", "p1"}},
+		},
+		{
+			name: "areas",
+			apt:  store.Apartment{CarpetArea: 850, SuperBuiltUpArea: 1100},
+			want: store.Apartment{CarpetArea: 850, SuperBuiltUpArea: 1100},
+		},
+		{
+			name:    "unknown unit type",
+			apt:     store.Apartment{UnitType: "castle"},
+			wantErr: "unknown unit type",
+		},
+		{
+			name:    "super built-up smaller than carpet",
+			apt:     store.Apartment{CarpetArea: 900, SuperBuiltUpArea: 800},
+			wantErr: "cannot be smaller",
+		},
+		{
+			name:    "negative area",
+			apt:     store.Apartment{CarpetArea: -1},
+			wantErr: "cannot be negative",
+		},
+		{
+			name:    "comma in slot",
+			apt:     store.Apartment{ParkingSlots: []string{"P1,P2"}},
+			wantErr: "cannot contain a comma",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apt := tt.apt
+			err := normaliseDetails(&apt)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(apt, tt.want) {
+				t.Errorf("got %+v, want %+v", apt, tt.want)
+			}
+		})
+	}
+}
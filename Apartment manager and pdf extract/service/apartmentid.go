@@ -0,0 +1,458 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"apartment_login/store"
+)
+
+// In an ID pattern @ stands for a letter and # for a digit; anything else is
+// written as it is. "@-###" is a block letter, a dash and a three digit flat
+// number, as in A-101; "##" is a two digit number, as in 04.
+const (
+	idLetter = '@'
+	idDigit  = '#'
+)
+
+// idPrefixes are words written in front of an apartment number that are not
+// part of the ID itself, as in APT01 or Flat 4
+var idPrefixes = []string{"APARTMENT", "APT", "FLAT", "UNIT", "NO"}
+
+// idToken is a run of letters or of digits in an apartment ID
+type idToken struct {
+	text   string
+	digits bool
+}
+
+// idTokens splits an ID into uppercased runs of letters and digits,
+// dropping spaces, dashes and other separators
+func idTokens(id string) []idToken {
+	var tokens []idToken
+	var current idToken
+	flush := func() {
+		if current.text != "" {
+			tokens = append(tokens, current)
+		}
+		current = idToken{}
+	}
+	for _, r := range strings.ToUpper(id) {
+		digit := r >= '0' && r <= '9'
+		if !digit && !unicode.IsLetter(r) {
+			flush()
+			continue
+		}
+		if current.text != "" && current.digits != digit {
+			flush()
+		}
+		current.text += string(r)
+		current.digits = digit
+	}
+	flush()
+	return tokens
+}
+
+// withoutPrefix drops the words of idPrefixes from the start of an ID,
+// unless the pattern writes them itself
+func withoutPrefix(tokens []idToken, pattern string) []idToken {
+	pattern = strings.ToUpper(pattern)
+	for len(tokens) > 1 && !tokens[0].digits && slices.Contains(idPrefixes, tokens[0].text) &&
+		!strings.Contains(pattern, tokens[0].text) {
+		tokens = tokens[1:]
+	}
+	return tokens
+}
+
+// idSlot is a part of an ID pattern: a run of letters or digits of some
+// width, or text written as it is when kind is 0
+type idSlot struct {
+	kind    rune
+	width   int
+	literal string
+}
+
+func parseIDPattern(pattern string) []idSlot {
+	var slots []idSlot
+	for _, r := range pattern {
+		kind := r
+		if r != idLetter && r != idDigit {
+			kind = 0
+		}
+		if len(slots) == 0 || slots[len(slots)-1].kind != kind {
+			slots = append(slots, idSlot{kind: kind})
+		}
+		last := &slots[len(slots)-1]
+		last.width++
+		if kind == 0 {
+			last.literal += string(r)
+		}
+	}
+	return slots
+}
+
+// ValidateIDPattern checks an ID pattern; blank means any ID is accepted
+func ValidateIDPattern(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	if !strings.ContainsAny(pattern, string(idLetter)+string(idDigit)) {
+		return fmt.Errorf("ID pattern %q needs at least one %c for a letter or %c for a digit",
+			pattern, idLetter, idDigit)
+	}
+	if strings.ContainsAny(pattern, ",\t\n") {
+		return fmt.Errorf("ID pattern %q cannot contain commas, tabs or line breaks", pattern)
+	}
+	// Apartment IDs name receipt files
+	if strings.ContainsAny(pattern, `/\`) {
+		return fmt.Errorf("ID pattern %q cannot contain slashes", pattern)
+	}
+	return nil
+}
+
+// IDExample writes an example ID following pattern, e.g. A-101 for @-###
+func IDExample(pattern string) string {
+	var b strings.Builder
+	for _, slot := range parseIDPattern(pattern) {
+		switch slot.kind {
+		case idLetter:
+			b.WriteString(strings.Repeat("A", slot.width))
+		case idDigit:
+			b.WriteString(strings.Repeat("0", slot.width-1) + "1")
+		default:
+			b.WriteString(slot.literal)
+		}
+	}
+	return b.String()
+}
+
+// followingDigits is the width of the digit slots that come straight after
+// a digit slot, separated from it by nothing but punctuation
+func followingDigits(slots []idSlot) int {
+	width := 0
+	for _, slot := range slots {
+		switch {
+		case slot.kind == idDigit:
+			width += slot.width
+		case slot.kind == 0 && len(idTokens(slot.literal)) == 0:
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+// NormaliseID writes an apartment ID the way pattern asks: letters are
+// uppercased, numbers are padded or stripped of leading zeros to their
+// width, separators are put in and words such as APT and Flat dropped, so
+// with "@-###" a-101, A101 and Flat A-0101 all become A-101. Without a
+// pattern the ID is only trimmed and uppercased.
+func NormaliseID(id, pattern string) (string, error) {
+	id = strings.ToUpper(strings.Join(strings.Fields(id), " "))
+	if id == "" {
+		return "", errors.New("apartment ID is required")
+	}
+	if pattern == "" {
+		return id, nil
+	}
+
+	mismatch := fmt.Errorf("apartment ID %q does not follow the pattern %s, as in %s",
+		id, pattern, IDExample(pattern))
+	slots := parseIDPattern(pattern)
+	tokens := withoutPrefix(idTokens(id), pattern)
+	var b strings.Builder
+	for i, slot := range slots {
+		switch slot.kind {
+		case idLetter:
+			if len(tokens) == 0 || tokens[0].digits || len(tokens[0].text) != slot.width {
+				return "", mismatch
+			}
+			b.WriteString(tokens[0].text)
+			tokens = tokens[1:]
+		case idDigit:
+			if len(tokens) == 0 || !tokens[0].digits {
+				return "", mismatch
+			}
+			digits := tokens[0].text
+			tokens = tokens[1:]
+			// One run of digits may fill several digit slots, as 101 does
+			// for #-##, the later slots taking their width from the end
+			rest := followingDigits(slots[i+1:])
+			if rest > 0 && len(digits) > rest && (len(tokens) == 0 || !tokens[0].digits) {
+				tokens = append([]idToken{{text: digits[len(digits)-rest:], digits: true}}, tokens...)
+				digits = digits[:len(digits)-rest]
+			}
+			digits = strings.TrimLeft(digits, "0")
+			if len(digits) > slot.width {
+				return "", mismatch
+			}
+			b.WriteString(strings.Repeat("0", slot.width-len(digits)) + digits)
+		default:
+			// Letters and digits the pattern writes itself may be left out
+			for _, want := range idTokens(slot.literal) {
+				if len(tokens) > 0 && tokens[0] == want {
+					tokens = tokens[1:]
+				}
+			}
+			b.WriteString(slot.literal)
+		}
+	}
+	if len(tokens) > 0 {
+		return "", mismatch
+	}
+	return b.String(), nil
+}
+
+// IDKey reduces an apartment ID to what tells flats apart, so lookalikes
+// such as 01, 001, APT01 and apt-1 share a key. An ID without letters or
+// digits has a blank key and looks like nothing else.
+func IDKey(id string) string {
+	tokens := withoutPrefix(idTokens(id), "")
+	parts := make([]string, len(tokens))
+	for i, t := range tokens {
+		parts[i] = t.text
+		if t.digits {
+			parts[i] = strings.TrimLeft(t.text, "0")
+			if parts[i] == "" {
+				parts[i] = "0"
+			}
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// idPattern returns the ID pattern of the store's society
+func (s *Service) idPattern() (string, error) {
+	society, err := s.store.GetSociety(s.store.SocietyID())
+	if errors.Is(err, store.ErrNotFound) {
+		return "", nil
+	}
+	return society.IDPattern, err
+}
+
+// apartmentID resolves an ID typed or imported for an apartment. The ID of
+// an existing apartment, or of one in the recycle bin, is kept as it is so
+// apartments saved before the pattern was set can still be edited; any
+// other is normalised to the society's pattern.
+func (s *Service) apartmentID(id string) (string, error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return "", errors.New("apartment ID is required")
+	}
+	if _, err := s.store.GetApartment(id); err == nil {
+		return id, nil
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return "", err
+	}
+	if _, err := s.store.DeletedEntry(store.BinApartments, id); err == nil {
+		return id, nil
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return "", err
+	}
+
+	pattern, err := s.idPattern()
+	if err != nil {
+		return "", err
+	}
+	return NormaliseID(id, pattern)
+}
+
+// checkLookalikes refuses new apartment IDs that look like an existing
+// apartment's, or like each other's, so one flat does not end up under two
+// IDs such as 01 and APT01
+func (s *Service) checkLookalikes(ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	stored, err := s.store.ListApartments(0, 0)
+	if err != nil {
+		return err
+	}
+	seen := map[string]string{}
+	for _, apt := range stored {
+		seen[IDKey(apt.ID)] = apt.ID
+	}
+	for _, id := range ids {
+		key := IDKey(id)
+		if key == "" {
+			continue
+		}
+		if other, ok := seen[key]; ok && other != id {
+			return fmt.Errorf("apartment %s looks like apartment %s; use that ID, or merge the two under Duplicate IDs",
+				id, other)
+		}
+		seen[key] = id
+	}
+	return nil
+}
+
+// IDGroup is a set of apartment IDs that look like the same flat, or a
+// single ID not written the way the society's pattern asks. Suggested is
+// the ID to merge them into, which need not exist yet; it is blank for a
+// single ID the pattern cannot write at all, such as 01 under @-###.
+type IDGroup struct {
+	IDs []string
+	// Collections counts each apartment's collections, including those in
+	// the recycle bin
+	Collections map[string]int
+	Suggested   string
+}
+
+// LookalikeIDs finds the apartments whose IDs look like the same flat,
+// either because they only differ in leading zeros, separators and words
+// such as APT, or because the society's pattern writes them the same way,
+// and the apartments whose ID does not follow the pattern, whether or not
+// it can be rewritten to
+func (s *Service) LookalikeIDs() ([]IDGroup, error) {
+	apartments, err := s.store.ListApartments(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := s.idPattern()
+	if err != nil {
+		return nil, err
+	}
+
+	// Group the IDs sharing either key, merging groups an ID joins up
+	type group struct {
+		ids  []string
+		keys []string
+	}
+	groups := map[string]*group{}
+	normalised := map[string]string{}
+	for _, apt := range apartments {
+		var keys []string
+		if key := IDKey(apt.ID); key != "" {
+			keys = append(keys, "key:"+key)
+		}
+		if pattern != "" {
+			if id, err := NormaliseID(apt.ID, pattern); err == nil {
+				normalised[apt.ID] = id
+				keys = append(keys, "id:"+id)
+			}
+		}
+		if len(keys) == 0 {
+			// Looks like nothing else, but may still break the pattern
+			keys = append(keys, "lone:"+apt.ID)
+		}
+
+		joined := &group{ids: []string{apt.ID}, keys: keys}
+		absorbed := map[*group]bool{}
+		for _, key := range keys {
+			if g, ok := groups[key]; ok && !absorbed[g] {
+				absorbed[g] = true
+				joined.ids = append(joined.ids, g.ids...)
+				joined.keys = append(joined.keys, g.keys...)
+			}
+		}
+		for _, key := range joined.keys {
+			groups[key] = joined
+		}
+	}
+
+	seen := map[*group]bool{}
+	var result []IDGroup
+	for _, apt := range apartments {
+		for _, key := range []string{"key:" + IDKey(apt.ID), "id:" + normalised[apt.ID], "lone:" + apt.ID} {
+			g, ok := groups[key]
+			if !ok || seen[g] {
+				continue
+			}
+			seen[g] = true
+
+			sort.Strings(g.ids)
+			found := IDGroup{IDs: g.ids, Collections: map[string]int{}}
+			for _, id := range g.ids {
+				if found.Collections[id], err = s.store.CountCollections(id); err != nil {
+					return nil, err
+				}
+				if normalised[id] != "" && found.Suggested == "" {
+					found.Suggested = normalised[id]
+				}
+			}
+			switch {
+			case found.Suggested != "":
+			case len(g.ids) > 1:
+				found.Suggested = g.ids[0]
+				for _, id := range g.ids {
+					if found.Collections[id] > found.Collections[found.Suggested] {
+						found.Suggested = id
+					}
+				}
+			case pattern == "":
+				// A lone ID with nothing to follow is fine as it is
+				continue
+			}
+			if len(g.ids) > 1 || found.Suggested != g.ids[0] {
+				result = append(result, found)
+			}
+		}
+	}
+	return result, nil
+}
+
+// Merge is apartment From merged into Into. Created is set when Into was
+// made for the merge as a copy of From; Collections counts those moved.
+type Merge struct {
+	From        store.Apartment
+	Into        store.Apartment
+	Created     bool
+	Collections int
+}
+
+// MergeApartments moves the collections and resident logins of apartment
+// from over to into and moves from to the recycle bin, keeping its details
+// and history there. The moved collections keep the owner and resident
+// from had when they were received. into is normalised to the society's
+// pattern; if no such apartment exists it is created with from's details,
+// parking slots, history, co-owners and shares, so merging a lone ID into
+// its suggested form renames it.
+func (s *Service) MergeApartments(from, into, mergedBy string) (Merge, error) {
+	var merge Merge
+	var err error
+	merge.From, err = s.store.GetApartment(from)
+	if errors.Is(err, store.ErrNotFound) {
+		return merge, fmt.Errorf("apartment %s not found", from)
+	}
+	if err != nil {
+		return merge, err
+	}
+
+	into, err = s.apartmentID(into)
+	if err != nil {
+		return merge, err
+	}
+	if into == from {
+		return merge, errors.New("an apartment cannot be merged into itself")
+	}
+	if _, err := s.store.DeletedEntry(store.BinApartments, into); err == nil {
+		return merge, fmt.Errorf("apartment %s is in the recycle bin; restore it before merging into it", into)
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return merge, err
+	}
+	if merge.Collections, err = s.store.CountCollections(from); err != nil {
+		return merge, err
+	}
+
+	existing, err := s.store.GetApartment(into)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return merge, err
+	}
+
+	// Into is only set once the merge is written, even if the resident
+	// logins were left behind
+	merge.Created, err = s.store.MergeApartments(from, into, mergedBy)
+	if err != nil && !errors.Is(err, store.ErrLoginsNotMoved) {
+		return merge, err
+	}
+	merge.Into = existing
+	if merge.Created {
+		merge.Into = merge.From
+		merge.Into.ID = into
+		merge.Into.Version = 1
+	}
+	return merge, err
+}
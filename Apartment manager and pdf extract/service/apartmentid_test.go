@@ -0,0 +1,75 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateIDPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr string
+	}{
+		{"", ""},
+		{"@-###", ""},
+		{"TOWER @ ##", ""},
+		{"A-1", "needs at least one"},
+		{"@,###", "cannot contain commas"},
+		{"@/###", "cannot contain slashes"},
+		{`@\###`, "cannot contain slashes"},
+	}
+	for _, tt := range tests {
+		err := ValidateIDPattern(tt.pattern)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("ValidateIDPattern(%q) = %v", tt.pattern, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("ValidateIDPattern(%q) = %v, want %q", tt.pattern, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNormaliseID(t *testing.T) {
+	tests := []struct {
+		id      string
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{" a  101 ", "", "A 101", false},
+		{"a-1", "@-###", "A-001", false},
+		{"A101", "@-###", "A-101", false},
+		{"Flat A-0101", "@-###", "A-101", false},
+		{"01", "@-###", "", true},
+		{"A-1001", "@-###", "", true},
+		{"101", "#-##", "1-01", false},
+		{"APT 0101", "#-##", "1-01", false},
+		{"", "@-###", "", true},
+	}
+	for _, tt := range tests {
+		got, err := NormaliseID(tt.id, tt.pattern)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("NormaliseID(%q, %q) = %q, %v; want %q, error %v", tt.id, tt.pattern, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestIDKey(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"01", "1"},
+		{"APT01", "1"},
+		{"apt-001", "1"},
+		{"Flat A-0101", "A.101"},
+		{"000", "0"},
+		{"/", ""},
+	}
+	for _, tt := range tests {
+		if got := IDKey(tt.id); got != tt.want {
+			t.Errorf("IDKey(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
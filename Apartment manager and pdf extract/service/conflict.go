@@ -0,0 +1,127 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"apartment_login/store"
+)
+
+// FieldDiff is one field whose saved value differs from the one being saved
+type FieldDiff struct {
+	Field string
+	Yours string
+	Saved string
+}
+
+// ConflictError reports a save that lost the race with another editor.
+// Saved is the stored record now, for the editor to reload, or nil if it was
+// deleted; Diff lists the fields the two copies disagree on.
+type ConflictError struct {
+	Entity    string
+	ID        string
+	UpdatedAt string
+	Saved     any
+	Diff      []FieldDiff
+}
+
+func (e *ConflictError) Error() string {
+	if e.Saved == nil {
+		return fmt.Sprintf("%s %s was deleted by someone else", e.Entity, e.ID)
+	}
+	if e.UpdatedAt != "" {
+		return fmt.Sprintf("%s %s was changed by someone else at %s", e.Entity, e.ID, e.UpdatedAt)
+	}
+	return fmt.Sprintf("%s %s was changed by someone else", e.Entity, e.ID)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return store.ErrConflict
+}
+
+// diffFields lists the pairs of values that differ; fields come as
+// name, yours, saved triples
+func diffFields(fields ...string) []FieldDiff {
+	var diff []FieldDiff
+	for i := 0; i+2 < len(fields); i += 3 {
+		if fields[i+1] != fields[i+2] {
+			diff = append(diff, FieldDiff{Field: fields[i], Yours: fields[i+1], Saved: fields[i+2]})
+		}
+	}
+	return diff
+}
+
+// apartmentConflict describes why saving apt failed with store.ErrConflict
+func (s *Service) apartmentConflict(apt store.Apartment) error {
+	conflict := &ConflictError{Entity: "apartment", ID: apt.ID}
+	saved, err := s.store.GetApartment(apt.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return conflict
+	}
+	if err != nil {
+		return err
+	}
+
+	conflict.UpdatedAt = saved.UpdatedAt
+	conflict.Saved = saved
+	conflict.Diff = diffFields(
+		"Owner", apt.Owner, saved.Owner,
+		"Owner since", apt.OwnerSince, saved.OwnerSince,
+		"Resident", apt.Resident, saved.Resident,
+		"Resident since", apt.ResidentSince, saved.ResidentSince,
+		"Block", apt.Block, saved.Block,
+		"Floor", strconv.Itoa(apt.Floor), strconv.Itoa(saved.Floor),
+		"Unit type", apt.UnitType, saved.UnitType,
+		"Carpet area", FormatArea(apt.CarpetArea), FormatArea(saved.CarpetArea),
+		"Super built-up area", FormatArea(apt.SuperBuiltUpArea), FormatArea(saved.SuperBuiltUpArea),
+		"Parking", strings.Join(apt.ParkingSlots, ", "), strings.Join(saved.ParkingSlots, ", "),
+	)
+	return conflict
+}
+
+// userConflict describes why saving user failed with store.ErrConflict
+func (s *Service) userConflict(user store.User) error {
+	conflict := &ConflictError{Entity: "user", ID: user.Username}
+	saved, err := s.store.GetUser(user.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return conflict
+	}
+	if err != nil {
+		return err
+	}
+
+	conflict.UpdatedAt = saved.UpdatedAt
+	conflict.Saved = saved
+	conflict.Diff = diffFields(
+		"Username", user.Username, saved.Username,
+		"Full name", user.FullName, saved.FullName,
+		"Role", string(user.Role), string(saved.Role),
+		"Disabled", strconv.FormatBool(user.Disabled), strconv.FormatBool(saved.Disabled),
+	)
+	return conflict
+}
+
+// personConflict describes why saving p failed with store.ErrConflict
+func (s *Service) personConflict(p store.Person) error {
+	conflict := &ConflictError{Entity: "person", ID: p.Name}
+	saved, err := s.store.GetPerson(p.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return conflict
+	}
+	if err != nil {
+		return err
+	}
+
+	conflict.UpdatedAt = saved.UpdatedAt
+	conflict.Saved = saved
+	conflict.Diff = diffFields(
+		"Name", p.Name, saved.Name,
+		"Phone", p.Phone, saved.Phone,
+		"Email", p.Email, saved.Email,
+		"Alternate contact", p.AlternateContact, saved.AlternateContact,
+		"ID proof", p.IDProof, saved.IDProof,
+	)
+	return conflict
+}
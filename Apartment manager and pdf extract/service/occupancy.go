@@ -0,0 +1,206 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"apartment_login/store"
+)
+
+// DateFormat is how occupancy dates are written
+const DateFormat = "2006-01-02"
+
+// Occupants are the owner and resident of an apartment on some date.
+// CoOwners are only known for the current owner, since their history is not
+// kept.
+type Occupants struct {
+	Owner    string
+	CoOwners []string
+	Resident string
+}
+
+// Owners names the owner followed by any co-owners
+func (o Occupants) Owners() string {
+	return strings.Join(append([]string{o.Owner}, o.CoOwners...), ", ")
+}
+
+// SeparateResident reports whether the resident should be named alongside
+// the owner, that is someone other than the owner lives there
+func (o Occupants) SeparateResident() bool {
+	return o.Resident != "" && o.Resident != Vacant && o.Resident != o.Owner
+}
+
+// StatementLine is a collection together with who owned and lived in the
+// apartment on the day it was received
+type StatementLine struct {
+	Collection store.Collection
+	Occupants  Occupants
+}
+
+// checkOccupancy validates the owner and resident since dates of apt against
+// the stored apartment. A new owner or resident whose date was left as it
+// was takes over today.
+func (s *Service) checkOccupancy(apt *store.Apartment, before *store.Apartment) error {
+	today := time.Now().Format(DateFormat)
+	if err := s.checkSince(apt.ID, store.OccupancyOwner, apt.Owner, &apt.OwnerSince, before, today); err != nil {
+		return fmt.Errorf("apartment %s: %w", apt.ID, err)
+	}
+	if err := s.checkSince(apt.ID, store.OccupancyResident, apt.Resident, &apt.ResidentSince, before, today); err != nil {
+		return fmt.Errorf("apartment %s: %w", apt.ID, err)
+	}
+	return nil
+}
+
+// checkSince validates the since date of one role; see checkOccupancy
+func (s *Service) checkSince(apartmentID string, role store.OccupancyRole, name string, since *string,
+	before *store.Apartment, today string) error {
+	*since = strings.TrimSpace(*since)
+	if *since != "" {
+		if _, err := time.Parse(DateFormat, *since); err != nil {
+			return fmt.Errorf("%s since must be a date written as YYYY-MM-DD, not %q", role, *since)
+		}
+		if *since > today {
+			return fmt.Errorf("%s since cannot be in the future", role)
+		}
+	}
+	if before == nil {
+		return nil
+	}
+
+	beforeName, beforeSince := before.Owner, before.OwnerSince
+	if role == store.OccupancyResident {
+		beforeName, beforeSince = before.Resident, before.ResidentSince
+	}
+
+	if name != beforeName {
+		if *since == "" || *since == beforeSince {
+			*since = today
+		}
+		if beforeSince != "" && *since < beforeSince {
+			return fmt.Errorf("the new %s cannot take over before %s, when %s did", role, beforeSince, beforeName)
+		}
+		return nil
+	}
+
+	if *since == "" {
+		*since = beforeSince
+	}
+	if *since == beforeSince || beforeSince == "" {
+		return nil
+	}
+	// Moving the start of the current period moves the end of the one
+	// before it, which cannot end before it began
+	history, err := s.store.OccupancyHistory(apartmentID)
+	if err != nil {
+		return err
+	}
+	for _, period := range history {
+		if period.Role == role && period.To == beforeSince && period.From != "" && *since <= period.From {
+			return fmt.Errorf("%s since must be after %s, when %s took over", role, period.From, period.Name)
+		}
+	}
+	return nil
+}
+
+// occupantsOn picks the owner and resident on date from an apartment's
+// history. A date before the earliest period gets the earliest names.
+func occupantsOn(history []store.Occupancy, date string) Occupants {
+	var occupants Occupants
+	found := map[store.OccupancyRole]bool{}
+	for _, period := range history {
+		if found[period.Role] {
+			continue
+		}
+		covers := period.Covers(date)
+		name := &occupants.Owner
+		if period.Role == store.OccupancyResident {
+			name = &occupants.Resident
+		}
+		if covers || *name == "" {
+			*name = period.Name
+		}
+		found[period.Role] = covers
+	}
+	return occupants
+}
+
+// OccupantsOn returns who owned and lived in an apartment on date, given as
+// YYYY-MM-DD
+func (s *Service) OccupantsOn(apartmentID, date string) (Occupants, error) {
+	history, err := s.store.OccupancyHistory(apartmentID)
+	if err != nil {
+		return Occupants{}, err
+	}
+	owners, err := s.Owners(apartmentID)
+	if err != nil {
+		return Occupants{}, err
+	}
+	occupants := occupantsOn(history, date)
+	occupants.CoOwners = coOwnersOf(owners, occupants.Owner)
+	return occupants, nil
+}
+
+// CollectionOccupants returns who owned and lived in the apartment a
+// collection was received for, on the day it was received
+func (s *Service) CollectionOccupants(c store.Collection) (Occupants, error) {
+	apartmentID, err := s.receivedFor(c)
+	if err != nil {
+		return Occupants{}, err
+	}
+	return s.OccupantsOn(apartmentID, c.Date)
+}
+
+// receivedFor is the apartment whose history names the occupants of a
+// collection: the one it was received for, even if since merged away,
+// unless that apartment has been purged
+func (s *Service) receivedFor(c store.Collection) (string, error) {
+	if c.MergedFrom == "" {
+		return c.ApartmentID, nil
+	}
+	history, err := s.store.OccupancyHistory(c.MergedFrom)
+	if err != nil {
+		return "", err
+	}
+	if len(history) == 0 {
+		return c.ApartmentID, nil
+	}
+	return c.MergedFrom, nil
+}
+
+// Statement lists an apartment's collections, newest first, each with the
+// owner and resident at the time rather than today's. Collections moved
+// over from a merged apartment name that apartment's occupants.
+func (s *Service) Statement(apartmentID string) ([]StatementLine, error) {
+	collections, err := s.store.CollectionsForApartment(apartmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	type occupancy struct {
+		history []store.Occupancy
+		owners  []store.PersonLink
+	}
+	loaded := map[string]occupancy{}
+	lines := make([]StatementLine, len(collections))
+	for i, c := range collections {
+		receivedFor, err := s.receivedFor(c)
+		if err != nil {
+			return nil, err
+		}
+		o, ok := loaded[receivedFor]
+		if !ok {
+			if o.history, err = s.store.OccupancyHistory(receivedFor); err != nil {
+				return nil, err
+			}
+			if o.owners, err = s.Owners(receivedFor); err != nil {
+				return nil, err
+			}
+			loaded[receivedFor] = o
+		}
+		occupants := occupantsOn(o.history, c.Date)
+		occupants.CoOwners = coOwnersOf(o.owners, occupants.Owner)
+		lines[i] = StatementLine{Collection: c, Occupants: occupants}
+	}
+	return lines, nil
+}
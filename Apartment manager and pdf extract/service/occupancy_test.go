@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+
+	"apartment_login/store"
+)
+
+func TestOccupantsOn(t *testing.T) {
+	// Owners before residents and oldest first, as OccupancyHistory returns them
+	history := []store.Occupancy{
+		{Role: store.OccupancyOwner, Name: "Asha", To: "2021-04-01"},
+		{Role: store.OccupancyOwner, Name: "Ravi", From: "2021-04-01"},
+		{Role: store.OccupancyResident, Name: "Kiran", From: "2020-01-01", To: "2022-07-15"},
+		{Role: store.OccupancyResident, Name: Vacant, From: "2022-07-15"},
+	}
+	tests := []struct {
+		date string
+		want Occupants
+	}{
+		{"2019-05-01", Occupants{Owner: "Asha", Resident: "Kiran"}},
+		{"2021-03-31", Occupants{Owner: "Asha", Resident: "Kiran"}},
+		{"2021-04-01", Occupants{Owner: "Ravi", Resident: "Kiran"}},
+		{"2022-07-15", Occupants{Owner: "Ravi", Resident: Vacant}},
+		{"2030-01-01", Occupants{Owner: "Ravi", Resident: Vacant}},
+	}
+	for _, tt := range tests {
+		got := occupantsOn(history, tt.date)
+		if got.Owner != tt.want.Owner || got.Resident != tt.want.Resident {
+			t.Errorf("occupantsOn(%s) = %+v, want %+v", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestSeparateResident(t *testing.T) {
+	tests := []struct {
+		occupants Occupants
+		want      bool
+	}{
+		{Occupants{Owner: "Asha", Resident: "Asha"}, false},
+		{Occupants{Owner: "Asha", Resident: Vacant}, false},
+		{Occupants{Owner: "Asha"}, false},
+		{Occupants{Owner: "Asha", Resident: "Ravi"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.occupants.SeparateResident(); got != tt.want {
+			t.Errorf("%+v.SeparateResident() = %v, want %v", tt.occupants, got, tt.want)
+		}
+	}
+}
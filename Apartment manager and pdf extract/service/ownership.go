@@ -0,0 +1,276 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"apartment_login/money"
+	"apartment_login/store"
+)
+
+// ParseShare reads a percentage such as "33.33" or "50%" as store Share
+// units
+func ParseShare(text string) (int, error) {
+	text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(text), "%"))
+	percent, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(percent) || math.IsInf(percent, 0) {
+		return 0, fmt.Errorf("invalid share %q", text)
+	}
+	share := math.Round(percent * store.FullShare / 100)
+	if share <= 0 || share > store.FullShare {
+		return 0, fmt.Errorf("a share must be more than 0%% and at most 100%%, not %s%%", text)
+	}
+	return int(share), nil
+}
+
+// FormatShare shows store Share units as a percentage, e.g. "33.33%"
+func FormatShare(share int) string {
+	return strconv.FormatFloat(float64(share)*100/store.FullShare, 'f', -1, 64) + "%"
+}
+
+// FormatVotes shows a voting weight in Share units as votes, e.g. "2.5"
+func FormatVotes(weight int) string {
+	return strconv.FormatFloat(float64(weight)/store.FullShare, 'f', -1, 64)
+}
+
+// ownerLinks picks the owner and co-owners out of an apartment's links, the
+// owner first
+func ownerLinks(links []store.PersonLink) []store.PersonLink {
+	var owners []store.PersonLink
+	for _, link := range links {
+		if link.Role == store.LinkOwner {
+			owners = append([]store.PersonLink{link}, owners...)
+		} else if link.Role == store.LinkCoOwner {
+			owners = append(owners, link)
+		}
+	}
+	return owners
+}
+
+// Owners returns the owner and co-owners of an apartment, the owner first,
+// with their shares
+func (s *Service) Owners(apartmentID string) ([]store.PersonLink, error) {
+	links, err := s.store.ApartmentLinks(apartmentID)
+	if err != nil {
+		return nil, err
+	}
+	return ownerLinks(links), nil
+}
+
+// balanceShares brings the owners' shares of an apartment back to a total
+// of 100% after someone joined or left. A single newcomer takes the share
+// nobody holds, as when a co-owner's part is sold; otherwise newcomers mean
+// an equal split. A share left by someone who went goes to the owner.
+func (s *Service) balanceShares(apartmentID string) error {
+	owners, err := s.Owners(apartmentID)
+	if err != nil || len(owners) == 0 {
+		return err
+	}
+
+	held := 0
+	var newcomers []int
+	for i, o := range owners {
+		if o.Share <= 0 {
+			newcomers = append(newcomers, i)
+		} else {
+			held += o.Share
+		}
+	}
+	missing := store.FullShare - held
+
+	shares := map[int]int{}
+	switch {
+	case len(newcomers) == 1 && missing > 0:
+		shares[owners[newcomers[0]].PersonID] = missing
+	case len(newcomers) == 0 && missing == 0:
+		return nil
+	case len(newcomers) == 0 && owners[0].Share+missing > 0:
+		shares[owners[0].PersonID] = owners[0].Share + missing
+	default:
+		for i, o := range owners {
+			shares[o.PersonID] = store.FullShare / len(owners)
+			if i == 0 {
+				shares[o.PersonID] += store.FullShare % len(owners)
+			}
+		}
+	}
+	return s.store.SetOwnershipShares(apartmentID, shares)
+}
+
+// SetOwnershipShares sets the share of every owner of an apartment, keyed
+// by person, and returns the owners as they were. The shares must cover
+// exactly the apartment's owners and add up to 100%.
+func (s *Service) SetOwnershipShares(apartmentID string, shares map[int]int) ([]store.PersonLink, error) {
+	owners, err := s.Owners(apartmentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(owners) == 0 {
+		return nil, fmt.Errorf("apartment %s has no owners linked", apartmentID)
+	}
+
+	total := 0
+	for _, o := range owners {
+		share, ok := shares[o.PersonID]
+		if !ok {
+			return nil, fmt.Errorf("no share given for %s", o.PersonName)
+		}
+		if share <= 0 {
+			return nil, fmt.Errorf("%s must have a share of more than 0%%", o.PersonName)
+		}
+		total += share
+	}
+	if len(shares) != len(owners) {
+		return nil, errors.New("shares can only be given to the apartment's owners")
+	}
+	if total != store.FullShare {
+		return nil, fmt.Errorf("the shares add up to %s; they must total 100%%", FormatShare(total))
+	}
+	return owners, s.store.SetOwnershipShares(apartmentID, shares)
+}
+
+// coOwnersOf names the co-owners to show beside owner, who are only known
+// while owner is still the apartment's owner
+func coOwnersOf(owners []store.PersonLink, owner string) []string {
+	if len(owners) == 0 || owners[0].Role != store.LinkOwner || NameKey(owners[0].PersonName) != NameKey(owner) {
+		return nil
+	}
+	var names []string
+	for _, o := range owners[1:] {
+		names = append(names, o.PersonName)
+	}
+	return names
+}
+
+// OwnerStatement is one owner's part of an apartment's collections for a
+// year: Amounts holds their part of each of Lines
+type OwnerStatement struct {
+	ApartmentID string
+	Year        int
+	Owner       store.PersonLink
+	Lines       []StatementLine
+	Amounts     []money.Money
+	Total       money.Money
+}
+
+// OwnerStatements splits an apartment's collections received in year among
+// its owners by their shares. Each collection is split exactly, so the
+// owners' parts add up to what was received. Shares are only known for the
+// current ownership, so a collection received while someone else owned the
+// apartment goes wholly to that owner, in a statement of their own.
+func (s *Service) OwnerStatements(apartmentID string, year int) ([]OwnerStatement, error) {
+	owners, err := s.Owners(apartmentID)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := s.Statement(apartmentID)
+	if err != nil {
+		return nil, err
+	}
+	people, err := s.store.SearchPeople("")
+	if err != nil {
+		return nil, err
+	}
+
+	statements := make([]OwnerStatement, len(owners))
+	weights := make([]int, len(owners))
+	for i, o := range owners {
+		statements[i] = OwnerStatement{ApartmentID: apartmentID, Year: year, Owner: o}
+		weights[i] = o.Share
+	}
+	current := ""
+	if len(owners) > 0 && owners[0].Role == store.LinkOwner {
+		current = NameKey(owners[0].PersonName)
+	}
+
+	// former finds or starts the statement of an earlier owner
+	former := map[string]int{}
+	formerOwner := func(name string) *OwnerStatement {
+		key := NameKey(name)
+		i, ok := former[key]
+		if !ok {
+			owner := store.PersonLink{PersonName: name, ApartmentID: apartmentID, Role: store.LinkOwner, Share: store.FullShare}
+			for _, p := range people {
+				if NameKey(p.Name) == key {
+					owner.PersonID = p.ID
+					break
+				}
+			}
+			statements = append(statements, OwnerStatement{ApartmentID: apartmentID, Year: year, Owner: owner})
+			i = len(statements) - 1
+			former[key] = i
+		}
+		return &statements[i]
+	}
+
+	prefix := strconv.Itoa(year) + "-"
+	for _, line := range lines {
+		if !strings.HasPrefix(line.Collection.Date, prefix) {
+			continue
+		}
+		if owner := NameKey(line.Occupants.Owner); owner != "" && owner != current {
+			statement := formerOwner(line.Occupants.Owner)
+			statement.Lines = append(statement.Lines, line)
+			statement.Amounts = append(statement.Amounts, line.Collection.Price)
+			statement.Total += line.Collection.Price
+			continue
+		}
+		for i, amount := range line.Collection.Price.Allocate(weights) {
+			statements[i].Lines = append(statements[i].Lines, line)
+			statements[i].Amounts = append(statements[i].Amounts, amount)
+			statements[i].Total += amount
+		}
+	}
+	return statements, nil
+}
+
+// Vote is someone's voting weight at the AGM, in Share units: each
+// apartment carries one vote, split among its owners by their shares
+type Vote struct {
+	PersonID   int
+	Name       string
+	Apartments []string
+	Weight     int
+}
+
+// VotingWeights returns the voting weight of everyone who owns part of an
+// apartment, ordered by name
+func (s *Service) VotingWeights() ([]Vote, error) {
+	apartments, err := s.store.ListApartments(0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	votes := map[int]*Vote{}
+	for _, apt := range apartments {
+		owners, err := s.Owners(apt.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range owners {
+			vote, ok := votes[o.PersonID]
+			if !ok {
+				vote = &Vote{PersonID: o.PersonID, Name: o.PersonName}
+				votes[o.PersonID] = vote
+			}
+			vote.Apartments = append(vote.Apartments, apt.ID)
+			vote.Weight += o.Share
+		}
+	}
+
+	list := make([]Vote, 0, len(votes))
+	for _, vote := range votes {
+		list = append(list, *vote)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if NameKey(list[i].Name) != NameKey(list[j].Name) {
+			return NameKey(list[i].Name) < NameKey(list[j].Name)
+		}
+		return list[i].PersonID < list[j].PersonID
+	})
+	return list, nil
+}
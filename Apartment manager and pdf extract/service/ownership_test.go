@@ -0,0 +1,264 @@
+package service_test
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"apartment_login/money"
+	"apartment_login/service"
+	"apartment_login/store"
+)
+
+func TestParseShare(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    int
+		wantErr bool
+	}{
+		{"50", 5000, false},
+		{"33.33%", 3333, false},
+		{" 100 % ", store.FullShare, false},
+		{"0", 0, true},
+		{"-10", 0, true},
+		{"100.01", 0, true},
+		{"half", 0, true},
+		{"NaN", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := service.ParseShare(tt.text)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseShare(%q) = %d, %v; want %d, error %v", tt.text, got, err, tt.want, tt.wantErr)
+		}
+	}
+	for share, want := range map[int]string{5000: "50%", 3333: "33.33%", store.FullShare: "100%"} {
+		if got := service.FormatShare(share); got != want {
+			t.Errorf("FormatShare(%d) = %q, want %q", share, got, want)
+		}
+	}
+}
+
+func TestOwnershipShares(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha"})
+		mustSaveApartment(t, svc, store.Apartment{ID: "102", Owner: "Asha"})
+		owners, err := svc.Owners("101")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(owners) != 1 || owners[0].PersonName != "Asha" || owners[0].Share != store.FullShare {
+			t.Fatalf("owners of 101 = %+v, want Asha with 100%%", owners)
+		}
+		asha := owners[0].PersonID
+
+		kiran, err := svc.SavePerson(store.Person{Name: "Kiran"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := svc.LinkPerson(kiran.After.ID, "101", store.LinkCoOwner); err != nil {
+			t.Fatal(err)
+		}
+		if owners, err = svc.Owners("101"); err != nil {
+			t.Fatal(err)
+		}
+		if len(owners) != 2 || owners[0].Share+owners[1].Share != store.FullShare {
+			t.Fatalf("owners of 101 after linking a co-owner = %+v, want shares totalling 100%%", owners)
+		}
+
+		for _, bad := range []struct {
+			shares  map[int]int
+			wantErr string
+		}{
+			{map[int]int{asha: 6000}, "no share given for Kiran"},
+			{map[int]int{asha: 6000, kiran.After.ID: 3000}, "must total 100%"},
+			{map[int]int{asha: store.FullShare, kiran.After.ID: 0}, "more than 0%"},
+			{map[int]int{asha: 6000, kiran.After.ID: 3000, 999: 1000}, "only be given to the apartment's owners"},
+		} {
+			if _, err := svc.SetOwnershipShares("101", bad.shares); err == nil || !strings.Contains(err.Error(), bad.wantErr) {
+				t.Errorf("SetOwnershipShares(%v) error = %v, want %q", bad.shares, err, bad.wantErr)
+			}
+		}
+		if _, err := svc.SetOwnershipShares("101", map[int]int{asha: 6000, kiran.After.ID: 4000}); err != nil {
+			t.Fatal(err)
+		}
+
+		votes, err := svc.VotingWeights()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []struct {
+			name       string
+			apartments string
+			weight     int
+		}{
+			{"Asha", "101,102", 16000},
+			{"Kiran", "101", 4000},
+		}
+		if len(votes) != len(want) {
+			t.Fatalf("got %d votes, want %d: %+v", len(votes), len(want), votes)
+		}
+		for i, w := range want {
+			v := votes[i]
+			if v.Name != w.name || strings.Join(v.Apartments, ",") != w.apartments || v.Weight != w.weight {
+				t.Errorf("vote %d = %+v, want %s on %s with weight %d", i, v, w.name, w.apartments, w.weight)
+			}
+		}
+		if got := service.FormatVotes(votes[0].Weight); got != "1.6" {
+			t.Errorf("FormatVotes(%d) = %q, want 1.6", votes[0].Weight, got)
+		}
+	})
+}
+
+func TestOwnerStatementsSplitByShare(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha"})
+		kiran, err := svc.SavePerson(store.Person{Name: "Kiran"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := svc.LinkPerson(kiran.After.ID, "101", store.LinkCoOwner); err != nil {
+			t.Fatal(err)
+		}
+		owners, err := svc.Owners("101")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := svc.SetOwnershipShares("101", map[int]int{owners[0].PersonID: 6000, kiran.After.ID: 4000}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := svc.RecordCollection("101", "January", service.MaintenanceType, 100001); err != nil {
+			t.Fatal(err)
+		}
+
+		c := mustRecordCollection(t, svc, "101", "February")
+		year, err := strconv.Atoi(c.Date[:4])
+		if err != nil {
+			t.Fatal(err)
+		}
+		statements, err := svc.OwnerStatements("101", year)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(statements) != 2 {
+			t.Fatalf("got %d statements, want 2", len(statements))
+		}
+		for i, want := range []struct {
+			owner string
+			total int64
+		}{{"Asha", 60001 + 240000}, {"Kiran", 40000 + 160000}} {
+			st := statements[i]
+			if st.Owner.PersonName != want.owner || int64(st.Total) != want.total || len(st.Lines) != 2 {
+				t.Errorf("statement %d = %s with %d lines totalling %d, want %s totalling %d",
+					i, st.Owner.PersonName, len(st.Lines), st.Total, want.owner, want.total)
+			}
+		}
+	})
+}
+
+func TestOwnerStatements(t *testing.T) {
+	year := time.Now().Year() - 1
+	on := func(monthDay string) string { return fmt.Sprintf("%d-%s", year, monthDay) }
+
+	// Each store records collections on a given date: the memory store
+	// through its clock, SQLite by correcting the date afterwards
+	stores := []struct {
+		name string
+		open func(t *testing.T) (*service.Service, func(date string, c store.Collection))
+	}{
+		{"memory", func(t *testing.T) (*service.Service, func(string, store.Collection)) {
+			return service.New(store.NewMemory()), nil
+		}},
+		{"sqlite", func(t *testing.T) (*service.Service, func(string, store.Collection)) {
+			appDB, residentDB := newTestDatabases(t)
+			svc := service.New(store.NewSQLite(appDB, residentDB))
+			return svc, func(date string, c store.Collection) {
+				if _, err := residentDB.Exec("UPDATE collections SET date = ? WHERE id = ?", date, c.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}},
+	}
+
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			svc, redate := st.open(t)
+			record := func(date string, price money.Money) {
+				t.Helper()
+				if m, ok := svc.Store().(*store.Memory); ok {
+					day, _ := time.ParseInLocation(service.DateFormat, date, time.Local)
+					m.Now = func() time.Time { return day }
+					defer func() { m.Now = time.Now }()
+				}
+				c, err := svc.RecordCollection("101", "January", service.MaintenanceType, price)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if redate != nil {
+					redate(date, c)
+				}
+			}
+
+			apt := mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha", OwnerSince: on("01-01")})
+			record(on("01-10"), 100000)
+			record(fmt.Sprintf("%d-12-31", year-1), 5000)
+
+			apt.Owner, apt.OwnerSince = "Ravi", on("01-15")
+			if _, err := svc.SaveApartment(apt); err != nil {
+				t.Fatal(err)
+			}
+			kiran, err := svc.SavePerson(store.Person{Name: "Kiran"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.LinkPerson(kiran.After.ID, "101", store.LinkCoOwner); err != nil {
+				t.Fatal(err)
+			}
+			owners, err := svc.Owners("101")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := svc.SetOwnershipShares("101", map[int]int{owners[0].PersonID: 6000, kiran.After.ID: 4000}); err != nil {
+				t.Fatal(err)
+			}
+			record(on("02-10"), 100001)
+			record(on("03-10"), 50000)
+
+			statements, err := svc.OwnerStatements("101", year)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []struct {
+				owner string
+				share int
+				lines int
+				total money.Money
+			}{
+				{"Ravi", 6000, 2, 60001 + 30000},
+				{"Kiran", 4000, 2, 40000 + 20000},
+				{"Asha", store.FullShare, 1, 100000},
+			}
+			if len(statements) != len(want) {
+				t.Fatalf("got %d statements, want %d", len(statements), len(want))
+			}
+			var received money.Money
+			for i, w := range want {
+				got := statements[i]
+				if got.Owner.PersonName != w.owner || got.Owner.Share != w.share || len(got.Lines) != w.lines ||
+					got.Total != w.total {
+					t.Errorf("statement %d: %s %d with %d line(s) totalling %s, want %s %d with %d totalling %s",
+						i, got.Owner.PersonName, got.Owner.Share, len(got.Lines), got.Total,
+						w.owner, w.share, w.lines, w.total)
+				}
+				received += got.Total
+			}
+			if received != 250001 {
+				t.Errorf("statements total %s, want %s", received, money.Money(250001))
+			}
+			if statements[2].Owner.PersonID == 0 {
+				t.Error("the earlier owner's statement is not tied to them in the directory")
+			}
+		})
+	}
+}
@@ -0,0 +1,449 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"apartment_login/store"
+)
+
+// PersonColumns is the header of a people file. Imports accept the same
+// names in any order.
+var PersonColumns = []string{"Name", "Phone", "Email", "Alternate Contact", "ID Proof"}
+
+// PersonChange pairs a person with their previous details; Before is nil
+// for someone added to the directory
+type PersonChange struct {
+	Before *store.Person
+	After  store.Person
+}
+
+// Duplicate is someone in the directory who may be the same person as
+// another, with the detail they share
+type Duplicate struct {
+	Person store.Person
+	Reason string
+}
+
+// NameKey is how names are compared: ignoring case and extra spaces
+func NameKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// phoneKey is the last ten digits of a phone number, so the same number
+// written with or without the country code or a leading zero matches
+func phoneKey(phone string) string {
+	var digits []rune
+	for _, r := range phone {
+		if r >= '0' && r <= '9' {
+			digits = append(digits, r)
+		}
+	}
+	if len(digits) > 10 {
+		digits = digits[len(digits)-10:]
+	}
+	return string(digits)
+}
+
+// normalisePerson validates a person's details
+func normalisePerson(p store.Person) (store.Person, error) {
+	p.Name = strings.Join(strings.Fields(p.Name), " ")
+	p.Phone = strings.TrimSpace(p.Phone)
+	p.Email = strings.TrimSpace(p.Email)
+	p.AlternateContact = strings.TrimSpace(p.AlternateContact)
+	p.IDProof = strings.TrimSpace(p.IDProof)
+
+	if p.Name == "" {
+		return p, errors.New("name is required")
+	}
+	if strings.EqualFold(p.Name, Vacant) {
+		return p, fmt.Errorf("%s is not a person's name", Vacant)
+	}
+	if p.Phone != "" {
+		if strings.Trim(p.Phone, "0123456789+-() ") != "" || len(phoneKey(p.Phone)) < 7 {
+			return p, fmt.Errorf("invalid phone number %q", p.Phone)
+		}
+	}
+	if p.Email != "" {
+		if addr, err := mail.ParseAddress(p.Email); err != nil || addr.Address != p.Email {
+			return p, fmt.Errorf("invalid email address %q", p.Email)
+		}
+	}
+	return p, nil
+}
+
+// sameAs says why two people look like the same person, or returns "" if
+// they do not. Matching names only count when the phone numbers and email
+// addresses do not tell them apart.
+func sameAs(a, b store.Person) string {
+	if a.Email != "" && strings.EqualFold(a.Email, b.Email) {
+		return "same email"
+	}
+	if a.Phone != "" && phoneKey(a.Phone) == phoneKey(b.Phone) {
+		return "same phone"
+	}
+	if NameKey(a.Name) != NameKey(b.Name) {
+		return ""
+	}
+	if a.Email != "" && b.Email != "" {
+		return ""
+	}
+	if a.Phone != "" && b.Phone != "" {
+		return ""
+	}
+	return "same name"
+}
+
+// PossibleDuplicates lists the other people in the directory who share p's
+// email, phone or name
+func (s *Service) PossibleDuplicates(p store.Person) ([]Duplicate, error) {
+	people, err := s.store.SearchPeople("")
+	if err != nil {
+		return nil, err
+	}
+	var duplicates []Duplicate
+	for _, other := range people {
+		if other.ID == p.ID {
+			continue
+		}
+		if reason := sameAs(p, other); reason != "" {
+			duplicates = append(duplicates, Duplicate{Person: other, Reason: reason})
+		}
+	}
+	return duplicates, nil
+}
+
+// SavePerson adds someone to the directory when p.ID is 0, otherwise
+// updates them from the copy read at p.Version
+func (s *Service) SavePerson(p store.Person) (PersonChange, error) {
+	p, err := normalisePerson(p)
+	if err != nil {
+		return PersonChange{}, err
+	}
+
+	var before *store.Person
+	if p.ID != 0 {
+		existing, err := s.store.GetPerson(p.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return PersonChange{}, s.personConflict(p)
+		}
+		if err != nil {
+			return PersonChange{}, err
+		}
+		before = &existing
+	}
+
+	saved, err := s.store.SavePerson(p)
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return PersonChange{}, s.personConflict(p)
+		}
+		return PersonChange{}, err
+	}
+	return PersonChange{Before: before, After: saved}, nil
+}
+
+// DeletePerson removes someone from the directory. People still linked to
+// an apartment have to be unlinked first.
+func (s *Service) DeletePerson(id int) (store.Person, error) {
+	before, err := s.store.GetPerson(id)
+	if err != nil {
+		return before, err
+	}
+	links, err := s.store.PersonLinks(id)
+	if err != nil {
+		return before, err
+	}
+	if len(links) > 0 {
+		return before, fmt.Errorf("%s is still linked to %s; unlink them first", before.Name, linkSummary(links))
+	}
+	return before, s.store.DeletePerson(id)
+}
+
+// linkSummary lists links as "A-101 (owner), B-2 (co-owner)"
+func linkSummary(links []store.PersonLink) string {
+	parts := make([]string, len(links))
+	for i, link := range links {
+		parts[i] = fmt.Sprintf("%s (%s)", link.ApartmentID, link.Role)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// LinkPerson ties a person to an apartment. An apartment has one owner and
+// one resident, who are named in the Apartment Manager, so only someone of
+// that name can be linked in either role and they replace whoever was
+// linked before. Any number of co-owners can be linked; a new owner takes
+// the share of the one they replace and co-owners added to an apartment
+// split it equally until the shares are set.
+func (s *Service) LinkPerson(personID int, apartmentID string, role store.LinkRole) error {
+	person, err := s.store.GetPerson(personID)
+	if err != nil {
+		return err
+	}
+	apt, err := s.store.GetApartment(apartmentID)
+	if err != nil {
+		return err
+	}
+	links, err := s.store.ApartmentLinks(apartmentID)
+	if err != nil {
+		return err
+	}
+
+	switch role {
+	case store.LinkOwner, store.LinkResident:
+		name := apt.Owner
+		if role == store.LinkResident {
+			name = apt.Resident
+		}
+		if NameKey(name) != NameKey(person.Name) {
+			return fmt.Errorf("the %s of %s is %s; change it in the Apartment Manager first", role, apt.ID, name)
+		}
+		if role == store.LinkResident {
+			return s.store.SetApartmentLinks(apartmentID, role, []int{personID})
+		}
+		for _, link := range links {
+			if link.Role == store.LinkCoOwner && link.PersonID == personID {
+				return fmt.Errorf("%s is already a co-owner of %s", person.Name, apt.ID)
+			}
+		}
+		if err := s.store.SetApartmentLinks(apartmentID, role, []int{personID}); err != nil {
+			return err
+		}
+		return s.balanceShares(apartmentID)
+	case store.LinkCoOwner:
+		ids := []int{personID}
+		for _, link := range links {
+			if link.Role == store.LinkOwner && link.PersonID == personID {
+				return fmt.Errorf("%s is already the owner of %s", person.Name, apt.ID)
+			}
+			if link.Role == store.LinkCoOwner && link.PersonID != personID {
+				ids = append(ids, link.PersonID)
+			}
+		}
+		if err := s.store.SetApartmentLinks(apartmentID, role, ids); err != nil {
+			return err
+		}
+		return s.balanceShares(apartmentID)
+	}
+	return fmt.Errorf("unknown role %q", role)
+}
+
+// UnlinkCoOwner removes a person from the co-owners of an apartment. Owners
+// and residents change with the apartment instead. The co-owner's share
+// goes to the owner.
+func (s *Service) UnlinkCoOwner(personID int, apartmentID string) error {
+	links, err := s.store.ApartmentLinks(apartmentID)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	found := false
+	for _, link := range links {
+		if link.Role != store.LinkCoOwner {
+			continue
+		}
+		if link.PersonID == personID {
+			found = true
+			continue
+		}
+		ids = append(ids, link.PersonID)
+	}
+	if !found {
+		return fmt.Errorf("they are not a co-owner of %s", apartmentID)
+	}
+	if err := s.store.SetApartmentLinks(apartmentID, store.LinkCoOwner, ids); err != nil {
+		return err
+	}
+	return s.balanceShares(apartmentID)
+}
+
+// linkOccupants links the owner and resident of saved apartments to the
+// person of the same name, adding anyone not yet in the directory. Only
+// apartments whose owner or resident changed are relinked, so links set by
+// hand are kept.
+func (s *Service) linkOccupants(changes []ApartmentChange) error {
+	people, err := s.store.SearchPeople("")
+	if err != nil {
+		return err
+	}
+	byName := map[string]int{}
+	for _, p := range people {
+		if _, ok := byName[NameKey(p.Name)]; !ok {
+			byName[NameKey(p.Name)] = p.ID
+		}
+	}
+
+	for _, change := range changes {
+		for _, role := range []store.LinkRole{store.LinkOwner, store.LinkResident} {
+			name := change.After.Owner
+			if role == store.LinkResident {
+				name = change.After.Resident
+			}
+			if change.Before != nil {
+				before := change.Before.Owner
+				if role == store.LinkResident {
+					before = change.Before.Resident
+				}
+				if NameKey(before) == NameKey(name) {
+					continue
+				}
+			}
+
+			var ids []int
+			if key := NameKey(name); key != "" && name != Vacant {
+				id, ok := byName[key]
+				if !ok {
+					person, err := s.store.SavePerson(store.Person{Name: strings.Join(strings.Fields(name), " ")})
+					if err != nil {
+						return err
+					}
+					id = person.ID
+					byName[key] = id
+				}
+				ids = []int{id}
+			}
+			if err := s.store.SetApartmentLinks(change.After.ID, role, ids); err != nil {
+				return err
+			}
+			if role == store.LinkOwner {
+				if err := s.balanceShares(change.After.ID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// personImportColumns maps the header of a people file to PersonColumns
+// indexes. Files without a Name column are read in PersonColumns order.
+func personImportColumns(header []string) map[int]int {
+	key := func(name string) string {
+		return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(strings.ToLower(strings.TrimSpace(name)))
+	}
+	known := map[string]int{}
+	for i, name := range PersonColumns {
+		known[key(name)] = i
+	}
+	// Alternative names seen in society registers
+	known["mobile"] = 1
+	known["phonenumber"] = 1
+	known["emailaddress"] = 2
+	known["alternatephone"] = 3
+	known["idproofreference"] = 4
+
+	columns := map[int]int{}
+	hasName := false
+	for i, name := range header {
+		if column, ok := known[key(name)]; ok {
+			columns[i] = column
+			hasName = hasName || column == 0
+		}
+	}
+	if !hasName {
+		return map[int]int{0: 0, 1: 1, 2: 2, 3: 3, 4: 4}
+	}
+	return columns
+}
+
+// ImportPeople adds the rows of a people file to the directory. The header
+// row names the columns, as in PersonColumns. A row for someone already in
+// the directory, or earlier in the file, by email, phone or name, updates
+// them instead of adding a duplicate; blank cells keep their details.
+// Either every row is saved or none is.
+func (s *Service) ImportPeople(records [][]string) ([]PersonChange, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	columns := personImportColumns(records[0])
+
+	existing, err := s.store.SearchPeople("")
+	if err != nil {
+		return nil, err
+	}
+	people := append([]store.Person(nil), existing...)
+	changed := map[int]bool{}
+
+	for i, record := range records[1:] {
+		row := i + 2
+		var fields [5]string
+		for col, value := range record {
+			if column, ok := columns[col]; ok {
+				fields[column] = strings.TrimSpace(value)
+			}
+		}
+		if strings.Join(fields[:], "") == "" {
+			continue
+		}
+		candidate, err := normalisePerson(store.Person{
+			Name: fields[0], Phone: fields[1], Email: fields[2], AlternateContact: fields[3], IDProof: fields[4],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+
+		match := -1
+		for _, reason := range []string{"same email", "same phone", "same name"} {
+			for j, p := range people {
+				if sameAs(candidate, p) == reason {
+					match = j
+					break
+				}
+			}
+			if match >= 0 {
+				break
+			}
+		}
+		if match < 0 {
+			people = append(people, candidate)
+			changed[len(people)-1] = true
+			continue
+		}
+
+		// The file's details replace the directory's, but blank cells do not
+		p := &people[match]
+		fill := func(to *string, value string) {
+			if value != "" && *to != value {
+				*to = value
+				changed[match] = true
+			}
+		}
+		if phoneKey(p.Phone) != phoneKey(candidate.Phone) {
+			fill(&p.Phone, candidate.Phone)
+		}
+		fill(&p.Email, candidate.Email)
+		fill(&p.AlternateContact, candidate.AlternateContact)
+		fill(&p.IDProof, candidate.IDProof)
+	}
+
+	var toSave []store.Person
+	var befores []*store.Person
+	for i, p := range people {
+		if !changed[i] {
+			continue
+		}
+		var before *store.Person
+		if i < len(existing) {
+			before = &existing[i]
+		}
+		toSave = append(toSave, p)
+		befores = append(befores, before)
+	}
+	if len(toSave) == 0 {
+		return nil, nil
+	}
+	saved, err := s.store.SavePeople(toSave...)
+	if errors.Is(err, store.ErrConflict) {
+		return nil, fmt.Errorf("the directory was changed by someone else during the import; import again: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]PersonChange, len(saved))
+	for i, p := range saved {
+		changes[i] = PersonChange{Before: befores[i], After: p}
+	}
+	return changes, nil
+}
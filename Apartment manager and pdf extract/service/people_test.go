@@ -0,0 +1,72 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"apartment_login/store"
+)
+
+func TestNormalisePerson(t *testing.T) {
+	tests := []struct {
+		name    string
+		person  store.Person
+		want    store.Person
+		wantErr string
+	}{
+		{
+			name:   "tidied",
+			person: store.Person{Name: "  Asha   Rao ", Phone: " +91 98450 12345 ", Email: " asha@example.com "},
+			want:   store.Person{Name: "Asha Rao", Phone: "+91 98450 12345", Email: "asha@example.com"},
+		},
+		{name: "name required", person: store.Person{Phone: "9845012345"}, wantErr: "name is required"},
+		{name: "vacant", person: store.Person{Name: "vacant"}, wantErr: "not a person's name"},
+		{name: "letters in phone", person: store.Person{Name: "Asha", Phone: "98450 ASHA"}, wantErr: "invalid phone"},
+		{name: "short phone", person: store.Person{Name: "Asha", Phone: "12345"}, wantErr: "invalid phone"},
+		{name: "bad email", person: store.Person{Name: "Asha", Email: "asha at example"}, wantErr: "invalid email"},
+		{name: "named email", person: store.Person{Name: "Asha", Email: "Asha <asha@example.com>"},
+			wantErr: "invalid email"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalisePerson(tt.person)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameAs(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b store.Person
+		want string
+	}{
+		{"email ignores case", store.Person{Name: "A", Email: "asha@example.com"},
+			store.Person{Name: "B", Email: "Asha@Example.com"}, "same email"},
+		{"phone with country code", store.Person{Name: "A", Phone: "+91 98450 12345"},
+			store.Person{Name: "B", Phone: "098450-12345"}, "same phone"},
+		{"name with nothing else", store.Person{Name: "Asha  Rao"}, store.Person{Name: "asha rao"}, "same name"},
+		{"name, one phone", store.Person{Name: "Asha", Phone: "9845012345"}, store.Person{Name: "asha"}, "same name"},
+		{"name, different phones", store.Person{Name: "Asha", Phone: "9845012345"},
+			store.Person{Name: "Asha", Phone: "9845099999"}, ""},
+		{"name, different emails", store.Person{Name: "Asha", Email: "a@example.com"},
+			store.Person{Name: "Asha", Email: "b@example.com"}, ""},
+		{"nothing shared", store.Person{Name: "Asha"}, store.Person{Name: "Ravi"}, ""},
+	}
+	for _, tt := range tests {
+		if got := sameAs(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: sameAs = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+
+	"apartment_login/store"
+)
+
+// NewReceipt lays out the receipt for a collection under the letterhead of
+// its society, made out to the occupants on the day it was received
+func NewReceipt(collection store.Collection, society store.Society, occupants Occupants) *gofpdf.Fpdf {
+	// Create PDF document
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+	letterhead(pdf, society)
+
+	pdf.SetFont("Arial", "", 12)
+	pdf.Cell(40, 10, fmt.Sprintf("Receipt #: %d", collection.ReceiptNumber))
+	pdf.Ln(8)
+	pdf.Cell(40, 10, fmt.Sprintf("Date: %s", collection.Date))
+	pdf.Ln(8)
+	pdf.Cell(40, 10, fmt.Sprintf("Apartment: %s", collection.ApartmentID))
+	pdf.Ln(8)
+	switch {
+	case len(occupants.CoOwners) > 0:
+		pdf.Cell(40, 10, fmt.Sprintf("Owners: %s", occupants.Owners()))
+		pdf.Ln(8)
+	case occupants.Owner != "":
+		pdf.Cell(40, 10, fmt.Sprintf("Owner: %s", occupants.Owner))
+		pdf.Ln(8)
+	}
+	if occupants.SeparateResident() {
+		pdf.Cell(40, 10, fmt.Sprintf("Resident: %s", occupants.Resident))
+		pdf.Ln(8)
+	}
+	pdf.Cell(40, 10, fmt.Sprintf("Month: %s", collection.Month))
+	pdf.Ln(8)
+	pdf.Cell(40, 10, fmt.Sprintf("Type: %s", collection.Type))
+	pdf.Ln(8)
+	pdf.Cell(40, 10, fmt.Sprintf("Amount: %s", collection.Price))
+
+	return pdf
+}
+
+// letterhead writes the society's name, address and registration number at
+// the top of a page
+func letterhead(pdf *gofpdf.Fpdf, society store.Society) {
+	title := "Apartment Management System"
+	if society.Name != "" {
+		title = society.Name
+	}
+
+	pdf.SetFont("Arial", "B", 16)
+	pdf.Cell(40, 10, title)
+	pdf.Ln(8)
+
+	pdf.SetFont("Arial", "", 10)
+	if society.Address != "" {
+		pdf.Cell(40, 6, society.Address)
+		pdf.Ln(6)
+	}
+	if society.RegistrationNumber != "" {
+		pdf.Cell(40, 6, fmt.Sprintf("Reg. No.: %s", society.RegistrationNumber))
+		pdf.Ln(6)
+	}
+	pdf.Ln(7)
+}
+
+// WriteReceipt renders the receipt for a collection to w
+func WriteReceipt(w io.Writer, collection store.Collection, society store.Society, occupants Occupants) error {
+	if err := NewReceipt(collection, society, occupants).Output(w); err != nil {
+		return fmt.Errorf("failed to write PDF: %w", err)
+	}
+	return nil
+}
+
+// ReceiptFileName is the file a collection's receipt is saved as. Characters
+// of the apartment ID that cannot appear in a file name, such as the slash
+// in A/101, are written as dashes.
+func ReceiptFileName(collection store.Collection) string {
+	apartmentID := strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '-'
+		}
+		return r
+	}, collection.ApartmentID)
+	return fmt.Sprintf("receipt_%d_%s.pdf", collection.ID, apartmentID)
+}
+
+// SaveReceipt writes the receipt for a collection into dir, creating it if
+// needed, and returns the file's path
+func SaveReceipt(dir string, collection store.Collection, society store.Society, occupants Occupants) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	filename := filepath.Join(dir, ReceiptFileName(collection))
+	if err := NewReceipt(collection, society, occupants).OutputFileAndClose(filename); err != nil {
+		return "", fmt.Errorf("failed to save PDF file: %w", err)
+	}
+	return filename, nil
+}
+
+// NewOwnerStatement lays out one owner's part of an apartment's collections
+// for a year under the letterhead of its society
+func NewOwnerStatement(statement OwnerStatement, society store.Society) *gofpdf.Fpdf {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddPage()
+	letterhead(pdf, society)
+
+	pdf.SetFont("Arial", "B", 12)
+	pdf.Cell(40, 10, fmt.Sprintf("Annual Statement %d", statement.Year))
+	pdf.Ln(8)
+	pdf.SetFont("Arial", "", 12)
+	pdf.Cell(40, 10, fmt.Sprintf("Apartment: %s", statement.ApartmentID))
+	pdf.Ln(8)
+	pdf.Cell(40, 10, fmt.Sprintf("Owner: %s (%s share)", statement.Owner.PersonName, FormatShare(statement.Owner.Share)))
+	pdf.Ln(12)
+
+	pdf.SetFont("Arial", "B", 10)
+	for _, header := range []string{"Receipt #", "Date", "Month", "Type", "Received", "Owner's Part"} {
+		pdf.CellFormat(30, 7, header, "1", 0, "", false, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Arial", "", 10)
+	for i, line := range statement.Lines {
+		c := line.Collection
+		for _, cell := range []string{
+			fmt.Sprintf("%d", c.ReceiptNumber), c.Date, c.Month, c.Type, c.Price.String(), statement.Amounts[i].String(),
+		} {
+			pdf.CellFormat(30, 7, cell, "1", 0, "", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	pdf.Ln(4)
+	pdf.SetFont("Arial", "B", 12)
+	pdf.Cell(40, 10, fmt.Sprintf("Total: %s", statement.Total))
+	return pdf
+}
+
+// OwnerStatementFileName is the file an owner's annual statement is saved as
+func OwnerStatementFileName(statement OwnerStatement) string {
+	return fmt.Sprintf("statement_%d_%s_%d.pdf", statement.Year, statement.ApartmentID, statement.Owner.PersonID)
+}
+
+// SaveOwnerStatement writes an owner's annual statement into dir, creating it
+// if needed, and returns the file's path
+func SaveOwnerStatement(dir string, statement OwnerStatement, society store.Society) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	filename := filepath.Join(dir, OwnerStatementFileName(statement))
+	if err := NewOwnerStatement(statement, society).OutputFileAndClose(filename); err != nil {
+		return "", fmt.Errorf("failed to save PDF file: %w", err)
+	}
+	return filename, nil
+}
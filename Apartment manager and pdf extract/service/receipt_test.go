@@ -0,0 +1,57 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"apartment_login/store"
+)
+
+func TestReceiptFileName(t *testing.T) {
+	tests := []struct {
+		apartmentID string
+		want        string
+	}{
+		{"A-101", "receipt_7_A-101.pdf"},
+		{"A/101", "receipt_7_A-101.pdf"},
+		{`..\A:101`, "receipt_7_..-A-101.pdf"},
+	}
+	for _, tt := range tests {
+		if got := ReceiptFileName(store.Collection{ID: 7, ApartmentID: tt.apartmentID}); got != tt.want {
+			t.Errorf("ReceiptFileName(%q) = %q, want %q", tt.apartmentID, got, tt.want)
+		}
+	}
+}
+
+func TestSaveReceipt(t *testing.T) {
+	collection := store.Collection{ID: 7, ApartmentID: "A-101", Month: "April",
+		Type: MaintenanceType, Price: 400000, Date: "2024-04-05"}
+	society := store.Society{Name: "Green Acres", Address: "12 MG Road", RegistrationNumber: "PNA/1234"}
+	occupants := Occupants{Owner: "Asha", Resident: "Ravi"}
+
+	dir := filepath.Join(t.TempDir(), "receipts")
+	path, err := SaveReceipt(dir, collection, society, occupants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "receipt_7_A-101.pdf"); path != want {
+		t.Errorf("saved to %q, want %q", path, want)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		t.Errorf("receipt does not start with a PDF header: %q", data[:min(len(data), 8)])
+	}
+
+	var buf bytes.Buffer
+	if err := WriteReceipt(&buf, collection, society, occupants); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")) {
+		t.Error("WriteReceipt did not write a PDF")
+	}
+}
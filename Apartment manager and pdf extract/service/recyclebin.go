@@ -0,0 +1,90 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"apartment_login/store"
+)
+
+// Restore returns a row from the recycle bin to normal use. A collection can
+// only come back once its apartment has.
+func (s *Service) Restore(kind store.BinKind, id string) (store.BinEntry, error) {
+	entry, err := s.store.DeletedEntry(kind, id)
+	if err != nil {
+		return entry, err
+	}
+
+	if kind == store.BinCollections {
+		if _, err := s.store.GetApartment(entry.ApartmentID); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				return entry, fmt.Errorf("restore apartment %s before its collections", entry.ApartmentID)
+			}
+			return entry, err
+		}
+	}
+	return entry, s.store.Restore(kind, id)
+}
+
+// PurgeableAt is when an entry's retention period ends
+func PurgeableAt(entry store.BinEntry, retention time.Duration) time.Time {
+	return entry.DeletedAt.Add(retention)
+}
+
+// Purge permanently removes a row that has been in the recycle bin for at
+// least the retention period. An apartment cannot be purged while any
+// collection, including one in the recycle bin, still refers to it.
+func (s *Service) Purge(kind store.BinKind, id string, now time.Time, retention time.Duration) (store.BinEntry, error) {
+	entry, err := s.store.DeletedEntry(kind, id)
+	if err != nil {
+		return entry, err
+	}
+
+	if at := PurgeableAt(entry, retention); now.Before(at) {
+		return entry, fmt.Errorf("%s %s can be purged from %s", kind, id, at.Format("2006-01-02 15:04"))
+	}
+	if kind == store.BinApartments {
+		count, err := s.store.CountCollections(id)
+		if err != nil {
+			return entry, err
+		}
+		if count > 0 {
+			return entry, fmt.Errorf("apartment %s has %d collection(s) on record and cannot be purged", id, count)
+		}
+	}
+	return entry, s.store.Purge(kind, id)
+}
+
+// PurgeExpired purges every entry whose retention period has ended and
+// returns the ones removed. Collections go before apartments so an apartment
+// whose collections were all deleted can go in the same pass; apartments
+// still referred to are left in the recycle bin.
+func (s *Service) PurgeExpired(now time.Time, retention time.Duration) ([]store.BinEntry, error) {
+	var purged []store.BinEntry
+	for _, kind := range []store.BinKind{store.BinCollections, store.BinPayments, store.BinUsers, store.BinApartments} {
+		entries, err := s.store.Deleted(kind)
+		if err != nil {
+			return purged, err
+		}
+		for _, entry := range entries {
+			if now.Before(PurgeableAt(entry, retention)) {
+				continue
+			}
+			if kind == store.BinApartments {
+				count, err := s.store.CountCollections(entry.ID)
+				if err != nil {
+					return purged, err
+				}
+				if count > 0 {
+					continue
+				}
+			}
+			if err := s.store.Purge(kind, entry.ID); err != nil {
+				return purged, err
+			}
+			purged = append(purged, entry)
+		}
+	}
+	return purged, nil
+}
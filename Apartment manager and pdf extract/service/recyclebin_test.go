@@ -0,0 +1,174 @@
+package service_test
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"apartment_login/service"
+	"apartment_login/store"
+)
+
+func TestRestore(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha"})
+		c := mustRecordCollection(t, svc, "101", "January")
+		collectionID := strconv.Itoa(c.ID)
+		if _, err := svc.DeleteCollection(c.ID, "test"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := svc.DeleteApartment("101", "test"); err != nil {
+			t.Fatal(err)
+		}
+
+		steps := []struct {
+			name    string
+			kind    store.BinKind
+			id      string
+			wantErr string
+		}{
+			{"collection before its apartment", store.BinCollections, collectionID, "restore apartment 101"},
+			{"apartment", store.BinApartments, "101", ""},
+			{"collection", store.BinCollections, collectionID, ""},
+			{"not in the bin", store.BinCollections, collectionID, store.ErrNotFound.Error()},
+		}
+		for _, step := range steps {
+			entry, err := svc.Restore(step.kind, step.id)
+			if step.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), step.wantErr) {
+					t.Fatalf("%s: got error %v, want %q", step.name, err, step.wantErr)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+			if entry.ID != step.id || entry.DeletedBy != "test" {
+				t.Errorf("%s: restored %+v", step.name, entry)
+			}
+		}
+
+		if _, err := svc.Store().GetCollection(c.ID); err != nil {
+			t.Errorf("restored collection: %v", err)
+		}
+		if _, err := svc.Store().GetApartment("101"); err != nil {
+			t.Errorf("restored apartment: %v", err)
+		}
+	})
+}
+
+func TestPurge(t *testing.T) {
+	const retention = 30 * 24 * time.Hour
+	later := time.Now().Add(retention + time.Hour)
+
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha"})
+		mustSaveApartment(t, svc, store.Apartment{ID: "102", Owner: "Ravi"})
+		mustRecordCollection(t, svc, "101", "January")
+		p, err := svc.RecordPayment("January", "Security", 1500000, "Expense")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := svc.DeleteApartment("101", "test"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := svc.DeleteApartment("102", "test"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := svc.DeletePayment(p.ID, "test"); err != nil {
+			t.Fatal(err)
+		}
+
+		steps := []struct {
+			name    string
+			kind    store.BinKind
+			id      string
+			now     time.Time
+			wantErr string
+		}{
+			{"within retention", store.BinPayments, strconv.Itoa(p.ID), time.Now(), "can be purged from"},
+			{"apartment with collections", store.BinApartments, "101", later, "1 collection(s) on record"},
+			{"payment", store.BinPayments, strconv.Itoa(p.ID), later, ""},
+			{"apartment without collections", store.BinApartments, "102", later, ""},
+			{"already purged", store.BinApartments, "102", later, store.ErrNotFound.Error()},
+		}
+		for _, step := range steps {
+			_, err := svc.Purge(step.kind, step.id, step.now, retention)
+			if step.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), step.wantErr) {
+					t.Fatalf("%s: got error %v, want %q", step.name, err, step.wantErr)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+		}
+
+		if _, err := svc.Store().DeletedEntry(store.BinApartments, "101"); err != nil {
+			t.Errorf("apartment 101 left the recycle bin: %v", err)
+		}
+		if count, err := svc.Store().CountCollections("101"); err != nil || count != 1 {
+			t.Errorf("apartment 101 has %d collection(s), %v; want 1", count, err)
+		}
+	})
+}
+
+func TestPurgeExpired(t *testing.T) {
+	const retention = 30 * 24 * time.Hour
+
+	tests := []struct {
+		name string
+		now  time.Time
+		// want lists the purged entries as kind/id, in purge order
+		want []string
+	}{
+		{name: "nothing due", now: time.Now()},
+		{name: "collections before apartments", now: time.Now().Add(retention + time.Hour),
+			want: []string{"collections/1", "payments/1", "apartments/101"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, svc *service.Service) {
+				mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha"})
+				mustSaveApartment(t, svc, store.Apartment{ID: "102", Owner: "Ravi"})
+				c := mustRecordCollection(t, svc, "101", "January")
+				mustRecordCollection(t, svc, "102", "January")
+				p, err := svc.RecordPayment("January", "Security", 1500000, "Expense")
+				if err != nil {
+					t.Fatal(err)
+				}
+				deletions := []func() error{
+					func() error { _, err := svc.DeleteCollection(c.ID, "test"); return err },
+					func() error { _, err := svc.DeletePayment(p.ID, "test"); return err },
+					func() error { _, err := svc.DeleteApartment("101", "test"); return err },
+					// 102 still has a collection, so it stays in the bin
+					func() error { _, err := svc.DeleteApartment("102", "test"); return err },
+				}
+				for _, deletion := range deletions {
+					if err := deletion(); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				purged, err := svc.PurgeExpired(tt.now, retention)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, entry := range purged {
+					got = append(got, string(entry.Kind)+"/"+entry.ID)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("purged %v, want %v", got, tt.want)
+				}
+				if _, err := svc.Store().DeletedEntry(store.BinApartments, "102"); err != nil {
+					t.Errorf("apartment 102 left the recycle bin: %v", err)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,416 @@
+// Package service holds the society's business rules. It works against the
+// store interfaces and has no knowledge of the UI, the signed-in user or the
+// audit log; callers check permissions and record audit entries.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"apartment_login/money"
+	"apartment_login/store"
+)
+
+// MonthNames are the values stored in collections.month and payments.month
+var MonthNames = []string{
+	"January", "February", "March", "April", "May", "June",
+	"July", "August", "September", "October", "November", "December",
+}
+
+// Vacant is recorded as the resident of an apartment nobody lives in
+const Vacant = "Vacant"
+
+// MaintenanceType is the collection type billed every month
+const MaintenanceType = "Maintenance"
+
+// Service applies the business rules on top of a store
+type Service struct {
+	store store.Store
+}
+
+// New returns a service backed by s
+func New(s store.Store) *Service {
+	return &Service{store: s}
+}
+
+// Store returns the underlying store for plain reads
+func (s *Service) Store() store.Store {
+	return s.store
+}
+
+// ApartmentChange pairs an apartment with its previous values; Before is nil
+// for a newly created apartment
+type ApartmentChange struct {
+	Before *store.Apartment
+	After  store.Apartment
+}
+
+// Due is a month of maintenance not yet collected for an apartment
+type Due struct {
+	ApartmentID string
+	Month       string
+	Amount      money.Money
+}
+
+// UpdateSameFlag marks an apartment whose owner also lives in it
+func UpdateSameFlag(apt *store.Apartment) {
+	apt.SameFlag = apt.Owner != "" && apt.Owner == apt.Resident
+}
+
+// normaliseApartment validates an apartment and fills in the derived fields
+func normaliseApartment(apt store.Apartment) (store.Apartment, error) {
+	apt.ID = strings.TrimSpace(apt.ID)
+	if apt.ID == "" {
+		return apt, errors.New("apartment ID is required")
+	}
+	if apt.Resident == "" {
+		apt.Resident = Vacant
+	}
+	UpdateSameFlag(&apt)
+	if err := normaliseDetails(&apt); err != nil {
+		return apt, fmt.Errorf("apartment %s: %w", apt.ID, err)
+	}
+	return apt, nil
+}
+
+// previousApartment returns the stored apartment, or nil if there is none.
+// An apartment in the recycle bin has to be restored rather than replaced.
+func (s *Service) previousApartment(id string) (*store.Apartment, error) {
+	if _, err := s.store.DeletedEntry(store.BinApartments, id); err == nil {
+		return nil, fmt.Errorf("apartment %s is in the recycle bin; restore it instead", id)
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	existing, err := s.store.GetApartment(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// SaveApartment creates an apartment with Version 0 or updates one read at
+// apt.Version. A new apartment's ID is normalised to the society's pattern
+// and may not look like an existing one. A changed owner or resident
+// starts a new occupancy period on their since date, today unless it was
+// changed, and is linked to the person of that name in the directory. If
+// someone else saved or deleted it since, a *ConflictError describes the
+// difference.
+func (s *Service) SaveApartment(apt store.Apartment) (ApartmentChange, error) {
+	id, err := s.apartmentID(apt.ID)
+	if err != nil {
+		return ApartmentChange{}, err
+	}
+	apt.ID = id
+	apt, err = normaliseApartment(apt)
+	if err != nil {
+		return ApartmentChange{}, err
+	}
+	apt.SocietyID = s.store.SocietyID()
+	before, err := s.previousApartment(apt.ID)
+	if err != nil {
+		return ApartmentChange{}, err
+	}
+	if before == nil {
+		if err := s.checkLookalikes(apt.ID); err != nil {
+			return ApartmentChange{}, err
+		}
+	}
+	if err := s.checkOccupancy(&apt, before); err != nil {
+		return ApartmentChange{}, err
+	}
+	if err := s.checkParkingSlots(apt); err != nil {
+		return ApartmentChange{}, err
+	}
+	if err := s.store.SaveApartments(apt); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return ApartmentChange{}, s.apartmentConflict(apt)
+		}
+		return ApartmentChange{}, err
+	}
+	apt.Version++
+	change := ApartmentChange{Before: before, After: apt}
+	return change, s.linkOccupants([]ApartmentChange{change})
+}
+
+// ImportApartments saves imported rows. The header row names the columns,
+// as in ApartmentColumns; a file without an ID column is read as ID, Owner
+// and Resident. IDs are normalised as in SaveApartment. Existing apartments
+// are updated, keeping the fields the file has no column for; a changed
+// owner or resident takes over on the day of the import. An apartment may
+// only appear on one row. The same name on several rows is linked to one
+// person in the directory. Either every row is saved or none is.
+func (s *Service) ImportApartments(records [][]string) ([]ApartmentChange, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	columns := importColumns(records[0])
+
+	var changes []ApartmentChange
+	var created []string
+	// rows maps each normalised ID to the row it is on, so that a file
+	// listing one apartment twice fails here rather than as a conflict
+	rows := map[string]int{}
+	for i, record := range records[1:] {
+		row := i + 2
+		var apt store.Apartment
+		if err := applyImportedRow(&apt, record, columns); err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		id, err := s.apartmentID(apt.ID)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		if first, ok := rows[id]; ok {
+			return nil, fmt.Errorf("row %d repeats apartment %s from row %d", row, id, first)
+		}
+		rows[id] = row
+		before, err := s.previousApartment(id)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		if before != nil {
+			apt = *before
+			if err := applyImportedRow(&apt, record, columns); err != nil {
+				return nil, fmt.Errorf("row %d: %w", row, err)
+			}
+		} else {
+			created = append(created, id)
+		}
+		apt.ID = id
+
+		apt, err = normaliseApartment(apt)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		apt.SocietyID = s.store.SocietyID()
+		if err := s.checkOccupancy(&apt, before); err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		changes = append(changes, ApartmentChange{Before: before, After: apt})
+	}
+
+	if err := s.checkLookalikes(created...); err != nil {
+		return nil, err
+	}
+
+	apartments := make([]store.Apartment, len(changes))
+	for i, c := range changes {
+		apartments[i] = c.After
+	}
+	if err := s.checkParkingSlots(apartments...); err != nil {
+		return nil, err
+	}
+	if err := s.store.SaveApartments(apartments...); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return nil, fmt.Errorf("apartments were changed by someone else during the import; import again: %w", err)
+		}
+		return nil, err
+	}
+	for i := range changes {
+		changes[i].After.Version++
+	}
+	return changes, s.linkOccupants(changes)
+}
+
+// DeleteApartment moves an apartment to the recycle bin. Its collections
+// keep pointing at it, so receipts stay valid while it is there.
+func (s *Service) DeleteApartment(id, deletedBy string) (store.Apartment, error) {
+	before, err := s.store.GetApartment(id)
+	if err != nil {
+		return before, err
+	}
+	return before, s.store.MoveToBin(store.BinApartments, id, deletedBy)
+}
+
+// RecordCollection stores money received for an apartment
+func (s *Service) RecordCollection(apartmentID, month, collectionType string, price money.Money) (store.Collection, error) {
+	if apartmentID == "" || month == "" || collectionType == "" {
+		return store.Collection{}, errors.New("all fields are required")
+	}
+	if err := money.Validate(price); err != nil {
+		return store.Collection{}, err
+	}
+	if _, err := s.store.GetApartment(apartmentID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return store.Collection{}, fmt.Errorf("unknown apartment: %s", apartmentID)
+		}
+		return store.Collection{}, err
+	}
+
+	return s.store.AddCollection(store.Collection{
+		ApartmentID: apartmentID,
+		Month:       month,
+		Type:        collectionType,
+		Price:       price,
+	})
+}
+
+// RecordPayment stores a society income or expense entry
+func (s *Service) RecordPayment(month, paymentType string, price money.Money, transactionType string) (store.Payment, error) {
+	if month == "" || paymentType == "" || transactionType == "" {
+		return store.Payment{}, errors.New("all fields are required")
+	}
+	if err := money.Validate(price); err != nil {
+		return store.Payment{}, err
+	}
+
+	return s.store.AddPayment(store.Payment{
+		Month:           month,
+		Type:            paymentType,
+		Price:           price,
+		TransactionType: transactionType,
+	})
+}
+
+// DeleteCollection moves a collection to the recycle bin; it no longer counts
+// towards the apartment's dues
+func (s *Service) DeleteCollection(id int, deletedBy string) (store.Collection, error) {
+	before, err := s.store.GetCollection(id)
+	if err != nil {
+		return before, err
+	}
+	return before, s.store.MoveToBin(store.BinCollections, strconv.Itoa(id), deletedBy)
+}
+
+// DeletePayment moves a payment to the recycle bin
+func (s *Service) DeletePayment(id int, deletedBy string) (store.Payment, error) {
+	before, err := s.store.GetPayment(id)
+	if err != nil {
+		return before, err
+	}
+	return before, s.store.MoveToBin(store.BinPayments, strconv.Itoa(id), deletedBy)
+}
+
+// OutstandingDues lists the months of now's year, up to and including the
+// current month, with no maintenance collection recorded
+func (s *Service) OutstandingDues(apartmentID string, now time.Time, amount money.Money) ([]Due, error) {
+	paid, err := s.store.CollectedMonths(apartmentID, now.Year(), MaintenanceType)
+	if err != nil {
+		return nil, err
+	}
+
+	var dues []Due
+	for _, month := range MonthNames[:now.Month()] {
+		if !paid[month] {
+			dues = append(dues, Due{ApartmentID: apartmentID, Month: month, Amount: amount})
+		}
+	}
+	return dues, nil
+}
+
+// DeleteUser moves an account to the recycle bin, which stops it signing in;
+// nobody can delete their own
+func (s *Service) DeleteUser(id, actingUserID int, deletedBy string) (store.User, error) {
+	if id == actingUserID {
+		return store.User{}, errors.New("you cannot delete your own account")
+	}
+	before, err := s.store.GetUser(id)
+	if err != nil {
+		return before, err
+	}
+	return before, s.store.MoveToBin(store.BinUsers, strconv.Itoa(id), deletedBy)
+}
+
+// UpdateUser saves the directory details of a user read at user.Version and
+// returns the previous ones. If someone else saved or deleted the user
+// since, a *ConflictError describes the difference.
+func (s *Service) UpdateUser(user store.User) (store.User, error) {
+	before, err := s.store.GetUser(user.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		return before, s.userConflict(user)
+	}
+	if err != nil {
+		return before, err
+	}
+	if err := s.store.UpdateUser(user); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return before, s.userConflict(user)
+		}
+		return before, err
+	}
+	return before, nil
+}
+
+// SetUserDisabled disables or re-enables an account without deleting its
+// history; nobody can disable their own
+func (s *Service) SetUserDisabled(id, actingUserID int, disabled bool) (store.User, error) {
+	if id == actingUserID && disabled {
+		return store.User{}, errors.New("you cannot disable your own account")
+	}
+	before, err := s.store.GetUser(id)
+	if err != nil {
+		return before, err
+	}
+	return before, s.store.SetUserDisabled(id, disabled)
+}
+
+// SetUserApartments replaces the apartments linked to a user and returns the
+// previous list. Every apartment must exist.
+func (s *Service) SetUserApartments(userID int, apartmentIDs []string) ([]string, error) {
+	for _, id := range apartmentIDs {
+		if _, err := s.store.GetApartment(id); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				return nil, fmt.Errorf("unknown apartment: %s", id)
+			}
+			return nil, err
+		}
+	}
+
+	before, err := s.store.UserApartments(userID)
+	if err != nil {
+		return nil, err
+	}
+	return before, s.store.SetUserApartments(userID, apartmentIDs)
+}
+
+// SaveSociety creates or updates a society; its name is required and must
+// not be used by another society
+func (s *Service) SaveSociety(society store.Society) (store.Society, error) {
+	society.Name = strings.TrimSpace(society.Name)
+	society.Address = strings.TrimSpace(society.Address)
+	society.RegistrationNumber = strings.TrimSpace(society.RegistrationNumber)
+	society.IDPattern = strings.ToUpper(strings.TrimSpace(society.IDPattern))
+	if society.Name == "" {
+		return society, errors.New("society name is required")
+	}
+	if err := ValidateIDPattern(society.IDPattern); err != nil {
+		return society, err
+	}
+
+	societies, err := s.store.ListSocieties()
+	if err != nil {
+		return society, err
+	}
+	for _, existing := range societies {
+		if existing.ID != society.ID && strings.EqualFold(existing.Name, society.Name) {
+			return society, fmt.Errorf("a society named %s already exists", existing.Name)
+		}
+	}
+	return s.store.SaveSociety(society)
+}
+
+// SetUserSocieties replaces the societies a user may work in and returns the
+// previous list. Every society must exist.
+func (s *Service) SetUserSocieties(userID int, societyIDs []int) ([]int, error) {
+	for _, id := range societyIDs {
+		if _, err := s.store.GetSociety(id); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				return nil, fmt.Errorf("unknown society: %d", id)
+			}
+			return nil, err
+		}
+	}
+
+	before, err := s.store.UserSocieties(userID)
+	if err != nil {
+		return nil, err
+	}
+	return before, s.store.SetUserSocieties(userID, societyIDs)
+}
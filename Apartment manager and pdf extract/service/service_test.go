@@ -0,0 +1,32 @@
+package service
+
+import (
+	"testing"
+
+	"apartment_login/store"
+)
+
+func TestUpdateSameFlag(t *testing.T) {
+	tests := []struct {
+		name     string
+		owner    string
+		resident string
+		want     bool
+	}{
+		{"owner lives in it", "Asha", "Asha", true},
+		{"let out", "Asha", "Ravi", false},
+		{"vacant", "Asha", Vacant, false},
+		{"names differ in case", "Asha", "asha", false},
+		{"no owner", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A stale flag is corrected both ways
+			apt := store.Apartment{Owner: tt.owner, Resident: tt.resident, SameFlag: !tt.want}
+			UpdateSameFlag(&apt)
+			if apt.SameFlag != tt.want {
+				t.Errorf("SameFlag = %v, want %v", apt.SameFlag, tt.want)
+			}
+		})
+	}
+}
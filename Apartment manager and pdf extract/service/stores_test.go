@@ -0,0 +1,1169 @@
+package service_test
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"apartment_login/money"
+	"apartment_login/service"
+	"apartment_login/store"
+)
+
+// The service rules are tested against both stores so the in-memory fake
+// keeps behaving like SQLite.
+
+// testStores open an empty store
+var testStores = []struct {
+	name string
+	open func(t *testing.T) store.Store
+}{
+	{"memory", func(*testing.T) store.Store { return store.NewMemory() }},
+	{"sqlite", newTestSQLite},
+}
+
+// newTestSQLite opens a SQLite store over freshly migrated databases in a
+// temporary directory
+func newTestSQLite(t *testing.T) store.Store {
+	t.Helper()
+	return store.NewSQLite(newTestDatabases(t))
+}
+
+// newTestDatabases opens a freshly migrated app.db and resident.db in a
+// temporary directory
+func newTestDatabases(t *testing.T) (appDB, residentDB *sql.DB) {
+	t.Helper()
+	dir := t.TempDir()
+	open := func(name string, migrations []store.Migration) *sql.DB {
+		path := filepath.Join(dir, name)
+		db, err := store.OpenDatabase(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if err := store.Migrate(db, path, migrations); err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	return open("app.db", store.AppMigrations), open("resident.db", store.ResidentMigrations)
+}
+
+// forEachStore runs test on a new service over each of the test stores
+func forEachStore(t *testing.T, test func(t *testing.T, svc *service.Service)) {
+	for _, ts := range testStores {
+		t.Run(ts.name, func(t *testing.T) {
+			test(t, service.New(ts.open(t)))
+		})
+	}
+}
+
+// mustSaveApartment creates an apartment or fails the test
+func mustSaveApartment(t *testing.T, svc *service.Service, apt store.Apartment) store.Apartment {
+	t.Helper()
+	change, err := svc.SaveApartment(apt)
+	if err != nil {
+		t.Fatalf("saving apartment %s: %v", apt.ID, err)
+	}
+	return change.After
+}
+
+// mustRecordCollection records a maintenance collection or fails the test
+func mustRecordCollection(t *testing.T, svc *service.Service, apartmentID, month string) store.Collection {
+	t.Helper()
+	c, err := svc.RecordCollection(apartmentID, month, service.MaintenanceType, 400000)
+	if err != nil {
+		t.Fatalf("recording %s for %s: %v", month, apartmentID, err)
+	}
+	return c
+}
+
+// mustSetIDPattern sets the default society's ID pattern or fails the test
+func mustSetIDPattern(t *testing.T, svc *service.Service, pattern string) {
+	t.Helper()
+	society, err := svc.Store().GetSociety(store.DefaultSocietyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	society.IDPattern = pattern
+	if _, err := svc.SaveSociety(society); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// mustCreateUser creates an account or fails the test
+func mustCreateUser(t *testing.T, svc *service.Service, username string) store.User {
+	t.Helper()
+	user, err := svc.Store().CreateUser(store.User{Username: username, Role: "admin"}, "hash")
+	if err != nil {
+		t.Fatalf("creating user %s: %v", username, err)
+	}
+	return user
+}
+
+func TestSaveApartment(t *testing.T) {
+	tests := []struct {
+		name    string
+		apt     store.Apartment
+		want    store.Apartment
+		wantErr string
+	}{
+		{
+			name: "owner lives in it",
+			apt:  store.Apartment{ID: "101", Owner: "Asha", Resident: "Asha"},
+			want: store.Apartment{ID: "101", Owner: "Asha", Resident: "Asha", SameFlag: true},
+		},
+		{
+			name: "let out",
+			apt:  store.Apartment{ID: "102", Owner: "Asha", Resident: "Ravi"},
+			want: store.Apartment{ID: "102", Owner: "Asha", Resident: "Ravi"},
+		},
+		{
+			name: "blank resident is vacant",
+			apt:  store.Apartment{ID: " 103 ", Owner: "Asha"},
+			want: store.Apartment{ID: "103", Owner: "Asha", Resident: service.Vacant},
+		},
+		{
+			name:    "ID required",
+			apt:     store.Apartment{ID: " ", Owner: "Asha"},
+			wantErr: "apartment ID is required",
+		},
+	}
+
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				change, err := svc.SaveApartment(tt.apt)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("got error %v, want %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if change.Before != nil {
+					t.Errorf("Before = %+v, want nil for a new apartment", change.Before)
+				}
+				got, err := svc.Store().GetApartment(tt.want.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Owner != tt.want.Owner || got.Resident != tt.want.Resident || got.SameFlag != tt.want.SameFlag {
+					t.Errorf("saved %+v, want owner %q, resident %q, same %v",
+						got, tt.want.Owner, tt.want.Resident, tt.want.SameFlag)
+				}
+				if got.SocietyID != store.DefaultSocietyID {
+					t.Errorf("saved in society %d, want the default society", got.SocietyID)
+				}
+				if got.Version != 1 || change.After.Version != 1 {
+					t.Errorf("version %d, change version %d, want 1", got.Version, change.After.Version)
+				}
+			})
+		}
+
+	})
+}
+
+func TestSaveApartmentConflict(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		read := mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha", Resident: "Asha"})
+
+		mine, theirs := read, read
+		theirs.Resident = "Ravi"
+		change, err := svc.SaveApartment(theirs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if change.Before == nil || change.Before.Resident != "Asha" || change.After.Version != 2 {
+			t.Fatalf("update gave %+v", change)
+		}
+
+		mine.Owner = "Meena"
+		_, err = svc.SaveApartment(mine)
+		var conflict *service.ConflictError
+		if !errors.As(err, &conflict) || !errors.Is(err, store.ErrConflict) {
+			t.Fatalf("saving a stale copy gave %v, want a *ConflictError", err)
+		}
+		if conflict.Saved == nil {
+			t.Error("conflict does not carry the saved apartment")
+		}
+
+		got, err := svc.Store().GetApartment("101")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Owner != "Asha" || got.Resident != "Ravi" || got.Version != 2 {
+			t.Errorf("stale save changed the apartment to %+v", got)
+		}
+	})
+}
+
+func TestImportApartments(t *testing.T) {
+	tests := []struct {
+		name string
+		// pattern is the society's ID pattern
+		pattern string
+		records [][]string
+		// want maps each apartment expected afterwards to owner/resident
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "old layout",
+			records: [][]string{{"Flat", "Name", "Tenant"}, {"101", "Asha", "Asha"}, {"201", "Ravi", ""}},
+			want:    map[string]string{"101": "Asha/Asha", "102": "Meena/Vacant", "201": "Ravi/Vacant"},
+		},
+		{
+			name:    "named columns update and keep other fields",
+			records: [][]string{{"Resident", "ID"}, {"Kiran", "102"}},
+			want:    map[string]string{"102": "Meena/Kiran"},
+		},
+		{
+			name:    "bad row saves nothing",
+			records: [][]string{{"ID", "Owner", "Floor"}, {"301", "Asha", "3"}, {"302", "Ravi", "third"}},
+			want:    map[string]string{"102": "Meena/Vacant"},
+			wantErr: "row 3",
+		},
+		{
+			name:    "same ID twice",
+			records: [][]string{{"ID", "Owner"}, {"301", "Asha"}, {"301", "Ravi"}},
+			want:    map[string]string{"102": "Meena/Vacant"},
+			wantErr: "row 3 repeats apartment 301 from row 2",
+		},
+		{
+			name:    "existing apartment twice",
+			records: [][]string{{"ID", "Resident"}, {"102", "Asha"}, {"301", "Ravi"}, {"102", "Kiran"}},
+			want:    map[string]string{"102": "Meena/Vacant"},
+			wantErr: "row 4 repeats apartment 102 from row 2",
+		},
+		{
+			name:    "same flat after normalising",
+			pattern: "###",
+			records: [][]string{{"ID", "Owner"}, {"APT301", "Asha"}, {"Flat 0301", "Ravi"}},
+			want:    map[string]string{"102": "Meena/Vacant"},
+			wantErr: "row 3 repeats apartment 301 from row 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, svc *service.Service) {
+				mustSaveApartment(t, svc, store.Apartment{ID: "102", Owner: "Meena", Block: "A"})
+				if tt.pattern != "" {
+					mustSetIDPattern(t, svc, tt.pattern)
+				}
+
+				_, err := svc.ImportApartments(tt.records)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("got error %v, want %q", err, tt.wantErr)
+					}
+				} else if err != nil {
+					t.Fatal(err)
+				}
+
+				apartments, err := svc.Store().ListApartments(0, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := map[string]string{}
+				for _, apt := range apartments {
+					got[apt.ID] = apt.Owner + "/" + apt.Resident
+					if apt.ID == "102" && apt.Block != "A" {
+						t.Errorf("import cleared the block of 102")
+					}
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got apartments %v, want %v", got, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestSaveApartmentsConflictSavesNothing(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		read := mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha", Resident: "Asha"})
+		mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha", Resident: "Ravi", Version: read.Version})
+
+		fresh := store.Apartment{ID: "102", Owner: "Meena", Resident: "Meena", SameFlag: true}
+		err := svc.Store().SaveApartments(fresh, read)
+		if !errors.Is(err, store.ErrConflict) {
+			t.Fatalf("saving at a stale version gave %v, want ErrConflict", err)
+		}
+		if _, err := svc.Store().GetApartment("102"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("apartment 102 was saved despite the conflict (%v)", err)
+		}
+	})
+}
+
+func TestApartmentDetails(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		mustSaveApartment(t, svc, store.Apartment{
+			ID: "101", Owner: "Asha", Block: "A", Floor: -1, UnitType: "3bhk",
+			CarpetArea: 1250.5, SuperBuiltUpArea: 1600, ParkingSlots: []string{"P2", "P1"},
+		})
+		got, err := svc.Store().GetApartment("101")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Block != "A" || got.Floor != -1 || got.UnitType != "3BHK" || got.CarpetArea != 1250.5 ||
+			got.SuperBuiltUpArea != 1600 || !reflect.DeepEqual(got.ParkingSlots, []string{"P1", "P2"}) {
+			t.Errorf("stored %+v", got)
+		}
+
+		tests := []struct {
+			name    string
+			save    func() error
+			wantErr string
+		}{
+			{"slot taken", func() error {
+				_, err := svc.SaveApartment(store.Apartment{ID: "102", Owner: "Ravi", ParkingSlots: []string{"p1"}})
+				return err
+			}, "parking slot P1 is allotted to both 102 and 101"},
+			{"slot handed over in one import", func() error {
+				_, err := svc.ImportApartments([][]string{{"ID", "Parking"}, {"101", "P2"}, {"102", "P1"}})
+				return err
+			}, ""},
+			{"slot given twice in one import", func() error {
+				_, err := svc.ImportApartments([][]string{{"ID", "Parking"}, {"103", "P3"}, {"104", "P3"}})
+				return err
+			}, "allotted to both 103 and 104"},
+			{"own slot kept", func() error {
+				apt, err := svc.Store().GetApartment("101")
+				if err == nil {
+					apt.Resident = "Kiran"
+					_, err = svc.SaveApartment(apt)
+				}
+				return err
+			}, ""},
+		}
+		for _, tt := range tests {
+			err := tt.save()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+			}
+		}
+	})
+}
+
+func TestOccupancyHistory(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		apt := mustSaveApartment(t, svc, store.Apartment{
+			ID: "101", Owner: "Asha", OwnerSince: "2020-01-01", Resident: "Kiran", ResidentSince: "2020-02-01",
+		})
+
+		tests := []struct {
+			name    string
+			edit    func(apt *store.Apartment)
+			wantErr string
+		}{
+			{"since in the future", func(apt *store.Apartment) {
+				apt.Owner, apt.OwnerSince = "Ravi", "2999-01-01"
+			}, "owner since cannot be in the future"},
+			{"since not a date", func(apt *store.Apartment) {
+				apt.ResidentSince = "last May"
+			}, "YYYY-MM-DD"},
+			{"new owner before the last took over", func(apt *store.Apartment) {
+				apt.Owner, apt.OwnerSince = "Ravi", "2019-06-01"
+			}, "cannot take over before 2020-01-01"},
+			{"owner sold", func(apt *store.Apartment) {
+				apt.Owner, apt.OwnerSince = "Ravi", "2023-06-01"
+			}, ""},
+			{"resident moved out today", func(apt *store.Apartment) {
+				apt.Resident = ""
+			}, ""},
+			{"new owner's date moved before the last took over", func(apt *store.Apartment) {
+				apt.OwnerSince = "2019-12-31"
+			}, "owner since must be after 2020-01-01"},
+			{"new owner's date corrected", func(apt *store.Apartment) {
+				apt.OwnerSince = "2023-05-01"
+			}, ""},
+		}
+		for _, tt := range tests {
+			edited := apt
+			tt.edit(&edited)
+			change, err := svc.SaveApartment(edited)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			apt = change.After
+		}
+
+		history, err := svc.Store().OccupancyHistory("101")
+		if err != nil {
+			t.Fatal(err)
+		}
+		today := time.Now().Format(service.DateFormat)
+		want := []string{
+			"owner Asha 2020-01-01..2023-05-01",
+			"owner Ravi 2023-05-01..",
+			"resident Kiran 2020-02-01.." + today,
+			"resident Vacant " + today + "..",
+		}
+		var got []string
+		for _, period := range history {
+			got = append(got, fmt.Sprintf("%s %s %s..%s", period.Role, period.Name, period.From, period.To))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("history\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+
+		dates := []struct {
+			date  string
+			owner string
+		}{
+			{"2019-01-01", "Asha"},
+			{"2023-04-30", "Asha"},
+			{"2023-05-01", "Ravi"},
+		}
+		for _, d := range dates {
+			occupants, err := svc.OccupantsOn("101", d.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if occupants.Owner != d.owner {
+				t.Errorf("owner on %s is %s, want %s", d.date, occupants.Owner, d.owner)
+			}
+		}
+	})
+}
+
+func TestImportPeople(t *testing.T) {
+	tests := []struct {
+		name    string
+		records [][]string
+		// want lists the directory afterwards as name/phone/email
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "new people",
+			records: [][]string{{"Name", "Mobile"}, {"Ravi", "9845011111"}},
+			want:    []string{"Asha/9845012345/asha@example.com", "Ravi/9845011111/"},
+		},
+		{
+			name:    "matched by phone and blank cells kept",
+			records: [][]string{{"Name", "Phone", "Email"}, {"A. Rao", "+91 98450 12345", ""}},
+			want:    []string{"Asha/9845012345/asha@example.com"},
+		},
+		{
+			name:    "matched by email takes the file's details",
+			records: [][]string{{"Email", "Phone", "Name"}, {"ASHA@example.com", "9845099999", "Asha"}},
+			want:    []string{"Asha/9845099999/ASHA@example.com"},
+		},
+		{
+			name:    "repeated rows become one person",
+			records: [][]string{{"Name", "Email"}, {"Kiran", ""}, {"kiran", "kiran@example.com"}},
+			want:    []string{"Asha/9845012345/asha@example.com", "Kiran//kiran@example.com"},
+		},
+		{
+			name:    "bad row saves nothing",
+			records: [][]string{{"Name", "Email"}, {"Ravi", ""}, {"Kiran", "not an email"}},
+			want:    []string{"Asha/9845012345/asha@example.com"},
+			wantErr: "row 3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, svc *service.Service) {
+				_, err := svc.SavePerson(store.Person{Name: "Asha", Phone: "9845012345", Email: "asha@example.com"})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				_, err = svc.ImportPeople(tt.records)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("got error %v, want %q", err, tt.wantErr)
+					}
+				} else if err != nil {
+					t.Fatal(err)
+				}
+
+				people, err := svc.Store().SearchPeople("")
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, p := range people {
+					got = append(got, p.Name+"/"+p.Phone+"/"+p.Email)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("directory %v, want %v", got, tt.want)
+				}
+			})
+		})
+	}
+}
+
+func TestSavePeopleConflictSavesNothing(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		read, err := svc.Store().SavePerson(store.Person{Name: "Asha"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		changed := read
+		changed.Phone = "9845012345"
+		if _, err := svc.Store().SavePerson(changed); err != nil {
+			t.Fatal(err)
+		}
+
+		read.Email = "asha@example.com"
+		_, err = svc.Store().SavePeople(store.Person{Name: "Ravi"}, read)
+		if !errors.Is(err, store.ErrConflict) {
+			t.Fatalf("saving at a stale version gave %v, want ErrConflict", err)
+		}
+		people, err := svc.Store().SearchPeople("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(people) != 1 || people[0].Email != "" {
+			t.Errorf("directory %+v after the conflict, want Asha unchanged", people)
+		}
+
+		saved, err := svc.Store().SavePeople(store.Person{Name: "Ravi"}, people[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(saved) != 2 || saved[0].ID == 0 || saved[0].Version != 1 || saved[1].Version != people[0].Version+1 {
+			t.Errorf("saved %+v, want Ravi created and Asha at the next version", saved)
+		}
+	})
+}
+
+func TestPeopleLinks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		apt := mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha Rao", Resident: "Ravi"})
+		mustSaveApartment(t, svc, store.Apartment{ID: "102", Owner: "asha  rao"})
+
+		people, err := svc.Store().SearchPeople("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(people) != 2 {
+			t.Fatalf("directory %+v, want Asha Rao and Ravi", people)
+		}
+		asha, ravi := people[0], people[1]
+		links, err := svc.Store().PersonLinks(asha.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(links); got != 2 {
+			t.Errorf("Asha has %d link(s), want 2", got)
+		}
+
+		searches := []struct {
+			query string
+			want  int
+		}{
+			{"", 2},
+			{"RAO", 1},
+			{"%", 0},
+			{"_", 0},
+		}
+		for _, search := range searches {
+			found, err := svc.Store().SearchPeople(search.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(found) != search.want {
+				t.Errorf("searching %q found %d, want %d", search.query, len(found), search.want)
+			}
+		}
+
+		if _, err := svc.DeletePerson(asha.ID); err == nil || !strings.Contains(err.Error(), "101 (owner)") {
+			t.Errorf("deleting a linked person gave %v", err)
+		}
+
+		// A new resident is linked in place of the old one, who can then go
+		apt.Resident = "Kiran"
+		if _, err := svc.SaveApartment(apt); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := svc.DeletePerson(ravi.ID); err != nil {
+			t.Errorf("deleting an unlinked person: %v", err)
+		}
+
+		if err := svc.LinkPerson(asha.ID, "101", store.LinkResident); err == nil {
+			t.Error("linked someone as resident under another name")
+		}
+	})
+}
+
+func TestDeleteApartment(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha"})
+		c := mustRecordCollection(t, svc, "101", "January")
+
+		if _, err := svc.DeleteApartment("999", "test"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("deleting an unknown apartment gave %v, want ErrNotFound", err)
+		}
+		before, err := svc.DeleteApartment("101", "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if before.Owner != "Asha" {
+			t.Errorf("deleted %+v", before)
+		}
+		if _, err := svc.Store().GetApartment("101"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("apartment 101 still listed (%v)", err)
+		}
+		if _, err := svc.Store().GetCollection(c.ID); err != nil {
+			t.Errorf("the apartment's collection went with it: %v", err)
+		}
+
+		// The ID stays taken until the apartment is restored or purged
+		_, err = svc.SaveApartment(store.Apartment{ID: "101", Owner: "Ravi"})
+		if err == nil || !strings.Contains(err.Error(), "in the recycle bin") {
+			t.Errorf("reusing a deleted apartment's ID gave %v", err)
+		}
+	})
+}
+
+func TestRecordCollection(t *testing.T) {
+	tests := []struct {
+		name        string
+		apartmentID string
+		month       string
+		price       money.Money
+		wantErr     string
+	}{
+		{name: "maintenance", apartmentID: "101", month: "January", price: 400000},
+		{name: "with paise", apartmentID: "101", month: "February", price: 400050},
+		{name: "unknown apartment", apartmentID: "999", month: "January", price: 400000,
+			wantErr: "unknown apartment"},
+		{name: "zero amount", apartmentID: "101", month: "March", price: 0, wantErr: "greater than zero"},
+		{name: "negative amount", apartmentID: "101", month: "March", price: -1, wantErr: "negative"},
+		{name: "missing month", apartmentID: "101", price: 400000, wantErr: "required"},
+	}
+
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha"})
+
+		var want []store.Collection
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				c, err := svc.RecordCollection(tt.apartmentID, tt.month, service.MaintenanceType, tt.price)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("got error %v, want %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if c.ID == 0 || c.Date == "" || c.Price != tt.price {
+					t.Errorf("recorded %+v", c)
+				}
+				want = append([]store.Collection{c}, want...)
+			})
+		}
+
+		got, err := svc.Store().CollectionsForApartment("101")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("stored %+v, want %+v", got, want)
+		}
+	})
+}
+
+func TestRecordPayment(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		if _, err := svc.RecordPayment("January", "", 150000, "Expense"); err == nil {
+			t.Error("a payment without a type was recorded")
+		}
+		if _, err := svc.RecordPayment("January", "Security", 0, "Expense"); err == nil {
+			t.Error("a zero payment was recorded")
+		}
+		first, err := svc.RecordPayment("January", "Security", 1500000, "Expense")
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := svc.RecordPayment("January", "Hall hire", 250000, "Income")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := svc.Store().RecentPayments(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].ID != second.ID || got[1].ID != first.ID || got[1].Price != 1500000 {
+			t.Errorf("recent payments %+v, want the newest first", got)
+		}
+		if got, _ := svc.Store().RecentPayments(1); len(got) != 1 {
+			t.Errorf("limit 1 returned %d payments", len(got))
+		}
+	})
+}
+
+func TestOutstandingDues(t *testing.T) {
+	// Collections are dated today, so dues are worked out for this year
+	march := time.Date(time.Now().Year(), time.March, 15, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name string
+		// collect records maintenance for these months first
+		collect []string
+		// deleteFirst moves the first collection to the recycle bin
+		deleteFirst bool
+		// otherType records a collection of another type for January
+		otherType bool
+		now       time.Time
+		want      []string
+	}{
+		{name: "nothing paid", now: march, want: []string{"January", "February", "March"}},
+		{name: "January paid", collect: []string{"January"}, now: march, want: []string{"February", "March"}},
+		{name: "all paid", collect: []string{"January", "February", "March"}, now: march},
+		{name: "paid ahead", collect: []string{"April"}, now: march, want: []string{"January", "February", "March"}},
+		{name: "other types do not count", otherType: true, now: march,
+			want: []string{"January", "February", "March"}},
+		{name: "deleted collections do not count", collect: []string{"January"}, deleteFirst: true, now: march,
+			want: []string{"January", "February", "March"}},
+		{name: "last year's collections do not count", collect: []string{"January"},
+			now: march.AddDate(1, -2, 0), want: []string{"January"}},
+	}
+
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		for i, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				id := strings.Repeat("9", i+1)
+				mustSaveApartment(t, svc, store.Apartment{ID: id, Owner: "Asha"})
+				for j, month := range tt.collect {
+					c := mustRecordCollection(t, svc, id, month)
+					if j == 0 && tt.deleteFirst {
+						if _, err := svc.DeleteCollection(c.ID, "test"); err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+				if tt.otherType {
+					if _, err := svc.RecordCollection(id, "January", "Repair", 50000); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				dues, err := svc.OutstandingDues(id, tt.now, 400000)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, due := range dues {
+					if due.ApartmentID != id || due.Amount != 400000 {
+						t.Errorf("due %+v", due)
+					}
+					got = append(got, due.Month)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("dues %v, want %v", got, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestUserAccounts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		admin := mustCreateUser(t, svc, "admin")
+		other := mustCreateUser(t, svc, "treasurer")
+
+		for query, want := range map[string]int{"TREAS": 1, "%": 0, "_": 0} {
+			found, err := svc.Store().SearchUsers(query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(found) != want {
+				t.Errorf("searching users for %q found %d, want %d", query, len(found), want)
+			}
+		}
+
+		if _, err := svc.DeleteUser(admin.ID, admin.ID, "admin"); err == nil {
+			t.Error("an account deleted itself")
+		}
+		if _, err := svc.SetUserDisabled(admin.ID, admin.ID, true); err == nil {
+			t.Error("an account disabled itself")
+		}
+
+		if _, err := svc.SetUserDisabled(other.ID, admin.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := svc.Store().GetUser(other.ID); !got.Disabled {
+			t.Error("account not disabled")
+		}
+		before, err := svc.DeleteUser(other.ID, admin.ID, "admin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if before.Username != "treasurer" {
+			t.Errorf("deleted %+v", before)
+		}
+		if _, err := svc.Store().GetUser(other.ID); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("deleted account still there (%v)", err)
+		}
+		if count, _ := svc.Store().CountUsers(); count != 1 {
+			t.Errorf("%d accounts left, want 1", count)
+		}
+	})
+}
+
+func TestSetUserApartments(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		user := mustCreateUser(t, svc, "asha")
+		mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha"})
+		mustSaveApartment(t, svc, store.Apartment{ID: "102", Owner: "Asha"})
+
+		if _, err := svc.SetUserApartments(user.ID, []string{"101", "999"}); err == nil ||
+			!strings.Contains(err.Error(), "unknown apartment: 999") {
+			t.Fatalf("linking an unknown apartment gave %v", err)
+		}
+		if got, _ := svc.Store().UserApartments(user.ID); len(got) != 0 {
+			t.Errorf("a rejected link saved %v", got)
+		}
+
+		if _, err := svc.SetUserApartments(user.ID, []string{"102", "101", "102"}); err != nil {
+			t.Fatal(err)
+		}
+		before, err := svc.SetUserApartments(user.ID, []string{"101"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(before, []string{"101", "102"}) {
+			t.Errorf("previous links %v, want [101 102]", before)
+		}
+		if got, _ := svc.Store().UserApartments(user.ID); !reflect.DeepEqual(got, []string{"101"}) {
+			t.Errorf("links %v, want [101]", got)
+		}
+	})
+}
+
+func TestSaveSociety(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		if _, err := svc.SaveSociety(store.Society{Name: " "}); err == nil {
+			t.Error("a society without a name was saved")
+		}
+		if _, err := svc.SaveSociety(store.Society{Name: "default society"}); err == nil ||
+			!strings.Contains(err.Error(), "already exists") {
+			t.Errorf("a second society named like the default gave %v", err)
+		}
+
+		saved, err := svc.SaveSociety(store.Society{Name: " Green Acres ", Address: "12 MG Road"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.ID == 0 || saved.ID == store.DefaultSocietyID || saved.Name != "Green Acres" {
+			t.Fatalf("saved %+v", saved)
+		}
+		saved.RegistrationNumber = "PNA/1234"
+		if _, err := svc.SaveSociety(saved); err != nil {
+			t.Fatalf("saving a society under its own name: %v", err)
+		}
+
+		societies, err := svc.Store().ListSocieties()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, society := range societies {
+			names = append(names, society.Name)
+		}
+		if want := []string{store.DefaultSocietyName, "Green Acres"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("societies %v, want %v", names, want)
+		}
+		if got, _ := svc.Store().GetSociety(saved.ID); got.RegistrationNumber != "PNA/1234" {
+			t.Errorf("update not saved: %+v", got)
+		}
+	})
+}
+
+func TestSocietyScope(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		other, err := svc.SaveSociety(store.Society{Name: "Second Society"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherSvc := service.New(svc.Store().InSociety(other.ID))
+
+		// Apartment IDs and receipt numbers are each society's own
+		mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha"})
+		mustSaveApartment(t, otherSvc, store.Apartment{ID: "101", Owner: "Kiran"})
+		var receipts []int
+		for _, c := range []store.Collection{
+			mustRecordCollection(t, svc, "101", "January"),
+			mustRecordCollection(t, svc, "101", "February"),
+			mustRecordCollection(t, otherSvc, "101", "January"),
+		} {
+			receipts = append(receipts, c.ReceiptNumber)
+		}
+		if want := []int{1, 2, 1}; !reflect.DeepEqual(receipts, want) {
+			t.Errorf("receipt numbers %v, want %v", receipts, want)
+		}
+
+		if apt, _ := svc.Store().GetApartment("101"); apt.Owner != "Asha" {
+			t.Errorf("default society's 101 is %+v", apt)
+		}
+		if apt, _ := otherSvc.Store().GetApartment("101"); apt.Owner != "Kiran" || apt.SocietyID != other.ID {
+			t.Errorf("second society's 101 is %+v", apt)
+		}
+		if count, _ := otherSvc.Store().CountCollections("101"); count != 1 {
+			t.Errorf("second society's 101 has %d collection(s), want 1", count)
+		}
+		if _, err := otherSvc.RecordPayment("January", "Security", 100000, "Expense"); err != nil {
+			t.Fatal(err)
+		}
+		if payments, _ := svc.Store().RecentPayments(10); len(payments) != 0 {
+			t.Errorf("the default society sees %d payment(s) of another", len(payments))
+		}
+	})
+}
+
+func TestSetUserSocieties(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		user := mustCreateUser(t, svc, "asha")
+		other, err := svc.SaveSociety(store.Society{Name: "Second Society"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := svc.SetUserSocieties(user.ID, []int{other.ID, 99}); err == nil ||
+			!strings.Contains(err.Error(), "unknown society: 99") {
+			t.Fatalf("assigning an unknown society gave %v", err)
+		}
+		if _, err := svc.SetUserSocieties(user.ID, []int{other.ID, store.DefaultSocietyID}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := svc.Store().UserSocieties(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int{store.DefaultSocietyID, other.ID}; !reflect.DeepEqual(got, want) {
+			t.Errorf("societies %v, want %v", got, want)
+		}
+	})
+}
+
+func TestUpdateUserConflict(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		read := mustCreateUser(t, svc, "asha")
+
+		mine, theirs := read, read
+		theirs.FullName = "Asha Rao"
+		if _, err := svc.UpdateUser(theirs); err != nil {
+			t.Fatal(err)
+		}
+
+		mine.Role = "treasurer"
+		_, err := svc.UpdateUser(mine)
+		var conflict *service.ConflictError
+		if !errors.As(err, &conflict) || !errors.Is(err, store.ErrConflict) {
+			t.Fatalf("saving a stale copy gave %v, want a *ConflictError", err)
+		}
+		want := []service.FieldDiff{
+			{Field: "Full name", Yours: "", Saved: "Asha Rao"},
+			{Field: "Role", Yours: "treasurer", Saved: "admin"},
+		}
+		if !reflect.DeepEqual(conflict.Diff, want) {
+			t.Errorf("diff %+v, want %+v", conflict.Diff, want)
+		}
+
+		got, err := svc.Store().GetUser(read.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Role != "admin" || got.FullName != "Asha Rao" || got.Version != read.Version+1 {
+			t.Errorf("stale save changed the user to %+v", got)
+		}
+	})
+}
+
+func TestLookalikeIDs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		// Saved straight to the store, as lookalikes were before being refused
+		for _, id := range []string{"01", "APT01", "A-101", "A101", "102", "/"} {
+			if err := svc.Store().SaveApartments(store.Apartment{ID: id, Owner: "Asha", Resident: service.Vacant}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		mustRecordCollection(t, svc, "APT01", "January")
+
+		// groups maps each group's IDs to the ID suggested for them
+		groups := func() map[string]string {
+			t.Helper()
+			found, err := svc.LookalikeIDs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, g := range found {
+				got[strings.Join(g.IDs, " ")] = g.Suggested
+			}
+			return got
+		}
+
+		want := map[string]string{"01 APT01": "APT01", "A-101 A101": "A-101"}
+		if got := groups(); !reflect.DeepEqual(got, want) {
+			t.Errorf("without a pattern got %v, want %v", got, want)
+		}
+
+		mustSetIDPattern(t, svc, "@-###")
+		want = map[string]string{"01 APT01": "APT01", "A-101 A101": "A-101", "102": "", "/": ""}
+		if got := groups(); !reflect.DeepEqual(got, want) {
+			t.Errorf("with a pattern got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestMergeApartments(t *testing.T) {
+	const retention = 30 * 24 * time.Hour
+	later := time.Now().Add(retention + time.Hour)
+
+	forEachStore(t, func(t *testing.T, svc *service.Service) {
+		// statementOwners lists the owner named on each of an apartment's
+		// collections by receipt number
+		statementOwners := func(apartmentID string) map[int]string {
+			t.Helper()
+			lines, err := svc.Statement(apartmentID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			owners := map[int]string{}
+			for _, line := range lines {
+				owners[line.Collection.ReceiptNumber] = line.Occupants.Owner
+				occupants, err := svc.CollectionOccupants(line.Collection)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if occupants.Owner != line.Occupants.Owner {
+					t.Errorf("receipt %d names %s, the statement %s",
+						line.Collection.ReceiptNumber, occupants.Owner, line.Occupants.Owner)
+				}
+			}
+			return owners
+		}
+
+		// Into an existing apartment, whose history differs
+		mustSaveApartment(t, svc, store.Apartment{ID: "101", Owner: "Asha"})
+		if err := svc.Store().SaveApartments(store.Apartment{ID: "APT101", Owner: "Ravi", Resident: service.Vacant}); err != nil {
+			t.Fatal(err)
+		}
+		own := mustRecordCollection(t, svc, "101", "January")
+		moved := mustRecordCollection(t, svc, "APT101", "February")
+		merge, err := svc.MergeApartments("APT101", "101", "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if merge.Created || merge.Into.ID != "101" || merge.Into.Owner != "Asha" || merge.Collections != 1 {
+			t.Errorf("got merge into %s (%s), created %v, %d collection(s); want 101 (Asha), 1",
+				merge.Into.ID, merge.Into.Owner, merge.Created, merge.Collections)
+		}
+		want := map[int]string{own.ReceiptNumber: "Asha", moved.ReceiptNumber: "Ravi"}
+		if got := statementOwners("101"); !reflect.DeepEqual(got, want) {
+			t.Errorf("after merging got owners %v, want %v", got, want)
+		}
+		if _, err := svc.Store().DeletedEntry(store.BinApartments, "APT101"); err != nil {
+			t.Errorf("APT101 is not in the recycle bin: %v", err)
+		}
+
+		// Once the merged apartment is purged its history is gone
+		if _, err := svc.Purge(store.BinApartments, "APT101", later, retention); err != nil {
+			t.Fatal(err)
+		}
+		want[moved.ReceiptNumber] = "Asha"
+		if got := statementOwners("101"); !reflect.DeepEqual(got, want) {
+			t.Errorf("after purging got owners %v, want %v", got, want)
+		}
+
+		// Into a new ID, which renames the apartment
+		b7 := mustSaveApartment(t, svc, store.Apartment{ID: "B7", Owner: "Kiran", ParkingSlots: []string{"P1"}})
+		b7.Owner = "Meena"
+		if _, err := svc.SaveApartment(b7); err != nil {
+			t.Fatal(err)
+		}
+		divya, err := svc.SavePerson(store.Person{Name: "Divya"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := svc.LinkPerson(divya.After.ID, "B7", store.LinkCoOwner); err != nil {
+			t.Fatal(err)
+		}
+		owners, err := svc.Owners("B7")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := svc.SetOwnershipShares("B7", map[int]int{owners[0].PersonID: 7000, divya.After.ID: 3000}); err != nil {
+			t.Fatal(err)
+		}
+		history, err := svc.Store().OccupancyHistory("B7")
+		if err != nil {
+			t.Fatal(err)
+		}
+		renamed := mustRecordCollection(t, svc, "B7", "March")
+		merge, err = svc.MergeApartments("B7", "b-007", "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !merge.Created || merge.Into.ID != "B-007" || merge.Collections != 1 {
+			t.Errorf("got merge into %s, created %v, %d collection(s); want B-007, true, 1",
+				merge.Into.ID, merge.Created, merge.Collections)
+		}
+		apt, err := svc.Store().GetApartment("B-007")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if apt.Owner != "Meena" || !reflect.DeepEqual(apt.ParkingSlots, []string{"P1"}) {
+			t.Errorf("got B-007 owned by %s with slots %v, want Meena with [P1]", apt.Owner, apt.ParkingSlots)
+		}
+		copied, err := svc.Store().OccupancyHistory("B-007")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(copied) != len(history) {
+			t.Errorf("B-007 has %d occupancy period(s), want the %d of B7", len(copied), len(history))
+		}
+		owners, err = svc.Owners("B-007")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var shares []string
+		for _, o := range owners {
+			shares = append(shares, fmt.Sprintf("%s %d", o.PersonName, o.Share))
+		}
+		if want := []string{"Meena 7000", "Divya 3000"}; !reflect.DeepEqual(shares, want) {
+			t.Errorf("got B-007 owners %q, want %q", shares, want)
+		}
+		if got := statementOwners("B-007"); got[renamed.ReceiptNumber] != "Meena" {
+			t.Errorf("B-007 collection names %s, want Meena", got[renamed.ReceiptNumber])
+		}
+
+		for _, step := range []struct{ from, into, wantErr string }{
+			{"B-007", "B-007", "cannot be merged into itself"},
+			{"B7", "B-007", "not found"},
+			{"B-007", "B7", "in the recycle bin"},
+		} {
+			if _, err := svc.MergeApartments(step.from, step.into, "test"); err == nil ||
+				!strings.Contains(err.Error(), step.wantErr) {
+				t.Errorf("merging %s into %s: got error %v, want %q", step.from, step.into, err, step.wantErr)
+			}
+		}
+	})
+}
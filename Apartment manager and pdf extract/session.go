@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/driver/desktop"
+	"fyne.io/fyne/v2/widget"
+
+	"apartment_login/store"
+)
+
+// defaultIdleTimeout is used when no idle_timeout_minutes setting exists
+const defaultIdleTimeout = 10 * time.Minute
+
+// idleCheckInterval is how often the idle watcher looks at the last activity
+const idleCheckInterval = 15 * time.Second
+
+// sessionState tracks the windows opened by the signed-in user so they can
+// be locked when idle and closed on logout
+type sessionState struct {
+	mu           sync.Mutex
+	app          fyne.App
+	windows      []fyne.Window
+	saved        map[fyne.Window]fyne.CanvasObject
+	lastActivity time.Time
+	locked       bool
+	stop         chan struct{}
+	// lastSeen is each window's focused widget, its text and the dialog on
+	// top when the idle watcher last looked; see windowState
+	lastSeen map[fyne.Window]string
+}
+
+var appSession sessionState
+
+// idleTimeout returns the configured inactivity timeout; zero disables auto-lock
+func idleTimeout() time.Duration {
+	value := getSetting(settingIdleTimeout)
+	if value == "" {
+		return defaultIdleTimeout
+	}
+	minutes, err := strconv.Atoi(value)
+	if err != nil || minutes < 0 {
+		log.Println("Invalid idle timeout setting:", value)
+		return defaultIdleTimeout
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// setIdleTimeout stores the inactivity timeout in whole minutes
+func setIdleTimeout(minutes int) error {
+	if err := requirePermission(PermManageUsers); err != nil {
+		return err
+	}
+	if minutes < 0 {
+		return errors.New("idle timeout cannot be negative")
+	}
+	return setSetting(settingIdleTimeout, strconv.Itoa(minutes))
+}
+
+// startSession begins tracking activity for the signed-in user
+func startSession(myApp fyne.App) {
+	appSession.mu.Lock()
+	defer appSession.mu.Unlock()
+
+	appSession.app = myApp
+	appSession.windows = nil
+	appSession.saved = map[fyne.Window]fyne.CanvasObject{}
+	appSession.lastSeen = map[fyne.Window]string{}
+	appSession.lastActivity = time.Now()
+	appSession.locked = false
+	appSession.stop = make(chan struct{})
+
+	go watchIdle(appSession.stop)
+}
+
+// watchIdle locks the session once no activity has been seen for the timeout
+func watchIdle(stop chan struct{}) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			timeout := idleTimeout()
+			if timeout == 0 {
+				continue
+			}
+			noticeTyping()
+
+			appSession.mu.Lock()
+			idle := !appSession.locked && time.Since(appSession.lastActivity) > timeout
+			appSession.mu.Unlock()
+
+			if idle {
+				lockSession()
+			}
+		}
+	}
+}
+
+// touchActivity records user activity, postponing the idle lock
+func touchActivity() {
+	appSession.mu.Lock()
+	appSession.lastActivity = time.Now()
+	appSession.mu.Unlock()
+}
+
+// windowState describes what the keyboard can change in a window: the
+// focused widget, its text if it is an entry, and the dialog on top
+func windowState(w fyne.Window) string {
+	c := w.Canvas()
+	focused := c.Focused()
+	text := ""
+	if entry, ok := focused.(*widget.Entry); ok {
+		text = entry.Text
+	}
+	return fmt.Sprintf("%p %p %s", focused, c.Overlays().Top(), text)
+}
+
+// noticeTyping counts a change of windowState since the last look as
+// activity. Keys typed into a focused widget never reach the canvas key
+// handlers, so they are only seen through what they change.
+func noticeTyping() {
+	changed := false
+	windows := sessionWindows()
+	states := make(map[fyne.Window]string, len(windows))
+	for _, w := range windows {
+		states[w] = windowState(w)
+	}
+
+	appSession.mu.Lock()
+	for w, state := range states {
+		if seen, ok := appSession.lastSeen[w]; ok && seen != state {
+			changed = true
+		}
+	}
+	appSession.lastSeen = states
+	appSession.mu.Unlock()
+
+	if changed {
+		touchActivity()
+	}
+}
+
+// showSessionWindow sets the window content, registers the window with the
+// session and shows it
+func showSessionWindow(w fyne.Window, content fyne.CanvasObject) {
+	w.SetContent(container.NewStack(newActivityLayer(), content))
+	w.Canvas().SetOnTypedKey(func(*fyne.KeyEvent) { touchActivity() })
+	w.Canvas().SetOnTypedRune(func(rune) { touchActivity() })
+	if c, ok := w.Canvas().(desktop.Canvas); ok {
+		c.SetOnKeyDown(func(*fyne.KeyEvent) { touchActivity() })
+	}
+	w.SetOnClosed(func() { untrackWindow(w) })
+
+	appSession.mu.Lock()
+	appSession.windows = append(appSession.windows, w)
+	locked := appSession.locked
+	appSession.mu.Unlock()
+
+	if locked {
+		lockWindow(w)
+	}
+	w.Show()
+}
+
+func untrackWindow(w fyne.Window) {
+	appSession.mu.Lock()
+	defer appSession.mu.Unlock()
+
+	for i, tracked := range appSession.windows {
+		if tracked == w {
+			appSession.windows = append(appSession.windows[:i], appSession.windows[i+1:]...)
+			break
+		}
+	}
+	delete(appSession.saved, w)
+	delete(appSession.lastSeen, w)
+}
+
+// sessionWindows returns a copy of the tracked windows
+func sessionWindows() []fyne.Window {
+	appSession.mu.Lock()
+	defer appSession.mu.Unlock()
+	return append([]fyne.Window(nil), appSession.windows...)
+}
+
+// lockSession covers every session window with a re-authentication prompt
+func lockSession() {
+	appSession.mu.Lock()
+	if appSession.locked {
+		appSession.mu.Unlock()
+		return
+	}
+	appSession.locked = true
+	appSession.mu.Unlock()
+
+	log.Println("Session locked after inactivity")
+	for _, w := range sessionWindows() {
+		lockWindow(w)
+	}
+}
+
+func lockWindow(w fyne.Window) {
+	appSession.mu.Lock()
+	if _, ok := appSession.saved[w]; ok {
+		appSession.mu.Unlock()
+		return
+	}
+	appSession.saved[w] = w.Content()
+	appSession.mu.Unlock()
+
+	// Dialogs float above the content and would stay usable over the lock
+	// screen, so they are closed; whatever they were doing is abandoned
+	overlays := w.Canvas().Overlays()
+	for _, overlay := range overlays.List() {
+		overlay.Hide()
+		overlays.Remove(overlay)
+	}
+	w.SetContent(newLockScreen(w))
+}
+
+// unlockSession restores every session window after re-authentication
+func unlockSession() {
+	appSession.mu.Lock()
+	saved := appSession.saved
+	appSession.saved = map[fyne.Window]fyne.CanvasObject{}
+	appSession.locked = false
+	appSession.lastActivity = time.Now()
+	appSession.mu.Unlock()
+
+	for w, content := range saved {
+		w.SetContent(content)
+	}
+}
+
+// logout closes every session window and returns to the login screen
+func logout() {
+	appSession.mu.Lock()
+	myApp := appSession.app
+	if appSession.stop != nil {
+		close(appSession.stop)
+		appSession.stop = nil
+	}
+	appSession.mu.Unlock()
+
+	log.Println("User logged out:", currentUser.Username)
+	currentUser = User{}
+	currentSociety = store.Society{}
+
+	// Show the login window first so the app does not exit when the
+	// last session window closes
+	ShowLoginWindow(myApp)
+	for _, w := range sessionWindows() {
+		w.Close()
+	}
+}
+
+// newLockScreen builds the re-authentication prompt shown while locked
+func newLockScreen(w fyne.Window) fyne.CanvasObject {
+	passwordEntry := widget.NewPasswordEntry()
+	passwordEntry.SetPlaceHolder("Password")
+
+	unlock := func() {
+		// The session already passed two-factor sign-in, so the password
+		// alone is enough to unlock it
+		user, err := Authenticate(currentUser.Username, passwordEntry.Text)
+		if errors.Is(err, errSecondFactorRequired) {
+			finishLogin(user)
+			err = nil
+		}
+		if err != nil {
+			passwordEntry.SetText("")
+			dialog.ShowError(err, w)
+			return
+		}
+		unlockSession()
+	}
+	passwordEntry.OnSubmitted = func(string) { unlock() }
+
+	unlockButton := widget.NewButton("Unlock", unlock)
+	logoutButton := widget.NewButton("Logout", logout)
+
+	return container.NewCenter(container.NewVBox(
+		widget.NewLabel("Session locked due to inactivity"),
+		widget.NewLabel(fmt.Sprintf("Enter the password for %s to continue.", currentUser.Username)),
+		passwordEntry,
+		container.NewHBox(unlockButton, logoutButton),
+	))
+}
+
+// showIdleTimeoutDialog lets an administrator change the inactivity timeout
+func showIdleTimeoutDialog(parent fyne.Window) {
+	minutesEntry := widget.NewEntry()
+	minutesEntry.SetText(strconv.Itoa(int(idleTimeout() / time.Minute)))
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Lock after (minutes)", minutesEntry),
+	}
+
+	dialog.ShowForm("Session Settings", "Save", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		minutes, err := strconv.Atoi(strings.TrimSpace(minutesEntry.Text))
+		if err != nil {
+			dialog.ShowError(errors.New("enter a whole number of minutes (0 disables auto-lock)"), parent)
+			return
+		}
+		if err := setIdleTimeout(minutes); err != nil {
+			dialog.ShowError(err, parent)
+		}
+	}, parent)
+}
+
+// activityLayer sits behind window content and reports mouse movement
+// anywhere in the window as activity. The desktop driver asks every object
+// under the pointer for its cursor on each move, including those covered
+// by other widgets, so the layer hears about moves over buttons and entries
+// without taking their events.
+type activityLayer struct {
+	widget.BaseWidget
+}
+
+func newActivityLayer() *activityLayer {
+	l := &activityLayer{}
+	l.ExtendBaseWidget(l)
+	return l
+}
+
+func (l *activityLayer) CreateRenderer() fyne.WidgetRenderer {
+	return widget.NewSimpleRenderer(canvas.NewRectangle(color.Transparent))
+}
+
+// Cursor records the move and leaves the pointer to the widgets on top
+func (l *activityLayer) Cursor() desktop.Cursor {
+	touchActivity()
+	return desktop.DefaultCursor
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"apartment_login/store"
+)
+
+// Keys used in the settings table
+const (
+	settingSocietyName = "society_name"
+	settingIdleTimeout = "idle_timeout_minutes"
+)
+
+// adminPasswordEnv lets scripted installs pass the administrator password
+// without it showing up in the process list
+const adminPasswordEnv = "APARTMENT_ADMIN_PASSWORD"
+
+// getSetting returns a value from the settings table, or "" if it is not set
+func getSetting(key string) string {
+	var value string
+	err := userDB.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println("Error reading setting", key+":", err)
+	}
+	return value
+}
+
+// setSetting stores a value in the settings table
+func setSetting(key, value string) error {
+	_, err := userDB.Exec(
+		"INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)",
+		key, value)
+	return err
+}
+
+// needsFirstRunSetup reports whether no user accounts exist yet
+func needsFirstRunSetup() bool {
+	return getUserCount() == 0
+}
+
+// bootstrapAdministrator creates the first admin account and records the
+// society name. It refuses to run once any user exists outside the recycle
+// bin, so it can never be used to add a second, unaudited administrator.
+func bootstrapAdministrator(societyName, username, password string) error {
+	societyName = strings.TrimSpace(societyName)
+	username = strings.TrimSpace(username)
+
+	if societyName == "" || username == "" || password == "" {
+		return errors.New("society name, username and password are required")
+	}
+	if err := getPasswordPolicy().validate(password); err != nil {
+		return err
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := userDB.Begin()
+	if err != nil {
+		return err
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE deleted_at IS NULL").Scan(&count); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count > 0 {
+		tx.Rollback()
+		return errors.New("setup has already been completed")
+	}
+	// Deleted accounts keep their usernames until purged
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&count); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if count > 0 {
+		tx.Rollback()
+		return fmt.Errorf("username %q belongs to a deleted account in the recycle bin; choose another", username)
+	}
+
+	now := time.Now()
+	result, err := tx.Exec(
+		"INSERT INTO users (username, password, role, password_changed_at, created_at) VALUES (?, ?, ?, ?, ?)",
+		username, hash, RoleAdmin, now.Format(time.RFC3339), now.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)",
+		settingSocietyName, societyName)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The default society was created by the migration before its name was
+	// known; the administrator works in it from the start
+	society, err := appStore.GetSociety(store.DefaultSocietyID)
+	if err != nil {
+		return err
+	}
+	society.Name = societyName
+	if _, err := appService.SaveSociety(society); err != nil {
+		return err
+	}
+	if err := appStore.SetUserSocieties(int(id), []int{store.DefaultSocietyID}); err != nil {
+		return err
+	}
+
+	return recordAudit(auditEntityUser, strconv.FormatInt(id, 10), auditActionCreate, nil,
+		auditUser{Username: username, Role: RoleAdmin, PasswordChanged: true})
+}
+
+// runHeadlessSetup performs first-run setup without a window, for scripted
+// installs. The password is taken from APARTMENT_ADMIN_PASSWORD or, if that
+// is unset, read from the first line of stdin.
+func runHeadlessSetup(societyName, username string, stdin io.Reader) error {
+	password := os.Getenv(adminPasswordEnv)
+	if password == "" {
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	if err := bootstrapAdministrator(societyName, username, password); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created administrator %s for %s\n", username, societyName)
+	return nil
+}
+
+// First-run setup UI
+func ShowSetupWindow(myApp fyne.App) {
+	setupWindow := myApp.NewWindow("First-Run Setup")
+	setupWindow.Resize(fyne.NewSize(400, 400))
+
+	societyEntry := widget.NewEntry()
+	societyEntry.SetPlaceHolder("Society Name")
+
+	usernameEntry := widget.NewEntry()
+	usernameEntry.SetPlaceHolder("Administrator Username")
+
+	passwordEntry := widget.NewPasswordEntry()
+	passwordEntry.SetPlaceHolder("Password")
+
+	confirmEntry := widget.NewPasswordEntry()
+	confirmEntry.SetPlaceHolder("Confirm Password")
+
+	createButton := widget.NewButton("Create Administrator", func() {
+		if passwordEntry.Text != confirmEntry.Text {
+			dialog.ShowError(errors.New("passwords do not match"), setupWindow)
+			return
+		}
+
+		err := bootstrapAdministrator(societyEntry.Text, usernameEntry.Text, passwordEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, setupWindow)
+			return
+		}
+
+		setupWindow.Hide()
+		ShowLoginWindow(myApp)
+	})
+
+	content := container.NewVBox(
+		widget.NewLabel("Welcome! No accounts exist yet."),
+		widget.NewLabel("Create the administrator account to get started."),
+		widget.NewLabel("Society Name:"),
+		societyEntry,
+		widget.NewLabel("Username:"),
+		usernameEntry,
+		widget.NewLabel("Password:"),
+		passwordEntry,
+		widget.NewLabel("Confirm Password:"),
+		confirmEntry,
+		createButton,
+	)
+
+	setupWindow.SetContent(content)
+	setupWindow.Show()
+	if dataDirNotice != "" {
+		dialog.ShowInformation("Data Directory", dataDirNotice, setupWindow)
+		dataDirNotice = ""
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBootstrapAdministrator(t *testing.T) {
+	newTestDatabases(t)
+	const password = "Correct horse battery 42"
+
+	// An installation whose only account was deleted can be set up again
+	mustAddUser(t, "former", "correct horse")
+	if _, err := userDB.Exec("UPDATE users SET deleted_at = '2024-05-01T10:00:00Z', deleted_by = 'former'"); err != nil {
+		t.Fatal(err)
+	}
+	if !needsFirstRunSetup() {
+		t.Fatal("setup not needed with only a deleted account")
+	}
+	if err := bootstrapAdministrator("Green Acres", "former", password); err == nil ||
+		!strings.Contains(err.Error(), "recycle bin") {
+		t.Errorf("reusing a deleted username: got error %v, want one about the recycle bin", err)
+	}
+	if err := bootstrapAdministrator("Green Acres", "admin", password); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := appStore.GetUserByUsername("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != RoleAdmin {
+		t.Errorf("got role %q, want %q", user.Role, RoleAdmin)
+	}
+	if err := bootstrapAdministrator("Green Acres", "second", password); err == nil ||
+		!strings.Contains(err.Error(), "already been completed") {
+		t.Errorf("second setup: got error %v, want it refused", err)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"apartment_login/service"
+	"apartment_login/store"
+)
+
+// currentSociety is the society the signed-in user is working in. appStore
+// and appService are scoped to it.
+var currentSociety store.Society
+
+// accessibleSocieties lists the societies the signed-in user may work in,
+// ordered by name
+func accessibleSocieties() ([]store.Society, error) {
+	societies, err := appStore.ListSocieties()
+	if err != nil || can(PermManageSocieties) {
+		return societies, err
+	}
+
+	ids, err := appStore.UserSocieties(currentUser.ID)
+	if err != nil {
+		return nil, err
+	}
+	assigned := map[int]bool{}
+	for _, id := range ids {
+		assigned[id] = true
+	}
+	var accessible []store.Society
+	for _, society := range societies {
+		if assigned[society.ID] {
+			accessible = append(accessible, society)
+		}
+	}
+	return accessible, nil
+}
+
+// selectSociety scopes the application to a society the signed-in user may
+// work in
+func selectSociety(id int) error {
+	societies, err := accessibleSocieties()
+	if err != nil {
+		return err
+	}
+	for _, society := range societies {
+		if society.ID == id {
+			appStore = appStore.InSociety(id)
+			appService = service.New(appStore)
+			currentSociety = society
+			invalidateApartmentLists()
+			return nil
+		}
+	}
+	return fmt.Errorf("permission denied: you are not assigned to society %d", id)
+}
+
+// nameDefaultSociety gives the society created when a single-society
+// installation was upgraded the configured name, address and registration
+// number, unless it has been named already
+func nameDefaultSociety() error {
+	society, err := appStore.GetSociety(store.DefaultSocietyID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	name := societyName()
+	if society.Name != store.DefaultSocietyName || name == "" {
+		return nil
+	}
+	society.Name = name
+	society.Address = config.Society.Address
+	society.RegistrationNumber = config.Society.RegistrationNumber
+	_, err = appService.SaveSociety(society)
+	return err
+}
+
+// selectFirstSociety scopes the application to the first society, by
+// name, that the signed-in user may work in
+func selectFirstSociety() error {
+	societies, err := accessibleSocieties()
+	if err != nil {
+		return err
+	}
+	if len(societies) == 0 {
+		return errors.New("your account is not assigned to any society; ask an administrator")
+	}
+	return selectSociety(societies[0].ID)
+}
+
+// societySwitcher lets the signed-in user move to another of their
+// societies; onSwitch runs after the application has been rescoped
+func societySwitcher(parent fyne.Window, onSwitch func()) fyne.CanvasObject {
+	societies, err := accessibleSocieties()
+	if err != nil {
+		log.Println("Error fetching societies:", err)
+	}
+	if len(societies) < 2 {
+		return widget.NewLabel(fmt.Sprintf("Society: %s", currentSociety.Name))
+	}
+
+	names := make([]string, len(societies))
+	for i, society := range societies {
+		names[i] = society.Name
+	}
+	societySelect := widget.NewSelect(names, nil)
+	societySelect.SetSelected(currentSociety.Name)
+	societySelect.OnChanged = func(name string) {
+		for _, society := range societies {
+			if society.Name != name || society.ID == currentSociety.ID {
+				continue
+			}
+			if err := selectSociety(society.ID); err != nil {
+				dialog.ShowError(err, parent)
+				return
+			}
+			onSwitch()
+		}
+	}
+	return container.NewHBox(widget.NewLabel("Society:"), societySelect)
+}
+
+// saveSociety creates or updates a society
+func saveSociety(society store.Society) (store.Society, error) {
+	if err := requirePermission(PermManageSocieties); err != nil {
+		return society, err
+	}
+
+	var before *store.Society
+	if society.ID != 0 {
+		existing, err := appStore.GetSociety(society.ID)
+		if err != nil {
+			return society, err
+		}
+		before = &existing
+	}
+
+	saved, err := appService.SaveSociety(society)
+	if err != nil {
+		return saved, err
+	}
+	if saved.ID == currentSociety.ID {
+		currentSociety = saved
+	}
+
+	action := auditActionUpdate
+	if before == nil {
+		action = auditActionCreate
+	}
+	return saved, recordAudit(auditEntitySociety, strconv.Itoa(saved.ID), action, before, saved)
+}
+
+// setUserSocieties replaces the societies a user may work in
+func setUserSocieties(userID int, societyIDs []int) error {
+	if err := requirePermission(PermManageUsers); err != nil {
+		return err
+	}
+
+	before, err := appService.SetUserSocieties(userID, societyIDs)
+	if err != nil {
+		return err
+	}
+
+	if fmt.Sprint(before) == fmt.Sprint(societyIDs) {
+		return nil
+	}
+	return recordAudit(auditEntityUser, strconv.Itoa(userID), auditActionUpdate,
+		map[string][]int{"societies": before},
+		map[string][]int{"societies": societyIDs})
+}
+
+// Society Manager UI
+func ShowSocietyManager(myApp fyne.App, previousWindow fyne.Window) {
+	societyWindow := myApp.NewWindow("Societies")
+	societyWindow.Resize(fyne.NewSize(700, 400))
+
+	nameEntry := widget.NewEntry()
+	nameEntry.SetPlaceHolder("Society Name")
+
+	addressEntry := widget.NewEntry()
+	addressEntry.SetPlaceHolder("Address")
+
+	registrationEntry := widget.NewEntry()
+	registrationEntry.SetPlaceHolder("Registration Number")
+
+	idPatternEntry := widget.NewEntry()
+	idPatternEntry.SetPlaceHolder("e.g. @-### for A-101; blank accepts any ID")
+
+	var societies []store.Society
+	var selected store.Society
+
+	societiesList := widget.NewList(
+		func() int { return len(societies) },
+		func() fyne.CanvasObject { return widget.NewLabel("") },
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			obj.(*widget.Label).SetText(societies[id].Name)
+		},
+	)
+
+	refreshList := func() {
+		var err error
+		societies, err = appStore.ListSocieties()
+		if err != nil {
+			dialog.ShowError(err, societyWindow)
+		}
+		societiesList.UnselectAll()
+		societiesList.Refresh()
+	}
+
+	resetForm := func() {
+		selected = store.Society{}
+		nameEntry.SetText("")
+		addressEntry.SetText("")
+		registrationEntry.SetText("")
+		idPatternEntry.SetText("")
+	}
+
+	societiesList.OnSelected = func(id widget.ListItemID) {
+		selected = societies[id]
+		nameEntry.SetText(selected.Name)
+		addressEntry.SetText(selected.Address)
+		registrationEntry.SetText(selected.RegistrationNumber)
+		idPatternEntry.SetText(selected.IDPattern)
+	}
+
+	saveButton := widget.NewButtonWithIcon("Save", theme.DocumentSaveIcon(), func() {
+		selected.Name = nameEntry.Text
+		selected.Address = addressEntry.Text
+		selected.RegistrationNumber = registrationEntry.Text
+		selected.IDPattern = idPatternEntry.Text
+		if _, err := saveSociety(selected); err != nil {
+			dialog.ShowError(err, societyWindow)
+			return
+		}
+		refreshList()
+		resetForm()
+	})
+
+	addButton := widget.NewButtonWithIcon("Add New", theme.ContentAddIcon(), func() {
+		societiesList.UnselectAll()
+		resetForm()
+	})
+
+	if !can(PermManageSocieties) {
+		saveButton.Disable()
+		addButton.Disable()
+	}
+
+	backButton := widget.NewButtonWithIcon("Back", theme.NavigateBackIcon(), func() {
+		societyWindow.Hide()
+		previousWindow.Show()
+	})
+
+	refreshList()
+
+	form := container.NewVBox(
+		widget.NewLabel("Society Details"),
+		widget.NewLabel("Name:"),
+		nameEntry,
+		widget.NewLabel("Address:"),
+		addressEntry,
+		widget.NewLabel("Registration Number:"),
+		registrationEntry,
+		widget.NewLabel("Apartment ID Pattern (@ a letter, # a digit):"),
+		idPatternEntry,
+		container.NewHBox(saveButton, addButton, backButton),
+	)
+
+	split := container.NewHSplit(societiesList, form)
+	split.Offset = 0.4
+
+	showSessionWindow(societyWindow, split)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"apartment_login/store"
+)
+
+func TestNameDefaultSociety(t *testing.T) {
+	keepConfig(t)
+	newTestDatabases(t)
+
+	config.Society = SocietyConfig{Name: "Green Acres", Address: "12 MG Road", RegistrationNumber: "PNA/1234"}
+	if err := nameDefaultSociety(); err != nil {
+		t.Fatal(err)
+	}
+	society, err := appStore.GetSociety(store.DefaultSocietyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if society.Name != "Green Acres" || society.Address != "12 MG Road" || society.RegistrationNumber != "PNA/1234" {
+		t.Fatalf("default society is %+v, want the configured details", society)
+	}
+
+	// A society that has been named is left alone
+	config.Society.Name = "Blue Meadows"
+	if err := nameDefaultSociety(); err != nil {
+		t.Fatal(err)
+	}
+	if society, _ := appStore.GetSociety(store.DefaultSocietyID); society.Name != "Green Acres" {
+		t.Errorf("default society renamed to %q", society.Name)
+	}
+}
+
+func TestReopenKeepsSociety(t *testing.T) {
+	keepConfig(t)
+	config.DataDir = t.TempDir()
+	currentUser = User{Username: "admin", Role: RoleAdmin}
+	t.Cleanup(func() {
+		if userDB != nil {
+			userDB.Close()
+			apartmentDB.Close()
+		}
+		userDB, apartmentDB, appStore, appService = nil, nil, nil, nil
+		currentUser, currentSociety = User{}, store.Society{}
+	})
+	reopen := func() {
+		t.Helper()
+		if userDB != nil {
+			userDB.Close()
+			apartmentDB.Close()
+		}
+		if err := openDatabases(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reopen()
+	other, err := appService.SaveSociety(store.Society{Name: "Blue Meadows"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := selectSociety(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	reopen()
+	if currentSociety.ID != other.ID || appStore.SocietyID() != other.ID {
+		t.Errorf("after reopening working in society %d (store %d), want %d",
+			currentSociety.ID, appStore.SocietyID(), other.ID)
+	}
+
+	// A society the reopened data no longer has falls back to the first
+	currentSociety.ID = other.ID + 1
+	reopen()
+	societies, err := accessibleSocieties()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if currentSociety.ID != societies[0].ID {
+		t.Errorf("after reopening without the society working in %d, want %d", currentSociety.ID, societies[0].ID)
+	}
+}
@@ -0,0 +1,1047 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is an in-memory Store for exercising the service layer without
+// SQLite. It keeps the same ordering and not-found behaviour as SQLite.
+// Stores returned by InSociety share their data with the original.
+type Memory struct {
+	*memoryData
+	society int
+}
+
+// scopedID identifies a row within a society; users are not scoped and use
+// society 0
+type scopedID struct {
+	society int
+	id      string
+}
+
+type memoryData struct {
+	mu sync.Mutex
+
+	users      map[int]User
+	nextUserID int
+	// userApartments maps a society to each user's apartments in it
+	userApartments map[int]map[int][]string
+	userSocieties  map[int][]int
+
+	societies     map[int]Society
+	nextSocietyID int
+	// nextReceipt is each society's next receipt number
+	nextReceipt map[int]int
+
+	apartments map[scopedID]Apartment
+
+	occupancy       []Occupancy
+	nextOccupancyID int
+
+	people       map[int]Person
+	nextPersonID int
+	// personLinks hold each society's links; PersonName is left blank
+	personLinks map[int][]PersonLink
+
+	collections      []Collection
+	nextCollectionID int
+
+	payments      []Payment
+	nextPaymentID int
+
+	// bin holds the recycle bin entries of rows hidden from normal use
+	bin map[BinKind]map[scopedID]BinEntry
+
+	// Now supplies the date recorded on new collections and payments
+	Now func() time.Time
+}
+
+// NewMemory returns an in-memory store holding just the default society,
+// scoped to it
+func NewMemory() *Memory {
+	return &Memory{
+		memoryData: &memoryData{
+			users:          map[int]User{},
+			userApartments: map[int]map[int][]string{},
+			userSocieties:  map[int][]int{},
+			societies:      map[int]Society{DefaultSocietyID: {ID: DefaultSocietyID, Name: DefaultSocietyName}},
+			nextSocietyID:  DefaultSocietyID,
+			nextReceipt:    map[int]int{DefaultSocietyID: 1},
+			apartments:     map[scopedID]Apartment{},
+			people:         map[int]Person{},
+			personLinks:    map[int][]PersonLink{},
+			bin:            map[BinKind]map[scopedID]BinEntry{},
+			Now:            time.Now,
+		},
+		society: DefaultSocietyID,
+	}
+}
+
+func (m *Memory) SocietyID() int {
+	return m.society
+}
+
+func (m *Memory) InSociety(id int) Store {
+	return &Memory{memoryData: m.memoryData, society: id}
+}
+
+// key identifies a row of kind as seen from the store's society
+func (m *Memory) key(kind BinKind, id string) scopedID {
+	if kind == BinUsers {
+		return scopedID{id: id}
+	}
+	return scopedID{m.society, id}
+}
+
+// inBin reports whether a row is in the recycle bin. The caller must hold mu.
+func (m *Memory) inBin(kind BinKind, id string) bool {
+	_, ok := m.bin[kind][m.key(kind, id)]
+	return ok
+}
+
+func (m *Memory) userInBin(id int) bool {
+	return m.inBin(BinUsers, strconv.Itoa(id))
+}
+
+func (m *Memory) GetUser(id int) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[id]
+	if !ok || m.userInBin(id) {
+		return User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (m *Memory) GetUserByUsername(username string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.Username == username && !m.userInBin(user.ID) {
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (m *Memory) SearchUsers(query string) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	var users []User
+	for _, user := range m.users {
+		if m.userInBin(user.ID) {
+			continue
+		}
+		if strings.Contains(strings.ToLower(user.Username), query) ||
+			strings.Contains(strings.ToLower(user.FullName), query) ||
+			strings.Contains(strings.ToLower(string(user.Role)), query) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return strings.ToLower(users[i].Username) < strings.ToLower(users[j].Username)
+	})
+	return users, nil
+}
+
+func (m *Memory) CountUsers() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.users) - len(m.bin[BinUsers]), nil
+}
+
+func (m *Memory) CreateUser(user User, passwordHash string) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.users {
+		if existing.Username == user.Username {
+			return user, errors.New("UNIQUE constraint failed: users.username")
+		}
+	}
+
+	m.nextUserID++
+	now := m.Now()
+	user.ID = m.nextUserID
+	user.Password = ""
+	user.Apartments = nil
+	user.CreatedAt = now.Format("2006-01-02 15:04:05")
+	user.PasswordChangedAt = now
+	user.Version = 1
+	user.UpdatedAt = now.Format(updatedAtFormat)
+	m.users[user.ID] = user
+	return user, nil
+}
+
+func (m *Memory) UpdateUser(user User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.users[user.ID]
+	if !ok || m.userInBin(user.ID) || existing.Version != user.Version {
+		return ErrConflict
+	}
+	existing.Username = user.Username
+	existing.FullName = user.FullName
+	existing.Role = user.Role
+	existing.Version++
+	existing.UpdatedAt = m.Now().Format(updatedAtFormat)
+	m.users[user.ID] = existing
+	return nil
+}
+
+func (m *Memory) SetUserDisabled(id int, disabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if user, ok := m.users[id]; ok {
+		user.Disabled = disabled
+		user.Version++
+		user.UpdatedAt = m.Now().Format(updatedAtFormat)
+		m.users[id] = user
+	}
+	return nil
+}
+
+func (m *Memory) UserApartments(userID int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.userApartments[m.society][userID]...), nil
+}
+
+func (m *Memory) SetUserApartments(userID int, apartmentIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := map[string]bool{}
+	var ids []string
+	for _, id := range apartmentIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if m.userApartments[m.society] == nil {
+		m.userApartments[m.society] = map[int][]string{}
+	}
+	m.userApartments[m.society][userID] = ids
+	return nil
+}
+
+func (m *Memory) ListSocieties() ([]Society, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	societies := make([]Society, 0, len(m.societies))
+	for _, society := range m.societies {
+		societies = append(societies, society)
+	}
+	sort.Slice(societies, func(i, j int) bool {
+		return strings.ToLower(societies[i].Name) < strings.ToLower(societies[j].Name)
+	})
+	return societies, nil
+}
+
+func (m *Memory) GetSociety(id int) (Society, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	society, ok := m.societies[id]
+	if !ok {
+		return Society{}, ErrNotFound
+	}
+	return society, nil
+}
+
+func (m *Memory) SaveSociety(society Society) (Society, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.societies {
+		if existing.ID != society.ID && existing.Name == society.Name {
+			return society, errors.New("UNIQUE constraint failed: societies.name")
+		}
+	}
+	if society.ID == 0 {
+		m.nextSocietyID++
+		society.ID = m.nextSocietyID
+		m.nextReceipt[society.ID] = 1
+	} else if _, ok := m.societies[society.ID]; !ok {
+		return society, ErrNotFound
+	}
+	m.societies[society.ID] = society
+	return society, nil
+}
+
+func (m *Memory) UserSocieties(userID int) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]int(nil), m.userSocieties[userID]...), nil
+}
+
+func (m *Memory) SetUserSocieties(userID int, societyIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := map[int]bool{}
+	var ids []int
+	for _, id := range societyIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	m.userSocieties[userID] = ids
+	return nil
+}
+
+func (m *Memory) GetApartment(id string) (Apartment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	apt, ok := m.apartments[scopedID{m.society, id}]
+	if !ok || m.inBin(BinApartments, id) {
+		return Apartment{}, ErrNotFound
+	}
+	return apt, nil
+}
+
+func (m *Memory) ListApartments(offset, limit int) ([]Apartment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	apartments := make([]Apartment, 0, len(m.apartments))
+	for key, apt := range m.apartments {
+		if key.society == m.society && !m.inBin(BinApartments, apt.ID) {
+			apartments = append(apartments, apt)
+		}
+	}
+	sort.Slice(apartments, func(i, j int) bool { return apartments[i].ID < apartments[j].ID })
+
+	if offset >= len(apartments) {
+		return nil, nil
+	}
+	apartments = apartments[offset:]
+	if limit > 0 && limit < len(apartments) {
+		apartments = apartments[:limit]
+	}
+	return apartments, nil
+}
+
+func (m *Memory) CountApartments() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for key := range m.apartments {
+		if key.society == m.society && !m.inBin(BinApartments, key.id) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *Memory) SaveApartments(apartments ...Apartment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Check every apartment against the stored rows, and those earlier in
+	// the batch, before saving any
+	now := m.Now().Format(updatedAtFormat)
+	saved := map[scopedID]Apartment{}
+	for _, apt := range apartments {
+		key := scopedID{m.society, apt.ID}
+		existing, ok := saved[key]
+		if !ok {
+			existing, ok = m.apartments[key]
+		}
+		if apt.Version == 0 && ok {
+			return ErrConflict
+		}
+		if apt.Version != 0 && (!ok || m.inBin(BinApartments, apt.ID) || existing.Version != apt.Version) {
+			return ErrConflict
+		}
+		apt.SocietyID = m.society
+		apt.ParkingSlots = append([]string(nil), apt.ParkingSlots...)
+		apt.Version++
+		apt.UpdatedAt = now
+		saved[key] = apt
+	}
+	for key, apt := range saved {
+		apt.OwnerSince = m.recordOccupancy(apt.ID, OccupancyOwner, apt.Owner, apt.OwnerSince)
+		apt.ResidentSince = m.recordOccupancy(apt.ID, OccupancyResident, apt.Resident, apt.ResidentSince)
+		m.apartments[key] = apt
+	}
+	return nil
+}
+
+// recordOccupancy brings the current period of a role in line with name, as
+// described on SaveApartments, and returns the date it starts
+func (m *Memory) recordOccupancy(apartmentID string, role OccupancyRole, name, since string) string {
+	current := -1
+	for i, o := range m.occupancy {
+		if o.SocietyID == m.society && o.ApartmentID == apartmentID && o.Role == role && o.To == "" {
+			current = i
+		}
+	}
+
+	start := func(from string) string {
+		m.nextOccupancyID++
+		m.occupancy = append(m.occupancy, Occupancy{
+			ID: m.nextOccupancyID, SocietyID: m.society, ApartmentID: apartmentID,
+			Role: role, Name: name, From: from,
+		})
+		return from
+	}
+
+	if current < 0 {
+		return start(since)
+	}
+	o := &m.occupancy[current]
+	switch {
+	case o.Name == name && (since == "" || since == o.From):
+		return o.From
+	case o.Name == name || since == o.From:
+		if since != o.From && o.From != "" {
+			for i, earlier := range m.occupancy {
+				if earlier.SocietyID == m.society && earlier.ApartmentID == apartmentID &&
+					earlier.Role == role && earlier.To == o.From {
+					m.occupancy[i].To = since
+				}
+			}
+		}
+		o.Name, o.From = name, since
+		return since
+	}
+	if since == "" {
+		since = m.Now().Format("2006-01-02")
+	}
+	o.To = since
+	return start(since)
+}
+
+func (m *Memory) OccupancyHistory(apartmentID string) ([]Occupancy, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var history []Occupancy
+	for _, o := range m.occupancy {
+		if o.SocietyID == m.society && o.ApartmentID == apartmentID {
+			history = append(history, o)
+		}
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		a, b := history[i], history[j]
+		if a.Role != b.Role {
+			return a.Role < b.Role
+		}
+		if (a.To == "") != (b.To == "") {
+			return b.To == ""
+		}
+		return a.From < b.From
+	})
+	return history, nil
+}
+
+func (m *Memory) MergeApartments(from, into, mergedBy string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	source, ok := m.apartments[scopedID{m.society, from}]
+	if !ok || m.inBin(BinApartments, from) {
+		return false, ErrNotFound
+	}
+	_, exists := m.apartments[scopedID{m.society, into}]
+	if exists && m.inBin(BinApartments, into) {
+		return false, ErrNotFound
+	}
+	if !exists {
+		m.copyApartment(source, into)
+	}
+
+	for i, c := range m.collections {
+		if c.SocietyID == m.society && c.ApartmentID == from {
+			m.collections[i].ApartmentID = into
+			if c.MergedFrom == "" {
+				m.collections[i].MergedFrom = from
+			}
+			key := m.key(BinCollections, strconv.Itoa(c.ID))
+			if entry, ok := m.bin[BinCollections][key]; ok {
+				entry.Summary = into + strings.TrimPrefix(entry.Summary, from)
+				entry.ApartmentID = into
+				m.bin[BinCollections][key] = entry
+			}
+		}
+	}
+
+	for userID, ids := range m.userApartments[m.society] {
+		seen := map[string]bool{}
+		var merged []string
+		for _, id := range ids {
+			if id == from {
+				id = into
+			}
+			if !seen[id] {
+				seen[id] = true
+				merged = append(merged, id)
+			}
+		}
+		sort.Strings(merged)
+		m.userApartments[m.society][userID] = merged
+	}
+
+	entry, err := m.describe(BinApartments, from)
+	if err != nil {
+		return false, err
+	}
+	entry.DeletedAt, _ = time.ParseInLocation(deletedAtFormat, m.Now().Format(deletedAtFormat), time.Local)
+	entry.DeletedBy = mergedBy
+	if m.bin[BinApartments] == nil {
+		m.bin[BinApartments] = map[scopedID]BinEntry{}
+	}
+	m.bin[BinApartments][m.key(BinApartments, from)] = entry
+	m.bumpVersion(BinApartments, from)
+	return !exists, nil
+}
+
+// copyApartment creates apartment into as a copy of from, moving its
+// parking slots across and copying its occupancy history and people
+func (m *Memory) copyApartment(from Apartment, into string) {
+	created := from
+	created.ID = into
+	created.ParkingSlots = append([]string(nil), from.ParkingSlots...)
+	created.Version = 1
+	created.UpdatedAt = m.Now().Format(updatedAtFormat)
+	m.apartments[scopedID{m.society, into}] = created
+	from.ParkingSlots = nil
+	m.apartments[scopedID{m.society, from.ID}] = from
+
+	for _, o := range m.occupancy {
+		if o.SocietyID == m.society && o.ApartmentID == from.ID {
+			m.nextOccupancyID++
+			o.ID = m.nextOccupancyID
+			o.ApartmentID = into
+			m.occupancy = append(m.occupancy, o)
+		}
+	}
+	for _, link := range m.personLinks[m.society] {
+		if link.ApartmentID == from.ID {
+			link.ApartmentID = into
+			m.personLinks[m.society] = append(m.personLinks[m.society], link)
+		}
+	}
+}
+
+func (m *Memory) AddCollection(c Collection) (Collection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.apartments[scopedID{m.society, c.ApartmentID}]; !ok {
+		return c, errors.New("FOREIGN KEY constraint failed")
+	}
+	if _, ok := m.societies[m.society]; !ok {
+		return c, ErrNotFound
+	}
+	m.nextCollectionID++
+	c.ID = m.nextCollectionID
+	c.SocietyID = m.society
+	c.ReceiptNumber = m.nextReceipt[m.society]
+	m.nextReceipt[m.society]++
+	c.Date = m.Now().Format("2006-01-02")
+	m.collections = append(m.collections, c)
+	return c, nil
+}
+
+func (m *Memory) GetCollection(id int) (Collection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range m.collections {
+		if c.ID == id && c.SocietyID == m.society && !m.inBin(BinCollections, strconv.Itoa(id)) {
+			return c, nil
+		}
+	}
+	return Collection{}, ErrNotFound
+}
+
+func (m *Memory) CollectionsForApartment(apartmentID string) ([]Collection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var collections []Collection
+	for i := len(m.collections) - 1; i >= 0; i-- {
+		c := m.collections[i]
+		if c.SocietyID == m.society && c.ApartmentID == apartmentID && !m.inBin(BinCollections, strconv.Itoa(c.ID)) {
+			collections = append(collections, c)
+		}
+	}
+	sort.SliceStable(collections, func(i, j int) bool { return collections[i].Date > collections[j].Date })
+	return collections, nil
+}
+
+func (m *Memory) CountCollections(apartmentID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, c := range m.collections {
+		if c.SocietyID == m.society && c.ApartmentID == apartmentID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *Memory) CollectedMonths(apartmentID string, year int, collectionType string) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix := strconv.Itoa(year) + "-"
+	months := map[string]bool{}
+	for _, c := range m.collections {
+		if c.SocietyID == m.society && c.ApartmentID == apartmentID && c.Type == collectionType &&
+			strings.HasPrefix(c.Date, prefix) &&
+			!m.inBin(BinCollections, strconv.Itoa(c.ID)) {
+			months[c.Month] = true
+		}
+	}
+	return months, nil
+}
+
+func (m *Memory) AddPayment(p Payment) (Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextPaymentID++
+	p.ID = m.nextPaymentID
+	p.SocietyID = m.society
+	p.Version = 1
+	p.UpdatedAt = m.Now().Format(updatedAtFormat)
+	p.Date = m.Now().Format("2006-01-02 15:04:05")
+	m.payments = append(m.payments, p)
+	return p, nil
+}
+
+func (m *Memory) GetPayment(id int) (Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.payments {
+		if p.ID == id && p.SocietyID == m.society && !m.inBin(BinPayments, strconv.Itoa(id)) {
+			return p, nil
+		}
+	}
+	return Payment{}, ErrNotFound
+}
+
+func (m *Memory) RecentPayments(limit int) ([]Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var payments []Payment
+	for i := len(m.payments) - 1; i >= 0 && len(payments) < limit; i-- {
+		p := m.payments[i]
+		if p.SocietyID == m.society && !m.inBin(BinPayments, strconv.Itoa(p.ID)) {
+			payments = append(payments, p)
+		}
+	}
+	return payments, nil
+}
+
+// describe builds the recycle bin entry for a row in normal use.
+// The caller must hold mu.
+func (m *Memory) describe(kind BinKind, id string) (BinEntry, error) {
+	entry := BinEntry{Kind: kind, ID: id}
+	if m.inBin(kind, id) {
+		return entry, ErrNotFound
+	}
+	switch kind {
+	case BinApartments:
+		apt, ok := m.apartments[scopedID{m.society, id}]
+		if !ok {
+			return entry, ErrNotFound
+		}
+		entry.Summary = apt.Owner + " / " + apt.Resident
+		return entry, nil
+	case BinUsers:
+		for _, user := range m.users {
+			if strconv.Itoa(user.ID) == id {
+				entry.Summary = user.Username + " (" + string(user.Role) + ")"
+				return entry, nil
+			}
+		}
+	case BinCollections:
+		for _, c := range m.collections {
+			if strconv.Itoa(c.ID) == id && c.SocietyID == m.society {
+				entry.Summary = c.ApartmentID + " " + c.Month + " " + c.Type
+				entry.ApartmentID = c.ApartmentID
+				entry.Amount = c.Price
+				return entry, nil
+			}
+		}
+	case BinPayments:
+		for _, p := range m.payments {
+			if strconv.Itoa(p.ID) == id && p.SocietyID == m.society {
+				entry.Summary = p.Month + " " + p.Type + " " + p.TransactionType
+				entry.Amount = p.Price
+				return entry, nil
+			}
+		}
+	default:
+		return entry, fmt.Errorf("unknown recycle bin kind %q", kind)
+	}
+	return entry, ErrNotFound
+}
+
+func (m *Memory) MoveToBin(kind BinKind, id string, deletedBy string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, err := m.describe(kind, id)
+	if err != nil {
+		return err
+	}
+	// Match the precision of the deleted_at column
+	entry.DeletedAt, _ = time.ParseInLocation(deletedAtFormat, m.Now().Format(deletedAtFormat), time.Local)
+	entry.DeletedBy = deletedBy
+	if m.bin[kind] == nil {
+		m.bin[kind] = map[scopedID]BinEntry{}
+	}
+	m.bin[kind][m.key(kind, id)] = entry
+	m.bumpVersion(kind, id)
+	return nil
+}
+
+func (m *Memory) Deleted(kind BinKind) ([]BinEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []BinEntry
+	for key, entry := range m.bin[kind] {
+		if key == m.key(kind, key.id) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].DeletedAt.Equal(entries[j].DeletedAt) {
+			return entries[i].DeletedAt.After(entries[j].DeletedAt)
+		}
+		return entries[i].ID > entries[j].ID
+	})
+	return entries, nil
+}
+
+func (m *Memory) DeletedEntry(kind BinKind, id string) (BinEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.bin[kind][m.key(kind, id)]
+	if !ok {
+		return BinEntry{}, ErrNotFound
+	}
+	return entry, nil
+}
+
+func (m *Memory) Restore(kind BinKind, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.inBin(kind, id) {
+		return ErrNotFound
+	}
+	delete(m.bin[kind], m.key(kind, id))
+	m.bumpVersion(kind, id)
+	return nil
+}
+
+// bumpVersion records moving a row in or out of the recycle bin as an edit,
+// as SQLite does for its versioned tables. The caller must hold mu.
+func (m *Memory) bumpVersion(kind BinKind, id string) {
+	switch kind {
+	case BinApartments:
+		key := scopedID{m.society, id}
+		apt := m.apartments[key]
+		apt.Version++
+		m.apartments[key] = apt
+	case BinUsers:
+		userID, _ := strconv.Atoi(id)
+		user := m.users[userID]
+		user.Version++
+		m.users[userID] = user
+	case BinPayments:
+		for i, p := range m.payments {
+			if strconv.Itoa(p.ID) == id && p.SocietyID == m.society {
+				m.payments[i].Version++
+			}
+		}
+	}
+}
+
+func (m *Memory) Purge(kind BinKind, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.inBin(kind, id) {
+		return ErrNotFound
+	}
+
+	switch kind {
+	case BinUsers:
+		userID, _ := strconv.Atoi(id)
+		delete(m.users, userID)
+		delete(m.userSocieties, userID)
+		for _, links := range m.userApartments {
+			delete(links, userID)
+		}
+	case BinApartments:
+		for _, c := range m.collections {
+			if c.SocietyID == m.society && c.ApartmentID == id {
+				return errors.New("FOREIGN KEY constraint failed")
+			}
+		}
+		delete(m.apartments, scopedID{m.society, id})
+		var history []Occupancy
+		for _, o := range m.occupancy {
+			if o.SocietyID != m.society || o.ApartmentID != id {
+				history = append(history, o)
+			}
+		}
+		m.occupancy = history
+		var people []PersonLink
+		for _, link := range m.personLinks[m.society] {
+			if link.ApartmentID != id {
+				people = append(people, link)
+			}
+		}
+		m.personLinks[m.society] = people
+		links := m.userApartments[m.society]
+		for userID, ids := range links {
+			var kept []string
+			for _, aptID := range ids {
+				if aptID != id {
+					kept = append(kept, aptID)
+				}
+			}
+			links[userID] = kept
+		}
+	case BinCollections:
+		for i, c := range m.collections {
+			if strconv.Itoa(c.ID) == id && c.SocietyID == m.society {
+				m.collections = append(m.collections[:i], m.collections[i+1:]...)
+				break
+			}
+		}
+	case BinPayments:
+		for i, p := range m.payments {
+			if strconv.Itoa(p.ID) == id && p.SocietyID == m.society {
+				m.payments = append(m.payments[:i], m.payments[i+1:]...)
+				break
+			}
+		}
+	}
+	delete(m.bin[kind], m.key(kind, id))
+	return nil
+}
+
+func (m *Memory) SearchPeople(query string) ([]Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query = strings.ToLower(strings.TrimSpace(query))
+	var people []Person
+	for _, p := range m.people {
+		if p.SocietyID != m.society {
+			continue
+		}
+		if strings.Contains(strings.ToLower(p.Name), query) ||
+			strings.Contains(strings.ToLower(p.Phone), query) ||
+			strings.Contains(strings.ToLower(p.Email), query) {
+			people = append(people, p)
+		}
+	}
+	sort.Slice(people, func(i, j int) bool {
+		a, b := strings.ToLower(people[i].Name), strings.ToLower(people[j].Name)
+		if a != b {
+			return a < b
+		}
+		return people[i].ID < people[j].ID
+	})
+	return people, nil
+}
+
+func (m *Memory) GetPerson(id int) (Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.people[id]
+	if !ok || p.SocietyID != m.society {
+		return Person{}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (m *Memory) SavePerson(p Person) (Person, error) {
+	saved, err := m.SavePeople(p)
+	if err != nil {
+		return p, err
+	}
+	return saved[0], nil
+}
+
+func (m *Memory) SavePeople(people ...Person) ([]Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Check every update before saving anyone
+	for _, p := range people {
+		if p.ID == 0 {
+			continue
+		}
+		existing, ok := m.people[p.ID]
+		if !ok || existing.SocietyID != m.society || existing.Version != p.Version {
+			return nil, ErrConflict
+		}
+	}
+
+	now := m.Now().Format(updatedAtFormat)
+	saved := make([]Person, len(people))
+	for i, p := range people {
+		p.SocietyID = m.society
+		p.UpdatedAt = now
+		if p.ID == 0 {
+			m.nextPersonID++
+			p.ID = m.nextPersonID
+		}
+		p.Version++
+		m.people[p.ID] = p
+		saved[i] = p
+	}
+	return saved, nil
+}
+
+func (m *Memory) DeletePerson(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if p, ok := m.people[id]; !ok || p.SocietyID != m.society {
+		return ErrNotFound
+	}
+	delete(m.people, id)
+	var links []PersonLink
+	for _, link := range m.personLinks[m.society] {
+		if link.PersonID != id {
+			links = append(links, link)
+		}
+	}
+	m.personLinks[m.society] = links
+	return nil
+}
+
+// linksWhere returns the society's links that match, with names filled in
+func (m *Memory) linksWhere(match func(PersonLink) bool) []PersonLink {
+	var links []PersonLink
+	for _, link := range m.personLinks[m.society] {
+		if match(link) {
+			link.PersonName = m.people[link.PersonID].Name
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+func (m *Memory) PersonLinks(personID int) ([]PersonLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	links := m.linksWhere(func(link PersonLink) bool { return link.PersonID == personID })
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].ApartmentID != links[j].ApartmentID {
+			return links[i].ApartmentID < links[j].ApartmentID
+		}
+		return links[i].Role < links[j].Role
+	})
+	return links, nil
+}
+
+func (m *Memory) ApartmentLinks(apartmentID string) ([]PersonLink, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	links := m.linksWhere(func(link PersonLink) bool { return link.ApartmentID == apartmentID })
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Role != links[j].Role {
+			return links[i].Role < links[j].Role
+		}
+		return strings.ToLower(links[i].PersonName) < strings.ToLower(links[j].PersonName)
+	})
+	return links, nil
+}
+
+func (m *Memory) SetApartmentLinks(apartmentID string, role LinkRole, personIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.apartments[scopedID{m.society, apartmentID}]; !ok {
+		return errors.New("FOREIGN KEY constraint failed")
+	}
+	keep := map[int]bool{}
+	for _, id := range personIDs {
+		if p, ok := m.people[id]; !ok || p.SocietyID != m.society {
+			return errors.New("FOREIGN KEY constraint failed")
+		}
+		keep[id] = true
+	}
+
+	var links []PersonLink
+	linked := map[int]bool{}
+	for _, link := range m.personLinks[m.society] {
+		if link.ApartmentID == apartmentID && link.Role == role {
+			if !keep[link.PersonID] {
+				continue
+			}
+			linked[link.PersonID] = true
+		}
+		links = append(links, link)
+	}
+	for _, id := range personIDs {
+		if !linked[id] {
+			linked[id] = true
+			links = append(links, PersonLink{PersonID: id, ApartmentID: apartmentID, Role: role})
+		}
+	}
+	m.personLinks[m.society] = links
+	return nil
+}
+
+func (m *Memory) SetOwnershipShares(apartmentID string, shares map[int]int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	links := append([]PersonLink(nil), m.personLinks[m.society]...)
+	for id, share := range shares {
+		found := false
+		for i, link := range links {
+			if link.ApartmentID == apartmentID && link.PersonID == id &&
+				(link.Role == LinkOwner || link.Role == LinkCoOwner) {
+				links[i].Share = share
+				found = true
+			}
+		}
+		if !found {
+			return ErrNotFound
+		}
+	}
+	m.personLinks[m.society] = links
+	return nil
+}
@@ -0,0 +1,603 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// busyTimeout is how long a connection waits for another process's write
+// lock before giving up with "database is locked"
+const busyTimeout = 5 * time.Second
+
+// OpenDatabase opens a SQLite database with foreign key enforcement turned on
+// for every connection in the pool. WAL mode lets readers carry on while
+// another copy of the application writes, and transactions take the write
+// lock when they begin so that waiting for it honours the busy timeout.
+func OpenDatabase(path string) (*sql.DB, error) {
+	return sql.Open("sqlite3", fmt.Sprintf("%s?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate",
+		path, busyTimeout.Milliseconds()))
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx so schema helpers can run
+// inside a migration transaction
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// Migration is one numbered schema change. Versions start at 1 and must be
+// consecutive; a database at user_version N has applied migrations 1..N.
+// Migrations written before versioning existed must stay idempotent because
+// older installations report version 0 whatever their actual schema.
+//
+// Migrations run with foreign keys off, as SQLite requires for rebuilding a
+// table, its only way to change a column. Rows orphaned before foreign keys
+// were enforced are then copied across instead of failing the migration;
+// they are logged and left for the integrity check to report.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *sql.Tx) error
+}
+
+// AppMigrations builds app.db: accounts, settings and the audit log
+var AppMigrations = []Migration{
+	{1, "create users, settings and audit log", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS users (
+			"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"username" TEXT UNIQUE,
+			"password" TEXT,
+			"role" TEXT NOT NULL DEFAULT 'admin'
+		);`)
+		if err != nil {
+			return err
+		}
+		// Users created before roles existed keep full access as admins
+		if err := addColumnIfMissing(tx, "users", "role", "TEXT NOT NULL DEFAULT 'admin'"); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS settings (
+			"key" TEXT PRIMARY KEY,
+			"value" TEXT NOT NULL
+		);`)
+		if err != nil {
+			return err
+		}
+		return createAuditTable(tx)
+	}},
+	{2, "track failed sign-ins and login history", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "users", "failed_attempts", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		if err := addColumnIfMissing(tx, "users", "locked_until", "TEXT"); err != nil {
+			return err
+		}
+		return createLoginHistoryTable(tx)
+	}},
+	{3, "add two-factor sign-in", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "users", "totp_secret", "TEXT"); err != nil {
+			return err
+		}
+		if err := addColumnIfMissing(tx, "users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		if err := addColumnIfMissing(tx, "users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		return createRecoveryCodesTable(tx)
+	}},
+	{4, "add password policy columns and history", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "users", "must_change_password", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		if err := addColumnIfMissing(tx, "users", "password_changed_at", "TEXT"); err != nil {
+			return err
+		}
+		// Start the expiry clock for accounts created before it existed
+		_, err := tx.Exec("UPDATE users SET password_changed_at = ? WHERE password_changed_at IS NULL",
+			time.Now().Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+		return createPasswordHistoryTable(tx)
+	}},
+	{5, "add user directory details", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "users", "full_name", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		if err := addColumnIfMissing(tx, "users", "disabled", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		if err := addColumnIfMissing(tx, "users", "created_at", "TEXT"); err != nil {
+			return err
+		}
+		return addColumnIfMissing(tx, "users", "last_login_at", "TEXT")
+	}},
+	{6, "link resident accounts to apartments", func(tx *sql.Tx) error {
+		return createUserApartmentsTable(tx)
+	}},
+	{7, "keep deleted accounts in a recycle bin", func(tx *sql.Tx) error {
+		return addRecycleBinColumns(tx, "users")
+	}},
+	{8, "assign users and apartment links to societies", func(tx *sql.Tx) error {
+		// Apartment IDs are only unique within a society, so the links are
+		// rebuilt with the society as part of the key. Everything that
+		// exists so far belongs to the default society.
+		statements := []string{
+			`CREATE TABLE user_apartments_scoped (
+				"user_id" INTEGER NOT NULL,
+				"society_id" INTEGER NOT NULL,
+				"apartment_id" TEXT NOT NULL,
+				PRIMARY KEY (user_id, society_id, apartment_id),
+				FOREIGN KEY (user_id) REFERENCES users (id)
+			);`,
+			fmt.Sprintf(`INSERT INTO user_apartments_scoped (user_id, society_id, apartment_id)
+			SELECT user_id, %d, apartment_id FROM user_apartments;`, DefaultSocietyID),
+			`DROP TABLE user_apartments;`,
+			`ALTER TABLE user_apartments_scoped RENAME TO user_apartments;`,
+			`CREATE TABLE user_societies (
+				"user_id" INTEGER NOT NULL,
+				"society_id" INTEGER NOT NULL,
+				PRIMARY KEY (user_id, society_id),
+				FOREIGN KEY (user_id) REFERENCES users (id)
+			);`,
+			fmt.Sprintf(`INSERT INTO user_societies (user_id, society_id) SELECT id, %d FROM users;`,
+				DefaultSocietyID),
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{9, "version users to detect conflicting edits", func(tx *sql.Tx) error {
+		return addVersionColumns(tx, "users")
+	}},
+}
+
+// ResidentMigrations builds resident.db: apartments, collections and payments
+var ResidentMigrations = []Migration{
+	{1, "create apartments, collections and payments", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS apartments (
+			"id" TEXT PRIMARY KEY,
+			"owner" TEXT NOT NULL,
+			"resident" TEXT NOT NULL,
+			"same_flag" INTEGER NOT NULL
+		);`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS collections (
+			"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"apartment_id" TEXT NOT NULL,
+			"month" TEXT NOT NULL,
+			"type" TEXT NOT NULL,
+			"price" REAL NOT NULL,
+			"date" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (apartment_id) REFERENCES apartments (id)
+		);`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS payments (
+			"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"month" TEXT NOT NULL,
+			"type" TEXT NOT NULL,
+			"price" REAL NOT NULL,
+			"transaction_type" TEXT NOT NULL,
+			"date" TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`)
+		return err
+	}},
+	{2, "store collection and payment amounts as integer paise", func(tx *sql.Tx) error {
+		// SQLite cannot change a column's type, so both tables are rebuilt
+		// with price holding whole paise instead of REAL rupees
+		statements := []string{
+			`CREATE TABLE collections_paise (
+				"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"apartment_id" TEXT NOT NULL,
+				"month" TEXT NOT NULL,
+				"type" TEXT NOT NULL,
+				"price" INTEGER NOT NULL,
+				"date" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				FOREIGN KEY (apartment_id) REFERENCES apartments (id)
+			);`,
+			`INSERT INTO collections_paise (id, apartment_id, month, type, price, date)
+			SELECT id, apartment_id, month, type, CAST(ROUND(price * 100) AS INTEGER), date FROM collections;`,
+			`DROP TABLE collections;`,
+			`ALTER TABLE collections_paise RENAME TO collections;`,
+			`CREATE TABLE payments_paise (
+				"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"month" TEXT NOT NULL,
+				"type" TEXT NOT NULL,
+				"price" INTEGER NOT NULL,
+				"transaction_type" TEXT NOT NULL,
+				"date" TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			);`,
+			`INSERT INTO payments_paise (id, month, type, price, transaction_type, date)
+			SELECT id, month, type, CAST(ROUND(price * 100) AS INTEGER), transaction_type, date FROM payments;`,
+			`DROP TABLE payments;`,
+			`ALTER TABLE payments_paise RENAME TO payments;`,
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{3, "keep deleted apartments and transactions in a recycle bin", func(tx *sql.Tx) error {
+		for _, table := range []string{"apartments", "collections", "payments"} {
+			if err := addRecycleBinColumns(tx, table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{4, "add societies and scope apartments, collections and payments", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE societies (
+			"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			"name" TEXT NOT NULL UNIQUE,
+			"address" TEXT NOT NULL DEFAULT '',
+			"registration_number" TEXT NOT NULL DEFAULT '',
+			"next_receipt_number" INTEGER NOT NULL DEFAULT 1
+		);`)
+		if err != nil {
+			return err
+		}
+		// Existing data becomes the default society; the application gives
+		// it the configured name and details once the migrations have run.
+		// Receipt numbers carry on from collection IDs so receipts already
+		// printed keep their numbers.
+		_, err = tx.Exec(
+			`INSERT INTO societies (id, name, next_receipt_number)
+			VALUES (?, ?, (SELECT COALESCE(MAX(id), 0) + 1 FROM collections))`,
+			DefaultSocietyID, DefaultSocietyName)
+		if err != nil {
+			return err
+		}
+
+		// Child tables are dropped before the apartments they refer to, and
+		// renaming apartments_scoped updates the reference in collections
+		statements := []string{
+			`CREATE TABLE apartments_scoped (
+				"society_id" INTEGER NOT NULL,
+				"id" TEXT NOT NULL,
+				"owner" TEXT NOT NULL,
+				"resident" TEXT NOT NULL,
+				"same_flag" INTEGER NOT NULL,
+				"deleted_at" TEXT,
+				"deleted_by" TEXT,
+				PRIMARY KEY (society_id, id),
+				FOREIGN KEY (society_id) REFERENCES societies (id)
+			);`,
+			fmt.Sprintf(`INSERT INTO apartments_scoped
+				(society_id, id, owner, resident, same_flag, deleted_at, deleted_by)
+			SELECT %d, id, owner, resident, same_flag, deleted_at, deleted_by FROM apartments;`,
+				DefaultSocietyID),
+			`CREATE TABLE collections_scoped (
+				"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"society_id" INTEGER NOT NULL,
+				"receipt_number" INTEGER NOT NULL,
+				"apartment_id" TEXT NOT NULL,
+				"month" TEXT NOT NULL,
+				"type" TEXT NOT NULL,
+				"price" INTEGER NOT NULL,
+				"date" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				"deleted_at" TEXT,
+				"deleted_by" TEXT,
+				UNIQUE (society_id, receipt_number),
+				FOREIGN KEY (society_id, apartment_id) REFERENCES apartments_scoped (society_id, id)
+			);`,
+			fmt.Sprintf(`INSERT INTO collections_scoped (id, society_id, receipt_number, apartment_id, month, type,
+				price, date, deleted_at, deleted_by)
+			SELECT id, %d, id, apartment_id, month, type, price, date, deleted_at, deleted_by FROM collections;`,
+				DefaultSocietyID),
+			`DROP TABLE collections;`,
+			`DROP TABLE apartments;`,
+			`ALTER TABLE apartments_scoped RENAME TO apartments;`,
+			`ALTER TABLE collections_scoped RENAME TO collections;`,
+			`CREATE TABLE payments_scoped (
+				"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"society_id" INTEGER NOT NULL,
+				"month" TEXT NOT NULL,
+				"type" TEXT NOT NULL,
+				"price" INTEGER NOT NULL,
+				"transaction_type" TEXT NOT NULL,
+				"date" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				"deleted_at" TEXT,
+				"deleted_by" TEXT,
+				FOREIGN KEY (society_id) REFERENCES societies (id)
+			);`,
+			fmt.Sprintf(`INSERT INTO payments_scoped (id, society_id, month, type, price, transaction_type, date,
+				deleted_at, deleted_by)
+			SELECT id, %d, month, type, price, transaction_type, date, deleted_at, deleted_by FROM payments;`,
+				DefaultSocietyID),
+			`DROP TABLE payments;`,
+			`ALTER TABLE payments_scoped RENAME TO payments;`,
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{5, "version apartments and payments to detect conflicting edits", func(tx *sql.Tx) error {
+		for _, table := range []string{"apartments", "payments"} {
+			if err := addVersionColumns(tx, table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{6, "describe apartment location, size and parking", func(tx *sql.Tx) error {
+		columns := []struct{ name, definition string }{
+			{"block", "TEXT NOT NULL DEFAULT ''"},
+			{"floor", "INTEGER NOT NULL DEFAULT 0"},
+			{"unit_type", "TEXT NOT NULL DEFAULT ''"},
+			{"carpet_area", "REAL NOT NULL DEFAULT 0"},
+			{"super_built_up_area", "REAL NOT NULL DEFAULT 0"},
+			// Comma separated; parking slot names never contain commas
+			{"parking_slots", "TEXT NOT NULL DEFAULT ''"},
+		}
+		for _, column := range columns {
+			if err := addColumnIfMissing(tx, "apartments", column.name, column.definition); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{7, "keep the history of owners and residents", func(tx *sql.Tx) error {
+		// Dates are YYYY-MM-DD; a blank effective_from is before history was
+		// kept and a blank effective_to is the current period. Today's owners
+		// and residents start out with one such open period each.
+		statements := []string{
+			`CREATE TABLE IF NOT EXISTS occupancy (
+				"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"society_id" INTEGER NOT NULL,
+				"apartment_id" TEXT NOT NULL,
+				"role" TEXT NOT NULL CHECK (role IN ('owner', 'resident')),
+				"name" TEXT NOT NULL,
+				"effective_from" TEXT NOT NULL DEFAULT '',
+				"effective_to" TEXT NOT NULL DEFAULT '',
+				FOREIGN KEY (society_id, apartment_id) REFERENCES apartments (society_id, id)
+			);`,
+			`CREATE INDEX IF NOT EXISTS occupancy_apartment ON occupancy (society_id, apartment_id, role);`,
+			`INSERT INTO occupancy (society_id, apartment_id, role, name)
+			SELECT society_id, id, 'owner', owner FROM apartments;`,
+			`INSERT INTO occupancy (society_id, apartment_id, role, name)
+			SELECT society_id, id, 'resident', resident FROM apartments;`,
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{8, "add a directory of people linked to apartments", func(tx *sql.Tx) error {
+		// Every distinct owner and resident name, ignoring case and outer
+		// spaces, becomes one person linked to each apartment it appears on.
+		// Vacant is what the service records as the resident of an empty flat.
+		statements := []string{
+			`CREATE TABLE IF NOT EXISTS people (
+				"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"society_id" INTEGER NOT NULL,
+				"name" TEXT NOT NULL,
+				"phone" TEXT NOT NULL DEFAULT '',
+				"email" TEXT NOT NULL DEFAULT '',
+				"alternate_contact" TEXT NOT NULL DEFAULT '',
+				"id_proof" TEXT NOT NULL DEFAULT '',
+				"version" INTEGER NOT NULL DEFAULT 1,
+				"updated_at" TEXT,
+				FOREIGN KEY (society_id) REFERENCES societies (id)
+			);`,
+			`CREATE TABLE IF NOT EXISTS apartment_people (
+				"society_id" INTEGER NOT NULL,
+				"apartment_id" TEXT NOT NULL,
+				"person_id" INTEGER NOT NULL,
+				"role" TEXT NOT NULL CHECK (role IN ('owner', 'co-owner', 'resident')),
+				PRIMARY KEY (society_id, apartment_id, role, person_id),
+				FOREIGN KEY (society_id, apartment_id) REFERENCES apartments (society_id, id),
+				FOREIGN KEY (person_id) REFERENCES people (id)
+			);`,
+			`CREATE INDEX IF NOT EXISTS apartment_people_person ON apartment_people (person_id);`,
+			`INSERT INTO people (society_id, name)
+			SELECT society_id, MIN(TRIM(name)) FROM (
+				SELECT society_id, owner AS name FROM apartments
+				UNION ALL
+				SELECT society_id, resident FROM apartments WHERE resident <> 'Vacant'
+			) WHERE TRIM(name) <> ''
+			GROUP BY society_id, LOWER(TRIM(name));`,
+			`INSERT INTO apartment_people (society_id, apartment_id, person_id, role)
+			SELECT a.society_id, a.id, p.id, 'owner' FROM apartments a
+			JOIN people p ON p.society_id = a.society_id AND LOWER(p.name) = LOWER(TRIM(a.owner));`,
+			`INSERT INTO apartment_people (society_id, apartment_id, person_id, role)
+			SELECT a.society_id, a.id, p.id, 'resident' FROM apartments a
+			JOIN people p ON p.society_id = a.society_id AND LOWER(p.name) = LOWER(TRIM(a.resident));`,
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{9, "record each owner's share of an apartment", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "apartment_people", "share", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		// Owners already linked split their apartment equally, the owner
+		// taking what is left over from the division
+		owners := `FROM apartment_people o WHERE o.society_id = apartment_people.society_id
+			AND o.apartment_id = apartment_people.apartment_id AND o.role IN ('owner', 'co-owner')`
+		statements := []string{
+			fmt.Sprintf(`UPDATE apartment_people SET share = %d / (SELECT COUNT(*) %s)
+			WHERE role IN ('owner', 'co-owner');`, FullShare, owners),
+			fmt.Sprintf(`UPDATE apartment_people SET share = share + %d - (SELECT SUM(o.share) %s)
+			WHERE role = 'owner';`, FullShare, owners),
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{10, "let each society set the pattern of its apartment IDs", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "societies", "id_pattern", "TEXT NOT NULL DEFAULT ''")
+	}},
+	{11, "remember the apartment a merged collection was received for", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "collections", "merged_from", "TEXT NOT NULL DEFAULT ''")
+	}},
+}
+
+// addRecycleBinColumns adds the columns marking a row as deleted; rows with
+// deleted_at set are hidden from normal use until restored or purged
+func addRecycleBinColumns(db execer, table string) error {
+	if err := addColumnIfMissing(db, table, "deleted_at", "TEXT"); err != nil {
+		return err
+	}
+	return addColumnIfMissing(db, table, "deleted_by", "TEXT")
+}
+
+// addVersionColumns adds the columns used for optimistic locking: version is
+// bumped by every edit, which only applies if the row is still at the
+// version the editor read
+func addVersionColumns(db execer, table string) error {
+	if err := addColumnIfMissing(db, table, "version", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	return addColumnIfMissing(db, table, "updated_at", "TEXT")
+}
+
+// SchemaVersion returns the migration version recorded in the database
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// Migrate brings the database at path up to the latest migration. Existing
+// data is backed up before the first pending migration runs, and each
+// migration commits together with its version so a failure leaves the
+// database at the last good version.
+func Migrate(db *sql.DB, path string, migrations []Migration) error {
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version of %s: %w", path, err)
+	}
+
+	latest := len(migrations)
+	if current > latest {
+		return fmt.Errorf("%s is at schema version %d but this build only supports version %d; "+
+			"install a newer version of the application", path, current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	backup, err := backupBeforeMigration(db, path, current)
+	if err != nil {
+		return fmt.Errorf("failed to back up %s before migrating: %w", path, err)
+	}
+	if backup != "" {
+		log.Printf("Backed up %s to %s", path, backup)
+	}
+
+	for _, m := range migrations[current:] {
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("%s migration %d (%s) failed: %w", path, m.Version, m.Description, err)
+		}
+		log.Printf("Applied %s migration %d: %s", path, m.Version, m.Description)
+	}
+	return logForeignKeyViolations(db, path)
+}
+
+func applyMigration(db *sql.DB, m Migration) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// foreign_keys cannot change inside a transaction, so it is turned off
+	// on this connection beforehand and back on before the connection
+	// returns to the pool
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = ON"); err != nil {
+			log.Println("Error turning foreign keys back on:", err)
+			// Discard the connection rather than reuse it unenforced
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rolling back after a commit does nothing; it releases the connection
+	// should the migration fail or panic
+	defer tx.Rollback()
+
+	if err := m.Up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.Version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// logForeignKeyViolations logs the rows the migrations carried across that
+// refer to a missing parent row
+func logForeignKeyViolations(db *sql.DB, path string) error {
+	rows, err := db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var fkID int
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return err
+		}
+		log.Printf("%s: %s row %d refers to a missing row in %s; the integrity check lists it",
+			path, table, rowID.Int64, parent)
+	}
+	return rows.Err()
+}
+
+// backupBeforeMigration writes a consistent copy of the database next to it
+// and returns its path. A new, empty database is not backed up.
+func backupBeforeMigration(db *sql.DB, path string, version int) (string, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && info.Size() == 0) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	backup := fmt.Sprintf("%s.v%d-%s.bak", path, version, time.Now().Format("20060102-150405"))
+	if _, err := db.Exec("VACUUM INTO ?", backup); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
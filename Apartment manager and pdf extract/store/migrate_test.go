@@ -0,0 +1,366 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openTestDB opens an unmigrated database file in a temporary directory
+func openTestDB(t *testing.T, name string) (*sql.DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, path
+}
+
+// mustExec runs statements or fails the test
+func mustExec(t *testing.T, db *sql.DB, statements ...string) {
+	t.Helper()
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+}
+
+// mustSchemaVersion fails the test unless db is at version want
+func mustSchemaVersion(t *testing.T, db *sql.DB, want int) {
+	t.Helper()
+	got, err := SchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("schema version %d, want %d", got, want)
+	}
+}
+
+// backups lists the pre-migration backups written beside path
+func backups(t *testing.T, path string) []string {
+	t.Helper()
+	found, err := filepath.Glob(path + ".v*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestMigrateNewDatabase(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		migrations []Migration
+	}{
+		{"app.db", AppMigrations},
+		{"resident.db", ResidentMigrations},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db, path := openTestDB(t, tt.name)
+			// The second run has nothing left to do
+			for run := 1; run <= 2; run++ {
+				if err := Migrate(db, path, tt.migrations); err != nil {
+					t.Fatalf("run %d: %v", run, err)
+				}
+			}
+			mustSchemaVersion(t, db, len(tt.migrations))
+			if found := backups(t, path); len(found) != 0 {
+				t.Errorf("a new database was backed up to %v", found)
+			}
+		})
+	}
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	// An app.db written before versioning, or roles, existed
+	db, path := openTestDB(t, "app.db")
+	mustExec(t, db,
+		`CREATE TABLE users ("id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, "username" TEXT UNIQUE, "password" TEXT);`,
+		`INSERT INTO users (username, password) VALUES ('admin', 'secret');`,
+	)
+
+	if err := Migrate(db, path, AppMigrations); err != nil {
+		t.Fatal(err)
+	}
+	mustSchemaVersion(t, db, len(AppMigrations))
+
+	var role, password string
+	if err := db.QueryRow("SELECT role, password FROM users WHERE username = 'admin'").Scan(&role, &password); err != nil {
+		t.Fatal(err)
+	}
+	if role != "admin" || password != "secret" {
+		t.Errorf("got role %q and password %q, want the account kept as an admin", role, password)
+	}
+
+	found := backups(t, path)
+	if len(found) != 1 || !strings.Contains(found[0], ".v0-") {
+		t.Fatalf("got backups %v, want one of version 0", found)
+	}
+	backup, err := sql.Open("sqlite3", found[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	var count int
+	if err := backup.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil || count != 1 {
+		t.Errorf("backup holds %d user(s), %v; want 1", count, err)
+	}
+	mustSchemaVersion(t, backup, 0)
+}
+
+func TestMigrateNewerDatabase(t *testing.T) {
+	db, path := openTestDB(t, "app.db")
+	mustExec(t, db, "CREATE TABLE t (x);", "PRAGMA user_version = 99")
+	err := Migrate(db, path, AppMigrations)
+	if err == nil || !strings.Contains(err.Error(), "install a newer version") {
+		t.Fatalf("got error %v, want a request for a newer version", err)
+	}
+	mustSchemaVersion(t, db, 99)
+}
+
+func TestMigrateFailureKeepsLastGoodVersion(t *testing.T) {
+	failed := errors.New("disk on fire")
+	migrations := []Migration{
+		{1, "create t", func(tx *sql.Tx) error {
+			_, err := tx.Exec("CREATE TABLE t (x INTEGER)")
+			return err
+		}},
+		{2, "half done", func(tx *sql.Tx) error {
+			if _, err := tx.Exec("INSERT INTO t VALUES (1)"); err != nil {
+				return err
+			}
+			return failed
+		}},
+	}
+
+	db, path := openTestDB(t, "test.db")
+	err := Migrate(db, path, migrations)
+	if !errors.Is(err, failed) || !strings.Contains(err.Error(), "migration 2 (half done)") {
+		t.Fatalf("got error %v, want migration 2 to fail", err)
+	}
+	mustSchemaVersion(t, db, 1)
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM t").Scan(&count); err != nil || count != 0 {
+		t.Errorf("table t holds %d row(s), %v; want the failed migration rolled back", count, err)
+	}
+}
+
+func TestMigrateAmountsToPaise(t *testing.T) {
+	db, path := openTestDB(t, "resident.db")
+	if err := Migrate(db, path, ResidentMigrations[:1]); err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, db,
+		`INSERT INTO apartments (id, owner, resident, same_flag) VALUES ('A-101', 'Asha', 'Asha', 1);`,
+		`INSERT INTO collections (id, apartment_id, month, type, price, date) VALUES
+			(1, 'A-101', 'April', 'Maintenance', 4000, '2024-04-05 10:00:00'),
+			(2, 'A-101', 'May', 'Maintenance', 4000.5, '2024-05-05 10:00:00'),
+			(3, 'A-101', 'May', 'Parking', 0.1 + 0.2, '2024-05-06 10:00:00'),
+			(4, 'A-101', 'June', 'Repairs', 1234.567, '2024-06-01 10:00:00');`,
+		`INSERT INTO payments (id, month, type, price, transaction_type, date) VALUES
+			(7, 'April', 'Electricity', 1999.99, 'Debit', '2024-04-30 10:00:00');`,
+	)
+
+	if err := Migrate(db, path, ResidentMigrations[:2]); err != nil {
+		t.Fatal(err)
+	}
+	mustSchemaVersion(t, db, 2)
+
+	want := map[int]int64{1: 400000, 2: 400050, 3: 30, 4: 123457}
+	rows, err := db.Query("SELECT id, price, typeof(price), date FROM collections")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id          int
+			price       int64
+			kind, stamp string
+		)
+		if err := rows.Scan(&id, &price, &kind, &stamp); err != nil {
+			t.Fatal(err)
+		}
+		if price != want[id] || kind != "integer" {
+			t.Errorf("collection %d: price %d (%s), want %d paise", id, price, kind, want[id])
+		}
+		if stamp == "" {
+			t.Errorf("collection %d lost its date", id)
+		}
+		delete(want, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 0 {
+		t.Errorf("collections %v were lost", want)
+	}
+
+	var price int64
+	if err := db.QueryRow("SELECT price FROM payments WHERE id = 7").Scan(&price); err != nil || price != 199999 {
+		t.Errorf("payment price %d, %v; want 199999 paise", price, err)
+	}
+}
+
+func TestMigrateToSocieties(t *testing.T) {
+	resident, residentPath := openTestDB(t, "resident.db")
+	if err := Migrate(resident, residentPath, ResidentMigrations[:3]); err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, resident,
+		`INSERT INTO apartments (id, owner, resident, same_flag) VALUES
+			('A-101', 'Asha', 'Asha', 1),
+			('A-102', 'Ravi', 'Vacant', 0);`,
+		`UPDATE apartments SET deleted_at = '2024-05-01T10:00:00Z', deleted_by = 'admin' WHERE id = 'A-102';`,
+		`INSERT INTO collections (id, apartment_id, month, type, price) VALUES
+			(5, 'A-101', 'April', 'Maintenance', 400000),
+			(9, 'A-102', 'April', 'Maintenance', 400000);`,
+		`INSERT INTO payments (id, month, type, price, transaction_type) VALUES
+			(3, 'April', 'Security', 1500000, 'Expense');`,
+	)
+
+	app, appPath := openTestDB(t, "app.db")
+	if err := Migrate(app, appPath, AppMigrations[:7]); err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, app,
+		`INSERT INTO users (id, username, password) VALUES (1, 'admin', 'x'), (2, 'asha', 'x');`,
+		`INSERT INTO user_apartments (user_id, apartment_id) VALUES (2, 'A-101');`,
+	)
+
+	if err := Migrate(resident, residentPath, ResidentMigrations[:4]); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(app, appPath, AppMigrations[:8]); err != nil {
+		t.Fatal(err)
+	}
+
+	var name string
+	var next int
+	err := resident.QueryRow("SELECT name, next_receipt_number FROM societies WHERE id = ?",
+		DefaultSocietyID).Scan(&name, &next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != DefaultSocietyName || next != 10 {
+		t.Errorf("default society %q with next receipt %d, want %q and 10", name, next, DefaultSocietyName)
+	}
+
+	counts := []struct {
+		query string
+		want  int
+	}{
+		{"SELECT COUNT(*) FROM apartments WHERE society_id = 1", 2},
+		{"SELECT COUNT(*) FROM apartments WHERE id = 'A-102' AND deleted_by = 'admin'", 1},
+		{"SELECT COUNT(*) FROM collections WHERE society_id = 1 AND receipt_number = id", 2},
+		{"SELECT COUNT(*) FROM payments WHERE society_id = 1 AND id = 3", 1},
+	}
+	for _, c := range counts {
+		var got int
+		if err := resident.QueryRow(c.query).Scan(&got); err != nil || got != c.want {
+			t.Errorf("%s = %d, %v; want %d", c.query, got, err, c.want)
+		}
+	}
+	var violations int
+	if err := resident.QueryRow("SELECT COUNT(*) FROM pragma_foreign_key_check").Scan(&violations); err != nil ||
+		violations != 0 {
+		t.Errorf("%d foreign key violation(s), %v", violations, err)
+	}
+
+	var society int
+	var apartment string
+	if err := app.QueryRow("SELECT society_id, apartment_id FROM user_apartments WHERE user_id = 2").
+		Scan(&society, &apartment); err != nil || society != DefaultSocietyID || apartment != "A-101" {
+		t.Errorf("link moved to society %d apartment %q, %v", society, apartment, err)
+	}
+	var assigned int
+	if err := app.QueryRow("SELECT COUNT(*) FROM user_societies WHERE society_id = 1").Scan(&assigned); err != nil ||
+		assigned != 2 {
+		t.Errorf("%d user(s) assigned to the default society, %v; want 2", assigned, err)
+	}
+}
+
+func TestMigrateToPeople(t *testing.T) {
+	db, path := openTestDB(t, "resident.db")
+	if err := Migrate(db, path, ResidentMigrations[:7]); err != nil {
+		t.Fatal(err)
+	}
+	mustExec(t, db,
+		`INSERT INTO apartments (society_id, id, owner, resident, same_flag) VALUES
+			(1, 'A-101', 'Asha Rao', 'Ravi', 0),
+			(1, 'A-102', ' asha rao ', 'Vacant', 0),
+			(1, 'A-103', 'Meena', 'Meena', 1);`,
+	)
+	if err := Migrate(db, path, ResidentMigrations[:8]); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query(`SELECT p.name, ap.apartment_id, ap.role FROM apartment_people ap
+		JOIN people p ON p.id = ap.person_id ORDER BY p.name, ap.apartment_id, ap.role`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var name, apartment, role string
+		if err := rows.Scan(&name, &apartment, &role); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, name+" "+apartment+" "+role)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"Asha Rao A-101 owner",
+		"Asha Rao A-102 owner",
+		"Meena A-103 owner",
+		"Meena A-103 resident",
+		"Ravi A-101 resident",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("links\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMigrateKeepsOrphanedRows(t *testing.T) {
+	db, path := openTestDB(t, "resident.db")
+	if err := Migrate(db, path, ResidentMigrations[:3]); err != nil {
+		t.Fatal(err)
+	}
+	// Written before foreign keys were enforced
+	db.SetMaxOpenConns(1)
+	mustExec(t, db,
+		`PRAGMA foreign_keys = OFF;`,
+		`INSERT INTO apartments (id, owner, resident, same_flag) VALUES ('A-101', 'Asha', 'Asha', 1);`,
+		`INSERT INTO collections (id, apartment_id, month, type, price) VALUES
+			(1, 'A-101', 'April', 'Maintenance', 400000),
+			(2, 'GONE', 'April', 'Maintenance', 400000);`,
+	)
+	db.Close()
+
+	db, err := OpenDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := Migrate(db, path, ResidentMigrations); err != nil {
+		t.Fatal(err)
+	}
+	mustSchemaVersion(t, db, len(ResidentMigrations))
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM collections").Scan(&count); err != nil || count != 2 {
+		t.Errorf("%d collection(s) after migrating (%v), want both", count, err)
+	}
+	var enforced bool
+	if err := db.QueryRow("PRAGMA foreign_keys").Scan(&enforced); err != nil || !enforced {
+		t.Errorf("foreign keys enforced = %v (%v) after migrating, want true", enforced, err)
+	}
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Tables created by the app.db migrations. Each helper is idempotent so
+// migrations written before versioning existed can call it again safely.
+
+// addColumnIfMissing adds a column to an existing table created by an older version
+func addColumnIfMissing(db execer, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// createAuditTable creates the audit table and the triggers that make it append-only
+func createAuditTable(db execer) error {
+	createAuditLogTable := `CREATE TABLE IF NOT EXISTS audit_log (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"timestamp" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		"actor" TEXT NOT NULL,
+		"entity" TEXT NOT NULL,
+		"entity_id" TEXT NOT NULL,
+		"action" TEXT NOT NULL,
+		"before" TEXT,
+		"after" TEXT
+	);`
+
+	if _, err := db.Exec(createAuditLogTable); err != nil {
+		return err
+	}
+
+	triggers := []string{
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_update
+		BEFORE UPDATE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'audit log is append-only');
+		END;`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete
+		BEFORE DELETE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'audit log is append-only');
+		END;`,
+	}
+	for _, trigger := range triggers {
+		if _, err := db.Exec(trigger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createLoginHistoryTable creates the table recording every sign-in attempt
+func createLoginHistoryTable(db execer) error {
+	createLoginHistoryTable := `CREATE TABLE IF NOT EXISTS login_history (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"username" TEXT NOT NULL,
+		"success" INTEGER NOT NULL,
+		"reason" TEXT NOT NULL DEFAULT '',
+		"timestamp" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	_, err := db.Exec(createLoginHistoryTable)
+	return err
+}
+
+// createRecoveryCodesTable creates the table of one-time recovery codes
+func createRecoveryCodesTable(db execer) error {
+	createRecoveryCodesTable := `CREATE TABLE IF NOT EXISTS recovery_codes (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"user_id" INTEGER NOT NULL,
+		"code_hash" TEXT NOT NULL,
+		"used_at" TEXT,
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	);`
+
+	_, err := db.Exec(createRecoveryCodesTable)
+	return err
+}
+
+// createPasswordHistoryTable creates the table of previous password hashes
+func createPasswordHistoryTable(db execer) error {
+	createPasswordHistoryTable := `CREATE TABLE IF NOT EXISTS password_history (
+		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		"user_id" INTEGER NOT NULL,
+		"password" TEXT NOT NULL,
+		"changed_at" TEXT NOT NULL,
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	);`
+
+	_, err := db.Exec(createPasswordHistoryTable)
+	return err
+}
+
+// createUserApartmentsTable creates the link between resident accounts and
+// the apartments they may see
+func createUserApartmentsTable(db execer) error {
+	createUserApartmentsTable := `CREATE TABLE IF NOT EXISTS user_apartments (
+		"user_id" INTEGER NOT NULL,
+		"apartment_id" TEXT NOT NULL,
+		PRIMARY KEY (user_id, apartment_id),
+		FOREIGN KEY (user_id) REFERENCES users (id)
+	);`
+
+	_, err := db.Exec(createUserApartmentsTable)
+	return err
+}
@@ -0,0 +1,1148 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLite implements Store on top of app.db (accounts) and resident.db
+// (societies, apartments, collections and payments)
+type SQLite struct {
+	app      *sql.DB
+	resident *sql.DB
+	society  int
+}
+
+// NewSQLite returns a store using already opened and migrated databases,
+// scoped to DefaultSocietyID
+func NewSQLite(appDB, residentDB *sql.DB) *SQLite {
+	return &SQLite{app: appDB, resident: residentDB, society: DefaultSocietyID}
+}
+
+func (s *SQLite) SocietyID() int {
+	return s.society
+}
+
+func (s *SQLite) InSociety(id int) Store {
+	scoped := *s
+	scoped.society = id
+	return &scoped
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// notFound maps sql.ErrNoRows to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// likeEscaper escapes the LIKE wildcards, and the escape character itself,
+// so search text matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ContainsPattern turns search text into a LIKE pattern matching it anywhere.
+// Use it with ESCAPE '\'.
+func ContainsPattern(query string) string {
+	return "%" + likeEscaper.Replace(query) + "%"
+}
+
+// parseTime converts an RFC 3339 column to a time; empty or invalid values
+// give the zero time
+func parseTime(value sql.NullString) time.Time {
+	if !value.Valid || value.String == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value.String)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// userColumns lists the users columns read by scanUser, in order.
+// The password hash is deliberately not part of it.
+const userColumns = `id, username, full_name, role, disabled, failed_attempts, locked_until,
+	totp_enabled, must_change_password, password_changed_at,
+	COALESCE(created_at, ''), COALESCE(last_login_at, ''), version, COALESCE(updated_at, '')`
+
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	var lockedUntil, passwordChangedAt sql.NullString
+	err := row.Scan(&user.ID, &user.Username, &user.FullName, &user.Role, &user.Disabled,
+		&user.FailedAttempts, &lockedUntil, &user.TOTPEnabled, &user.MustChangePassword,
+		&passwordChangedAt, &user.CreatedAt, &user.LastLoginAt, &user.Version, &user.UpdatedAt)
+	if err != nil {
+		return user, notFound(err)
+	}
+	user.LockedUntil = parseTime(lockedUntil)
+	user.PasswordChangedAt = parseTime(passwordChangedAt)
+	return user, nil
+}
+
+func (s *SQLite) GetUser(id int) (User, error) {
+	return scanUser(s.app.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE id = ? AND deleted_at IS NULL", id))
+}
+
+func (s *SQLite) GetUserByUsername(username string) (User, error) {
+	return scanUser(s.app.QueryRow(
+		"SELECT "+userColumns+" FROM users WHERE username = ? AND deleted_at IS NULL", username))
+}
+
+func (s *SQLite) SearchUsers(query string) ([]User, error) {
+	pattern := ContainsPattern(strings.TrimSpace(query))
+	rows, err := s.app.Query(
+		"SELECT "+userColumns+` FROM users
+		WHERE deleted_at IS NULL
+		AND (username LIKE ? ESCAPE '\' OR full_name LIKE ? ESCAPE '\' OR role LIKE ? ESCAPE '\')
+		ORDER BY username COLLATE NOCASE`,
+		pattern, pattern, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (s *SQLite) CountUsers() (int, error) {
+	var count int
+	err := s.app.QueryRow("SELECT COUNT(*) FROM users WHERE deleted_at IS NULL").Scan(&count)
+	return count, err
+}
+
+func (s *SQLite) CreateUser(user User, passwordHash string) (User, error) {
+	now := time.Now()
+	result, err := s.app.Exec(
+		`INSERT INTO users (username, full_name, password, role, must_change_password,
+		password_changed_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		user.Username, user.FullName, passwordHash, user.Role, boolToInt(user.MustChangePassword),
+		now.Format(time.RFC3339), now.Format("2006-01-02 15:04:05"), now.Format(updatedAtFormat),
+	)
+	if err != nil {
+		return user, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return user, err
+	}
+	return s.GetUser(int(id))
+}
+
+func (s *SQLite) UpdateUser(user User) error {
+	return conflicted(s.app.Exec(
+		`UPDATE users SET username = ?, full_name = ?, role = ?, version = version + 1, updated_at = ?
+		WHERE id = ? AND version = ? AND deleted_at IS NULL`,
+		user.Username, user.FullName, user.Role, time.Now().Format(updatedAtFormat), user.ID, user.Version,
+	))
+}
+
+func (s *SQLite) SetUserDisabled(id int, disabled bool) error {
+	_, err := s.app.Exec("UPDATE users SET disabled = ?, version = version + 1, updated_at = ? WHERE id = ?",
+		boolToInt(disabled), time.Now().Format(updatedAtFormat), id)
+	return err
+}
+
+func (s *SQLite) UserApartments(userID int) ([]string, error) {
+	rows, err := s.app.Query(
+		`SELECT apartment_id FROM user_apartments WHERE user_id = ? AND society_id = ?
+		ORDER BY apartment_id`, userID, s.society)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQLite) SetUserApartments(userID int, apartmentIDs []string) error {
+	tx, err := s.app.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM user_apartments WHERE user_id = ? AND society_id = ?", userID, s.society)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, id := range apartmentIDs {
+		_, err := tx.Exec(
+			"INSERT OR IGNORE INTO user_apartments (user_id, society_id, apartment_id) VALUES (?, ?, ?)",
+			userID, s.society, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const apartmentColumns = `society_id, id, owner, resident, same_flag, block, floor, unit_type,
+	carpet_area, super_built_up_area, parking_slots, version, COALESCE(updated_at, ''),
+	COALESCE((SELECT effective_from ` + currentOccupancy + ` AND role = 'owner'), ''),
+	COALESCE((SELECT effective_from ` + currentOccupancy + ` AND role = 'resident'), '')`
+
+// currentOccupancy selects the open occupancy periods of the apartment in
+// the enclosing query
+const currentOccupancy = `FROM occupancy WHERE occupancy.society_id = apartments.society_id
+	AND occupancy.apartment_id = apartments.id AND effective_to = ''`
+
+func scanApartment(row rowScanner) (Apartment, error) {
+	var apt Apartment
+	var parkingSlots string
+	err := row.Scan(&apt.SocietyID, &apt.ID, &apt.Owner, &apt.Resident, &apt.SameFlag, &apt.Block, &apt.Floor,
+		&apt.UnitType, &apt.CarpetArea, &apt.SuperBuiltUpArea, &parkingSlots, &apt.Version, &apt.UpdatedAt,
+		&apt.OwnerSince, &apt.ResidentSince)
+	if parkingSlots != "" {
+		apt.ParkingSlots = strings.Split(parkingSlots, ",")
+	}
+	return apt, notFound(err)
+}
+
+func (s *SQLite) GetApartment(id string) (Apartment, error) {
+	return scanApartment(s.resident.QueryRow(
+		"SELECT "+apartmentColumns+" FROM apartments WHERE society_id = ? AND id = ? AND deleted_at IS NULL",
+		s.society, id))
+}
+
+func (s *SQLite) ListApartments(offset, limit int) ([]Apartment, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := s.resident.Query(
+		"SELECT "+apartmentColumns+` FROM apartments WHERE society_id = ? AND deleted_at IS NULL
+		ORDER BY id LIMIT ? OFFSET ?`, s.society, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var apartments []Apartment
+	for rows.Next() {
+		apt, err := scanApartment(rows)
+		if err != nil {
+			return nil, err
+		}
+		apartments = append(apartments, apt)
+	}
+	return apartments, rows.Err()
+}
+
+func (s *SQLite) CountApartments() (int, error) {
+	var count int
+	err := s.resident.QueryRow(
+		"SELECT COUNT(*) FROM apartments WHERE society_id = ? AND deleted_at IS NULL", s.society).Scan(&count)
+	return count, err
+}
+
+func (s *SQLite) SaveApartments(apartments ...Apartment) error {
+	tx, err := s.resident.Begin()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Format(updatedAtFormat)
+	for _, apt := range apartments {
+		// A new apartment whose ID is already taken, or an update to a row
+		// that moved on from apt.Version, changes nothing
+		var result sql.Result
+		if apt.Version == 0 {
+			result, err = tx.Exec(
+				`INSERT INTO apartments (society_id, id, owner, resident, same_flag, block, floor, unit_type,
+					carpet_area, super_built_up_area, parking_slots, updated_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(society_id, id) DO NOTHING`,
+				s.society, apt.ID, apt.Owner, apt.Resident, boolToInt(apt.SameFlag), apt.Block, apt.Floor,
+				apt.UnitType, apt.CarpetArea, apt.SuperBuiltUpArea, strings.Join(apt.ParkingSlots, ","), now,
+			)
+		} else {
+			result, err = tx.Exec(
+				`UPDATE apartments SET owner = ?, resident = ?, same_flag = ?, block = ?, floor = ?,
+					unit_type = ?, carpet_area = ?, super_built_up_area = ?, parking_slots = ?,
+					version = version + 1, updated_at = ?
+				WHERE society_id = ? AND id = ? AND version = ? AND deleted_at IS NULL`,
+				apt.Owner, apt.Resident, boolToInt(apt.SameFlag), apt.Block, apt.Floor, apt.UnitType,
+				apt.CarpetArea, apt.SuperBuiltUpArea, strings.Join(apt.ParkingSlots, ","), now,
+				s.society, apt.ID, apt.Version,
+			)
+		}
+		if err := conflicted(result, err); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := s.recordOccupancy(tx, apt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// recordOccupancy brings the current occupancy periods of apt in line with
+// its owner and resident, as described on SaveApartments
+func (s *SQLite) recordOccupancy(tx *sql.Tx, apt Apartment) error {
+	today := time.Now().Format("2006-01-02")
+	for _, role := range []OccupancyRole{OccupancyOwner, OccupancyResident} {
+		name, since := apt.Owner, apt.OwnerSince
+		if role == OccupancyResident {
+			name, since = apt.Resident, apt.ResidentSince
+		}
+
+		var current Occupancy
+		err := tx.QueryRow(
+			`SELECT id, name, effective_from FROM occupancy
+			WHERE society_id = ? AND apartment_id = ? AND role = ? AND effective_to = ''`,
+			s.society, apt.ID, role,
+		).Scan(&current.ID, &current.Name, &current.From)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			_, err = tx.Exec(
+				"INSERT INTO occupancy (society_id, apartment_id, role, name, effective_from) VALUES (?, ?, ?, ?, ?)",
+				s.society, apt.ID, role, name, since)
+		case err != nil:
+		case current.Name == name && (since == "" || since == current.From):
+			// Nothing changed
+		case current.Name == name || since == current.From:
+			// The current period is corrected rather than ended, and the
+			// period before it still ends where this one starts
+			if since != current.From && current.From != "" {
+				_, err = tx.Exec(
+					`UPDATE occupancy SET effective_to = ?
+					WHERE society_id = ? AND apartment_id = ? AND role = ? AND effective_to = ?`,
+					since, s.society, apt.ID, role, current.From)
+				if err != nil {
+					return err
+				}
+			}
+			_, err = tx.Exec("UPDATE occupancy SET name = ?, effective_from = ? WHERE id = ?",
+				name, since, current.ID)
+		default:
+			if since == "" {
+				since = today
+			}
+			_, err = tx.Exec("UPDATE occupancy SET effective_to = ? WHERE id = ?", since, current.ID)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(
+				"INSERT INTO occupancy (society_id, apartment_id, role, name, effective_from) VALUES (?, ?, ?, ?, ?)",
+				s.society, apt.ID, role, name, since)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLite) OccupancyHistory(apartmentID string) ([]Occupancy, error) {
+	rows, err := s.resident.Query(
+		`SELECT id, society_id, apartment_id, role, name, effective_from, effective_to FROM occupancy
+		WHERE society_id = ? AND apartment_id = ?
+		ORDER BY role, effective_to = '', effective_from, id`, s.society, apartmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []Occupancy
+	for rows.Next() {
+		var o Occupancy
+		if err := rows.Scan(&o.ID, &o.SocietyID, &o.ApartmentID, &o.Role, &o.Name, &o.From, &o.To); err != nil {
+			return nil, err
+		}
+		history = append(history, o)
+	}
+	return history, rows.Err()
+}
+
+func (s *SQLite) MergeApartments(from, into, mergedBy string) (bool, error) {
+	tx, err := s.resident.Begin()
+	if err != nil {
+		return false, err
+	}
+	created, err := s.mergeInto(tx, from, into, mergedBy)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	// Resident logins live in the other database
+	if err := s.moveLogins(from, into); err != nil {
+		return created, fmt.Errorf("%w: %v", ErrLoginsNotMoved, err)
+	}
+	return created, nil
+}
+
+// moveLogins gives the resident logins with access to apartment from access
+// to into instead
+func (s *SQLite) moveLogins(from, into string) error {
+	tx, err := s.app.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT OR IGNORE INTO user_apartments (user_id, society_id, apartment_id)
+		SELECT user_id, society_id, ? FROM user_apartments WHERE society_id = ? AND apartment_id = ?`,
+		into, s.society, from)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM user_apartments WHERE society_id = ? AND apartment_id = ?", s.society, from)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// mergeInto does the part of MergeApartments kept in the resident database
+func (s *SQLite) mergeInto(tx *sql.Tx, from, into, mergedBy string) (bool, error) {
+	var deleted bool
+	err := tx.QueryRow("SELECT deleted_at IS NOT NULL FROM apartments WHERE society_id = ? AND id = ?",
+		s.society, into).Scan(&deleted)
+	created := errors.Is(err, sql.ErrNoRows)
+	switch {
+	case created:
+		if err := s.copyApartment(tx, from, into); err != nil {
+			return false, err
+		}
+	case err != nil:
+		return false, err
+	case deleted:
+		return false, ErrNotFound
+	}
+
+	_, err = tx.Exec(`UPDATE collections SET apartment_id = ?,
+			merged_from = CASE WHEN merged_from = '' THEN apartment_id ELSE merged_from END
+		WHERE society_id = ? AND apartment_id = ?`,
+		into, s.society, from)
+	if err != nil {
+		return false, err
+	}
+	err = changedOne(tx.Exec(`UPDATE apartments SET deleted_at = ?, deleted_by = ?, version = version + 1
+		WHERE society_id = ? AND id = ? AND deleted_at IS NULL`,
+		time.Now().Format(deletedAtFormat), mergedBy, s.society, from))
+	return created, err
+}
+
+// copyApartment creates apartment into as a copy of from, moving its
+// parking slots across and copying its occupancy history and people
+func (s *SQLite) copyApartment(tx *sql.Tx, from, into string) error {
+	err := changedOne(tx.Exec(
+		`INSERT INTO apartments (society_id, id, owner, resident, same_flag, block, floor, unit_type,
+			carpet_area, super_built_up_area, parking_slots, updated_at)
+		SELECT society_id, ?, owner, resident, same_flag, block, floor, unit_type,
+			carpet_area, super_built_up_area, parking_slots, ?
+		FROM apartments WHERE society_id = ? AND id = ? AND deleted_at IS NULL`,
+		into, time.Now().Format(updatedAtFormat), s.society, from))
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE apartments SET parking_slots = '' WHERE society_id = ? AND id = ?",
+		s.society, from); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO occupancy (society_id, apartment_id, role, name, effective_from, effective_to)
+		SELECT society_id, ?, role, name, effective_from, effective_to FROM occupancy
+		WHERE society_id = ? AND apartment_id = ? ORDER BY id`,
+		into, s.society, from)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO apartment_people (society_id, apartment_id, person_id, role, share)
+		SELECT society_id, ?, person_id, role, share FROM apartment_people
+		WHERE society_id = ? AND apartment_id = ?`,
+		into, s.society, from)
+	return err
+}
+
+func (s *SQLite) AddCollection(c Collection) (Collection, error) {
+	// The receipt number is taken from the society's sequence in the same
+	// transaction as the insert, so two collections never share one
+	tx, err := s.resident.Begin()
+	if err != nil {
+		return c, err
+	}
+	defer tx.Rollback()
+
+	c.SocietyID = s.society
+	err = tx.QueryRow("SELECT next_receipt_number FROM societies WHERE id = ?", s.society).Scan(&c.ReceiptNumber)
+	if err != nil {
+		return c, notFound(err)
+	}
+	_, err = tx.Exec("UPDATE societies SET next_receipt_number = next_receipt_number + 1 WHERE id = ?", s.society)
+	if err != nil {
+		return c, err
+	}
+
+	result, err := tx.Exec(
+		`INSERT INTO collections (society_id, receipt_number, apartment_id, month, type, price)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		c.SocietyID, c.ReceiptNumber, c.ApartmentID, c.Month, c.Type, c.Price)
+	if err != nil {
+		return c, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return c, err
+	}
+	c.ID = int(id)
+	if err := tx.QueryRow("SELECT date(date) FROM collections WHERE id = ?", id).Scan(&c.Date); err != nil {
+		return c, err
+	}
+	return c, tx.Commit()
+}
+
+const collectionColumns = "id, society_id, receipt_number, apartment_id, month, type, price, date(date), merged_from"
+
+func scanCollection(row rowScanner) (Collection, error) {
+	var c Collection
+	err := row.Scan(&c.ID, &c.SocietyID, &c.ReceiptNumber, &c.ApartmentID, &c.Month, &c.Type, &c.Price, &c.Date,
+		&c.MergedFrom)
+	return c, notFound(err)
+}
+
+func (s *SQLite) GetCollection(id int) (Collection, error) {
+	return scanCollection(s.resident.QueryRow(
+		"SELECT "+collectionColumns+" FROM collections WHERE id = ? AND society_id = ? AND deleted_at IS NULL",
+		id, s.society))
+}
+
+func (s *SQLite) CollectionsForApartment(apartmentID string) ([]Collection, error) {
+	rows, err := s.resident.Query(
+		"SELECT "+collectionColumns+` FROM collections
+		WHERE society_id = ? AND apartment_id = ? AND deleted_at IS NULL ORDER BY date DESC, id DESC`,
+		s.society, apartmentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var collections []Collection
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, c)
+	}
+	return collections, rows.Err()
+}
+
+func (s *SQLite) CountCollections(apartmentID string) (int, error) {
+	var count int
+	err := s.resident.QueryRow(
+		"SELECT COUNT(*) FROM collections WHERE society_id = ? AND apartment_id = ?",
+		s.society, apartmentID).Scan(&count)
+	return count, err
+}
+
+func (s *SQLite) CollectedMonths(apartmentID string, year int, collectionType string) (map[string]bool, error) {
+	rows, err := s.resident.Query(
+		`SELECT DISTINCT month FROM collections
+		WHERE society_id = ? AND apartment_id = ? AND type = ? AND strftime('%Y', date) = ?
+			AND deleted_at IS NULL`,
+		s.society, apartmentID, collectionType, strconv.Itoa(year))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	months := map[string]bool{}
+	for rows.Next() {
+		var month string
+		if err := rows.Scan(&month); err != nil {
+			return nil, err
+		}
+		months[month] = true
+	}
+	return months, rows.Err()
+}
+
+func (s *SQLite) AddPayment(p Payment) (Payment, error) {
+	result, err := s.resident.Exec(
+		`INSERT INTO payments (society_id, month, type, price, transaction_type, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		s.society, p.Month, p.Type, p.Price, p.TransactionType, time.Now().Format(updatedAtFormat))
+	if err != nil {
+		return p, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return p, err
+	}
+	p.ID = int(id)
+	p.SocietyID = s.society
+	err = s.resident.QueryRow("SELECT date(date), version, updated_at FROM payments WHERE id = ?", id).
+		Scan(&p.Date, &p.Version, &p.UpdatedAt)
+	return p, err
+}
+
+const paymentColumns = `id, society_id, month, type, price, transaction_type, datetime(date),
+	version, COALESCE(updated_at, '')`
+
+func scanPayment(row rowScanner) (Payment, error) {
+	var p Payment
+	err := row.Scan(&p.ID, &p.SocietyID, &p.Month, &p.Type, &p.Price, &p.TransactionType, &p.Date,
+		&p.Version, &p.UpdatedAt)
+	return p, notFound(err)
+}
+
+func (s *SQLite) GetPayment(id int) (Payment, error) {
+	return scanPayment(s.resident.QueryRow(
+		"SELECT "+paymentColumns+" FROM payments WHERE id = ? AND society_id = ? AND deleted_at IS NULL",
+		id, s.society))
+}
+
+func (s *SQLite) RecentPayments(limit int) ([]Payment, error) {
+	rows, err := s.resident.Query(
+		"SELECT "+paymentColumns+` FROM payments
+		WHERE society_id = ? AND deleted_at IS NULL ORDER BY date DESC, id DESC LIMIT ?`,
+		s.society, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []Payment
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return payments, rows.Err()
+}
+
+// binSource describes where the rows of one recycle bin kind live
+type binSource struct {
+	db    *sql.DB
+	table string
+	// scope restricts the rows to those the store may see
+	scope string
+	// summary, apartment and amount are SQL expressions describing a row
+	summary   string
+	apartment string
+	amount    string
+	// versioned tables count moving a row in and out of the bin as an
+	// edit, so a copy read before then can no longer be saved
+	versioned bool
+}
+
+func (s *SQLite) binSource(kind BinKind) (binSource, error) {
+	society := fmt.Sprintf("society_id = %d", s.society)
+	switch kind {
+	case BinApartments:
+		return binSource{s.resident, "apartments", society, "owner || ' / ' || resident", "''", "0", true}, nil
+	case BinUsers:
+		return binSource{s.app, "users", "1 = 1", "username || ' (' || role || ')'", "''", "0", true}, nil
+	case BinCollections:
+		return binSource{s.resident, "collections", society, "apartment_id || ' ' || month || ' ' || type",
+			"apartment_id", "price", false}, nil
+	case BinPayments:
+		return binSource{s.resident, "payments", society, "month || ' ' || type || ' ' || transaction_type",
+			"''", "price", true}, nil
+	}
+	return binSource{}, fmt.Errorf("unknown recycle bin kind %q", kind)
+}
+
+func (src binSource) query() string {
+	return fmt.Sprintf(`SELECT CAST(id AS TEXT), %s, %s, %s, deleted_at, COALESCE(deleted_by, '')
+		FROM %s WHERE %s AND deleted_at IS NOT NULL`, src.summary, src.apartment, src.amount, src.table, src.scope)
+}
+
+// bump is the SET clause recording an edit, if the source is versioned
+func (src binSource) bump() string {
+	if src.versioned {
+		return ", version = version + 1"
+	}
+	return ""
+}
+
+// row is the condition selecting one row of the source by ID
+func (src binSource) row() string {
+	return src.scope + " AND id = ?"
+}
+
+func scanBinEntry(kind BinKind, row rowScanner) (BinEntry, error) {
+	entry := BinEntry{Kind: kind}
+	var deletedAt string
+	err := row.Scan(&entry.ID, &entry.Summary, &entry.ApartmentID, &entry.Amount, &deletedAt, &entry.DeletedBy)
+	if err != nil {
+		return entry, notFound(err)
+	}
+	entry.DeletedAt, err = time.ParseInLocation(deletedAtFormat, deletedAt, time.Local)
+	return entry, err
+}
+
+// changedOne returns ErrNotFound if an UPDATE or DELETE matched no row
+func changedOne(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// conflicted is changedOne for a write guarded by a version check, where no
+// change means someone else got there first
+func conflicted(result sql.Result, err error) error {
+	if err := changedOne(result, err); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *SQLite) MoveToBin(kind BinKind, id string, deletedBy string) error {
+	src, err := s.binSource(kind)
+	if err != nil {
+		return err
+	}
+	return changedOne(src.db.Exec(
+		"UPDATE "+src.table+" SET deleted_at = ?, deleted_by = ?"+src.bump()+
+			" WHERE "+src.row()+" AND deleted_at IS NULL",
+		time.Now().Format(deletedAtFormat), deletedBy, id))
+}
+
+func (s *SQLite) Deleted(kind BinKind) ([]BinEntry, error) {
+	src, err := s.binSource(kind)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := src.db.Query(src.query() + " ORDER BY deleted_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []BinEntry
+	for rows.Next() {
+		entry, err := scanBinEntry(kind, rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *SQLite) DeletedEntry(kind BinKind, id string) (BinEntry, error) {
+	src, err := s.binSource(kind)
+	if err != nil {
+		return BinEntry{}, err
+	}
+	return scanBinEntry(kind, src.db.QueryRow(src.query()+" AND id = ?", id))
+}
+
+func (s *SQLite) Restore(kind BinKind, id string) error {
+	src, err := s.binSource(kind)
+	if err != nil {
+		return err
+	}
+	return changedOne(src.db.Exec(
+		"UPDATE "+src.table+" SET deleted_at = NULL, deleted_by = NULL"+src.bump()+
+			" WHERE "+src.row()+" AND deleted_at IS NOT NULL",
+		id))
+}
+
+func (s *SQLite) Purge(kind BinKind, id string) error {
+	if _, err := s.DeletedEntry(kind, id); err != nil {
+		return err
+	}
+
+	switch kind {
+	case BinUsers:
+		// Remove the rows that reference the account before the account itself
+		tx, err := s.app.Begin()
+		if err != nil {
+			return err
+		}
+		for _, table := range []string{"password_history", "recovery_codes", "user_apartments", "user_societies"} {
+			if _, err := tx.Exec("DELETE FROM "+table+" WHERE user_id = ?", id); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if _, err := tx.Exec("DELETE FROM users WHERE id = ?", id); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	case BinApartments:
+		// Collections still referring to the apartment make this fail, so
+		// its history, people and resident links are only dropped once the
+		// apartment is gone
+		tx, err := s.resident.Begin()
+		if err != nil {
+			return err
+		}
+		for _, table := range []string{"occupancy", "apartment_people"} {
+			if _, err := tx.Exec("DELETE FROM "+table+" WHERE society_id = ? AND apartment_id = ?", s.society, id); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if _, err := tx.Exec("DELETE FROM apartments WHERE society_id = ? AND id = ?", s.society, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		_, err = s.app.Exec("DELETE FROM user_apartments WHERE society_id = ? AND apartment_id = ?", s.society, id)
+		return err
+	}
+
+	src, err := s.binSource(kind)
+	if err != nil {
+		return err
+	}
+	_, err = src.db.Exec("DELETE FROM "+src.table+" WHERE "+src.row(), id)
+	return err
+}
+
+const societyColumns = "id, name, address, registration_number, id_pattern"
+
+func scanSociety(row rowScanner) (Society, error) {
+	var society Society
+	err := row.Scan(&society.ID, &society.Name, &society.Address, &society.RegistrationNumber, &society.IDPattern)
+	return society, notFound(err)
+}
+
+func (s *SQLite) ListSocieties() ([]Society, error) {
+	rows, err := s.resident.Query("SELECT " + societyColumns + " FROM societies ORDER BY name COLLATE NOCASE")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var societies []Society
+	for rows.Next() {
+		society, err := scanSociety(rows)
+		if err != nil {
+			return nil, err
+		}
+		societies = append(societies, society)
+	}
+	return societies, rows.Err()
+}
+
+func (s *SQLite) GetSociety(id int) (Society, error) {
+	return scanSociety(s.resident.QueryRow("SELECT "+societyColumns+" FROM societies WHERE id = ?", id))
+}
+
+func (s *SQLite) SaveSociety(society Society) (Society, error) {
+	if society.ID == 0 {
+		result, err := s.resident.Exec(
+			"INSERT INTO societies (name, address, registration_number, id_pattern) VALUES (?, ?, ?, ?)",
+			society.Name, society.Address, society.RegistrationNumber, society.IDPattern)
+		if err != nil {
+			return society, err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return society, err
+		}
+		society.ID = int(id)
+		return society, nil
+	}
+
+	err := changedOne(s.resident.Exec(
+		"UPDATE societies SET name = ?, address = ?, registration_number = ?, id_pattern = ? WHERE id = ?",
+		society.Name, society.Address, society.RegistrationNumber, society.IDPattern, society.ID))
+	return society, err
+}
+
+func (s *SQLite) UserSocieties(userID int) ([]int, error) {
+	rows, err := s.app.Query(
+		"SELECT society_id FROM user_societies WHERE user_id = ? ORDER BY society_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQLite) SetUserSocieties(userID int, societyIDs []int) error {
+	tx, err := s.app.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_societies WHERE user_id = ?", userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, id := range societyIDs {
+		_, err := tx.Exec(
+			"INSERT OR IGNORE INTO user_societies (user_id, society_id) VALUES (?, ?)", userID, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const personColumns = `id, society_id, name, phone, email, alternate_contact, id_proof,
+	version, COALESCE(updated_at, '')`
+
+func scanPerson(row rowScanner) (Person, error) {
+	var p Person
+	err := row.Scan(&p.ID, &p.SocietyID, &p.Name, &p.Phone, &p.Email, &p.AlternateContact, &p.IDProof,
+		&p.Version, &p.UpdatedAt)
+	return p, notFound(err)
+}
+
+func (s *SQLite) SearchPeople(query string) ([]Person, error) {
+	pattern := ContainsPattern(strings.TrimSpace(query))
+	rows, err := s.resident.Query(
+		"SELECT "+personColumns+` FROM people
+		WHERE society_id = ?
+		AND (name LIKE ? ESCAPE '\' OR phone LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\')
+		ORDER BY name COLLATE NOCASE, id`,
+		s.society, pattern, pattern, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var people []Person
+	for rows.Next() {
+		p, err := scanPerson(rows)
+		if err != nil {
+			return nil, err
+		}
+		people = append(people, p)
+	}
+	return people, rows.Err()
+}
+
+func (s *SQLite) GetPerson(id int) (Person, error) {
+	return scanPerson(s.resident.QueryRow(
+		"SELECT "+personColumns+" FROM people WHERE society_id = ? AND id = ?", s.society, id))
+}
+
+func (s *SQLite) SavePerson(p Person) (Person, error) {
+	return s.savePerson(s.resident, p)
+}
+
+func (s *SQLite) SavePeople(people ...Person) ([]Person, error) {
+	tx, err := s.resident.Begin()
+	if err != nil {
+		return nil, err
+	}
+	saved := make([]Person, len(people))
+	for i, p := range people {
+		if saved[i], err = s.savePerson(tx, p); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// savePerson saves p through db, the database or a transaction
+func (s *SQLite) savePerson(db execer, p Person) (Person, error) {
+	p.SocietyID = s.society
+	p.UpdatedAt = time.Now().Format(updatedAtFormat)
+	if p.ID == 0 {
+		result, err := db.Exec(
+			`INSERT INTO people (society_id, name, phone, email, alternate_contact, id_proof, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			s.society, p.Name, p.Phone, p.Email, p.AlternateContact, p.IDProof, p.UpdatedAt)
+		if err != nil {
+			return p, err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return p, err
+		}
+		p.ID = int(id)
+		p.Version = 1
+		return p, nil
+	}
+
+	err := conflicted(db.Exec(
+		`UPDATE people SET name = ?, phone = ?, email = ?, alternate_contact = ?, id_proof = ?,
+			version = version + 1, updated_at = ?
+		WHERE society_id = ? AND id = ? AND version = ?`,
+		p.Name, p.Phone, p.Email, p.AlternateContact, p.IDProof, p.UpdatedAt, s.society, p.ID, p.Version))
+	if err != nil {
+		return p, err
+	}
+	p.Version++
+	return p, nil
+}
+
+func (s *SQLite) DeletePerson(id int) error {
+	tx, err := s.resident.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM apartment_people WHERE society_id = ? AND person_id = ?", s.society, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := changedOne(tx.Exec("DELETE FROM people WHERE society_id = ? AND id = ?", s.society, id)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryLinks runs a query over apartment_people joined to people
+func (s *SQLite) queryLinks(where, orderBy string, args ...any) ([]PersonLink, error) {
+	rows, err := s.resident.Query(
+		`SELECT l.person_id, p.name, l.apartment_id, l.role, l.share FROM apartment_people l
+		JOIN people p ON p.id = l.person_id
+		WHERE l.society_id = ? AND `+where+` ORDER BY `+orderBy,
+		append([]any{s.society}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []PersonLink
+	for rows.Next() {
+		var link PersonLink
+		if err := rows.Scan(&link.PersonID, &link.PersonName, &link.ApartmentID, &link.Role, &link.Share); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *SQLite) PersonLinks(personID int) ([]PersonLink, error) {
+	return s.queryLinks("l.person_id = ?", "l.apartment_id, l.role", personID)
+}
+
+func (s *SQLite) ApartmentLinks(apartmentID string) ([]PersonLink, error) {
+	return s.queryLinks("l.apartment_id = ?", "l.role, p.name COLLATE NOCASE", apartmentID)
+}
+
+func (s *SQLite) SetApartmentLinks(apartmentID string, role LinkRole, personIDs []int) error {
+	tx, err := s.resident.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Drop the links of people no longer in the role, keeping the rest as
+	// they are, then add the newcomers
+	keep := map[int]bool{}
+	for _, id := range personIDs {
+		keep[id] = true
+	}
+	var current []int
+	rows, err := tx.Query("SELECT person_id FROM apartment_people WHERE society_id = ? AND apartment_id = ? AND role = ?",
+		s.society, apartmentID, role)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+		current = append(current, id)
+	}
+	rows.Close()
+	for _, id := range current {
+		if keep[id] {
+			continue
+		}
+		_, err := tx.Exec("DELETE FROM apartment_people WHERE society_id = ? AND apartment_id = ? AND role = ? AND person_id = ?",
+			s.society, apartmentID, role, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, id := range personIDs {
+		_, err := tx.Exec(
+			"INSERT OR IGNORE INTO apartment_people (society_id, apartment_id, person_id, role) VALUES (?, ?, ?, ?)",
+			s.society, apartmentID, id, role)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite) SetOwnershipShares(apartmentID string, shares map[int]int) error {
+	tx, err := s.resident.Begin()
+	if err != nil {
+		return err
+	}
+	for id, share := range shares {
+		err := changedOne(tx.Exec(
+			`UPDATE apartment_people SET share = ?
+			WHERE society_id = ? AND apartment_id = ? AND person_id = ? AND role IN (?, ?)`,
+			share, s.society, apartmentID, id, LinkOwner, LinkCoOwner))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,386 @@
+// Package store defines the persistence interfaces used by the application
+// and provides SQLite and in-memory implementations of them.
+package store
+
+import (
+	"errors"
+	"time"
+
+	"apartment_login/money"
+)
+
+// ErrNotFound is returned when a requested row does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned when a row was changed or removed by someone else
+// after the caller read it
+var ErrConflict = errors.New("record was changed by someone else")
+
+// ErrLoginsNotMoved is returned by MergeApartments when the apartments were
+// merged but the resident logins could not be moved across
+var ErrLoginsNotMoved = errors.New("the apartments were merged, but moving the resident logins failed")
+
+// Role is the committee role assigned to a user
+type Role string
+
+// User represents a user in the database.
+// Password only carries a new plaintext password to be hashed on save;
+// it is never loaded back from the database.
+type User struct {
+	ID       int
+	Username string
+	FullName string
+	Password string
+	Role     Role
+	Disabled bool
+
+	// Apartments linked to a resident account in the current society
+	Apartments []string
+	// Societies the account may work in
+	Societies []int
+
+	CreatedAt   string
+	LastLoginAt string
+
+	FailedAttempts int
+	LockedUntil    time.Time
+	TOTPEnabled    bool
+
+	MustChangePassword bool
+	PasswordChangedAt  time.Time
+
+	// Version counts edits to the directory details; UpdatedAt is when the
+	// last one was made
+	Version   int
+	UpdatedAt string
+}
+
+// IsLocked reports whether sign-in is currently blocked for the user
+func (u User) IsLocked() bool {
+	return time.Now().Before(u.LockedUntil)
+}
+
+// DefaultSocietyID is the society created when a single-society
+// installation was upgraded; a new store starts out scoped to it
+const DefaultSocietyID = 1
+
+// DefaultSocietyName is the name the default society is created with until
+// it is given the configured one
+const DefaultSocietyName = "Default Society"
+
+// Society is one building managed by the installation. Apartments,
+// collections and payments each belong to exactly one society.
+type Society struct {
+	ID                 int
+	Name               string
+	Address            string
+	RegistrationNumber string
+	// IDPattern is how the society writes apartment IDs, e.g. "@-###" for
+	// A-101; blank accepts any ID
+	IDPattern string
+}
+
+// Apartment represents an apartment entry. IDs are unique within a society.
+// Version is 0 for an apartment that has not been saved yet.
+type Apartment struct {
+	SocietyID int
+	ID        string
+	Owner     string
+	Resident  string
+	SameFlag  bool
+	// OwnerSince and ResidentSince are the dates, as YYYY-MM-DD, the current
+	// owner and resident took over; blank when that was before history was kept
+	OwnerSince    string
+	ResidentSince string
+
+	// Block is the block or tower; Floor 0 is the ground floor
+	Block    string
+	Floor    int
+	UnitType string
+	// CarpetArea and SuperBuiltUpArea are in square feet, 0 when unknown
+	CarpetArea       float64
+	SuperBuiltUpArea float64
+	// ParkingSlots are the slots allotted to the apartment, in order
+	ParkingSlots []string
+
+	Version   int
+	UpdatedAt string
+}
+
+// Collection is money received for an apartment. ReceiptNumber runs in
+// sequence within the society.
+type Collection struct {
+	ID            int
+	SocietyID     int
+	ReceiptNumber int
+	ApartmentID   string
+	Month         string
+	Type          string
+	Price         money.Money
+	Date          string
+	// MergedFrom is the apartment the collection was received for, when
+	// that apartment has since been merged into ApartmentID
+	MergedFrom string
+}
+
+// Payment is a society income or expense entry
+type Payment struct {
+	ID              int
+	SocietyID       int
+	Month           string
+	Type            string
+	Price           money.Money
+	TransactionType string
+	Date            string
+	Version         int
+	UpdatedAt       string
+}
+
+// OccupancyRole says whether an occupancy period is ownership or residence
+type OccupancyRole string
+
+const (
+	OccupancyOwner    OccupancyRole = "owner"
+	OccupancyResident OccupancyRole = "resident"
+)
+
+// Occupancy is a period during which someone owned or lived in an
+// apartment. It runs from From up to, but not including, To. From is blank
+// when the period began before history was kept and To is blank while it is
+// current.
+type Occupancy struct {
+	ID          int
+	SocietyID   int
+	ApartmentID string
+	Role        OccupancyRole
+	Name        string
+	From        string
+	To          string
+}
+
+// Covers reports whether the period includes date, given as YYYY-MM-DD
+func (o Occupancy) Covers(date string) bool {
+	return (o.From == "" || o.From <= date) && (o.To == "" || date < o.To)
+}
+
+// Person is someone in a society's directory of owners and residents
+type Person struct {
+	ID               int
+	SocietyID        int
+	Name             string
+	Phone            string
+	Email            string
+	AlternateContact string
+	// IDProof names the identity document on file, such as "PAN ABCDE1234F"
+	IDProof   string
+	Version   int
+	UpdatedAt string
+}
+
+// LinkRole says how a person is tied to an apartment
+type LinkRole string
+
+const (
+	LinkOwner    LinkRole = "owner"
+	LinkResident LinkRole = "resident"
+	LinkCoOwner  LinkRole = "co-owner"
+)
+
+// LinkRoles lists every role a person can have in an apartment
+var LinkRoles = []LinkRole{LinkOwner, LinkCoOwner, LinkResident}
+
+// FullShare is an apartment's whole ownership in Share units, which are
+// hundredths of a percent
+const FullShare = 10000
+
+// PersonLink ties a person to an apartment; PersonName is filled in on read.
+// Share is an owner's or co-owner's part of the apartment in hundredths of a
+// percent; the shares of an apartment's owners add up to FullShare.
+type PersonLink struct {
+	PersonID    int
+	PersonName  string
+	ApartmentID string
+	Role        LinkRole
+	Share       int
+}
+
+// UserStore holds login accounts and the apartments linked to them.
+// Passwords, lockouts and two-factor secrets are managed by the auth code.
+// Accounts in the recycle bin are never returned.
+type UserStore interface {
+	GetUser(id int) (User, error)
+	GetUserByUsername(username string) (User, error)
+	// SearchUsers matches username, full name or role; empty returns everyone
+	SearchUsers(query string) ([]User, error)
+	CountUsers() (int, error)
+	CreateUser(user User, passwordHash string) (User, error)
+	// UpdateUser saves the username, full name and role. It fails with
+	// ErrConflict unless user.Version is the stored version.
+	UpdateUser(user User) error
+	SetUserDisabled(id int, disabled bool) error
+	// UserApartments and SetUserApartments work on the links within the
+	// store's society
+	UserApartments(userID int) ([]string, error)
+	SetUserApartments(userID int, apartmentIDs []string) error
+}
+
+// SocietyStore holds the societies and the users assigned to each
+type SocietyStore interface {
+	// ListSocieties returns every society ordered by name
+	ListSocieties() ([]Society, error)
+	GetSociety(id int) (Society, error)
+	// SaveSociety creates the society when its ID is 0, otherwise updates it
+	SaveSociety(society Society) (Society, error)
+	UserSocieties(userID int) ([]int, error)
+	SetUserSocieties(userID int, societyIDs []int) error
+}
+
+// ApartmentStore holds the apartments of the store's society, ordered by ID.
+// Apartments in the recycle bin are never returned.
+type ApartmentStore interface {
+	GetApartment(id string) (Apartment, error)
+	// ListApartments returns a page of apartments; limit <= 0 returns all
+	ListApartments(offset, limit int) ([]Apartment, error)
+	CountApartments() (int, error)
+	// SaveApartments creates or updates every apartment in one transaction.
+	// An apartment with Version 0 is created; any other is updated only if
+	// Version is still the stored version. Otherwise nothing is saved and
+	// ErrConflict is returned.
+	//
+	// When the owner or resident changes, the current occupancy period is
+	// ended and a new one starts on OwnerSince or ResidentSince, or today if
+	// that is blank. A changed since date for the same person moves the start
+	// of the current period instead.
+	SaveApartments(apartments ...Apartment) error
+	// OccupancyHistory returns the occupancy periods of an apartment, owners
+	// before residents and oldest first
+	OccupancyHistory(apartmentID string) ([]Occupancy, error)
+	// MergeApartments moves every collection of apartment from, including
+	// those in the recycle bin, and every resident login given access to it
+	// over to apartment into, then moves from to the recycle bin. Moved
+	// collections keep from as their MergedFrom. From must exist outside the
+	// recycle bin. If into does not exist at all it is first created as a
+	// copy of from, taking over its parking slots, occupancy history, people
+	// and shares, and created is true. Everything but the resident logins is
+	// written in one transaction; if only the logins fail, the error wraps
+	// ErrLoginsNotMoved.
+	MergeApartments(from, into, mergedBy string) (created bool, err error)
+}
+
+// PeopleStore holds the directory of people in the store's society and the
+// apartments they are linked to
+type PeopleStore interface {
+	// SearchPeople matches name, phone or email; empty returns everyone,
+	// ordered by name
+	SearchPeople(query string) ([]Person, error)
+	GetPerson(id int) (Person, error)
+	// SavePerson creates the person when its ID is 0. Otherwise it updates
+	// them, failing with ErrConflict unless p.Version is the stored version.
+	SavePerson(p Person) (Person, error)
+	// SavePeople saves every person as SavePerson does, in one transaction,
+	// and returns them as saved. If any of them conflicts nothing is saved
+	// and ErrConflict is returned.
+	SavePeople(people ...Person) ([]Person, error)
+	// DeletePerson removes a person together with their links
+	DeletePerson(id int) error
+	// PersonLinks returns a person's links ordered by apartment
+	PersonLinks(personID int) ([]PersonLink, error)
+	// ApartmentLinks returns the people linked to an apartment, by role
+	ApartmentLinks(apartmentID string) ([]PersonLink, error)
+	// SetApartmentLinks replaces the people linked to an apartment in a role.
+	// People who stay linked keep their share; those added start at 0.
+	SetApartmentLinks(apartmentID string, role LinkRole, personIDs []int) error
+	// SetOwnershipShares sets the shares of an apartment's owner and
+	// co-owners, keyed by person, in one transaction
+	SetOwnershipShares(apartmentID string, shares map[int]int) error
+}
+
+// CollectionStore holds money received for the store's apartments
+type CollectionStore interface {
+	// AddCollection stores c and returns it with its ID, receipt number and
+	// date filled in
+	AddCollection(c Collection) (Collection, error)
+	GetCollection(id int) (Collection, error)
+	// CollectionsForApartment returns an apartment's collections, newest first
+	CollectionsForApartment(apartmentID string) ([]Collection, error)
+	// CountCollections counts every collection of an apartment, including
+	// those in the recycle bin
+	CountCollections(apartmentID string) (int, error)
+	// CollectedMonths returns the months of year with a collection of the given type
+	CollectedMonths(apartmentID string, year int, collectionType string) (map[string]bool, error)
+}
+
+// PaymentStore holds the income and expenses of the store's society
+type PaymentStore interface {
+	// AddPayment stores p and returns it with its ID and date filled in
+	AddPayment(p Payment) (Payment, error)
+	GetPayment(id int) (Payment, error)
+	// RecentPayments returns the newest payments first
+	RecentPayments(limit int) ([]Payment, error)
+}
+
+// BinKind names a table whose rows go to the recycle bin when deleted
+type BinKind string
+
+const (
+	BinApartments  BinKind = "apartments"
+	BinUsers       BinKind = "users"
+	BinCollections BinKind = "collections"
+	BinPayments    BinKind = "payments"
+)
+
+// BinKinds lists every kind of row the recycle bin holds
+var BinKinds = []BinKind{BinApartments, BinUsers, BinCollections, BinPayments}
+
+// BinEntry is a deleted row waiting in the recycle bin
+type BinEntry struct {
+	Kind    BinKind
+	ID      string
+	Summary string
+	// ApartmentID is set for collections
+	ApartmentID string
+	// Amount is set for collections and payments
+	Amount    money.Money
+	DeletedAt time.Time
+	DeletedBy string
+}
+
+// RecycleBinStore moves rows out of normal use without losing them. A row in
+// the recycle bin keeps its ID, so nothing referring to it is broken, until
+// it is restored or purged. Apartments, collections and payments are only
+// seen from their own society.
+type RecycleBinStore interface {
+	// MoveToBin hides a row from normal use, recording who deleted it
+	MoveToBin(kind BinKind, id string, deletedBy string) error
+	// Deleted lists the rows of a kind in the recycle bin, most recently deleted first
+	Deleted(kind BinKind) ([]BinEntry, error)
+	// DeletedEntry returns ErrNotFound unless the row is in the recycle bin
+	DeletedEntry(kind BinKind, id string) (BinEntry, error)
+	// Restore returns a row from the recycle bin to normal use
+	Restore(kind BinKind, id string) error
+	// Purge permanently removes a row from the recycle bin together with the
+	// rows that only exist to describe it
+	Purge(kind BinKind, id string) error
+}
+
+// Store combines every store the application uses
+type Store interface {
+	UserStore
+	SocietyStore
+	ApartmentStore
+	PeopleStore
+	CollectionStore
+	PaymentStore
+	RecycleBinStore
+
+	// SocietyID is the society the store is scoped to
+	SocietyID() int
+	// InSociety returns a store over the same data scoped to another society
+	InSociety(id int) Store
+}
+
+// updatedAtFormat is how updated_at is stored, in local time
+const updatedAtFormat = "2006-01-02 15:04:05"
+
+// deletedAtFormat is how deleted_at is stored, in local time
+const deletedAtFormat = "2006-01-02 15:04:05"
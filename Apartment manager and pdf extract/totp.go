@@ -0,0 +1,404 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/canvas"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// RFC 6238 parameters. These are the defaults every authenticator app
+// understands, so they are not configurable.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is the number of periods either side of now that are accepted
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+)
+
+var (
+	errSecondFactorRequired = errors.New("authentication code required")
+	errInvalidSecondFactor  = errors.New("invalid authentication code")
+
+	totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// generateTOTPSecret returns a new random base32 secret
+func generateTOTPSecret() (string, error) {
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(key), nil
+}
+
+// totpCode computes the code for the given secret at the given time step
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// matchTOTP returns the time step the code is valid for, allowing for clock
+// skew, or -1 if it does not match
+func matchTOTP(secret, code string, now time.Time) int64 {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	current := now.Unix() / totpPeriod
+
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return -1
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step
+		}
+	}
+	return -1
+}
+
+// totpURI builds the otpauth:// URI encoded in the enrolment QR code
+func totpURI(username, secret string) string {
+	issuer := societyName()
+	if issuer == "" {
+		issuer = "Apartment Management System"
+	}
+
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", strconv.Itoa(totpDigits))
+	values.Set("period", strconv.Itoa(totpPeriod))
+
+	label := url.PathEscape(issuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// recoveryCodeBytes is the randomness in each recovery code: 80 bits, which
+// cannot be guessed offline even from a leaked unsalted hash
+const recoveryCodeBytes = 10
+
+// hashRecoveryCode hashes a recovery code for storage. Each code carries
+// 80 random bits, so unlike a password it cannot be found by trying likely
+// values, and a fast unsalted hash is enough to keep it secret. Dashes,
+// spaces and case are ignored.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.Join(strings.FieldsFunc(code, func(r rune) bool {
+		return r == '-' || r == ' '
+	}), ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateRecoveryCodes returns new codes formatted as xxxx-xxxx-xxxx-xxxx
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		h := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw))
+		codes[i] = h[:4] + "-" + h[4:8] + "-" + h[8:12] + "-" + h[12:]
+	}
+	return codes, nil
+}
+
+// beginTOTPEnrolment stores a new, not yet enabled secret for the user
+func beginTOTPEnrolment(userID int) (string, error) {
+	if err := requirePermission(PermManageUsers); err != nil {
+		return "", err
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = userDB.Exec(
+		"UPDATE users SET totp_secret = ?, totp_enabled = 0, totp_last_step = 0 WHERE id = ?",
+		secret, userID)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// confirmTOTPEnrolment enables two-factor sign-in once the user proves their
+// authenticator works, and returns a fresh set of recovery codes
+func confirmTOTPEnrolment(userID int, code string) ([]string, error) {
+	if err := requirePermission(PermManageUsers); err != nil {
+		return nil, err
+	}
+
+	var secret sql.NullString
+	err := userDB.QueryRow("SELECT totp_secret FROM users WHERE id = ?", userID).Scan(&secret)
+	if err != nil {
+		return nil, err
+	}
+	if !secret.Valid || secret.String == "" {
+		return nil, errors.New("two-factor enrolment has not been started")
+	}
+
+	step := matchTOTP(secret.String, code, time.Now())
+	if step < 0 {
+		return nil, errInvalidSecondFactor
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := userDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE id = ?", step, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	for _, c := range codes {
+		_, err := tx.Exec(
+			"INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID, hashRecoveryCode(c))
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	user, err := getUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	err = recordAudit(auditEntityUser, strconv.Itoa(userID), auditActionUpdate,
+		auditUser{Username: user.Username, Role: user.Role},
+		auditUser{Username: user.Username, Role: user.Role, TwoFactor: true})
+	return codes, err
+}
+
+// disableTOTP removes the user's secret and recovery codes
+func disableTOTP(userID int) error {
+	if err := requirePermission(PermManageUsers); err != nil {
+		return err
+	}
+
+	user, err := getUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := userDB.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0 WHERE id = ?",
+		userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return recordAudit(auditEntityUser, strconv.Itoa(userID), auditActionUpdate,
+		auditUser{Username: user.Username, Role: user.Role, TwoFactor: user.TOTPEnabled},
+		auditUser{Username: user.Username, Role: user.Role})
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused
+// recovery code. Codes for a time step already used are rejected.
+func verifySecondFactor(userID int, code string) error {
+	var secret sql.NullString
+	var lastStep int64
+	err := userDB.QueryRow(
+		"SELECT totp_secret, totp_last_step FROM users WHERE id = ? AND totp_enabled = 1",
+		userID).Scan(&secret, &lastStep)
+	if err != nil {
+		return errInvalidSecondFactor
+	}
+
+	if step := matchTOTP(secret.String, code, time.Now()); step >= 0 {
+		if step <= lastStep {
+			return errInvalidSecondFactor
+		}
+		_, err := userDB.Exec("UPDATE users SET totp_last_step = ? WHERE id = ?", step, userID)
+		return err
+	}
+
+	result, err := userDB.Exec(
+		"UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now().Format(time.RFC3339), userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errInvalidSecondFactor
+	}
+	return nil
+}
+
+// CompleteSecondFactor finishes a sign-in that Authenticate reported as
+// needing an authentication code. The account is read again first, as it
+// may have been locked or disabled while the code was being typed.
+func CompleteSecondFactor(user User, code string) (User, error) {
+	user, err := appStore.GetUser(user.ID)
+	if err != nil {
+		return User{}, err
+	}
+	if user.IsLocked() {
+		recordLogin(user.Username, false, "locked")
+		wait := time.Until(user.LockedUntil).Round(time.Second)
+		return User{}, fmt.Errorf("too many failed attempts, try again in %s", wait)
+	}
+	if user.Disabled {
+		recordLogin(user.Username, false, "disabled")
+		return User{}, errors.New("this account has been disabled")
+	}
+
+	if err := verifySecondFactor(user.ID, code); err != nil {
+		if errors.Is(err, errInvalidSecondFactor) {
+			return User{}, recordFailedLogin(user)
+		}
+		return User{}, err
+	}
+	return finishLogin(user), nil
+}
+
+// promptSecondFactor asks for the authentication code after the password
+// was accepted and calls onSuccess with the signed-in user
+func promptSecondFactor(user User, parent fyne.Window, onSuccess func(User)) {
+	codeEntry := widget.NewEntry()
+	codeEntry.SetPlaceHolder("123456 or recovery code")
+
+	items := []*widget.FormItem{
+		widget.NewFormItem("Code", codeEntry),
+	}
+
+	dialog.ShowForm("Two-Factor Authentication", "Verify", "Cancel", items, func(ok bool) {
+		if !ok {
+			return
+		}
+		signedIn, err := CompleteSecondFactor(user, codeEntry.Text)
+		if err != nil {
+			dialog.ShowError(err, parent)
+			return
+		}
+		onSuccess(signedIn)
+	}, parent)
+}
+
+// showTOTPEnrolment walks an administrator through enabling two-factor
+// sign-in for a user: scan the QR code, confirm a code, note the recovery codes
+func showTOTPEnrolment(user User, parent fyne.Window, onDone func()) {
+	secret, err := beginTOTPEnrolment(user.ID)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
+
+	png, err := qrcode.Encode(totpURI(user.Username, secret), qrcode.Medium, 256)
+	if err != nil {
+		dialog.ShowError(err, parent)
+		return
+	}
+
+	qrImage := canvas.NewImageFromResource(fyne.NewStaticResource("totp.png", png))
+	qrImage.FillMode = canvas.ImageFillOriginal
+
+	secretEntry := widget.NewEntry()
+	secretEntry.SetText(secret)
+	secretEntry.Disable()
+
+	codeEntry := widget.NewEntry()
+	codeEntry.SetPlaceHolder("Code from authenticator app")
+
+	content := container.NewVBox(
+		widget.NewLabel("Scan with an authenticator app, or enter the key manually."),
+		container.NewCenter(qrImage),
+		secretEntry,
+		codeEntry,
+	)
+
+	dialog.ShowCustomConfirm("Enable Two-Factor for "+user.Username, "Confirm", "Cancel", content,
+		func(ok bool) {
+			if !ok {
+				return
+			}
+			codes, err := confirmTOTPEnrolment(user.ID, codeEntry.Text)
+			if err != nil {
+				dialog.ShowError(err, parent)
+				return
+			}
+			showRecoveryCodes(user.Username, codes, parent)
+			onDone()
+		}, parent)
+}
+
+// showRecoveryCodes displays new recovery codes; they cannot be shown again
+func showRecoveryCodes(username string, codes []string, parent fyne.Window) {
+	codesEntry := widget.NewMultiLineEntry()
+	codesEntry.SetText(strings.Join(codes, "\n"))
+	codesEntry.SetMinRowsVisible(len(codes))
+
+	content := container.NewVBox(
+		widget.NewLabel("Two-factor sign-in is enabled for "+username+"."),
+		widget.NewLabel("Store these one-time recovery codes somewhere safe.\nThey will not be shown again."),
+		codesEntry,
+	)
+	dialog.ShowCustom("Recovery Codes", "Done", content, parent)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of RFC 6238 Appendix B, "12345678901234567890"
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The appendix lists eight digit codes; six digit codes are their last
+	// six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("an invalid secret was accepted")
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	code := func(step int64) string {
+		c, err := totpCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name string
+		code string
+		want int64
+	}{
+		{"current", code(current), current},
+		{"previous period", code(current - 1), current - 1},
+		{"next period", code(current + 1), current + 1},
+		{"two periods old", code(current - 2), -1},
+		{"two periods ahead", code(current + 2), -1},
+		{"spaces", " " + code(current)[:3] + " " + code(current)[3:] + " ", current},
+		{"wrong", "000000", -1},
+	}
+	for _, tt := range tests {
+		if got := matchTOTP(rfc6238Secret, tt.code, now); got != tt.want {
+			t.Errorf("%s: matchTOTP = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVerifySecondFactor(t *testing.T) {
+	newTestDatabases(t)
+	mustAddUser(t, "asha", "correct horse")
+	_, err := userDB.Exec("UPDATE users SET totp_secret = ?, totp_enabled = 1", rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userDB.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (1, ?)",
+		hashRecoveryCode("abcde-12345")); err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := totpCode(rfc6238Secret, time.Now().Unix()/totpPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name string
+		code string
+		want error
+	}{
+		{"current code", code, nil},
+		{"replayed code", code, errInvalidSecondFactor},
+		{"wrong code", "000000", errInvalidSecondFactor},
+		{"recovery code without dash, in capitals", "ABCDE12345", nil},
+		{"recovery code used again", "abcde-12345", errInvalidSecondFactor},
+	}
+	for _, step := range steps {
+		if err := verifySecondFactor(1, step.code); !errors.Is(err, step.want) {
+			t.Errorf("%s: got error %v, want %v", step.name, err, step.want)
+		}
+	}
+
+	// A user without two-factor sign-in has nothing to verify against
+	if _, err := userDB.Exec("UPDATE users SET totp_enabled = 0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySecondFactor(1, code); !errors.Is(err, errInvalidSecondFactor) {
+		t.Errorf("disabled two-factor: got error %v, want %v", err, errInvalidSecondFactor)
+	}
+}
+
+func TestCompleteSecondFactor(t *testing.T) {
+	newTestDatabases(t)
+	mustAddUser(t, "asha", "correct horse")
+	if _, err := userDB.Exec("UPDATE users SET totp_secret = ?, totp_enabled = 1", rfc6238Secret); err != nil {
+		t.Fatal(err)
+	}
+	user, err := Authenticate("asha", "correct horse")
+	if !errors.Is(err, errSecondFactorRequired) {
+		t.Fatalf("got error %v, want a second factor required", err)
+	}
+	exec := func(query string) {
+		t.Helper()
+		if _, err := userDB.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each wrong code counts, though user was read before either
+	for i := 0; i < 2; i++ {
+		if _, err := CompleteSecondFactor(user, "000000"); !errors.Is(err, errInvalidCredentials) {
+			t.Fatalf("wrong code: got error %v", err)
+		}
+		exec("UPDATE users SET locked_until = NULL")
+	}
+	var failures int
+	if err := userDB.QueryRow("SELECT failed_attempts FROM users").Scan(&failures); err != nil || failures != 2 {
+		t.Errorf("failed_attempts = %d (%v) after two wrong codes, want 2", failures, err)
+	}
+
+	code, err := totpCode(rfc6238Secret, time.Now().Unix()/totpPeriod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec("UPDATE users SET locked_until = '" + time.Now().Add(time.Minute).Format(time.RFC3339) + "'")
+	if _, err := CompleteSecondFactor(user, code); err == nil || !strings.Contains(err.Error(), "try again") {
+		t.Errorf("locked meanwhile: got error %v, want the account locked", err)
+	}
+	exec("UPDATE users SET locked_until = NULL, disabled = 1")
+	if _, err := CompleteSecondFactor(user, code); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("disabled meanwhile: got error %v, want the account disabled", err)
+	}
+
+	exec("UPDATE users SET disabled = 0")
+	signedIn, err := CompleteSecondFactor(user, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signedIn.Username != "asha" || signedIn.FailedAttempts != 0 {
+		t.Errorf("signed in as %+v", signedIn)
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d codes, want %d", len(codes), recoveryCodeCount)
+	}
+	format := regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`)
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if !format.MatchString(c) {
+			t.Errorf("code %q is not four groups of four base32 letters", c)
+		}
+		if retyped := strings.ToUpper(strings.ReplaceAll(c, "-", " ")); hashRecoveryCode(retyped) != hashRecoveryCode(c) {
+			t.Errorf("code %q typed as %q does not match", c, retyped)
+		}
+		if seen[hashRecoveryCode(c)] {
+			t.Errorf("code %s repeats", c)
+		}
+		seen[hashRecoveryCode(c)] = true
+	}
+}